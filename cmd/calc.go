@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
+	"asc-simulation/dataaccess"
 	"asc-simulation/phys"
 
 	"github.com/spf13/cobra"
@@ -29,7 +31,23 @@ var calcCmd = &cobra.Command{
     - Checkpoint 1 close time (HH:MM)
     - Checkpoint 2 close time (HH:MM)
     - Checkpoint 3 close time (HH:MM)
-    - Stage finish close time (HH:MM)`,
+    - Stage finish close time (HH:MM)
+
+    Use --weather-date to simulate a past race day with the weather that actually
+    happened, to compare with what the car did. Historical weather comes from the
+    weather provider, or from --weather-file (see "weather import").
+
+    The car slows down in strong crosswinds, gusts, heavy rain and low visibility.
+    Set SAFETY_RULES_FILE to a JSON list of rules to change the limits, e.g.:
+
+        [{"Condition": "crosswind", "Threshold": 25, "MaxSpeedMph": 40}]
+
+    The car's drag, rolling resistance and efficiencies come from VEHICLE_FILE, or
+    vehicle.json if it isn't set (see "vehicle fit").
+
+    Use --output to save the result of every section, to compare with telemetry
+    once the route has been driven (see "compare"), and --report to write an HTML
+    report with charts, a map of the route colored by speed and summary tables.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 10 {
 			panic("Provided too few commands: " + strconv.Itoa(len(args)) + "/10")
@@ -63,8 +81,29 @@ var calcCmd = &cobra.Command{
 				panic("Argument #" + strconv.Itoa(i) + " not in HH:MM format")
 			}
 		}
+		date := time.Now()
+		weatherDate, _ := cmd.Flags().GetString("weather-date")
+		if weatherDate != "" {
+			date, err = time.ParseInLocation("2006-01-02", weatherDate, time.Local)
+			if err != nil {
+				panic("Weather date not in YYYY-MM-DD format: '" + weatherDate + "'")
+			}
+		}
+
+		weatherFile, _ := cmd.Flags().GetString("weather-file")
+		if weatherFile != "" {
+			provider, err := dataaccess.NewFileWeatherProvider(weatherFile)
+			if err != nil {
+				panic(err)
+			}
+			dataaccess.SetWeatherProvider(provider)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		report, _ := cmd.Flags().GetString("report")
+
 		fmt.Println("Calculating...")
-		phys.CalcPhysics(routeSeg, battery, targSpeed, loopName, loopCount, startTime, cpOneClose, cpTwoClose, cpThreeClose, stageClose)
+		phys.CalcPhysics(cmd.Context(), routeSeg, battery, targSpeed, loopName, loopCount, date, startTime, cpOneClose, cpTwoClose, cpThreeClose, stageClose, output, report)
 	},
 }
 
@@ -80,4 +119,8 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// calcCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	calcCmd.Flags().String("weather-date", "", "Day to simulate, using the weather that happened on it (YYYY-MM-DD, default today)")
+	calcCmd.Flags().String("weather-file", "", "Weather file to read weather from instead of the weather provider")
+	calcCmd.Flags().String("output", "", "File to save the result of every section to, e.g. result.json")
+	calcCmd.Flags().String("report", "", "HTML file to write a report with charts and a map to, e.g. report.html")
 }
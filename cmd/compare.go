@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compares a simulation to what the car actually did",
+	Long: `Compares a simulation to what the car actually did
+
+    Takes a simulation saved with "calc --output" or "event --output" and a telemetry
+    run saved by "telemetry import" of the same itinerary, lines them up by section
+    and reports how far off the simulated time, battery energy and battery % were on
+    each section, e.g.:
+
+        asc-simulation compare --sim result.json --telemetry day1.run.json
+
+    The sections where the simulation was furthest off are listed, every section is
+    written to --sections, and plots along the route are written to ./plots.
+    The actual battery % comes from the state of charge in the log. Sections where
+    it wasn't logged use an estimate from the battery energy the car used instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		simFilePath, _ := cmd.Flags().GetString("sim")
+		runFilePath, _ := cmd.Flags().GetString("telemetry")
+		sectionsFilePath, _ := cmd.Flags().GetString("sections")
+		top, _ := cmd.Flags().GetInt("top")
+
+		if simFilePath == "" || runFilePath == "" {
+			panic("--sim and --telemetry are required")
+		}
+
+		simulation, err := dataaccess.LoadSimulatedRun(simFilePath)
+		if err != nil {
+			panic(err)
+		}
+		run, err := dataaccess.LoadTelemetryRun(runFilePath)
+		if err != nil {
+			panic(err)
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(simulation.Itinerary, routeFolder)
+		if err != nil {
+			panic(err)
+		}
+		runItinerary, err := dataaccess.ParseItinerary(run.Itinerary, routeFolder)
+		if err != nil {
+			panic(err)
+		}
+		if len(runItinerary.Sections) != len(itinerary.Sections) || runItinerary.LengthFt != itinerary.LengthFt {
+			panic("simulation itinerary \"" + simulation.Itinerary + "\" is not the same as telemetry itinerary \"" + run.Itinerary + "\"")
+		}
+
+		comparison, err := phys.CompareRuns(simulation, run, itinerary)
+		if err != nil {
+			panic(err)
+		}
+
+		first := comparison.Sections[0].Section
+		last := comparison.Sections[len(comparison.Sections)-1]
+		fmt.Printf("Compared %d sections from mile %.1f to %.1f", len(comparison.Sections),
+			first.DistanceFt/5280, (last.Section.DistanceFt+last.Section.LengthFt)/5280)
+		if comparison.UnmatchedSectionCount > 0 {
+			fmt.Printf(", %d sections were only in one of them", comparison.UnmatchedSectionCount)
+		}
+		fmt.Println()
+
+		simulatedSeconds, actualSeconds := 0.0, 0.0
+		simulatedWh, actualWh := 0.0, 0.0
+		simulatedPercent, actualPercent := 0.0, 0.0
+		for _, section := range comparison.Sections {
+			simulatedSeconds += section.SimulatedSeconds
+			actualSeconds += section.ActualSeconds
+			simulatedWh += section.SimulatedEnergyWh
+			actualWh += section.ActualEnergyWh
+			simulatedPercent += section.SimulatedBatteryChangePercent
+			actualPercent += section.ActualBatteryChangePercent
+		}
+
+		fmt.Printf("\n%-24s %12s %12s %12s\n", "", "Simulated", "Actual", "Error")
+		fmt.Printf("%-24s %12.1f %12.1f %+12.1f\n", "Time (min)", simulatedSeconds/60, actualSeconds/60, (actualSeconds-simulatedSeconds)/60)
+		fmt.Printf("%-24s %12.0f %12.0f %+12.0f\n", "Battery energy (Wh)", simulatedWh, actualWh, actualWh-simulatedWh)
+		fmt.Printf("%-24s %12.1f %12.1f %+12.1f\n", "Battery change (%)", simulatedPercent, actualPercent, actualPercent-simulatedPercent)
+
+		printWorstSections("energy", comparison.WorstSections(top, phys.SectionComparison.EnergyErrorWh))
+		printWorstSections("time", comparison.WorstSections(top, phys.SectionComparison.TimeErrorSeconds))
+
+		phys.OutputComparisonGraphs(comparison)
+		fmt.Println("\nSaved plots to ./plots")
+
+		if sectionsFilePath != "" {
+			err = writeSectionComparisons(sectionsFilePath, comparison)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Saved sections to", sectionsFilePath)
+		}
+	},
+}
+
+func printWorstSections(errorName string, sections []phys.SectionComparison) {
+	fmt.Printf("\nBiggest %s errors:\n", errorName)
+	fmt.Printf("  %-7s %-32s %7s %16s %18s  %s\n", "Section", "Route", "Mile", "Time (s)", "Energy (Wh)", "Battery error % (so far)")
+	for _, section := range sections {
+		fmt.Printf(
+			"  %-7d %-32s %7.1f %6.0f -> %6.0f %7.0f -> %7.0f %+6.2f (%+.2f)\n",
+			section.Section.Position,
+			section.Section.Leg.Route.Name,
+			section.Section.DistanceFt/5280,
+			section.SimulatedSeconds,
+			section.ActualSeconds,
+			section.SimulatedEnergyWh,
+			section.ActualEnergyWh,
+			section.BatteryErrorPercent(),
+			section.CumulativeBatteryErrorPercent,
+		)
+	}
+}
+
+// Writes every compared section to a CSV file
+func writeSectionComparisons(sectionsFilePath string, comparison *phys.RunComparison) error {
+	file, err := os.Create(sectionsFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{
+		"section", "route", "start_mi", "length_mi",
+		"simulated_s", "actual_s", "time_error_s", "cumulative_time_error_s",
+		"simulated_wh", "actual_wh", "energy_error_wh", "cumulative_energy_error_wh",
+		"simulated_battery_change_percent", "actual_battery_change_percent",
+		"battery_error_percent", "cumulative_battery_error_percent",
+	})
+
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+	for _, section := range comparison.Sections {
+		writer.Write([]string{
+			strconv.Itoa(section.Section.Position),
+			section.Section.Leg.Route.Name,
+			formatFloat(section.Section.DistanceFt / 5280),
+			formatFloat(section.Section.LengthFt / 5280),
+			formatFloat(section.SimulatedSeconds),
+			formatFloat(section.ActualSeconds),
+			formatFloat(section.TimeErrorSeconds()),
+			formatFloat(section.CumulativeTimeErrorSeconds),
+			formatFloat(section.SimulatedEnergyWh),
+			formatFloat(section.ActualEnergyWh),
+			formatFloat(section.EnergyErrorWh()),
+			formatFloat(section.CumulativeEnergyErrorWh),
+			formatFloat(section.SimulatedBatteryChangePercent),
+			formatFloat(section.ActualBatteryChangePercent),
+			formatFloat(section.BatteryErrorPercent()),
+			formatFloat(section.CumulativeBatteryErrorPercent),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().String("routes", "./asc-routes-2024", "Folder containing route files")
+	compareCmd.Flags().String("sim", "", "Simulation result saved with \"calc --output\" or \"event --output\"")
+	compareCmd.Flags().String("telemetry", "", "Telemetry run saved by \"telemetry import\"")
+	compareCmd.Flags().String("sections", "comparison.csv", "CSV file to write every compared section to, empty to skip")
+	compareCmd.Flags().Int("top", 10, "Number of sections with the biggest errors to list")
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/export"
+	"asc-simulation/phys"
+	"asc-simulation/types"
+
+	"github.com/spf13/cobra"
+)
+
+// cuesheetCmd represents the cuesheet command
+var cuesheetCmd = &cobra.Command{
+	Use:   "cuesheet <itinerary> <output file>",
+	Short: "Writes a printable cue sheet for the drivers",
+	Long: `Writes a printable cue sheet for the drivers
+
+    Lists every turn with its mileage along the itinerary, and with a speed plan the
+    speed to aim for, expected time and battery % there. A line is added wherever the
+    target speed changes. The sheet is split at the start of every route and at every
+    checkpoint. The format is picked from the output file's extension (.pdf, .html or .md).
+
+    The speed plan is a simulation saved with "calc --output" or "event --output", or
+    with --simulate the itinerary is simulated first, e.g.:
+
+        asc-simulation event A --battery 90 --output stage-a.json
+        asc-simulation cuesheet A stage-a.pdf --plan stage-a.json \
+            --checkpoint "Hopkinsville=93.4@13:15"
+
+    Checkpoints are given as [NAME=]MILE[@HH:MM], the mile along the itinerary and
+    optionally when it closes.
+
+    See the event command for the itinerary format.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		planFilePath, _ := cmd.Flags().GetString("plan")
+		simulate, _ := cmd.Flags().GetBool("simulate")
+		battery, _ := cmd.Flags().GetFloat64("battery")
+		targSpeed, _ := cmd.Flags().GetFloat64("speed")
+		startTime, _ := cmd.Flags().GetString("start")
+		checkpointFlags, _ := cmd.Flags().GetStringArray("checkpoint")
+
+		if planFilePath != "" && simulate {
+			panic("only one of --plan and --simulate can be used")
+		}
+		// Check the extension before spending time on a simulation
+		_, err := export.CueSheetFormatFromFilePath(args[1])
+		if err != nil {
+			panic(err)
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(args[0], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		var plan *types.SimulatedRun = nil
+		if planFilePath != "" {
+			plan, err = dataaccess.LoadSimulatedRun(planFilePath)
+			if err != nil {
+				panic(err)
+			}
+			planItinerary, err := dataaccess.ParseItinerary(plan.Itinerary, routeFolder)
+			if err != nil {
+				panic(err)
+			}
+			if len(planItinerary.Sections) != len(itinerary.Sections) || planItinerary.LengthFt != itinerary.LengthFt {
+				panic("plan itinerary \"" + plan.Itinerary + "\" is not the same as \"" + args[0] + "\"")
+			}
+		} else if simulate {
+			if !regexp.MustCompile(`^\d{2}\:\d{2}$`).MatchString(startTime) {
+				panic("Start time not in HH:MM format: '" + startTime + "'")
+			}
+			startT, err := parseClockToday(startTime, time.Now())
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Println("Calculating...")
+			result, err := phys.Simulate(itinerary, phys.SimulationOptions{
+				InitialBatteryPercent: battery,
+				TargetSpeedMph:        targSpeed,
+				StartTime:             startT,
+				ShowProgress:          true,
+				Context:               cmd.Context(),
+			})
+			if err != nil {
+				panic(err)
+			}
+			run := phys.NewSimulatedRun(itinerary.Name, args[0], result.Sections)
+			plan = &run
+		}
+
+		// Close times are on the day the plan starts
+		day := time.Now()
+		if plan != nil {
+			day = plan.StartTime
+		}
+		var checkpoints []export.CueCheckpoint
+		for _, flag := range checkpointFlags {
+			checkpoint, err := parseCueCheckpoint(flag, day)
+			if err != nil {
+				panic(err)
+			}
+			if checkpoint.DistanceFt > itinerary.LengthFt {
+				panic("checkpoint \"" + flag + "\" is past the end of the itinerary")
+			}
+			checkpoints = append(checkpoints, checkpoint)
+		}
+
+		sheet := export.NewCueSheet(itinerary, plan, checkpoints)
+		err = export.ExportCueSheet(args[1], sheet)
+		if err != nil {
+			panic(err)
+		}
+
+		cueCount := 0
+		for _, leg := range sheet.Legs {
+			cueCount += len(leg.Cues)
+		}
+		fmt.Println("Wrote", cueCount, "cues in", len(sheet.Legs), "legs to", args[1])
+	},
+}
+
+// Parses [NAME=]MILE[@HH:MM], with the close time on the same day as date
+func parseCueCheckpoint(flag string, date time.Time) (export.CueCheckpoint, error) {
+	checkpoint := export.CueCheckpoint{}
+
+	name, rest, found := strings.Cut(flag, "=")
+	if !found {
+		rest = name
+		name = ""
+	}
+	mileStr, closeStr, hasClose := strings.Cut(rest, "@")
+
+	mile, err := strconv.ParseFloat(strings.TrimSpace(mileStr), 64)
+	if err != nil || mile < 0 {
+		return checkpoint, fmt.Errorf("checkpoint \"%s\" not in [NAME=]MILE[@HH:MM] format", flag)
+	}
+	checkpoint.DistanceFt = mile * 5280
+
+	checkpoint.Name = strings.TrimSpace(name)
+	if checkpoint.Name == "" {
+		checkpoint.Name = fmt.Sprintf("checkpoint at mile %.1f", mile)
+	}
+
+	if hasClose {
+		checkpoint.CloseTime, err = parseClockToday(closeStr, date)
+		if err != nil {
+			return checkpoint, err
+		}
+	}
+
+	return checkpoint, nil
+}
+
+func init() {
+	rootCmd.AddCommand(cuesheetCmd)
+
+	cuesheetCmd.Flags().String("routes", "./asc-routes-2024", "Folder to look for route files in")
+	cuesheetCmd.Flags().String("plan", "", "Simulation saved with --output to take the speeds, times and battery % from")
+	cuesheetCmd.Flags().Bool("simulate", false, "Simulate the itinerary to get the speeds, times and battery %")
+	cuesheetCmd.Flags().Float64("battery", 100, "Initial battery % (with --simulate)")
+	cuesheetCmd.Flags().Float64("speed", 55, "Max target speed in mph (with --simulate)")
+	cuesheetCmd.Flags().String("start", "09:00", "Start time, HH:MM (with --simulate)")
+	cuesheetCmd.Flags().StringArray("checkpoint", nil, "Checkpoint as [NAME=]MILE[@HH:MM], e.g. Hopkinsville=93.4@13:15 (can be repeated)")
+}
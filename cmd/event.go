@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// eventCmd represents the event command
+var eventCmd = &cobra.Command{
+	Use:   "event <itinerary>",
+	Short: "Simulates a multi-day itinerary of stages and loops",
+	Long: `Simulates a multi-day itinerary of stages and loops
+
+    Each day starts with morning static charging, then the day's stages and loops
+    are driven within the driving window, followed by evening static charging and
+    overnight impound. Battery % carries over from one day to the next.
+
+    The itinerary lists routes separated by commas, with days separated by semicolons.
+    A route can be followed by *n to drive it n times, e.g.:
+
+        asc-simulation event "A,AL*3;B,BL*2" --routes ./asc-routes-2024
+
+    Routes are either paths to .route.json files, or routes in the catalog of
+    the --routes folder, found by stage letter ("A" is stage A, "AL" is its
+    loop) or by the start of the file name ("AL_Paducah" matches
+    "AL_Paducah_Loop.route.json").
+
+    The car slows down in strong crosswinds, gusts, heavy rain and low visibility.
+    Set SAFETY_RULES_FILE to a JSON list of rules to change the limits, e.g.:
+
+        [{"Condition": "crosswind", "Threshold": 25, "MaxSpeedMph": 40}]
+
+    Use --output to save the result of every section, to compare with telemetry
+    once the event has been driven (see "compare"), and --report to write an HTML
+    report with charts, a map of the route colored by speed and summary tables.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		battery, _ := cmd.Flags().GetFloat64("battery")
+		targSpeed, _ := cmd.Flags().GetFloat64("speed")
+		date, _ := cmd.Flags().GetString("date")
+
+		startDate := time.Now()
+		if date != "" {
+			var err error
+			startDate, err = time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				panic("Date not in YYYY-MM-DD format: '" + date + "'")
+			}
+		}
+
+		windows := make(map[string]phys.TimeWindow)
+		for _, flag := range []string{"drive", "morning-charge", "evening-charge"} {
+			value, _ := cmd.Flags().GetString(flag)
+			window, err := phys.ParseTimeWindow(value)
+			if err != nil {
+				panic(err)
+			}
+			windows[flag] = window
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(args[0], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println("Calculating...")
+		result, err := phys.SimulateEvent(itinerary, phys.EventOptions{
+			InitialBatteryPercent: battery,
+			TargetSpeedMph:        targSpeed,
+			StartDate:             startDate,
+			DrivingWindow:         windows["drive"],
+			MorningChargingWindow: windows["morning-charge"],
+			EveningChargingWindow: windows["evening-charge"],
+			ShowProgress:          true,
+			Context:               cmd.Context(),
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		for _, day := range result.Days {
+			fmt.Printf(
+				"\nDay %d (%s)  start %5.1f%%  morning charge +%.1f%%\n",
+				day.Day+1,
+				day.Date.Format("2006-01-02"),
+				day.StartBatteryPercent,
+				day.MorningChargePercent,
+			)
+			for _, leg := range day.Legs {
+				lap := ""
+				if leg.Leg.Repetition > 0 {
+					lap = fmt.Sprintf(" (lap %d)", leg.Leg.Repetition)
+				}
+				fmt.Printf(
+					"  %-40s %6.1f mi  %s - %s  battery %5.1f%% -> %5.1f%%  in town %3.0f min\n",
+					leg.Leg.Route.Name+lap,
+					leg.Leg.LengthFt/5280,
+					leg.StartTime.Format("15:04"),
+					leg.EndTime.Format("15:04"),
+					leg.StartBatteryPercent,
+					leg.EndBatteryPercent,
+					leg.UrbanSeconds/60,
+				)
+				for _, summary := range leg.SafetyCaps {
+					fmt.Printf(
+						"      %s for %.1f mi, %.0f min lost\n",
+						phys.DescribeSafetyRule(summary.Rule),
+						summary.DistanceFt/5280,
+						summary.DelaySeconds/60,
+					)
+				}
+			}
+			for _, leg := range day.SkippedLegs {
+				fmt.Printf("  %-40s skipped, would finish after the driving window\n", leg.Route.Name+fmt.Sprintf(" (lap %d)", leg.Repetition))
+			}
+			fmt.Printf(
+				"  %.1f mi driven, evening charge +%.1f%%, end of day battery %.1f%%\n",
+				day.DistanceFt/5280,
+				day.EveningChargePercent,
+				day.EndOfDayBatteryPercent,
+			)
+		}
+		fmt.Printf("\nTotal event miles: %.1f mi\n", result.TotalDistanceFt/5280)
+		fmt.Printf("Final battery: %.1f%%\n", result.FinalBatteryPercent)
+
+		var sections []phys.SectionResult
+		var legs []phys.LegResult
+		for _, day := range result.Days {
+			sections = append(sections, day.Sections...)
+			legs = append(legs, day.Legs...)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output != "" {
+			run := phys.NewSimulatedRun(itinerary.Name, args[0], sections)
+			err = dataaccess.SaveSimulatedRun(output, &run)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Saved result to", output)
+		}
+
+		report, _ := cmd.Flags().GetString("report")
+		if report != "" {
+			err = phys.ExportHtmlReport(report, itinerary.Name, sections, legs)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Wrote report to", report)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(eventCmd)
+
+	eventCmd.Flags().String("routes", "./asc-routes-2024", "Folder to look for route files in")
+	eventCmd.Flags().Float64("battery", 100, "Initial battery %")
+	eventCmd.Flags().Float64("speed", 55, "Max target speed (mph)")
+	eventCmd.Flags().String("date", "", "Date of the first day of the event (YYYY-MM-DD, default today)")
+	eventCmd.Flags().String("drive", "09:00-18:00", "Driving window each day (HH:MM-HH:MM)")
+	eventCmd.Flags().String("morning-charge", "07:00-09:00", "Morning static charging window (HH:MM-HH:MM, empty to skip)")
+	eventCmd.Flags().String("evening-charge", "18:00-20:00", "Evening static charging window (HH:MM-HH:MM, empty to skip)")
+	eventCmd.Flags().String("output", "", "File to save the result of every section to, e.g. result.json")
+	eventCmd.Flags().String("report", "", "HTML file to write a report with charts and a map to, e.g. report.html")
+}
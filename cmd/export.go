@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/export"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <itinerary> <output file>",
+	Short: "Exports routes to GeoJSON, KML or GPX",
+	Long: `Exports routes to GeoJSON, KML or GPX
+
+    The format is picked from the output file's extension (.geojson, .kml or .gpx).
+    Every route section is exported with its grade, speed limit and instruction.
+    With --simulate, the itinerary is simulated first and the planned speed,
+    battery % and energy are added to every section, e.g.:
+
+        asc-simulation export "A,AL*2" stage-a.kml --simulate --start 09:00
+
+    See the event command for the itinerary format.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		simulate, _ := cmd.Flags().GetBool("simulate")
+		battery, _ := cmd.Flags().GetFloat64("battery")
+		targSpeed, _ := cmd.Flags().GetFloat64("speed")
+		startTime, _ := cmd.Flags().GetString("start")
+
+		itinerary, err := dataaccess.ParseItinerary(args[0], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		var result *phys.SimulationResult = nil
+		if simulate {
+			if !regexp.MustCompile(`^\d{2}\:\d{2}$`).MatchString(startTime) {
+				panic("Start time not in HH:MM format: '" + startTime + "'")
+			}
+			startT, err := parseClockToday(startTime, time.Now())
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Println("Calculating...")
+			result, err = phys.Simulate(itinerary, phys.SimulationOptions{
+				InitialBatteryPercent: battery,
+				TargetSpeedMph:        targSpeed,
+				StartTime:             startT,
+				ShowProgress:          true,
+				Context:               cmd.Context(),
+			})
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		err = export.ExportItinerary(args[1], itinerary, result)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Exported", len(itinerary.Sections), "sections to", args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().String("routes", "./asc-routes-2024", "Folder to look for route files in")
+	exportCmd.Flags().Bool("simulate", false, "Simulate the itinerary and export the results along with the route")
+	exportCmd.Flags().Float64("battery", 100, "Initial battery % (with --simulate)")
+	exportCmd.Flags().Float64("speed", 55, "Max target speed in mph (with --simulate)")
+	exportCmd.Flags().String("start", "09:00", "Start time, HH:MM (with --simulate)")
+}
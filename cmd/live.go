@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/phys"
+	"asc-simulation/types"
+
+	"github.com/spf13/cobra"
+)
+
+// liveCmd represents the live command
+var liveCmd = &cobra.Command{
+	Use:   "live <itinerary>",
+	Short: "Recommends a target speed for the rest of the stage from where the car is now",
+	Long: `Recommends a target speed for the rest of the stage from where the car is now
+
+    Finds the car on the itinerary from its GPS position (--lat and --lon) or distance
+    (--distance), fetches fresh weather and simulates the rest of the itinerary at
+    different target speeds. The slowest speed that reaches every remaining checkpoint
+    before it closes is recommended, unless it would leave less than --min-battery.
+
+    Checkpoints are given as the mile along the itinerary and close time, e.g.:
+
+        asc-simulation live A --lat 36.95 --lon -87.48 --battery 72 \
+            --checkpoint 93.4@13:15 --stage-close 17:30
+
+    With --interval, the plan is re-run until stopped. Use --state to read the car's
+    position, battery % and time from a .json file that something else keeps up to date,
+    e.g. {"Time": "...", "BatteryPercent": 72, "Coordinates": {...}, "HasFix": true}.
+
+    See the event command for the itinerary format.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		stateFilePath, _ := cmd.Flags().GetString("state")
+		latitude, _ := cmd.Flags().GetFloat64("lat")
+		longitude, _ := cmd.Flags().GetFloat64("lon")
+		distanceMi, _ := cmd.Flags().GetFloat64("distance")
+		battery, _ := cmd.Flags().GetFloat64("battery")
+		clock, _ := cmd.Flags().GetString("time")
+		checkpointFlags, _ := cmd.Flags().GetStringArray("checkpoint")
+		stageClose, _ := cmd.Flags().GetString("stage-close")
+		minBattery, _ := cmd.Flags().GetFloat64("min-battery")
+		minSpeed, _ := cmd.Flags().GetInt("min-speed")
+		maxSpeed, _ := cmd.Flags().GetInt("max-speed")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		weatherMaxAge, _ := cmd.Flags().GetDuration("weather-max-age")
+
+		hasFix := cmd.Flags().Changed("lat") || cmd.Flags().Changed("lon")
+		if stateFilePath == "" && !hasFix && !cmd.Flags().Changed("distance") {
+			panic("one of --lat and --lon, --distance or --state is required")
+		}
+		if stateFilePath == "" && !cmd.Flags().Changed("battery") {
+			panic("--battery is required without --state")
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(args[0], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		vehicle, err := phys.LoadVehicle()
+		if err != nil {
+			panic(err)
+		}
+		weatherOptions := dataaccess.DefaultWeatherDataOptions()
+		weatherOptions.RefreshTimeSeconds = weatherMaxAge.Seconds()
+		weatherOptions.Context = cmd.Context()
+
+		// The car only moves forward, so later GPS fixes are matched no further back than this
+		lastDistanceFt := 0.0
+
+		for {
+			state := types.CarState{
+				Time:           time.Now(),
+				BatteryPercent: battery,
+				Coordinates:    types.Coordinates{Latitude: latitude, Longitude: longitude},
+				HasFix:         hasFix,
+				DistanceFt:     distanceMi * 5280,
+			}
+			if stateFilePath != "" {
+				loaded, err := dataaccess.LoadCarState(stateFilePath)
+				if err != nil {
+					panicOrReport(err, interval)
+					time.Sleep(interval)
+					continue
+				}
+				state = *loaded
+			} else if clock != "" {
+				state.Time, err = parseClockToday(clock, state.Time)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			err = planLive(cmd.Context(), itinerary, state, &lastDistanceFt, livePlanFlags{
+				checkpoints:    checkpointFlags,
+				stageClose:     stageClose,
+				minBattery:     minBattery,
+				minSpeed:       minSpeed,
+				maxSpeed:       maxSpeed,
+				vehicle:        vehicle,
+				weatherOptions: &weatherOptions,
+			})
+			if err != nil {
+				panicOrReport(err, interval)
+			}
+
+			if interval <= 0 {
+				break
+			}
+			fmt.Printf("\nNext update at %s\n", time.Now().Add(interval).Format("15:04:05"))
+			time.Sleep(interval)
+		}
+	},
+}
+
+// Flags that are the same every time the plan is re-run
+type livePlanFlags struct {
+	checkpoints    []string
+	stageClose     string
+	minBattery     float64
+	minSpeed       int
+	maxSpeed       int
+	vehicle        *types.Vehicle
+	weatherOptions *dataaccess.WeatherDataOptions
+}
+
+// Finds the car on the itinerary, plans the rest of it and prints the plan
+func planLive(ctx context.Context, itinerary *types.Itinerary, state types.CarState, lastDistanceFt *float64, flags livePlanFlags) error {
+	sectionIndex := -1
+	distanceFt := state.DistanceFt
+	if state.HasFix {
+		sectionIndex, distanceFt = dataaccess.LocateOnItinerary(state.Coordinates, itinerary, *lastDistanceFt)
+		if sectionIndex < 0 {
+			return fmt.Errorf("GPS position %.5f, %.5f is not on the itinerary", state.Coordinates.Latitude, state.Coordinates.Longitude)
+		}
+	} else {
+		if distanceFt < 0 || distanceFt >= itinerary.LengthFt {
+			return fmt.Errorf("distance %.1f mi is not on the itinerary", distanceFt/5280)
+		}
+		sectionIndex = sort.Search(len(itinerary.Sections), func(j int) bool {
+			return itinerary.Sections[j].DistanceFt+itinerary.Sections[j].LengthFt > distanceFt
+		})
+	}
+	*lastDistanceFt = distanceFt
+
+	var checkpoints []phys.Checkpoint
+	var names []string
+	for _, flag := range flags.checkpoints {
+		mileStr, closeStr, found := strings.Cut(flag, "@")
+		mile, err := strconv.ParseFloat(strings.TrimSpace(mileStr), 64)
+		if !found || err != nil {
+			return errors.New("checkpoint \"" + flag + "\" not in MILE@HH:MM format")
+		}
+		closeTime, err := parseClockToday(closeStr, state.Time)
+		if err != nil {
+			return err
+		}
+		if mile*5280 > itinerary.LengthFt {
+			return errors.New("checkpoint \"" + flag + "\" is past the end of the itinerary")
+		}
+		// Checkpoints the car has passed don't matter any more
+		if mile*5280 > distanceFt {
+			checkpoints = append(checkpoints, phys.Checkpoint{DistanceFt: mile * 5280, CloseTime: closeTime})
+			names = append(names, fmt.Sprintf("Checkpoint at mile %.1f", mile))
+		}
+	}
+	if flags.stageClose != "" {
+		closeTime, err := parseClockToday(flags.stageClose, state.Time)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, phys.Checkpoint{DistanceFt: itinerary.LengthFt, CloseTime: closeTime})
+		names = append(names, "Finish")
+	}
+
+	section := &itinerary.Sections[sectionIndex]
+	fmt.Printf("\n%s  %s, mile %.1f of %.1f, section %d, battery %.1f%%\n",
+		state.Time.Format("15:04:05"), section.Leg.Route.Name,
+		distanceFt/5280, itinerary.LengthFt/5280, sectionIndex, state.BatteryPercent)
+
+	plan, err := phys.PlanLive(itinerary, phys.LivePlanOptions{
+		SectionIndex:      sectionIndex,
+		BatteryPercent:    state.BatteryPercent,
+		Time:              state.Time,
+		Checkpoints:       checkpoints,
+		MinBatteryPercent: flags.minBattery,
+		MinSpeedMph:       flags.minSpeed,
+		MaxSpeedMph:       flags.maxSpeed,
+		Vehicle:           flags.vehicle,
+		WeatherOptions:    flags.weatherOptions,
+		Context:           ctx,
+	})
+	if err != nil {
+		return err
+	}
+
+	recommended := plan.Recommended
+	fmt.Printf("\nRecommended target speed: %d mph\n", recommended.TargetSpeedMph)
+	fmt.Printf("  Finish at %s with %.1f%% battery\n", recommended.EndTime.Format("15:04"), recommended.FinalBatteryPercent)
+	for i, checkpoint := range checkpoints {
+		arrival := recommended.CheckpointArrivals[i]
+		slack := checkpoint.CloseTime.Sub(arrival)
+		status := fmt.Sprintf("%3.0f min to spare", slack.Minutes())
+		if slack < 0 {
+			status = fmt.Sprintf("%3.0f min LATE", -slack.Minutes())
+		}
+		fmt.Printf("  %-28s arrive %s, closes %s, %s\n", names[i], arrival.Format("15:04"), checkpoint.CloseTime.Format("15:04"), status)
+	}
+	if !recommended.MakesCloseTimes {
+		fmt.Println("  Warning: no speed reaches every checkpoint in time while keeping enough battery")
+	}
+	if !recommended.AboveMinBattery {
+		fmt.Printf("  Warning: battery ends below %.1f%% even at the slowest speed\n", flags.minBattery)
+	}
+
+	fmt.Printf("\n  %5s  %6s  %8s  %-8s %s\n", "Speed", "Finish", "Battery", "On time", "Enough battery")
+	for _, option := range plan.Options {
+		fmt.Printf("  %5d  %6s  %7.1f%%  %-8t %t\n", option.TargetSpeedMph, option.EndTime.Format("15:04"),
+			option.FinalBatteryPercent, option.MakesCloseTimes, option.AboveMinBattery)
+	}
+
+	return nil
+}
+
+// Parses HH:MM as a time on the same day as date
+func parseClockToday(clock string, date time.Time) (time.Time, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return time.Time{}, errors.New("time \"" + clock + "\" not in HH:MM format")
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), parsed.Hour(), parsed.Minute(), 0, 0, date.Location()), nil
+}
+
+// Re-running live plans shouldn't stop because of one bad update, so errors are only printed then
+func panicOrReport(err error, interval time.Duration) {
+	if interval <= 0 {
+		panic(err)
+	}
+	fmt.Println("\nError:", err)
+}
+
+func init() {
+	rootCmd.AddCommand(liveCmd)
+
+	liveCmd.Flags().String("routes", "./asc-routes-2024", "Folder to look for route files in")
+	liveCmd.Flags().String("state", "", "JSON file to read the car's position, battery % and time from on every update")
+	liveCmd.Flags().Float64("lat", 0, "Latitude of the car")
+	liveCmd.Flags().Float64("lon", 0, "Longitude of the car")
+	liveCmd.Flags().Float64("distance", 0, "Miles along the itinerary, if there is no GPS position")
+	liveCmd.Flags().Float64("battery", 0, "Battery % of the car")
+	liveCmd.Flags().String("time", "", "Time it is now (HH:MM, default the clock time)")
+	liveCmd.Flags().StringArray("checkpoint", nil, "Remaining checkpoint as MILE@HH:MM, e.g. 93.4@13:15 (can be repeated)")
+	liveCmd.Flags().String("stage-close", "", "Time the end of the itinerary closes (HH:MM)")
+	liveCmd.Flags().Float64("min-battery", 20, "Battery % that has to be left at the end")
+	liveCmd.Flags().Int("min-speed", 20, "Slowest target speed to consider (mph)")
+	liveCmd.Flags().Int("max-speed", 60, "Fastest target speed to consider (mph)")
+	liveCmd.Flags().Duration("interval", 0, "Re-plan this often, e.g. 5m (default only once)")
+	liveCmd.Flags().Duration("weather-max-age", 15*time.Minute, "Cached weather older than this is fetched again")
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// loopsCmd represents the loops command
+var loopsCmd = &cobra.Command{
+	Use:   "loops <stage> <loop>",
+	Short: "Finds how many loops can be driven after a stage",
+	Long: `Finds how many loops can be driven after a stage
+
+    Simulates the stage, then adds loops one at a time until the car would
+    finish after close time or end the day below the minimum battery %.
+    Prints the finish time and battery % for every loop count, e.g.:
+
+        asc-simulation loops A AL --start 09:00 --close 18:00 --min-battery 30
+
+    Routes are either paths to .route.json files, or routes in the catalog of
+    the --routes folder, found by stage letter ("A" is stage A, "AL" is its
+    loop) or by the start of the file name ("AL_Paducah" matches
+    "AL_Paducah_Loop.route.json").`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		battery, _ := cmd.Flags().GetFloat64("battery")
+		targSpeed, _ := cmd.Flags().GetFloat64("speed")
+		minBattery, _ := cmd.Flags().GetFloat64("min-battery")
+		maxLoops, _ := cmd.Flags().GetInt("max-loops")
+		startTime, _ := cmd.Flags().GetString("start")
+		closeTime, _ := cmd.Flags().GetString("close")
+		eveningCharge, _ := cmd.Flags().GetString("evening-charge")
+
+		// Only the time of day matters, the window gives both times on the same date
+		window, err := phys.ParseTimeWindow(startTime + "-" + closeTime)
+		if err != nil {
+			panic(err)
+		}
+		today := time.Now()
+		midnight := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+
+		eveningChargingWindow, err := phys.ParseTimeWindow(eveningCharge)
+		if err != nil {
+			panic(err)
+		}
+
+		stageFilePath, err := dataaccess.FindRouteFile(args[0], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+		loopFilePath, err := dataaccess.FindRouteFile(args[1], routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println("Calculating...")
+		plan, err := phys.PlanLoops(stageFilePath, loopFilePath, phys.LoopPlanOptions{
+			InitialBatteryPercent: battery,
+			TargetSpeedMph:        targSpeed,
+			StartTime:             midnight.Add(window.Start),
+			CloseTime:             midnight.Add(window.End),
+			MinBatteryPercent:     minBattery,
+			EveningChargingWindow: eveningChargingWindow,
+			MaxLoops:              maxLoops,
+			ShowProgress:          true,
+			Context:               cmd.Context(),
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("%5s  %6s  %9s  %10s  %9s  %8s  %s\n", "Loops", "Finish", "Battery", "End of day", "Miles", "+Time", "")
+		for _, option := range plan.Options {
+			problem := ""
+			if !option.FinishedBeforeClose {
+				problem = "after close"
+			} else if !option.AboveMinBattery {
+				problem = "below min battery"
+			}
+
+			fmt.Printf(
+				"%5d  %6s  %8.1f%%  %9.1f%%  %9.1f  %8s  %s\n",
+				option.Loops,
+				option.EndTime.Format("15:04"),
+				option.BatteryPercent,
+				option.EndOfDayBatteryPercent,
+				option.DistanceFt/5280,
+				option.ExtraLoopDuration.Round(time.Minute),
+				problem,
+			)
+		}
+
+		if plan.MaxLoops < 0 {
+			fmt.Println("The stage alone cannot be finished before close with enough battery")
+		} else if plan.HitLoopLimit {
+			fmt.Println("Max loops:", plan.MaxLoops, "(stopped at --max-loops, more loops may fit)")
+		} else {
+			fmt.Println("Max loops:", plan.MaxLoops)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loopsCmd)
+
+	loopsCmd.Flags().String("routes", "./asc-routes-2024", "Folder to look for route files in")
+	loopsCmd.Flags().Float64("battery", 100, "Initial battery %")
+	loopsCmd.Flags().Float64("speed", 55, "Max target speed (mph)")
+	loopsCmd.Flags().Float64("min-battery", 20, "Battery % that has to be left for the next day")
+	loopsCmd.Flags().Int("max-loops", 20, "Stop after this many loops")
+	loopsCmd.Flags().String("start", "09:00", "Stage start time (HH:MM)")
+	loopsCmd.Flags().String("close", "18:00", "Close time for loops (HH:MM)")
+	loopsCmd.Flags().String("evening-charge", "18:00-20:00", "Evening static charging window (HH:MM-HH:MM, empty to skip)")
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/dataaccess/mockapis"
+
+	"github.com/spf13/cobra"
+)
+
+// mockApisCmd represents the mock-apis command
+var mockApisCmd = &cobra.Command{
+	Use:   "mock-apis",
+	Short: "Runs a local stand-in for the Solcast, OpenRouteService and traffic APIs",
+	Long: `Runs a local stand-in for the Solcast, OpenRouteService and traffic APIs
+
+    Answers with recorded responses from the fixtures folder when it has one for
+    the request, and with made up weather, straight line directions and rush hour
+    traffic otherwise. Traffic comes from --traffic-file instead if it is given.
+    Record fixtures by running any command with HTTP_FIXTURES=record set.
+
+    Point the tool at it by setting (in .env or the environment):
+
+        SOLCAST_URL=http://127.0.0.1:8787/
+        SOLCAST_TOKEN=anything
+        OPEN_ROUTE_SERVICE_URL=http://127.0.0.1:8787/
+        OPEN_ROUTE_SERVICE_TOKEN=anything
+        TRAFFIC_PROVIDER=http
+        TRAFFIC_URL=http://127.0.0.1:8787/`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		address, _ := cmd.Flags().GetString("address")
+		fixtureFolder, _ := cmd.Flags().GetString("fixtures")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		trafficFile, _ := cmd.Flags().GetString("traffic-file")
+
+		server, err := mockapis.NewServer(fixtureFolder)
+		if err != nil {
+			panic(err)
+		}
+		server.Verbose = !quiet
+
+		if trafficFile != "" {
+			server.Traffic, err = dataaccess.NewFileTrafficProvider(trafficFile)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		fmt.Printf("Loaded %d fixtures from %s\n", server.FixtureCount(), fixtureFolder)
+		fmt.Printf("Listening on http://%s/\n", address)
+		fmt.Printf("  SOLCAST_URL=http://%s/\n", address)
+		fmt.Printf("  OPEN_ROUTE_SERVICE_URL=http://%s/\n", address)
+		fmt.Printf("  TRAFFIC_URL=http://%s/\n", address)
+
+		err = http.ListenAndServe(address, server)
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mockApisCmd)
+
+	mockApisCmd.Flags().String("address", "127.0.0.1:8787", "Address to listen on")
+	mockApisCmd.Flags().String("fixtures", "./fixtures", "Folder of recorded responses")
+	mockApisCmd.Flags().Bool("quiet", false, "Don't print every request")
+	mockApisCmd.Flags().String("traffic-file", "", "Traffic file to answer traffic requests from (see TRAFFIC_FILE)")
+}
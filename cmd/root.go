@@ -5,7 +5,12 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"time"
+
+	"asc-simulation/dataaccess/httpclient"
 
 	"github.com/spf13/cobra"
 
@@ -28,17 +33,51 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+
+	// Lets any command record or replay API responses (see httpclient.FixtureTransport)
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := httpclient.UseFixturesFromEnvironment()
+		if err != nil {
+			panic(err)
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	err := rootCmd.ExecuteContext(interruptContext())
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// How long commands get to stop after Ctrl-C before the program exits anyway
+const interruptGracePeriod = 2 * time.Second
+
+/*
+Returns a context that is cancelled by Ctrl-C, which commands pass on so API calls
+and retry waits stop straight away. The program exits after interruptGracePeriod,
+or at the second Ctrl-C, in case the command doesn't stop by itself.
+*/
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+		select {
+		case <-interrupts:
+		case <-time.After(interruptGracePeriod):
+		}
+		os.Exit(130)
+	}()
+
+	return ctx
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+
+	"github.com/spf13/cobra"
+)
+
+// routesCmd represents the routes command
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Imports and lists route files",
+	Long: `Imports and lists route files
+
+    Every route folder has a catalog.json listing the routes in it along with
+    their metadata (stage or loop, stage letter, checkpoints and event year).
+    The catalog is what the other commands and the GUI use to find routes.`,
+}
+
+var routesLsCmd = &cobra.Command{
+	Use:   "ls [folder]",
+	Short: "Lists the routes in a folder",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder := "./asc-routes-2024"
+		if len(args) > 0 {
+			routeFolder = args[0]
+		}
+
+		catalog, err := dataaccess.LoadRouteCatalog(routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("%-6s %-5s %-6s %-34s %-20s %-20s %8s\n", "Stage", "Kind", "Year", "Name", "Start", "End", "Miles")
+		for _, entry := range catalog.Routes {
+			fmt.Printf(
+				"%-6s %-5s %-6d %-34s %-20s %-20s %8.1f\n",
+				entry.Metadata.StageLetter,
+				entry.Metadata.Kind,
+				entry.Metadata.EventYear,
+				entry.Name,
+				entry.Metadata.StartCheckpoint,
+				entry.Metadata.EndCheckpoint,
+				entry.LengthFt/5280,
+			)
+		}
+	},
+}
+
+var routesImportCmd = &cobra.Command{
+	Use:   "import <gpx file> <output folder>",
+	Short: "Creates route files from a .gpx file",
+	Long: `Creates route files from a .gpx file
+
+    Calls OpenRouteService, so OPEN_ROUTE_SERVICE_URL and OPEN_ROUTE_SERVICE_TOKEN must be set.
+    Metadata is guessed from route names like "AL: Paducah Loop", and any
+    metadata flags given replace the guessed values.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		options := dataaccess.RouteImportOptions{
+			Metadata: routeMetadataFromFlags(cmd),
+			Context:  cmd.Context(),
+		}
+
+		routeName, _ := cmd.Flags().GetString("route")
+		if routeName != "" {
+			err := dataaccess.FindAndCreateRoute(routeName, args[0], args[1], options)
+			if err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		errs := dataaccess.CreateRoutes(args[0], args[1], options)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var routesSetCmd = &cobra.Command{
+	Use:   "set <route file>",
+	Short: "Changes the metadata of a route file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := dataaccess.UpdateRouteMetadata(args[0], routeMetadataFromFlags(cmd))
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+var routesIndexCmd = &cobra.Command{
+	Use:   "index [folder]",
+	Short: "Rebuilds the route catalog of a folder",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder := "./asc-routes-2024"
+		if len(args) > 0 {
+			routeFolder = args[0]
+		}
+
+		catalog, err := dataaccess.IndexRoutes(routeFolder)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Indexed", len(catalog.Routes), "routes")
+	},
+}
+
+func routeMetadataFromFlags(cmd *cobra.Command) types.RouteMetadata {
+	kind, _ := cmd.Flags().GetString("kind")
+	stage, _ := cmd.Flags().GetString("stage")
+	start, _ := cmd.Flags().GetString("start")
+	end, _ := cmd.Flags().GetString("end")
+	year, _ := cmd.Flags().GetInt("year")
+	timeZone, _ := cmd.Flags().GetString("time-zone")
+
+	if kind != "" && kind != string(types.StageRoute) && kind != string(types.LoopRoute) {
+		panic("Kind must be \"stage\" or \"loop\", not: '" + kind + "'")
+	}
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		panic("Time zone must be an IANA name like America/Chicago, not: '" + timeZone + "'")
+	}
+
+	return types.RouteMetadata{
+		Kind:            types.RouteKind(kind),
+		StageLetter:     stage,
+		StartCheckpoint: start,
+		EndCheckpoint:   end,
+		EventYear:       year,
+		TimeZone:        timeZone,
+	}
+}
+
+func addRouteMetadataFlags(cmd *cobra.Command) {
+	cmd.Flags().String("kind", "", "Route kind (stage or loop)")
+	cmd.Flags().String("stage", "", "Stage letter, e.g. A")
+	cmd.Flags().String("start", "", "Start checkpoint")
+	cmd.Flags().String("end", "", "End checkpoint")
+	cmd.Flags().Int("year", 0, "Event year")
+	cmd.Flags().String("time-zone", "", "Time zone at the start of the route, e.g. America/Chicago")
+}
+
+func init() {
+	rootCmd.AddCommand(routesCmd)
+	routesCmd.AddCommand(routesLsCmd, routesImportCmd, routesSetCmd, routesIndexCmd)
+
+	addRouteMetadataFlags(routesImportCmd)
+	addRouteMetadataFlags(routesSetCmd)
+	routesImportCmd.Flags().String("route", "", "Only import the route or track with this name")
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/dataaccess/telemetryserver"
+	"asc-simulation/types"
+
+	"github.com/spf13/cobra"
+)
+
+// telemetryCmd represents the telemetry command
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Manages telemetry logged by the car",
+}
+
+var telemetryImportCmd = &cobra.Command{
+	Use:   "import <log file>...",
+	Short: "Matches CSV or NDJSON telemetry logs to an itinerary and saves them as a run",
+	Long: `Matches CSV or NDJSON telemetry logs to an itinerary and saves them as a run
+
+    Logs need a time column, plus any of speed, bus current and voltage, array power
+    and GPS latitude and longitude. Several logs (e.g. one per logger restart) are
+    joined into one run. Every GPS fix is matched to a section of the itinerary, and
+    the time, speed and energy on each section is added up so the run can be compared
+    to a simulation of the same itinerary, e.g.:
+
+        asc-simulation telemetry import day1.csv --route "A,AL*2" --output day1.run.json
+
+    See the event command for the itinerary format.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		routeSpec, _ := cmd.Flags().GetString("route")
+		output, _ := cmd.Flags().GetString("output")
+		name, _ := cmd.Flags().GetString("name")
+
+		if routeSpec == "" {
+			panic("--route is required")
+		}
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+		if output == "" {
+			output = name + ".run.json"
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(routeSpec, routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		samples := make([]types.TelemetrySample, 0)
+		for _, logFilePath := range args {
+			logSamples, err := dataaccess.ReadTelemetryLog(logFilePath)
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Printf("%s: %d samples, %s\n", logFilePath, len(logSamples),
+				formatTimeRange(logSamples[0].Time, logSamples[len(logSamples)-1].Time))
+			samples = append(samples, logSamples...)
+		}
+		// Logs can overlap or be given out of order
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Time.Before(samples[j].Time)
+		})
+
+		run := dataaccess.MatchTelemetry(name, samples, itinerary)
+
+		distanceFt := 0.0
+		busEnergyWh, arrayEnergyWh := 0.0, 0.0
+		for _, section := range run.Sections {
+			distanceFt += itinerary.Sections[section.SectionIndex].LengthFt
+			busEnergyWh += section.BusEnergyWh
+			arrayEnergyWh += section.ArrayEnergyWh
+		}
+
+		fmt.Printf("\nMatched %d of %d samples to %d of %d sections (%.1f of %.1f mi)\n",
+			len(samples)-run.UnmatchedSampleCount, len(samples),
+			len(run.Sections), len(itinerary.Sections),
+			distanceFt/5280, itinerary.LengthFt/5280)
+		fmt.Printf("Battery energy used: %.0f Wh, array energy: %.0f Wh\n", busEnergyWh, arrayEnergyWh)
+
+		err = dataaccess.SaveTelemetryRun(output, &run)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Saved run to", output)
+	},
+}
+
+var telemetryServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Receives live telemetry from the radio bridge and keeps the car's latest state",
+	Long: `Receives live telemetry from the radio bridge and keeps the car's latest state
+
+    Frames are one JSON object per line, with the same fields as an NDJSON telemetry
+    log (see "telemetry import"), plus the battery % as "battery_percent", e.g.:
+
+        {"time": "2026-07-01T10:15:02-05:00", "speed_mph": 41.5, "bus_current_a": 12.1,
+         "bus_voltage_v": 101.2, "array_power_w": 640, "battery_percent": 78.4,
+         "lat": 36.95, "lon": -87.48}
+
+    They can be sent as UDP datagrams, over TCP, or POSTed to /telemetry. Fields left
+    out of a frame keep their last value. The latest state is served at /state and
+    written to --state-file after every frame, for "live --state" and the GUI, e.g.:
+
+        asc-simulation telemetry serve --route A --record day1.ndjson
+        asc-simulation live A --state http://127.0.0.1:8790/state --interval 5m
+
+    Use "telemetry replay" to play a recorded log into it for testing.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		routeSpec, _ := cmd.Flags().GetString("route")
+		httpAddress, _ := cmd.Flags().GetString("http")
+		udpAddress, _ := cmd.Flags().GetString("udp")
+		tcpAddress, _ := cmd.Flags().GetString("tcp")
+		stateFilePath, _ := cmd.Flags().GetString("state-file")
+		recordFilePath, _ := cmd.Flags().GetString("record")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+
+		server := telemetryserver.NewServer()
+		server.StateFilePath = stateFilePath
+		server.Verbose = !quiet
+
+		if routeSpec != "" {
+			itinerary, err := dataaccess.ParseItinerary(routeSpec, routeFolder)
+			if err != nil {
+				panic(err)
+			}
+			server.Itinerary = itinerary
+		}
+
+		if recordFilePath != "" {
+			recordFile, err := os.OpenFile(recordFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				panic(err)
+			}
+			defer recordFile.Close()
+			server.Record = recordFile
+		}
+
+		// Whichever listener fails first stops the server
+		errs := make(chan error, 3)
+		if udpAddress != "" {
+			fmt.Printf("Listening for UDP frames on %s\n", udpAddress)
+			go func() { errs <- server.ServeUdp(udpAddress) }()
+		}
+		if tcpAddress != "" {
+			fmt.Printf("Listening for TCP frames on %s\n", tcpAddress)
+			go func() { errs <- server.ServeTcp(tcpAddress) }()
+		}
+		if httpAddress != "" {
+			fmt.Printf("Listening on http://%s/ (POST /telemetry, GET /state, GET /sample)\n", httpAddress)
+			go func() { errs <- http.ListenAndServe(httpAddress, server) }()
+		}
+		if udpAddress == "" && tcpAddress == "" && httpAddress == "" {
+			panic("at least one of --http, --udp and --tcp is needed")
+		}
+
+		panic(<-errs)
+	},
+}
+
+var telemetryReplayCmd = &cobra.Command{
+	Use:   "replay <log file>...",
+	Short: "Plays a recorded telemetry log into a telemetry server",
+	Long: `Plays a recorded telemetry log into a telemetry server
+
+    Sends the samples of CSV or NDJSON logs to "telemetry serve" as frames, spaced out
+    like they were logged, to test live mode without the car, e.g.:
+
+        asc-simulation telemetry replay day1.csv --speed 10 --retime
+
+    --to is udp://host:port, tcp://host:port or the server's /telemetry URL.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetString("to")
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		retime, _ := cmd.Flags().GetBool("retime")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+
+		samples := make([]types.TelemetrySample, 0)
+		for _, logFilePath := range args {
+			logSamples, err := dataaccess.ReadTelemetryLog(logFilePath)
+			if err != nil {
+				panic(err)
+			}
+			samples = append(samples, logSamples...)
+		}
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Time.Before(samples[j].Time)
+		})
+
+		duration := samples[len(samples)-1].Time.Sub(samples[0].Time)
+		if speed > 0 {
+			duration = time.Duration(float64(duration) / speed)
+		} else {
+			duration = 0
+		}
+		fmt.Printf("Replaying %d samples to %s, taking %s\n", len(samples), target, duration.Round(time.Second))
+
+		err := telemetryserver.Replay(samples, target, telemetryserver.ReplayOptions{
+			Speed:   speed,
+			Retime:  retime,
+			Verbose: !quiet,
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Done")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(telemetryCmd)
+	telemetryCmd.AddCommand(telemetryImportCmd)
+	telemetryCmd.AddCommand(telemetryServeCmd)
+	telemetryCmd.AddCommand(telemetryReplayCmd)
+
+	telemetryImportCmd.Flags().String("routes", "./asc-routes-2024", "Folder containing route files")
+	telemetryImportCmd.Flags().String("route", "", "Itinerary the logs were driven on, e.g. \"A,AL*2\"")
+	telemetryImportCmd.Flags().String("output", "", "Run file to write (default <name>.run.json)")
+	telemetryImportCmd.Flags().String("name", "", "Name of the run (default the first log's file name)")
+
+	telemetryServeCmd.Flags().String("routes", "./asc-routes-2024", "Folder containing route files")
+	telemetryServeCmd.Flags().String("route", "", "Itinerary being driven, to work out the car's distance along it (optional)")
+	telemetryServeCmd.Flags().String("http", "127.0.0.1:8790", "Address to serve HTTP on, empty to skip")
+	telemetryServeCmd.Flags().String("udp", "127.0.0.1:8791", "Address to listen for UDP frames on, empty to skip")
+	telemetryServeCmd.Flags().String("tcp", "127.0.0.1:8792", "Address to listen for TCP frames on, empty to skip")
+	telemetryServeCmd.Flags().String("state-file", "car-state.json", "File to write the latest state to, empty to skip")
+	telemetryServeCmd.Flags().String("record", "", "NDJSON file to append every frame to, to import later")
+	telemetryServeCmd.Flags().Bool("quiet", false, "Don't print every frame")
+
+	telemetryReplayCmd.Flags().String("to", "http://127.0.0.1:8790/telemetry", "Telemetry server to send frames to")
+	telemetryReplayCmd.Flags().Float64("speed", 1, "How many times faster than real time to play the log back, 0 for all at once")
+	telemetryReplayCmd.Flags().Bool("retime", false, "Move the sample times so the log starts now")
+	telemetryReplayCmd.Flags().Bool("quiet", false, "Don't print every frame")
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// vehicleCmd represents the vehicle command
+var vehicleCmd = &cobra.Command{
+	Use:   "vehicle",
+	Short: "Manages the vehicle parameters used by the simulation",
+	Long: `Manages the vehicle parameters used by the simulation
+
+    Simulations read the vehicle from VEHICLE_FILE, or vehicle.json if it isn't set.
+    Without a vehicle file, best guesses are used.`,
+}
+
+var vehicleFitCmd = &cobra.Command{
+	Use:   "fit <run file>...",
+	Short: "Fits CdA, rolling resistance and efficiencies to telemetry runs",
+	Long: `Fits CdA, rolling resistance and efficiencies to telemetry runs
+
+    Uses runs saved by "telemetry import". CdA, rolling resistance and drivetrain
+    efficiency are fitted to the power the motor drew, and array efficiency to the
+    power the array made under the weather on the day, by least squares, e.g.:
+
+        asc-simulation vehicle fit day1.run.json day2.run.json
+
+    The fitted values are saved to the vehicle file, and the measured and modelled
+    power of every sample is written to --residuals. Weather comes from the weather
+    provider, so use WEATHER_PROVIDER=file with imported weather for past days.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		vehicleFilePath, _ := cmd.Flags().GetString("vehicle")
+		residualsFilePath, _ := cmd.Flags().GetString("residuals")
+		if vehicleFilePath == "" {
+			vehicleFilePath = phys.VehicleFilePath()
+		}
+
+		vehicle := phys.DefaultVehicle()
+		existing, err := dataaccess.GetVehicle(vehicleFilePath)
+		if err == nil {
+			vehicle = *existing
+		} else if !errors.Is(err, os.ErrNotExist) {
+			panic(err)
+		}
+
+		runs := make([]phys.FitRun, 0, len(args))
+		for _, runFilePath := range args {
+			run, err := dataaccess.LoadTelemetryRun(runFilePath)
+			if err != nil {
+				panic(err)
+			}
+			itinerary, err := dataaccess.ParseItinerary(run.Itinerary, routeFolder)
+			if err != nil {
+				panic(err)
+			}
+			runs = append(runs, phys.FitRun{Run: run, Itinerary: itinerary})
+		}
+
+		fmt.Println("Fitting...")
+		fit, err := phys.FitVehicle(cmd.Context(), runs, vehicle)
+		if err != nil {
+			panic(err)
+		}
+
+		before := fit.InitialVehicle
+		fmt.Printf("\n%-30s %10s %10s\n", "", "Before", "Fitted")
+		fmt.Printf("%-30s %10.4f %10.4f\n", "CdA (m^2)", before.DragAreaM2, fit.Vehicle.DragAreaM2)
+		fmt.Printf("%-30s %10.5f %10.5f\n", "Rolling resistance (Crr)", before.RollingResistanceCoefficient, fit.Vehicle.RollingResistanceCoefficient)
+		fmt.Printf("%-30s %10.3f %10.3f\n", "Drivetrain efficiency", before.DrivetrainEfficiency, fit.Vehicle.DrivetrainEfficiency)
+		fmt.Printf("%-30s %10.3f %10.3f\n", "Array efficiency", before.ArrayEfficiency, fit.Vehicle.ArrayEfficiency)
+
+		fmt.Printf("\nDrivetrain: %d samples, RMS residual %.0f W, R^2 %.3f\n",
+			len(fit.DrivetrainResiduals), fit.DrivetrainRmsW, fit.DrivetrainRSquared)
+		fmt.Printf("Array: %d sections, RMS residual %.0f W, R^2 %.3f\n",
+			len(fit.ArrayResiduals), fit.ArrayRmsW, fit.ArrayRSquared)
+
+		err = dataaccess.UpdateVehicle(vehicleFilePath, &fit.Vehicle)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("\nSaved vehicle to", vehicleFilePath)
+
+		if residualsFilePath != "" {
+			err = writeFitResiduals(residualsFilePath, fit)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Saved residuals to", residualsFilePath)
+		}
+	},
+}
+
+// Writes every residual to a CSV file, drivetrain first, then array
+func writeFitResiduals(residualsFilePath string, fit *phys.VehicleFit) error {
+	file, err := os.Create(residualsFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"fit", "run", "time", "section", "speed_mph", "measured_w", "modelled_w", "residual_w"})
+
+	writeResiduals := func(name string, residuals []phys.FitResidual) {
+		for _, residual := range residuals {
+			writer.Write([]string{
+				name,
+				residual.Run,
+				residual.Time.Format(time.RFC3339),
+				strconv.Itoa(residual.SectionIndex),
+				strconv.FormatFloat(residual.SpeedMph, 'f', 1, 64),
+				strconv.FormatFloat(residual.MeasuredW, 'f', 1, 64),
+				strconv.FormatFloat(residual.ModelledW, 'f', 1, 64),
+				strconv.FormatFloat(residual.MeasuredW-residual.ModelledW, 'f', 1, 64),
+			})
+		}
+	}
+	writeResiduals("drivetrain", fit.DrivetrainResiduals)
+	writeResiduals("array", fit.ArrayResiduals)
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(vehicleCmd)
+	vehicleCmd.AddCommand(vehicleFitCmd)
+
+	vehicleFitCmd.Flags().String("routes", "./asc-routes-2024", "Folder containing route files")
+	vehicleFitCmd.Flags().String("vehicle", "", "Vehicle file to update (default $VEHICLE_FILE, or vehicle.json)")
+	vehicleFitCmd.Flags().String("residuals", "fit-residuals.csv", "CSV file to write the residuals to, empty to skip")
+}
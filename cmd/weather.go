@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/dataaccess/solcast"
+	"asc-simulation/phys"
+
+	"github.com/spf13/cobra"
+)
+
+// weatherCmd represents the weather command
+var weatherCmd = &cobra.Command{
+	Use:   "weather",
+	Short: "Manages weather data",
+}
+
+var weatherCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Lists, clears and shares the weather cache",
+	Long: `Lists, clears and shares the weather cache
+
+    Weather is cached per route and weather provider, with each route split into
+    chunks of about 2 miles. Every chunk holds the weather over time.
+    Use export and import to copy the cache to a laptop that will be offline.`,
+}
+
+var weatherCacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists the cached routes",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := dataaccess.ListWeatherCache()
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println("Weather cache:", dataaccess.WeatherCacheFolder())
+		fmt.Printf("%-50s %9s %8s %-33s %10s\n", "Name", "Coverage", "Samples", "Weather from", "Age")
+		for _, entry := range entries {
+			fmt.Printf(
+				"%-50s %4d/%-4d %8d %-33s %10s\n",
+				entry.Name,
+				entry.CoveredChunkCount,
+				entry.ChunkCount,
+				entry.SampleCount,
+				formatTimeRange(entry.FirstValidAt, entry.LastValidAt),
+				formatAge(entry.LastCollectedAt),
+			)
+		}
+	},
+}
+
+var weatherCacheShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Shows the coverage and age of every chunk of a cached route",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, err := dataaccess.FindWeatherCacheEntry(args[0])
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println(entry.Name)
+		fmt.Println(entry.FilePath)
+		fmt.Printf("%d of %d chunks have weather, %d samples, %.1f KB\n\n",
+			entry.CoveredChunkCount, entry.ChunkCount, entry.SampleCount, float64(entry.SizeBytes)/1024)
+
+		fmt.Printf("%-11s %7s %8s %-33s %10s\n", "Sections", "Miles", "Samples", "Weather from", "Age")
+		for _, chunk := range entry.Chunks {
+			fmt.Printf(
+				"%-11s %7.1f %8d %-33s %10s\n",
+				fmt.Sprintf("%d-%d", chunk.StartSectionIndex, chunk.EndSectionIndex),
+				chunk.LengthFt/5280,
+				chunk.SampleCount,
+				formatTimeRange(chunk.FirstValidAt, chunk.LastValidAt),
+				formatAge(chunk.LastCollectedAt),
+			)
+		}
+	},
+}
+
+// How old cached weather can be before prefetch fetches it again, and clear removes it
+const weatherPrefetchMaxAge = 24 * time.Hour
+
+var weatherCacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Removes expired weather from the cache",
+	Long: `Removes expired weather from the cache
+
+    Weather is expired when it was collected (downloaded) longer ago than --max-age,
+    no matter what time the weather is for. The default matches the window
+    "weather prefetch" uses, so clearing never removes weather prefetch would keep.
+    Files with no weather left are deleted. Use --all to remove everything.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		name, _ := cmd.Flags().GetString("name")
+		maxAge, _ := cmd.Flags().GetDuration("max-age")
+
+		// A max age of 0 expires everything, which should be asked for on purpose
+		if !all && maxAge <= 0 {
+			panic("--max-age must be more than 0, use --all to remove all weather")
+		}
+
+		removedSamples, removedFiles, err := dataaccess.ClearWeatherCache(dataaccess.WeatherCacheClearOptions{
+			All:           all,
+			Name:          name,
+			MaxAgeSeconds: maxAge.Seconds(),
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Removed %d samples and %d files\n", removedSamples, removedFiles)
+	},
+}
+
+var weatherCacheExportCmd = &cobra.Command{
+	Use:   "export <zip file>",
+	Short: "Bundles the weather cache into a zip file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		count, err := dataaccess.ExportWeatherCache(args[0])
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Exported %d cached routes to %s\n", count, args[0])
+	},
+}
+
+var weatherCacheImportCmd = &cobra.Command{
+	Use:   "import <zip file>",
+	Short: "Adds the weather from an exported zip file to the cache",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		count, err := dataaccess.ImportWeatherCache(args[0])
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Imported %d cached routes\n", count)
+	},
+}
+
+var weatherPrefetchCmd = &cobra.Command{
+	Use:   "prefetch",
+	Short: "Fills the weather cache for routes before race day",
+	Long: `Fills the weather cache for routes before race day
+
+    Fetches weather for every ~2 mile chunk of each route over the driving window,
+    so simulations don't call the weather API. Chunks that already have recent enough
+    weather are skipped. Routes are given like the event command's itinerary, with
+    days separated by semicolons, e.g.:
+
+        asc-simulation weather prefetch --route "A,AL;B,BL" --date 2024-07-20 --from 08:00 --to 18:00
+
+    Every route of a day uses that day's date, starting at --date.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		routeFolder, _ := cmd.Flags().GetString("routes")
+		routeSpec, _ := cmd.Flags().GetString("route")
+		date, _ := cmd.Flags().GetString("date")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		budget, _ := cmd.Flags().GetInt("budget")
+
+		if routeSpec == "" {
+			panic("--route is required")
+		}
+
+		startDate := time.Now()
+		if date != "" {
+			var err error
+			startDate, err = time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				panic("Date not in YYYY-MM-DD format: '" + date + "'")
+			}
+		}
+		midnight := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, time.Local)
+
+		window, err := phys.ParseTimeWindow(from + "-" + to)
+		if err != nil {
+			panic(err)
+		}
+
+		itinerary, err := dataaccess.ParseItinerary(routeSpec, routeFolder)
+		if err != nil {
+			panic(err)
+		}
+
+		// Loops driven several times only need fetching once per day
+		requests := make([]dataaccess.WeatherPrefetchRequest, 0)
+		fetched := make(map[string]bool)
+		for _, leg := range itinerary.Legs {
+			key := fmt.Sprintf("%d %s", leg.Day, leg.Route.Name)
+			if fetched[key] {
+				continue
+			}
+			fetched[key] = true
+
+			day := midnight.AddDate(0, 0, leg.Day)
+			requests = append(requests, dataaccess.WeatherPrefetchRequest{
+				Route: leg.Route,
+				Start: day.Add(window.Start),
+				End:   day.Add(window.End),
+			})
+		}
+
+		options := dataaccess.DefaultWeatherDataOptions()
+		maxAge, _ := cmd.Flags().GetDuration("max-age")
+		options.RefreshTimeSeconds = maxAge.Seconds()
+		options.Context = cmd.Context()
+
+		fmt.Println("Fetching weather...")
+		result, err := dataaccess.PrefetchWeather(requests, dataaccess.WeatherPrefetchOptions{
+			MaxApiCalls:    budget,
+			WeatherOptions: options,
+		})
+		if result != nil {
+			fmt.Printf("API calls used: %d", result.ApiCalls)
+			if budget > 0 {
+				fmt.Printf(" of %d", budget)
+			}
+			fmt.Printf("\nChunks fetched: %d\nChunks already cached: %d\n", result.FetchedChunks, result.CachedChunks)
+
+			if len(result.Unfinished) > 0 {
+				fmt.Println("\nStopped at the API call budget. Not fetched yet:")
+				for i, request := range result.Unfinished {
+					from := ""
+					if i == 0 && result.StoppedAtFt > 0 {
+						from = fmt.Sprintf(" from mile %.1f", result.StoppedAtFt/5280)
+					}
+					fmt.Printf("  %s  %s%s\n", request.Start.Format("2006-01-02"), request.Route.Name, from)
+				}
+				fmt.Println("Run the same command again to fetch the rest. Chunks that were fetched are skipped.")
+			}
+		}
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+var weatherQuotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Shows how many Solcast API calls have been used",
+	Long: `Shows how many Solcast API calls have been used
+
+    Calls are counted per API key and UTC day. Set SOLCAST_DAILY_BUDGET to stop
+    making calls once that many have been made in a day, and
+    SOLCAST_REQUESTS_PER_MINUTE to change how quickly calls are made.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		usage, err := solcast.GetUsage()
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println("API key:", usage.KeyId)
+		if usage.DailyBudget > 0 {
+			fmt.Printf("Today (%s UTC): %d of %d calls used, %d left\n",
+				usage.Date, usage.CallsToday, usage.DailyBudget, max(0, usage.DailyBudget-usage.CallsToday))
+		} else {
+			fmt.Printf("Today (%s UTC): %d calls used, no daily budget set\n", usage.Date, usage.CallsToday)
+		}
+
+		if len(usage.History) > 0 {
+			fmt.Println("\nPrevious days:")
+			for _, day := range usage.History[:min(7, len(usage.History))] {
+				fmt.Printf("  %s  %d\n", day.Date, day.Calls)
+			}
+		}
+	},
+}
+
+var weatherImportCmd = &cobra.Command{
+	Use:   "import <csv file>...",
+	Short: "Imports measured weather from NSRDB or ERA5 CSV files",
+	Long: `Imports measured weather from NSRDB or ERA5 CSV files
+
+    Adds the weather to a weather file (--output, or WEATHER_FILE if it is set), so
+    past race days can be simulated with the weather that actually happened:
+
+        asc-simulation weather import nsrdb-paducah-2024.csv era5-2024-07.csv
+        WEATHER_PROVIDER=file WEATHER_FILE=weather.json asc-simulation calc ... --weather-date 2024-07-20
+
+    Each location in the CSV files becomes a weather station. Routes use the closest one.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			output = os.Getenv("WEATHER_FILE")
+		}
+		if output == "" {
+			output = "weather.json"
+		}
+
+		array, err := dataaccess.GetSolarArray()
+		if err != nil {
+			panic(err)
+		}
+
+		stations := make([]dataaccess.WeatherStation, 0)
+		for _, csvFilePath := range args {
+			fileStations, format, err := dataaccess.ReadWeatherCsv(csvFilePath, array)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, station := range fileStations {
+				first, last := station.Samples[0].ValidAt, station.Samples[len(station.Samples)-1].ValidAt
+				fmt.Printf("%s (%s): %s at %.4f, %.4f, %d samples, %s\n",
+					csvFilePath, format, station.Name, station.Coordinates.Latitude, station.Coordinates.Longitude,
+					len(station.Samples), formatTimeRange(first, last))
+			}
+			stations = append(stations, fileStations...)
+		}
+
+		err = dataaccess.AddToWeatherFile(output, stations)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Added %d weather stations to %s\n", len(stations), output)
+	},
+}
+
+func formatTimeRange(from time.Time, to time.Time) string {
+	if from.IsZero() {
+		return "-"
+	}
+	return from.Local().Format("01-02 15:04") + " to " + to.Local().Format("01-02 15:04")
+}
+
+func formatAge(collectedAt time.Time) string {
+	if collectedAt.IsZero() {
+		return "-"
+	}
+	return time.Since(collectedAt).Round(time.Minute).String()
+}
+
+func init() {
+	rootCmd.AddCommand(weatherCmd)
+	weatherCmd.AddCommand(weatherCacheCmd, weatherPrefetchCmd, weatherQuotaCmd, weatherImportCmd)
+	weatherCacheCmd.AddCommand(
+		weatherCacheLsCmd,
+		weatherCacheShowCmd,
+		weatherCacheClearCmd,
+		weatherCacheExportCmd,
+		weatherCacheImportCmd,
+	)
+
+	weatherCacheClearCmd.Flags().Bool("all", false, "Remove all weather, not just expired weather")
+	weatherCacheClearCmd.Flags().String("name", "", "Only clear routes whose cache name contains this")
+	weatherCacheClearCmd.Flags().Duration("max-age", weatherPrefetchMaxAge, "Weather collected longer ago than this is expired")
+
+	weatherPrefetchCmd.Flags().String("routes", "./asc-routes-2024", "Folder containing route files")
+	weatherPrefetchCmd.Flags().String("route", "", "Routes to fetch weather for, e.g. \"A,AL;B,BL\"")
+	weatherPrefetchCmd.Flags().String("date", "", "Date of the first day (YYYY-MM-DD), defaults to today")
+	weatherPrefetchCmd.Flags().String("from", "08:00", "Start of the driving window (HH:MM)")
+	weatherPrefetchCmd.Flags().String("to", "18:00", "End of the driving window (HH:MM)")
+	weatherPrefetchCmd.Flags().Int("budget", 0, "Most weather API calls to make, 0 for no limit")
+	weatherPrefetchCmd.Flags().Duration("max-age", weatherPrefetchMaxAge, "Cached weather collected longer ago than this is fetched again")
+
+	weatherImportCmd.Flags().String("output", "", "Weather file to add to (default $WEATHER_FILE, or weather.json)")
+}
@@ -0,0 +1,214 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Every folder of route files can have one of these, listing the routes in it
+const routeCatalogFileName string = "catalog.json"
+
+type RouteCatalogEntry struct {
+	// Relative to the folder the catalog is in
+	FileName     string
+	Name         string
+	Metadata     types.RouteMetadata
+	LengthFt     float64
+	SectionCount int
+}
+
+/*
+Index of the route files in a folder, so routes can be listed and found by
+their metadata without loading every route file.
+*/
+type RouteCatalog struct {
+	Routes []RouteCatalogEntry
+}
+
+// Returns the routes of the given kind, in catalog order.
+func (catalog *RouteCatalog) RoutesOfKind(kind types.RouteKind) []RouteCatalogEntry {
+	var routes []RouteCatalogEntry
+	for _, entry := range catalog.Routes {
+		if entry.Metadata.Kind == kind {
+			routes = append(routes, entry)
+		}
+	}
+	return routes
+}
+
+/*
+Finds a route by its stage letter, where loops have an extra "L" (e.g. "A" is
+stage A and "AL" is stage A's loop), or by its file name without the extension.
+Returns nil if nothing matches.
+*/
+func (catalog *RouteCatalog) Find(routeName string) *RouteCatalogEntry {
+	for i, entry := range catalog.Routes {
+		letter := entry.Metadata.StageLetter
+		if letter != "" &&
+			((entry.Metadata.Kind == types.StageRoute && routeName == letter) ||
+				(entry.Metadata.Kind == types.LoopRoute && routeName == letter+"L")) {
+			return &catalog.Routes[i]
+		}
+	}
+
+	for i, entry := range catalog.Routes {
+		if strings.TrimSuffix(entry.FileName, routeFileExtension) == routeName || entry.Name == routeName {
+			return &catalog.Routes[i]
+		}
+	}
+
+	return nil
+}
+
+/*
+Loads the route catalog in the given folder.
+If the folder has no catalog yet, one is built from the route files in memory (but not saved).
+*/
+func LoadRouteCatalog(routeFolder string) (*RouteCatalog, error) {
+	functionErrMsg := errors.New("error loading route catalog")
+
+	file, err := os.Open(filepath.Join(routeFolder, routeCatalogFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return buildRouteCatalog(routeFolder)
+		}
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var catalog RouteCatalog
+
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&catalog)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	return &catalog, nil
+}
+
+/*
+Rebuilds the route catalog from every route file in the folder and saves it.
+Only needs to be called if route files were added or edited by hand;
+importing routes keeps the catalog up to date.
+*/
+func IndexRoutes(routeFolder string) (*RouteCatalog, error) {
+	catalog, err := buildRouteCatalog(routeFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveRouteCatalog(routeFolder, catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+func buildRouteCatalog(routeFolder string) (*RouteCatalog, error) {
+	functionErrMsg := errors.New("error building route catalog")
+
+	files, err := os.ReadDir(routeFolder)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	var catalog RouteCatalog
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), routeFileExtension) {
+			continue
+		}
+
+		route, err := LoadRoute(filepath.Join(routeFolder, file.Name()))
+		if err != nil {
+			return nil, errors.Join(functionErrMsg, err)
+		}
+
+		catalog.Routes = append(catalog.Routes, createRouteCatalogEntry(file.Name(), route))
+	}
+
+	sortRouteCatalog(&catalog)
+	return &catalog, nil
+}
+
+// Adds or replaces a single route in the catalog of the folder it is saved in.
+func updateRouteCatalog(routeFolder string, routeFilePath string, route *types.Route) error {
+	catalog, err := LoadRouteCatalog(routeFolder)
+	if err != nil {
+		return err
+	}
+
+	entry := createRouteCatalogEntry(filepath.Base(routeFilePath), route)
+
+	replaced := false
+	for i := range catalog.Routes {
+		if catalog.Routes[i].FileName == entry.FileName {
+			catalog.Routes[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		catalog.Routes = append(catalog.Routes, entry)
+	}
+
+	sortRouteCatalog(catalog)
+	return saveRouteCatalog(routeFolder, catalog)
+}
+
+func createRouteCatalogEntry(fileName string, route *types.Route) RouteCatalogEntry {
+	entry := RouteCatalogEntry{
+		FileName:     fileName,
+		Name:         route.Name,
+		Metadata:     route.Metadata,
+		SectionCount: len(route.Sections),
+	}
+
+	for _, section := range route.Sections {
+		entry.LengthFt += section.LengthFt
+	}
+
+	return entry
+}
+
+// Orders routes by stage, with each stage's loop right after it
+func sortRouteCatalog(catalog *RouteCatalog) {
+	sort.SliceStable(catalog.Routes, func(i, j int) bool {
+		first, second := catalog.Routes[i].Metadata, catalog.Routes[j].Metadata
+		if first.EventYear != second.EventYear {
+			return first.EventYear < second.EventYear
+		}
+		if first.StageLetter != second.StageLetter {
+			return first.StageLetter < second.StageLetter
+		}
+		if first.Kind != second.Kind {
+			return first.Kind == types.StageRoute
+		}
+		return catalog.Routes[i].FileName < catalog.Routes[j].FileName
+	})
+}
+
+func saveRouteCatalog(routeFolder string, catalog *RouteCatalog) error {
+	functionErrMsg := errors.New("error saving route catalog")
+
+	file, err := os.Create(filepath.Join(routeFolder, routeCatalogFileName))
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(catalog)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
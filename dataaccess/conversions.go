@@ -11,5 +11,5 @@ func celsiusToFahrenheit(temperature float64) float64 {
 }
 
 func msToMph(speedMetersPerSecond float64) float64 {
-	return (speedMetersPerSecond * (mToFt / miToFt)) / hoursToSeconds
+	return speedMetersPerSecond * (mToFt / miToFt) * hoursToSeconds
 }
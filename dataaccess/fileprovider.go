@@ -0,0 +1,127 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"time"
+)
+
+// Weather measured or forecasted at one place, oldest sample first
+type WeatherStation struct {
+	Name        string
+	Coordinates types.Coordinates
+	Samples     []WeatherSample
+}
+
+// The format of files read by FileWeatherProvider
+type WeatherFile struct {
+	Stations []WeatherStation
+}
+
+/*
+Gets weather from a JSON file of weather stations, using the station closest to
+each location. Useful for running simulations offline or with recorded weather.
+*/
+type FileWeatherProvider struct {
+	filePath string
+	stations []WeatherStation
+}
+
+func NewFileWeatherProvider(filePath string) (*FileWeatherProvider, error) {
+	functionErrMsg := errors.New("error loading weather file")
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var weatherFile WeatherFile
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&weatherFile)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	stations := make([]WeatherStation, 0, len(weatherFile.Stations))
+	for _, station := range weatherFile.Stations {
+		if len(station.Samples) > 0 {
+			stations = append(stations, station)
+		}
+	}
+	if len(stations) == 0 {
+		return nil, errors.Join(functionErrMsg, errors.New("\""+filePath+"\" has no weather samples"))
+	}
+
+	return &FileWeatherProvider{filePath: filePath, stations: stations}, nil
+}
+
+func (provider *FileWeatherProvider) Name() string {
+	return "file-" + removeIllegalFilenameChars(provider.filePath)
+}
+
+// Returns the sample closest to now
+func (provider *FileWeatherProvider) GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*WeatherSample, error) {
+	now := time.Now()
+	samples := samplesInWindow(provider.nearestStation(coordinates).Samples, now, now)
+
+	closest := samples[0]
+	for _, sample := range samples {
+		if sample.ValidAt.Sub(now).Abs() < closest.ValidAt.Sub(now).Abs() {
+			closest = sample
+		}
+	}
+
+	return &closest, nil
+}
+
+func (provider *FileWeatherProvider) GetForecastWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	return samplesInWindow(provider.nearestStation(coordinates).Samples, start, end), nil
+}
+
+func (provider *FileWeatherProvider) GetHistoricalWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	return samplesInWindow(provider.nearestStation(coordinates).Samples, start, end), nil
+}
+
+func (provider *FileWeatherProvider) nearestStation(coordinates types.Coordinates) *WeatherStation {
+	nearest := &provider.stations[0]
+	nearestDistance := math.Inf(1)
+
+	for i := range provider.stations {
+		distance := ApproximateDistanceMi(coordinates, provider.stations[i].Coordinates)
+		if distance < nearestDistance {
+			nearest = &provider.stations[i]
+			nearestDistance = distance
+		}
+	}
+
+	return nearest
+}
+
+// Haversine distance. Close enough for picking the nearest weather station, but not for route lengths.
+func ApproximateDistanceMi(a types.Coordinates, b types.Coordinates) float64 {
+	const earthRadiusMi float64 = 3958.8
+	toRadians := math.Pi / 180
+
+	deltaLatitude := (b.Latitude - a.Latitude) * toRadians
+	deltaLongitude := (b.Longitude - a.Longitude) * toRadians
+
+	h := math.Pow(math.Sin(deltaLatitude/2), 2) +
+		math.Cos(a.Latitude*toRadians)*math.Cos(b.Latitude*toRadians)*math.Pow(math.Sin(deltaLongitude/2), 2)
+
+	return 2 * earthRadiusMi * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,163 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"time"
+)
+
+// Sections further than this from every road segment in a traffic file have no traffic data
+const trafficSegmentMatchDistanceMi float64 = 0.25
+
+// Typical traffic on one stretch of road through the week
+type TrafficSegment struct {
+	Name string
+	// Points along the road, in order
+	Coordinates      []types.Coordinates
+	FreeFlowSpeedMph float64
+	// Flow speed for each weekday (Sunday first) and hour of the day. 0 for hours
+	// without data, which use FreeFlowSpeedMph.
+	HourlyFlowSpeedMph [7][24]float64
+}
+
+// The format of files read by FileTrafficProvider
+type TrafficFile struct {
+	// IANA time zone the hours in the file are in, e.g. "America/Chicago". Empty uses the computer's time zone.
+	TimeZone string
+	Segments []TrafficSegment
+}
+
+/*
+Gets traffic from a JSON file of historical flow profiles, using the road segment
+closest to each location. Flow speeds are interpolated between hours.
+*/
+type FileTrafficProvider struct {
+	filePath string
+	location *time.Location
+	segments []TrafficSegment
+}
+
+func NewFileTrafficProvider(filePath string) (*FileTrafficProvider, error) {
+	functionErrMsg := errors.New("error loading traffic file")
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var trafficFile TrafficFile
+	err = json.NewDecoder(file).Decode(&trafficFile)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	location := time.Local
+	if trafficFile.TimeZone != "" {
+		location, err = time.LoadLocation(trafficFile.TimeZone)
+		if err != nil {
+			return nil, errors.Join(functionErrMsg, err)
+		}
+	}
+
+	segments := make([]TrafficSegment, 0, len(trafficFile.Segments))
+	for _, segment := range trafficFile.Segments {
+		if len(segment.Coordinates) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, errors.Join(functionErrMsg, errors.New("\""+filePath+"\" has no road segments"))
+	}
+
+	return &FileTrafficProvider{filePath: filePath, location: location, segments: segments}, nil
+}
+
+func (provider *FileTrafficProvider) Name() string {
+	return "file-" + removeIllegalFilenameChars(provider.filePath)
+}
+
+func (provider *FileTrafficProvider) GetTraffic(ctx context.Context, coordinates types.Coordinates, at time.Time) (*types.Traffic, error) {
+	var nearest *TrafficSegment = nil
+	nearestDistance := trafficSegmentMatchDistanceMi
+
+	for i := range provider.segments {
+		distance := distanceToPolylineMi(coordinates, provider.segments[i].Coordinates)
+		if distance <= nearestDistance {
+			nearest = &provider.segments[i]
+			nearestDistance = distance
+		}
+	}
+	if nearest == nil {
+		return nil, nil
+	}
+
+	local := at.In(provider.location)
+	hourFraction := float64(local.Minute())/60 + float64(local.Second())/3600
+	next := local.Add(time.Hour)
+
+	flowSpeed := nearest.flowSpeedMph(local.Weekday(), local.Hour())*(1-hourFraction) +
+		nearest.flowSpeedMph(next.Weekday(), next.Hour())*hourFraction
+
+	return &types.Traffic{
+		FlowSpeedMph:     flowSpeed,
+		FreeFlowSpeedMph: nearest.FreeFlowSpeedMph,
+	}, nil
+}
+
+func (segment *TrafficSegment) flowSpeedMph(weekday time.Weekday, hour int) float64 {
+	flowSpeed := segment.HourlyFlowSpeedMph[weekday][hour]
+	if flowSpeed <= 0 {
+		return segment.FreeFlowSpeedMph
+	}
+	return flowSpeed
+}
+
+/*
+Shortest distance from the point to the line through the coordinates. Treats the
+ground as flat around the point, which is fine over the length of a road segment.
+*/
+func distanceToPolylineMi(point types.Coordinates, line []types.Coordinates) float64 {
+	if len(line) == 1 {
+		return ApproximateDistanceMi(point, line[0])
+	}
+
+	nearest := math.Inf(1)
+	for i := 1; i < len(line); i++ {
+		distance, _ := projectOntoSegmentMi(point, line[i-1], line[i])
+		nearest = math.Min(nearest, distance)
+	}
+
+	return nearest
+}
+
+/*
+Finds the closest point to the point on the straight line from start to end.
+Returns the distance to it and how far along the line it is, from 0 (start) to 1 (end).
+*/
+func projectOntoSegmentMi(point types.Coordinates, start types.Coordinates, end types.Coordinates) (float64, float64) {
+	const miPerDegreeLatitude float64 = 69.05
+	miPerDegreeLongitude := miPerDegreeLatitude * math.Cos(point.Latitude*math.Pi/180)
+
+	// Miles east and north of the point
+	toXY := func(coordinates types.Coordinates) (float64, float64) {
+		return (coordinates.Longitude - point.Longitude) * miPerDegreeLongitude,
+			(coordinates.Latitude - point.Latitude) * miPerDegreeLatitude
+	}
+
+	ax, ay := toXY(start)
+	bx, by := toXY(end)
+
+	// Closest point on the segment to the origin
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSquared))
+	}
+
+	return math.Hypot(ax+t*dx, ay+t*dy), t
+}
@@ -0,0 +1,144 @@
+package dataaccess
+
+import (
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+These tests answer every API call from the responses in testdata/fixtures, so they
+run without API keys or a network. To record them again, start `asc mock-apis` (or
+use real keys) and run:
+
+	HTTP_FIXTURES=record HTTP_FIXTURES_DIR=testdata/fixtures \
+	SOLCAST_URL=http://127.0.0.1:8787/ SOLCAST_TOKEN=test \
+	OPEN_ROUTE_SERVICE_URL=http://127.0.0.1:8787/ OPEN_ROUTE_SERVICE_TOKEN=test \
+	go test ./dataaccess -run Fixtures
+*/
+
+const testFixtureFolder = "testdata/fixtures"
+
+// Replays testdata/fixtures, unless HTTP_FIXTURES is already set to record them
+func useTestFixtures(t *testing.T) {
+	t.Helper()
+
+	defaults := map[string]string{
+		"HTTP_FIXTURES":            string(httpclient.FixturesReplay),
+		"HTTP_FIXTURES_DIR":        testFixtureFolder,
+		"SOLCAST_URL":              "http://127.0.0.1:8787/",
+		"SOLCAST_TOKEN":            "test",
+		"OPEN_ROUTE_SERVICE_URL":   "http://127.0.0.1:8787/",
+		"OPEN_ROUTE_SERVICE_TOKEN": "test",
+	}
+	for name, value := range defaults {
+		if os.Getenv(name) == "" {
+			t.Setenv(name, value)
+		}
+	}
+	// Keeps the weather cache and Solcast usage file out of the real cache folder
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	client := httpclient.Default()
+	t.Cleanup(func() { httpclient.SetDefault(client) })
+
+	err := httpclient.UseFixturesFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRouteSection() *types.RouteSection {
+	route := types.Route{
+		Name: "fixture test",
+		Sections: []types.RouteSection{{
+			LengthFt:           5 * miToFt,
+			CoordinatesInitial: types.Coordinates{Latitude: 36.1627, Longitude: -86.7816},
+			CoordinatesFinal:   types.Coordinates{Latitude: 36.19, Longitude: -86.815},
+		}},
+	}
+	route.Sections[0].Route = &route
+	return &route.Sections[0]
+}
+
+func TestGetWeatherAtTimeFixtures(t *testing.T) {
+	useTestFixtures(t)
+
+	tests := []struct {
+		name       string
+		targetTime time.Time
+	}{
+		{name: "afternoon", targetTime: time.Date(2024, 6, 20, 19, 0, 0, 0, time.UTC)},
+		{name: "next afternoon", targetTime: time.Date(2024, 6, 21, 19, 0, 0, 0, time.UTC)},
+		{name: "night", targetTime: time.Date(2024, 6, 21, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := WeatherDataOptions{Provider: SolcastWeatherProvider{}}
+			weather, err := GetWeatherAtTime(testRouteSection(), test.targetTime, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if weather.SolarZenithDegrees < 0 || weather.SolarZenithDegrees > 180 {
+				t.Errorf("SolarZenithDegrees = %v, want 0-180", weather.SolarZenithDegrees)
+			}
+			if weather.CloudCoverPercentage < 0 || weather.CloudCoverPercentage > 100 {
+				t.Errorf("CloudCoverPercentage = %v, want 0-100", weather.CloudCoverPercentage)
+			}
+			// The sun is up at 2pm in Nashville in June, and down at 2am
+			isDay := test.targetTime.Hour() > 12
+			if isDay != (weather.SolarZenithDegrees < 90) {
+				t.Errorf("SolarZenithDegrees = %v at %v", weather.SolarZenithDegrees, test.targetTime)
+			}
+		})
+	}
+}
+
+func TestGetWeatherFixtures(t *testing.T) {
+	useTestFixtures(t)
+
+	options := WeatherDataOptions{Provider: SolcastWeatherProvider{}}
+	weather, err := GetWeather(testRouteSection(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weather.AirTempDegreesF < -40 || weather.AirTempDegreesF > 130 {
+		t.Errorf("AirTempDegreesF = %v", weather.AirTempDegreesF)
+	}
+}
+
+func TestCreateRoutesFixtures(t *testing.T) {
+	useTestFixtures(t)
+
+	outputFolder := t.TempDir()
+	errs := CreateRoutes("testdata/short.gpx", outputFolder, RouteImportOptions{})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	route, err := LoadRoute(filepath.Join(outputFolder, removeIllegalFilenameChars("A: Nashville to Paducah")+routeFileExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(route.Sections) == 0 {
+		t.Fatal("route has no sections")
+	}
+	if route.Metadata.StageLetter != "A" || route.Metadata.EndCheckpoint != "Paducah" {
+		t.Errorf("Metadata = %+v, want stage A to Paducah", route.Metadata)
+	}
+
+	lengthFt := 0.0
+	for _, section := range route.Sections {
+		lengthFt += section.LengthFt
+	}
+	// The points are about 2.3 miles apart in a straight line
+	if lengthFt < 2*miToFt || lengthFt > 5*miToFt {
+		t.Errorf("route is %.2f miles long, want 2-5", lengthFt/miToFt)
+	}
+}
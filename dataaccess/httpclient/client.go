@@ -0,0 +1,243 @@
+/*
+HTTP client shared by every API client (Solcast, OpenRouteService, Open-Meteo).
+Adds timeouts, retries with exponential backoff on 429 and 5xx responses (honouring
+Retry-After), and errors that keep the response status and body.
+*/
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error bodies longer than this are cut off
+const maxErrorBodyBytes = 4096
+
+type Config struct {
+	// Time limit for each attempt, including reading the response
+	Timeout time.Duration
+	// Attempts after the first one. 0 never retries.
+	MaxRetries int
+	// Wait before the first retry, doubled for each retry after it
+	InitialBackoff time.Duration
+	// Longest wait between retries, unless the server asks for longer with Retry-After
+	MaxBackoff time.Duration
+	// Give up instead of waiting if Retry-After asks for longer than this
+	MaxRetryAfter time.Duration
+	// Used to send requests. nil uses http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:        30 * time.Second,
+		MaxRetries:     4,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		MaxRetryAfter:  5 * time.Minute,
+	}
+}
+
+type Client struct {
+	config     Config
+	httpClient *http.Client
+}
+
+func NewClient(config Config) *Client {
+	return &Client{
+		config: config,
+		httpClient: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: config.Transport,
+		},
+	}
+}
+
+var defaultClient = NewClient(DefaultConfig())
+var defaultClientMutex sync.RWMutex
+
+// The client used by every API client
+func Default() *Client {
+	defaultClientMutex.RLock()
+	defer defaultClientMutex.RUnlock()
+	return defaultClient
+}
+
+// Replaces the client used by every API client, e.g. to change timeouts or record requests.
+func SetDefault(client *Client) {
+	defaultClientMutex.Lock()
+	defer defaultClientMutex.Unlock()
+	defaultClient = client
+}
+
+func (client *Client) Config() Config {
+	return client.config
+}
+
+type Request struct {
+	// Name of the API, used in error messages
+	Service string
+	Method  string
+	Url     string
+	Query   url.Values
+	Headers map[string]string
+	Body    []byte
+	// Called before every attempt, including retries. Returning an error stops the request.
+	BeforeAttempt func() error
+}
+
+/*
+Returned when the server responds with an error status.
+Use errors.As() to get it from the errors returned by API clients.
+*/
+type StatusError struct {
+	Service    string
+	StatusCode int
+	Status     string
+	Body       string
+	// How long the server asked us to wait, 0 if it didn't
+	RetryAfter time.Duration
+}
+
+func (err *StatusError) Error() string {
+	message := fmt.Sprintf("request to %s failed: %s", err.Service, err.Status)
+	if err.Body != "" {
+		message += ": " + err.Body
+	}
+	return message
+}
+
+// True for responses that may succeed if the request is sent again
+func (err *StatusError) Retryable() bool {
+	return isRetryableStatus(err.StatusCode)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+/*
+Sends the request, retrying if it fails in a way that might not happen again,
+and decodes the JSON response into result.
+*/
+func (client *Client) SendJson(ctx context.Context, request Request, result any) error {
+	body, err := client.Send(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return errors.Join(errors.New("invalid response from "+request.Service), err)
+	}
+
+	return nil
+}
+
+// Sends the request, retrying if it fails in a way that might not happen again, and returns the response body.
+func (client *Client) Send(ctx context.Context, request Request) ([]byte, error) {
+	backoff := client.config.InitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		if request.BeforeAttempt != nil {
+			err := request.BeforeAttempt()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		body, err := client.sendOnce(ctx, request)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= client.config.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := backoff
+		var statusError *StatusError
+		if errors.As(err, &statusError) {
+			if !statusError.Retryable() {
+				return nil, err
+			}
+			if statusError.RetryAfter > client.config.MaxRetryAfter {
+				return nil, err
+			}
+			wait = max(wait, statusError.RetryAfter)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+
+		backoff = min(backoff*2, client.config.MaxBackoff)
+	}
+}
+
+func (client *Client) sendOnce(ctx context.Context, request Request) ([]byte, error) {
+	httpRequest, err := http.NewRequestWithContext(
+		ctx,
+		request.Method,
+		request.Url,
+		bytes.NewReader(request.Body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range request.Headers {
+		httpRequest.Header.Set(name, value)
+	}
+	if request.Query != nil {
+		httpRequest.URL.RawQuery = request.Query.Encode()
+	}
+
+	response, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		errorBody, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyBytes))
+		return nil, &StatusError{
+			Service:    request.Service,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			Body:       string(errorBody),
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+
+	date, err := http.ParseTime(value)
+	if err == nil {
+		return max(0, time.Until(date))
+	}
+
+	return 0
+}
@@ -0,0 +1,254 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+Record/replay of API requests, so code that calls Solcast or OpenRouteService can be
+run without API keys. Set HTTP_FIXTURES to "record" to save every response to
+HTTP_FIXTURES_DIR (./fixtures by default), and to "replay" to answer every request
+from the saved responses without using the network.
+
+Auth headers are never saved. Requests are matched on every query parameter,
+including dates and forecast windows, so a replay only answers the exact request
+that was recorded. Record again when a test or command asks for different times.
+*/
+
+type FixtureMode string
+
+const (
+	FixturesOff    FixtureMode = ""
+	FixturesRecord FixtureMode = "record"
+	FixturesReplay FixtureMode = "replay"
+)
+
+const defaultFixtureFolder = "./fixtures"
+
+// One recorded request and the response to it
+type Fixture struct {
+	Request  FixtureRequest
+	Response FixtureResponse
+}
+
+type FixtureRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   string
+}
+
+type FixtureResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+}
+
+// Response headers worth keeping
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// Records or replays requests, passing them on to Next when recording
+type FixtureTransport struct {
+	Mode   FixtureMode
+	Folder string
+	// nil uses http.DefaultTransport
+	Next http.RoundTripper
+}
+
+/*
+Switches the default client to record or replay fixtures if the HTTP_FIXTURES
+environment variable is set. Does nothing otherwise.
+*/
+func UseFixturesFromEnvironment() error {
+	mode := FixtureMode(strings.ToLower(os.Getenv("HTTP_FIXTURES")))
+	switch mode {
+	case FixturesOff:
+		return nil
+	case FixturesRecord, FixturesReplay:
+	default:
+		return errors.New("HTTP_FIXTURES must be \"record\" or \"replay\", not: '" + string(mode) + "'")
+	}
+
+	folder := os.Getenv("HTTP_FIXTURES_DIR")
+	if folder == "" {
+		folder = defaultFixtureFolder
+	}
+
+	config := Default().Config()
+	config.Transport = &FixtureTransport{Mode: mode, Folder: folder, Next: config.Transport}
+	SetDefault(NewClient(config))
+	return nil
+}
+
+// True if requests are answered from fixtures instead of the network
+func (client *Client) Replaying() bool {
+	transport, isFixtureTransport := client.config.Transport.(*FixtureTransport)
+	return isFixtureTransport && transport.Mode == FixturesReplay
+}
+
+func (transport *FixtureTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtureRequest := FixtureRequest{
+		Method: request.Method,
+		Path:   request.URL.Path,
+		Query:  request.URL.Query(),
+		Body:   string(requestBody),
+	}
+	filePath := filepath.Join(transport.Folder, FixtureKey(fixtureRequest)+".json")
+
+	if transport.Mode == FixturesReplay {
+		fixture, err := ReadFixture(filePath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errors.New("no recorded response for " + request.Method + " " + request.URL.Path + " in " + transport.Folder)
+			}
+			return nil, err
+		}
+		return fixture.Response.toHttpResponse(request), nil
+	}
+
+	next := transport.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	response, err := next.RoundTrip(request)
+	if err != nil || transport.Mode != FixturesRecord {
+		return response, err
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	fixture := Fixture{
+		Request: fixtureRequest,
+		Response: FixtureResponse{
+			StatusCode: response.StatusCode,
+			Headers:    make(map[string]string),
+			Body:       string(responseBody),
+		},
+	}
+	for _, header := range recordedHeaders {
+		if value := response.Header.Get(header); value != "" {
+			fixture.Response.Headers[header] = value
+		}
+	}
+
+	err = SaveFixture(filePath, &fixture)
+	if err != nil {
+		return nil, errors.Join(errors.New("error recording HTTP fixture"), err)
+	}
+
+	return response, nil
+}
+
+/*
+File name (without extension) a request is saved under. Made from the endpoint so
+the files are easy to find, plus a hash of everything that identifies the request.
+*/
+func FixtureKey(request FixtureRequest) string {
+	names := make([]string, 0, len(request.Query))
+	for name := range request.Query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.Path + "\n"))
+	for _, name := range names {
+		values := append([]string{}, request.Query[name]...)
+		sort.Strings(values)
+		hash.Write([]byte(name + "=" + strings.Join(values, ",") + "\n"))
+	}
+	hash.Write([]byte(request.Body))
+
+	endpoint := strings.ReplaceAll(strings.Trim(request.Path, "/"), "/", "_")
+	return strings.ToLower(request.Method) + "_" + endpoint + "_" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func ReadFixture(filePath string) (*Fixture, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var fixture Fixture
+	err = json.NewDecoder(file).Decode(&fixture)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid HTTP fixture \""+filePath+"\""), err)
+	}
+
+	return &fixture, nil
+}
+
+func SaveFixture(filePath string, fixture *Fixture) error {
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(fixture)
+}
+
+func (response *FixtureResponse) toHttpResponse(request *http.Request) *http.Response {
+	header := make(http.Header)
+	for name, value := range response.Headers {
+		header.Set(name, value)
+	}
+
+	return &http.Response{
+		StatusCode:    response.StatusCode,
+		Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(response.Body)),
+		ContentLength: int64(len(response.Body)),
+		Request:       request,
+	}
+}
+
+func readRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
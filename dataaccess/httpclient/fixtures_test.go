@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFixtureKey(t *testing.T) {
+	base := FixtureRequest{
+		Method: http.MethodGet,
+		Path:   "/data/historic/radiation_and_weather",
+		Query:  url.Values{"latitude": {"36.1"}, "start": {"2024-06-20T14:00:00Z"}, "end": {"2024-06-20T20:00:00Z"}},
+	}
+
+	tests := []struct {
+		name    string
+		request FixtureRequest
+		same    bool
+	}{
+		{
+			name:    "same request",
+			request: base,
+			same:    true,
+		},
+		{
+			name: "parameters in another order",
+			request: FixtureRequest{
+				Method: base.Method,
+				Path:   base.Path,
+				Query:  url.Values{"end": {"2024-06-20T20:00:00Z"}, "start": {"2024-06-20T14:00:00Z"}, "latitude": {"36.1"}},
+			},
+			same: true,
+		},
+		{
+			name: "another day",
+			request: FixtureRequest{
+				Method: base.Method,
+				Path:   base.Path,
+				Query:  url.Values{"latitude": {"36.1"}, "start": {"2024-06-21T14:00:00Z"}, "end": {"2024-06-21T20:00:00Z"}},
+			},
+			same: false,
+		},
+		{
+			name: "another forecast window",
+			request: FixtureRequest{
+				Method: base.Method,
+				Path:   base.Path,
+				Query:  url.Values{"latitude": {"36.1"}, "start": {"2024-06-20T14:00:00Z"}, "end": {"2024-06-20T20:00:00Z"}, "hours": {"6"}},
+			},
+			same: false,
+		},
+		{
+			name: "another body",
+			request: FixtureRequest{
+				Method: base.Method,
+				Path:   base.Path,
+				Query:  base.Query,
+				Body:   "{}",
+			},
+			same: false,
+		},
+		{
+			name: "another path",
+			request: FixtureRequest{
+				Method: base.Method,
+				Path:   "/data/forecast/radiation_and_weather",
+				Query:  base.Query,
+			},
+			same: false,
+		},
+	}
+
+	baseKey := FixtureKey(base)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := FixtureKey(test.request)
+			if (key == baseKey) != test.same {
+				t.Errorf("FixtureKey() = %q, base key %q, want same = %v", key, baseKey, test.same)
+			}
+		})
+	}
+}
+
+func TestFixtureTransportRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		io.WriteString(writer, "traffic at "+request.URL.Query().Get("time"))
+	}))
+	defer server.Close()
+
+	folder := t.TempDir()
+	recorder := &http.Client{Transport: &FixtureTransport{Mode: FixturesRecord, Folder: folder}}
+	for _, hour := range []string{"08", "17"} {
+		response, err := recorder.Get(server.URL + "/flow?time=" + hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+	server.Close()
+
+	replayer := &http.Client{Transport: &FixtureTransport{Mode: FixturesReplay, Folder: folder}}
+	tests := []struct {
+		hour string
+		want string
+		err  bool
+	}{
+		{hour: "08", want: "traffic at 08"},
+		{hour: "17", want: "traffic at 17"},
+		{hour: "12", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.hour, func(t *testing.T) {
+			response, err := replayer.Get(server.URL + "/flow?time=" + test.hour)
+			if test.err {
+				if err == nil {
+					response.Body.Close()
+					t.Fatal("expected an error for a request that was never recorded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(response.Body)
+			if string(body) != test.want {
+				t.Errorf("body = %q, want %q", body, test.want)
+			}
+		})
+	}
+}
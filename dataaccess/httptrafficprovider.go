@@ -0,0 +1,33 @@
+package dataaccess
+
+import (
+	"asc-simulation/dataaccess/traffic"
+	"asc-simulation/types"
+	"context"
+	"time"
+)
+
+/*
+Gets traffic from the traffic flow API (see the traffic package).
+Needs the TRAFFIC_URL environment variable, and TRAFFIC_TOKEN if the server wants one.
+*/
+type HttpTrafficProvider struct{}
+
+func (provider HttpTrafficProvider) Name() string {
+	return "http"
+}
+
+func (provider HttpTrafficProvider) GetTraffic(ctx context.Context, coordinates types.Coordinates, at time.Time) (*types.Traffic, error) {
+	flow, err := traffic.GetFlow(ctx, coordinates, at)
+	if err != nil {
+		return nil, err
+	}
+	if !flow.Covered {
+		return nil, nil
+	}
+
+	return &types.Traffic{
+		FlowSpeedMph:     flow.FlowSpeedMph,
+		FreeFlowSpeedMph: flow.FreeFlowSpeedMph,
+	}, nil
+}
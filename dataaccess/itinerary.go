@@ -0,0 +1,199 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const routeFileExtension string = ".route.json"
+
+/*
+One route in an itinerary, and how many times in a row it is driven.
+Stages are normally driven once; loops can be driven any number of times.
+*/
+type ItineraryEntry struct {
+	RouteFilePath string
+	// Treated as 1 if left at 0
+	Repetitions int
+	Day         int
+}
+
+/*
+Loads every route in the entries and lays them out end to end.
+Each repetition of a route becomes its own leg with its own global section indexes.
+Routes that appear more than once are only loaded from disk once.
+*/
+func NewItinerary(name string, entries []ItineraryEntry) (*types.Itinerary, error) {
+	functionErrMsg := errors.New("error creating itinerary")
+
+	if len(entries) == 0 {
+		return nil, errors.Join(functionErrMsg, errors.New("itinerary has no routes"))
+	}
+
+	itinerary := types.Itinerary{Name: name}
+	loadedRoutes := make(map[string]*types.Route)
+	sectionCount := 0
+
+	for _, entry := range entries {
+		route, loaded := loadedRoutes[entry.RouteFilePath]
+		if !loaded {
+			var err error
+			route, err = LoadRoute(entry.RouteFilePath)
+			if err != nil {
+				return nil, errors.Join(functionErrMsg, err)
+			}
+			if len(route.Sections) == 0 {
+				return nil, errors.Join(
+					functionErrMsg,
+					errors.New("route \""+route.Name+"\" has no sections"),
+				)
+			}
+			loadedRoutes[entry.RouteFilePath] = route
+		}
+
+		repetitions := max(1, entry.Repetitions)
+		for i := 0; i < repetitions; i++ {
+			leg := types.ItineraryLeg{
+				Route:             route,
+				Kind:              route.Metadata.Kind,
+				Day:               entry.Day,
+				StartSectionIndex: sectionCount,
+				EndSectionIndex:   sectionCount + len(route.Sections) - 1,
+				StartDistanceFt:   itinerary.LengthFt,
+			}
+			if leg.Kind == types.LoopRoute {
+				leg.Repetition = i + 1
+			}
+
+			for _, section := range route.Sections {
+				leg.LengthFt += section.LengthFt
+			}
+
+			itinerary.Legs = append(itinerary.Legs, leg)
+			itinerary.LengthFt += leg.LengthFt
+			itinerary.DayCount = max(itinerary.DayCount, entry.Day+1)
+			sectionCount += len(route.Sections)
+		}
+	}
+
+	// Sections are filled in after all legs exist so the Leg pointers stay valid
+	itinerary.Sections = make([]types.ItinerarySection, 0, sectionCount)
+	for i := range itinerary.Legs {
+		leg := &itinerary.Legs[i]
+		distanceFt := leg.StartDistanceFt
+
+		for j := range leg.Route.Sections {
+			section := &leg.Route.Sections[j]
+			itinerary.Sections = append(itinerary.Sections, types.ItinerarySection{
+				RouteSection: section,
+				Leg:          leg,
+				Position:     len(itinerary.Sections),
+				DistanceFt:   distanceFt,
+			})
+			distanceFt += section.LengthFt
+		}
+	}
+
+	for i := 0; i < len(itinerary.Sections)-1; i++ {
+		itinerary.Sections[i].Next = &itinerary.Sections[i+1]
+	}
+
+	return &itinerary, nil
+}
+
+/*
+Creates an itinerary from a short description such as "A,AL*3;B,BL*2".
+
+Routes are separated by commas and days are separated by semicolons.
+A route can be followed by "*n" to drive it n times in a row.
+Each route is either a path to a .route.json file, or a route in routeFolder's
+catalog, found by stage letter ("A" is stage A, "AL" is stage A's loop) or file name.
+*/
+func ParseItinerary(spec string, routeFolder string) (*types.Itinerary, error) {
+	functionErrMsg := errors.New("error parsing itinerary \"" + spec + "\"")
+
+	var entries []ItineraryEntry
+
+	for day, daySpec := range strings.Split(spec, ";") {
+		for _, entrySpec := range strings.Split(daySpec, ",") {
+			entrySpec = strings.TrimSpace(entrySpec)
+			if entrySpec == "" {
+				continue
+			}
+
+			routeName, repetitionsStr, hasRepetitions := strings.Cut(entrySpec, "*")
+			repetitions := 1
+			if hasRepetitions {
+				var err error
+				repetitions, err = strconv.Atoi(strings.TrimSpace(repetitionsStr))
+				if err != nil || repetitions < 0 {
+					return nil, errors.Join(
+						functionErrMsg,
+						errors.New("repetitions must be a non-negative integer, not: '"+repetitionsStr+"'"),
+					)
+				}
+			}
+
+			// Found even if it is driven 0 times, so a misspelled route isn't silently left out
+			routeFilePath, err := FindRouteFile(strings.TrimSpace(routeName), routeFolder)
+			if err != nil {
+				return nil, errors.Join(functionErrMsg, err)
+			}
+			if repetitions == 0 {
+				continue
+			}
+
+			entries = append(entries, ItineraryEntry{
+				RouteFilePath: routeFilePath,
+				Repetitions:   repetitions,
+				Day:           day,
+			})
+		}
+	}
+
+	return NewItinerary(spec, entries)
+}
+
+/*
+Finds the route file for a route name used in an itinerary.
+See ParseItinerary() for the accepted formats.
+*/
+func FindRouteFile(routeName string, routeFolder string) (string, error) {
+	if strings.HasSuffix(routeName, routeFileExtension) {
+		if _, err := os.Stat(routeName); err == nil || routeFolder == "" {
+			return routeName, nil
+		}
+		return filepath.Join(routeFolder, routeName), nil
+	}
+
+	catalog, err := LoadRouteCatalog(routeFolder)
+	if err != nil {
+		return "", err
+	}
+	if entry := catalog.Find(routeName); entry != nil {
+		return filepath.Join(routeFolder, entry.FileName), nil
+	}
+
+	// Routes without a stage letter can still be found by the start of their file name
+	var matches []string
+	for _, entry := range catalog.Routes {
+		if strings.HasPrefix(entry.FileName, routeName+"_") {
+			matches = append(matches, filepath.Join(routeFolder, entry.FileName))
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", errors.New("no route file found for \"" + routeName + "\" in " + routeFolder)
+	}
+	if len(matches) > 1 {
+		return "", errors.New(
+			"route \"" + routeName + "\" is ambiguous, matches: " + strings.Join(matches, ", "),
+		)
+	}
+
+	return matches[0], nil
+}
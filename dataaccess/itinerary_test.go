@@ -0,0 +1,167 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRouteFolder = "../asc-routes-2024"
+
+// What the test checks about each leg
+type testLeg struct {
+	// Start of the route's name, e.g. "A" for "A: Nashville to Paducah" and "AL" for its loop
+	Route      string
+	Day        int
+	Repetition int
+}
+
+func TestParseItinerary(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		wantLegs []testLeg
+		// Part of the error message, empty if the spec is valid
+		wantErr string
+	}{
+		{
+			name:     "one stage",
+			spec:     "A",
+			wantLegs: []testLeg{{"A", 0, 0}},
+		},
+		{
+			name: "stages and loops over two days",
+			spec: "A,AL*3;B,BL*2",
+			wantLegs: []testLeg{
+				{"A", 0, 0}, {"AL", 0, 1}, {"AL", 0, 2}, {"AL", 0, 3},
+				{"B", 1, 0}, {"BL", 1, 1}, {"BL", 1, 2},
+			},
+		},
+		{
+			name:     "spaces and empty entries",
+			spec:     " A , AL * 2 ,; B",
+			wantLegs: []testLeg{{"A", 0, 0}, {"AL", 0, 1}, {"AL", 0, 2}, {"B", 1, 0}},
+		},
+		{
+			name:     "loop driven no times",
+			spec:     "A,AL*0",
+			wantLegs: []testLeg{{"A", 0, 0}},
+		},
+		{
+			name:     "file name",
+			spec:     "A_Nashville_to_Paducah",
+			wantLegs: []testLeg{{"A", 0, 0}},
+		},
+		{
+			name:     "start of a file name",
+			spec:     "BL_Edwardsville",
+			wantLegs: []testLeg{{"BL", 0, 1}},
+		},
+		{
+			name:     "route file",
+			spec:     testRouteFolder + "/C_Edwardsville_to_Jefferson_City.route.json",
+			wantLegs: []testLeg{{"C", 0, 0}},
+		},
+		{
+			name:    "unknown route",
+			spec:    "A,Z",
+			wantErr: "no route file found for \"Z\"",
+		},
+		{
+			name:    "unknown route driven no times",
+			spec:    "A,ZZ*0",
+			wantErr: "no route file found for \"ZZ\"",
+		},
+		{
+			name:    "repetitions aren't a number",
+			spec:    "AL*x",
+			wantErr: "repetitions must be a non-negative integer",
+		},
+		{
+			name:    "negative repetitions",
+			spec:    "AL*-1",
+			wantErr: "repetitions must be a non-negative integer",
+		},
+		{
+			name:    "no routes",
+			spec:    " ; ",
+			wantErr: "itinerary has no routes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			itinerary, err := ParseItinerary(test.spec, testRouteFolder)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("ParseItinerary() error = %v, want one about %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var legs []testLeg
+			for _, leg := range itinerary.Legs {
+				route, _, _ := strings.Cut(leg.Route.Name, ":")
+				legs = append(legs, testLeg{route, leg.Day, leg.Repetition})
+			}
+			if !reflect.DeepEqual(legs, test.wantLegs) {
+				t.Errorf("legs = %v, want %v", legs, test.wantLegs)
+			}
+			if itinerary.DayCount != test.wantLegs[len(test.wantLegs)-1].Day+1 {
+				t.Errorf("DayCount = %d", itinerary.DayCount)
+			}
+
+			checkItineraryLayout(t, itinerary)
+		})
+	}
+}
+
+// Checks that the legs and sections follow on from each other with no gaps
+func checkItineraryLayout(t *testing.T, itinerary *types.Itinerary) {
+	t.Helper()
+
+	sectionCount := 0
+	distanceFt := 0.0
+	for i, leg := range itinerary.Legs {
+		if leg.StartSectionIndex != sectionCount || leg.EndSectionIndex != sectionCount+len(leg.Route.Sections)-1 {
+			t.Errorf("leg %d has sections %d-%d, want them to start at %d", i, leg.StartSectionIndex, leg.EndSectionIndex, sectionCount)
+		}
+		if math.Abs(leg.StartDistanceFt-distanceFt) > 1e-6 {
+			t.Errorf("leg %d starts at %v ft, want %v", i, leg.StartDistanceFt, distanceFt)
+		}
+		sectionCount += len(leg.Route.Sections)
+		distanceFt += leg.LengthFt
+	}
+
+	if len(itinerary.Sections) != sectionCount {
+		t.Fatalf("%d sections, want %d", len(itinerary.Sections), sectionCount)
+	}
+	if math.Abs(itinerary.LengthFt-distanceFt) > 1e-6 {
+		t.Errorf("LengthFt = %v, want %v", itinerary.LengthFt, distanceFt)
+	}
+
+	for i := range itinerary.Sections {
+		section := &itinerary.Sections[i]
+		if section.Position != i {
+			t.Errorf("section %d has Position %d", i, section.Position)
+		}
+		if section.Leg.StartSectionIndex > i || section.Leg.EndSectionIndex < i {
+			t.Errorf("section %d is in a leg with sections %d-%d", i, section.Leg.StartSectionIndex, section.Leg.EndSectionIndex)
+		}
+		if i+1 < len(itinerary.Sections) {
+			if section.Next != &itinerary.Sections[i+1] {
+				t.Errorf("section %d's Next isn't section %d", i, i+1)
+			}
+			if math.Abs(section.DistanceFt+section.LengthFt-section.Next.DistanceFt) > 1e-6 {
+				t.Errorf("section %d ends at %v ft, but the next starts at %v ft", i, section.DistanceFt+section.LengthFt, section.Next.DistanceFt)
+			}
+		} else if section.Next != nil {
+			t.Errorf("last section has a Next")
+		}
+	}
+}
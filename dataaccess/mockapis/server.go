@@ -0,0 +1,390 @@
+/*
+Local stand-in for the Solcast, OpenRouteService and traffic APIs. Answers requests with
+recorded fixtures (see httpclient.FixtureTransport) when it has one, and with made up
+but realistic responses otherwise, so the whole tool can be run without API keys.
+*/
+package mockapis
+
+import (
+	"asc-simulation/dataaccess"
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/dataaccess/ors"
+	"asc-simulation/dataaccess/solcast"
+	"asc-simulation/dataaccess/traffic"
+	"asc-simulation/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Speed used for made up directions
+const syntheticSpeedMph float64 = 55
+
+// Made up traffic is this fast when the roads are empty
+const syntheticFreeFlowSpeedMph float64 = 65
+
+type Server struct {
+	// Recorded fixtures by FixtureKey(), for every suffix of their path
+	fixtures map[string]*httpclient.Fixture
+	weather  dataaccess.SyntheticWeatherProvider
+	// Answers traffic requests if set, instead of made up rush hours
+	Traffic dataaccess.TrafficProvider
+	// Prints every request if true
+	Verbose bool
+}
+
+/*
+Creates a server answering from the fixtures in fixtureFolder.
+fixtureFolder can be empty to only give made up responses.
+*/
+func NewServer(fixtureFolder string) (*Server, error) {
+	server := Server{
+		fixtures: make(map[string]*httpclient.Fixture),
+		weather:  dataaccess.DefaultSyntheticWeatherProvider(),
+	}
+	if fixtureFolder == "" {
+		return &server, nil
+	}
+
+	dirEntries, err := os.ReadDir(fixtureFolder)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &server, nil
+		}
+		return nil, errors.Join(errors.New("error loading HTTP fixtures"), err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".json" {
+			continue
+		}
+
+		fixture, err := httpclient.ReadFixture(filepath.Join(fixtureFolder, dirEntry.Name()))
+		if err != nil {
+			return nil, errors.Join(errors.New("error loading HTTP fixtures"), err)
+		}
+
+		// The API URLs may have a path ("https://api.solcast.com.au/data/"), and the server's URL
+		// won't, so fixtures are found by every ending of their path
+		for _, path := range pathSuffixes(fixture.Request.Path) {
+			request := fixture.Request
+			request.Path = path
+			server.fixtures[httpclient.FixtureKey(request)] = fixture
+		}
+	}
+
+	return &server, nil
+}
+
+func (server *Server) FixtureCount() int {
+	unique := make(map[*httpclient.Fixture]bool)
+	for _, fixture := range server.fixtures {
+		unique[fixture] = true
+	}
+	return len(unique)
+}
+
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	source := "synthetic"
+	defer func() {
+		if server.Verbose {
+			fmt.Printf("%s %s %s (%s)\n", time.Now().Format("15:04:05"), request.Method, request.URL.Path, source)
+		}
+	}()
+
+	for _, path := range pathSuffixes(request.URL.Path) {
+		fixture, exists := server.fixtures[httpclient.FixtureKey(httpclient.FixtureRequest{
+			Method: request.Method,
+			Path:   path,
+			Query:  request.URL.Query(),
+			Body:   string(body),
+		})]
+		if exists {
+			source = "fixture"
+			for name, value := range fixture.Response.Headers {
+				writer.Header().Set(name, value)
+			}
+			writer.WriteHeader(fixture.Response.StatusCode)
+			io.WriteString(writer, fixture.Response.Body)
+			return
+		}
+	}
+
+	var response any
+	switch {
+	case strings.HasSuffix(request.URL.Path, "/live/radiation_and_weather"):
+		response, err = server.solcastResponse(request, false)
+	case strings.HasSuffix(request.URL.Path, "/forecast/radiation_and_weather"):
+		response, err = server.solcastResponse(request, true)
+	case strings.HasSuffix(request.URL.Path, "/historic/radiation_and_weather"):
+		response, err = server.solcastResponse(request, false)
+	case strings.Contains(request.URL.Path, "/v2/directions/"):
+		response, err = directionsResponse(body)
+	case strings.HasSuffix(request.URL.Path, "/flow"):
+		response, err = server.flowResponse(request)
+	default:
+		source = "not found"
+		http.Error(writer, "no fixture or synthetic response for "+request.URL.Path, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(response)
+}
+
+// "/data/live/x" gives "/data/live/x", "/live/x" and "/x"
+func pathSuffixes(path string) []string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	suffixes := make([]string, len(segments))
+	for i := range segments {
+		suffixes[i] = "/" + strings.Join(segments[i:], "/")
+	}
+	return suffixes
+}
+
+/*
+Solcast's radiation_and_weather endpoints. Forecasts go forward from now, and live
+data goes back from now. Historic data covers the start and end parameters.
+*/
+func (server *Server) solcastResponse(request *http.Request, forecast bool) (any, error) {
+	query := request.URL.Query()
+
+	latitude, err := strconv.ParseFloat(query.Get("latitude"), 64)
+	if err != nil {
+		return nil, errors.New("invalid latitude")
+	}
+	longitude, err := strconv.ParseFloat(query.Get("longitude"), 64)
+	if err != nil {
+		return nil, errors.New("invalid longitude")
+	}
+	coordinates := types.Coordinates{Latitude: latitude, Longitude: longitude}
+
+	// The made up weather's gti is for the array in the request
+	weatherProvider := server.weather
+	weatherProvider.Array = types.SolarArray{AzimuthDegrees: 180}
+	if query.Has("tilt") {
+		weatherProvider.Array.TiltDegrees, err = strconv.ParseFloat(query.Get("tilt"), 64)
+		if err != nil {
+			return nil, errors.New("invalid tilt")
+		}
+	}
+	if query.Has("azimuth") {
+		azimuth, err := strconv.ParseFloat(query.Get("azimuth"), 64)
+		if err != nil {
+			return nil, errors.New("invalid azimuth")
+		}
+		weatherProvider.Array.AzimuthDegrees = solcast.FromSolcastAzimuth(azimuth)
+	}
+
+	period := solcast.TimePeriod(query.Get("period"))
+	if period == "" {
+		period = solcast.Period30Mins
+	}
+	periodDuration := time.Duration(period.MinuteValue()) * time.Minute
+	if periodDuration == 0 {
+		return nil, errors.New("invalid period")
+	}
+
+	now := time.Now().UTC().Truncate(periodDuration)
+	var start, end time.Time
+	if query.Has("start") {
+		start, err = time.Parse(time.RFC3339, query.Get("start"))
+		if err != nil {
+			return nil, errors.New("invalid start")
+		}
+		end, err = time.Parse(time.RFC3339, query.Get("end"))
+		if err != nil {
+			return nil, errors.New("invalid end")
+		}
+	} else {
+		hours, err := strconv.Atoi(query.Get("hours"))
+		if err != nil || hours <= 0 {
+			hours = 24
+		}
+		if forecast {
+			start, end = now, now.Add(time.Duration(hours)*time.Hour)
+		} else {
+			start, end = now.Add(-time.Duration(hours)*time.Hour), now
+		}
+	}
+
+	data := make([]solcast.LiveIrradianceAndWeatherActuals, 0)
+	for periodEnd := start.Add(periodDuration); !periodEnd.After(end); periodEnd = periodEnd.Add(periodDuration) {
+		samples, _ := weatherProvider.GetForecastWeather(request.Context(), coordinates, periodEnd.Add(-periodDuration/2), periodEnd.Add(-periodDuration/2))
+		weather := samples[0].Weather
+
+		airTempC := (weather.AirTempDegreesF - 32) * 5 / 9
+		data = append(data, solcast.LiveIrradianceAndWeatherActuals{
+			AirTemp:           airTempC,
+			CloudOpacity:      weather.CloudCoverPercentage,
+			DewpointTemp:      airTempC - 10,
+			PrecipitationRate: 0,
+			SurfacePressure:   weather.SurfacePressurePsi / 0.0145038,
+			WindDirection10m:  weather.WindDirectionDegrees,
+			WindSpeed10m:      weather.WindSpeedMph / 2.23694,
+			Zenith:            weather.SolarZenithDegrees,
+			Azimuth:           solcast.ToSolcastAzimuth(weather.SolarAzimuthDegrees),
+			Ghi:               weather.GlobalHorizontalIrradianceWm2,
+			Dni:               weather.DirectNormalIrradianceWm2,
+			Dhi:               weather.DiffuseHorizontalIrradianceWm2,
+			Gti:               weather.GlobalTiltedIrradianceWm2,
+			PeriodEnd:         periodEnd,
+		})
+	}
+
+	// Like Solcast, live data is newest first
+	if !forecast && !query.Has("start") {
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	if forecast {
+		forecasts := make([]solcast.ForecastIrradianceAndWeather, len(data))
+		for i := range data {
+			forecasts[i] = solcast.ForecastIrradianceAndWeather(data[i])
+		}
+		return solcast.ForecastIrradianceAndWeatherResponse{Forecasts: forecasts}, nil
+	}
+	return solcast.LiveIrradianceAndWeatherResponse{EstimatedActuals: data}, nil
+}
+
+/*
+The traffic API's flow endpoint (see the traffic package). Uses server.Traffic if set,
+and otherwise slows traffic down around 8am and 5pm local time on weekdays.
+*/
+func (server *Server) flowResponse(request *http.Request) (any, error) {
+	query := request.URL.Query()
+
+	latitude, err := strconv.ParseFloat(query.Get("latitude"), 64)
+	if err != nil {
+		return nil, errors.New("invalid latitude")
+	}
+	longitude, err := strconv.ParseFloat(query.Get("longitude"), 64)
+	if err != nil {
+		return nil, errors.New("invalid longitude")
+	}
+	coordinates := types.Coordinates{Latitude: latitude, Longitude: longitude}
+
+	at := time.Now()
+	if query.Has("time") {
+		at, err = time.Parse(time.RFC3339, query.Get("time"))
+		if err != nil {
+			return nil, errors.New("invalid time")
+		}
+	}
+
+	if server.Traffic != nil {
+		flow, err := server.Traffic.GetTraffic(request.Context(), coordinates, at)
+		if err != nil {
+			return nil, err
+		}
+		if flow == nil {
+			return traffic.FlowResponse{Covered: false}, nil
+		}
+		return traffic.FlowResponse{
+			FlowSpeedMph:     flow.FlowSpeedMph,
+			FreeFlowSpeedMph: flow.FreeFlowSpeedMph,
+			Covered:          true,
+		}, nil
+	}
+
+	// Approximate local time from the longitude, like the made up weather
+	localAt := at.UTC().Add(time.Duration(longitude / 15 * float64(time.Hour)))
+	hour := float64(localAt.Hour()) + float64(localAt.Minute())/60
+
+	rushHour := math.Exp(-math.Pow(hour-8, 2)/2) + math.Exp(-math.Pow(hour-17, 2)/2)
+	slowdown := 0.4
+	if localAt.Weekday() == time.Saturday || localAt.Weekday() == time.Sunday {
+		slowdown = 0.1
+	}
+
+	return traffic.FlowResponse{
+		FlowSpeedMph:     syntheticFreeFlowSpeedMph * (1 - slowdown*math.Min(1, rushHour)),
+		FreeFlowSpeedMph: syntheticFreeFlowSpeedMph,
+		Covered:          true,
+	}, nil
+}
+
+/*
+OpenRouteService's directions endpoint. Drives in a straight line from each waypoint
+to the next at syntheticSpeedMph, with a "Goal" step at every waypoint like ORS has.
+*/
+func directionsResponse(body []byte) (any, error) {
+	var request ors.DirectionsRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid directions request"), err)
+	}
+	if len(request.Coordinates) < 2 {
+		return nil, errors.New("at least 2 coordinates are needed")
+	}
+
+	segment := ors.Segment{AvgSpeed: syntheticSpeedMph}
+	for i := 1; i < len(request.Coordinates); i++ {
+		from := decodeCoordinates(request.Coordinates[i-1])
+		to := decodeCoordinates(request.Coordinates[i])
+
+		distanceMi := dataaccess.ApproximateDistanceMi(from, to)
+		durationS := distanceMi / syntheticSpeedMph * 3600
+
+		instructionType := types.Straight
+		instruction := fmt.Sprintf("Continue to waypoint %d", i)
+		if i == 1 {
+			instructionType = types.Depart
+			instruction = "Head to waypoint 1"
+		}
+
+		segment.Steps = append(segment.Steps,
+			ors.Step{
+				Distance:        distanceMi,
+				Duration:        durationS,
+				InstructionType: int(instructionType),
+				Instruction:     instruction,
+				WayPoints:       []int{i - 1, i},
+				Maneuver:        ors.Maneuver{Location: &to},
+			},
+			ors.Step{
+				InstructionType: int(types.Goal),
+				Instruction:     "Arrive at waypoint",
+				WayPoints:       []int{i, i},
+				Maneuver:        ors.Maneuver{Location: &to},
+			},
+		)
+		segment.Distance += distanceMi
+		segment.Duration += durationS
+	}
+
+	return ors.DirectionsResponse{
+		Routes: []ors.Route{{
+			Summary:  ors.Summary{Distance: segment.Distance, Duration: segment.Duration},
+			Segments: []ors.Segment{segment},
+		}},
+	}, nil
+}
+
+// ORS puts longitude before latitude
+func decodeCoordinates(coordinates []float64) types.Coordinates {
+	if len(coordinates) < 2 {
+		return types.Coordinates{}
+	}
+	return types.Coordinates{Latitude: coordinates[1], Longitude: coordinates[0]}
+}
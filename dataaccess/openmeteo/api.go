@@ -0,0 +1,119 @@
+package openmeteo
+
+import (
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Used if OPEN_METEO_URL or OPEN_METEO_ARCHIVE_URL are not set
+const defaultUrl string = "https://api.open-meteo.com/"
+const defaultArchiveUrl string = "https://archive-api.open-meteo.com/"
+
+// Gets current conditions, plus hourly conditions for the rest of today.
+func GetCurrentWeather(ctx context.Context, coordinates types.Coordinates, array types.SolarArray) (*WeatherResponse, error) {
+	functionErrMsg := errors.New("error getting weather from Open-Meteo")
+
+	query := createQuery(coordinates, array, forecastVariables())
+	query.Add("current", strings.Join(forecastVariables(), ","))
+	query.Add("forecast_days", "1")
+
+	var result WeatherResponse
+	err := sendRequest(ctx, getUrl("OPEN_METEO_URL", defaultUrl)+"v1/forecast", query, &result)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	if result.Current == nil {
+		return nil, errors.New("no current weather returned from Open-Meteo")
+	}
+
+	return &result, nil
+}
+
+// Gets hourly forecasted conditions for every day from start to end (up to 16 days ahead).
+func GetForecastWeather(ctx context.Context, coordinates types.Coordinates, array types.SolarArray, start time.Time, end time.Time) (*WeatherResponse, error) {
+	functionErrMsg := errors.New("error getting weather forecast from Open-Meteo")
+
+	query := createQuery(coordinates, array, forecastVariables())
+	query.Add("start_date", start.UTC().Format("2006-01-02"))
+	query.Add("end_date", end.UTC().Format("2006-01-02"))
+
+	var result WeatherResponse
+	err := sendRequest(ctx, getUrl("OPEN_METEO_URL", defaultUrl)+"v1/forecast", query, &result)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	if len(result.Hourly.Time) <= 0 {
+		return nil, errors.New("no weather data returned from Open-Meteo")
+	}
+
+	return &result, nil
+}
+
+// Gets hourly measured (reanalysis) conditions for every day from start to end.
+func GetHistoricalWeather(ctx context.Context, coordinates types.Coordinates, array types.SolarArray, start time.Time, end time.Time) (*WeatherResponse, error) {
+	functionErrMsg := errors.New("error getting historical weather from Open-Meteo")
+
+	query := createQuery(coordinates, array, Variables)
+	query.Add("start_date", start.UTC().Format("2006-01-02"))
+	query.Add("end_date", end.UTC().Format("2006-01-02"))
+
+	var result WeatherResponse
+	err := sendRequest(ctx, getUrl("OPEN_METEO_ARCHIVE_URL", defaultArchiveUrl)+"v1/archive", query, &result)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	if len(result.Hourly.Time) <= 0 {
+		return nil, errors.New("no weather data returned from Open-Meteo")
+	}
+
+	return &result, nil
+}
+
+func forecastVariables() []string {
+	return append(append([]string{}, Variables...), ForecastOnlyVariables...)
+}
+
+func createQuery(coordinates types.Coordinates, array types.SolarArray, variables []string) url.Values {
+	query := url.Values{}
+	query.Add("latitude", fmt.Sprint(coordinates.Latitude))
+	query.Add("longitude", fmt.Sprint(coordinates.Longitude))
+	// Used for global_tilted_irradiance. Open-Meteo's azimuth is 0 degrees for South and -90 degrees for East.
+	query.Add("tilt", fmt.Sprint(array.TiltDegrees))
+	query.Add("azimuth", fmt.Sprint(math.Mod(math.Mod(array.AzimuthDegrees, 360)+360, 360)-180))
+	query.Add("hourly", strings.Join(variables, ","))
+	query.Add("wind_speed_unit", "ms")
+	query.Add("timezone", "GMT")
+	return query
+}
+
+func getUrl(environmentVariable string, defaultValue string) string {
+	openMeteoUrl := os.Getenv(environmentVariable)
+	if openMeteoUrl == "" {
+		return defaultValue
+	}
+	if !strings.HasSuffix(openMeteoUrl, "/") {
+		openMeteoUrl += "/"
+	}
+	return openMeteoUrl
+}
+
+func sendRequest(ctx context.Context, requestUrl string, query url.Values, result any) error {
+	return httpclient.Default().SendJson(ctx, httpclient.Request{
+		Service: "Open-Meteo",
+		Method:  http.MethodGet,
+		Url:     requestUrl,
+		Query:   query,
+	}, result)
+}
@@ -0,0 +1,81 @@
+package openmeteo
+
+/*
+Type definitions for the Open-Meteo API.
+Reference: https://open-meteo.com/en/docs
+*/
+
+// Variables requested for every hour, and for current conditions
+var Variables = []string{
+	"temperature_2m",
+	"dew_point_2m",
+	"precipitation",
+	"surface_pressure",
+	"cloud_cover",
+	"wind_speed_10m",
+	"wind_direction_10m",
+	"shortwave_radiation",
+	"direct_normal_irradiance",
+	"diffuse_radiation",
+	"global_tilted_irradiance",
+	"wind_gusts_10m",
+}
+
+// Variables only available in forecasts, not historical data
+var ForecastOnlyVariables = []string{
+	"visibility",
+}
+
+/*
+Values are in the units requested: °C, mm, hPa, %, m/s and degrees. Radiation is in W/m^2,
+averaged over the hour before each time (the 15 minutes before for current conditions).
+*/
+type HourlyWeather struct {
+	// ISO 8601 times without a time zone, in UTC since we always request GMT
+	Time             []string
+	Temperature2m    []float64 `json:"temperature_2m"`
+	DewPoint2m       []float64 `json:"dew_point_2m"`
+	Precipitation    []float64
+	SurfacePressure  []float64 `json:"surface_pressure"`
+	CloudCover       []float64 `json:"cloud_cover"`
+	WindSpeed10m     []float64 `json:"wind_speed_10m"`
+	WindDirection10m []float64 `json:"wind_direction_10m"`
+	// Global horizontal irradiance
+	ShortwaveRadiation     []float64 `json:"shortwave_radiation"`
+	DirectNormalIrradiance []float64 `json:"direct_normal_irradiance"`
+	// Diffuse horizontal irradiance
+	DiffuseRadiation []float64 `json:"diffuse_radiation"`
+	// On the array with the tilt and azimuth that were requested
+	GlobalTiltedIrradiance []float64 `json:"global_tilted_irradiance"`
+	WindGusts10m           []float64 `json:"wind_gusts_10m"`
+	// In meters. Only in forecasts.
+	Visibility []float64
+}
+
+type CurrentWeather struct {
+	Time             string
+	Temperature2m    float64 `json:"temperature_2m"`
+	DewPoint2m       float64 `json:"dew_point_2m"`
+	Precipitation    float64
+	SurfacePressure  float64 `json:"surface_pressure"`
+	CloudCover       float64 `json:"cloud_cover"`
+	WindSpeed10m     float64 `json:"wind_speed_10m"`
+	WindDirection10m float64 `json:"wind_direction_10m"`
+	// See HourlyWeather
+	ShortwaveRadiation     float64 `json:"shortwave_radiation"`
+	DirectNormalIrradiance float64 `json:"direct_normal_irradiance"`
+	DiffuseRadiation       float64 `json:"diffuse_radiation"`
+	GlobalTiltedIrradiance float64 `json:"global_tilted_irradiance"`
+	WindGusts10m           float64 `json:"wind_gusts_10m"`
+	Visibility             float64
+}
+
+type WeatherResponse struct {
+	Latitude  float64
+	Longitude float64
+	Hourly    HourlyWeather
+	Current   *CurrentWeather // nullable, only set if current conditions were requested
+}
+
+// Layout of the times in responses
+const TimeLayout string = "2006-01-02T15:04"
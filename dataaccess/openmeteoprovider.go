@@ -0,0 +1,172 @@
+package dataaccess
+
+import (
+	"asc-simulation/dataaccess/openmeteo"
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+Gets weather from Open-Meteo, or any server with the same API.
+Uses the public servers unless OPEN_METEO_URL / OPEN_METEO_ARCHIVE_URL are set.
+Open-Meteo doesn't give the sun's position, so it is calculated instead.
+*/
+type OpenMeteoWeatherProvider struct {
+	// Open-Meteo gives the irradiance on this array
+	Array types.SolarArray
+}
+
+func (provider OpenMeteoWeatherProvider) Name() string {
+	return "open-meteo" + solarArrayName(provider.Array)
+}
+
+func (provider OpenMeteoWeatherProvider) GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*WeatherSample, error) {
+	response, err := openmeteo.GetCurrentWeather(ctx, coordinates, provider.Array)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	samples, err := openMeteoResponseToSamples(coordinates, response)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hourly data is only used to build up rain on the ground before now
+	now := time.Now()
+	rainOnGround := 0.0
+	for _, sample := range samples {
+		if sample.ValidAt.After(now) {
+			break
+		}
+		rainOnGround = sample.Weather.RainOnGroundInches
+	}
+
+	current := response.Current
+	zenith, azimuth := solarPositionDegrees(coordinates, now)
+	weather := metricWeatherToSamples([]metricWeather{{
+		validAt:              now,
+		zenithDegrees:        zenith,
+		solarAzimuthDegrees:  azimuth,
+		ghiWm2:               current.ShortwaveRadiation,
+		dniWm2:               current.DirectNormalIrradiance,
+		dhiWm2:               current.DiffuseRadiation,
+		gtiWm2:               current.GlobalTiltedIrradiance,
+		windGustMpS:          current.WindGusts10m,
+		visibilityM:          current.Visibility,
+		airTempC:             current.Temperature2m,
+		dewPointC:            current.DewPoint2m,
+		cloudCoverPercentage: current.CloudCover,
+		windSpeedMpS:         current.WindSpeed10m,
+		windDirectionDegrees: current.WindDirection10m,
+		precipitationMmph:    current.Precipitation,
+		surfacePressurehPa:   current.SurfacePressure,
+	}}, 0)[0]
+	weather.Weather.RainOnGroundInches = rainOnGround
+
+	return &weather, nil
+}
+
+// Can get forecasted data up to 16 days in the future.
+func (provider OpenMeteoWeatherProvider) GetForecastWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	response, err := openmeteo.GetForecastWeather(ctx, coordinates, provider.Array, start.Add(-rainBuildUpHours*time.Hour), end)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	samples, err := openMeteoResponseToSamples(coordinates, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return samplesInWindow(samples, start, end), nil
+}
+
+func (provider OpenMeteoWeatherProvider) GetHistoricalWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	response, err := openmeteo.GetHistoricalWeather(ctx, coordinates, provider.Array, start.Add(-rainBuildUpHours*time.Hour), end)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	samples, err := openMeteoResponseToSamples(coordinates, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return samplesInWindow(samples, start, end), nil
+}
+
+func openMeteoResponseToSamples(coordinates types.Coordinates, response *openmeteo.WeatherResponse) ([]WeatherSample, error) {
+	hourly := response.Hourly
+
+	weather := make([]metricWeather, 0, len(hourly.Time))
+	for i := range hourly.Time {
+		if i >= len(hourly.Temperature2m) || i >= len(hourly.DewPoint2m) ||
+			i >= len(hourly.Precipitation) || i >= len(hourly.SurfacePressure) ||
+			i >= len(hourly.CloudCover) || i >= len(hourly.WindSpeed10m) ||
+			i >= len(hourly.WindDirection10m) {
+			return nil, errors.New("incomplete hourly data returned from Open-Meteo")
+		}
+
+		validAt, err := time.Parse(openmeteo.TimeLayout, hourly.Time[i])
+		if err != nil {
+			return nil, errors.Join(errors.New("invalid time returned from Open-Meteo"), err)
+		}
+
+		zenith, azimuth := solarPositionDegrees(coordinates, validAt)
+		weather = append(weather, metricWeather{
+			validAt:              validAt,
+			zenithDegrees:        zenith,
+			solarAzimuthDegrees:  azimuth,
+			ghiWm2:               radiationAt(hourly.ShortwaveRadiation, i),
+			dniWm2:               radiationAt(hourly.DirectNormalIrradiance, i),
+			dhiWm2:               radiationAt(hourly.DiffuseRadiation, i),
+			gtiWm2:               radiationAt(hourly.GlobalTiltedIrradiance, i),
+			windGustMpS:          valueAt(hourly.WindGusts10m, i),
+			visibilityM:          valueAt(hourly.Visibility, i),
+			airTempC:             hourly.Temperature2m[i],
+			dewPointC:            hourly.DewPoint2m[i],
+			cloudCoverPercentage: hourly.CloudCover[i],
+			windSpeedMpS:         hourly.WindSpeed10m[i],
+			windDirectionDegrees: hourly.WindDirection10m[i],
+			// Open-Meteo gives the total over the past hour, which is the same as the hourly rate
+			precipitationMmph:  hourly.Precipitation[i],
+			surfacePressurehPa: hourly.SurfacePressure[i],
+		})
+	}
+
+	return metricWeatherToSamples(weather, 60), nil
+}
+
+/*
+Radiation is averaged over the hour before each time, so the value at a time is
+the average of the hours on either side of it. 0 if Open-Meteo didn't return any.
+*/
+func radiationAt(values []float64, i int) float64 {
+	if i >= len(values) {
+		return 0
+	}
+	if i+1 >= len(values) {
+		return values[i]
+	}
+	return (values[i] + values[i+1]) / 2
+}
+
+// 0 if Open-Meteo didn't return the variable, e.g. visibility in historical data
+func valueAt(values []float64, i int) float64 {
+	if i >= len(values) {
+		return 0
+	}
+	return values[i]
+}
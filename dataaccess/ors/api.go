@@ -1,17 +1,16 @@
 package ors
 
 import (
+	"asc-simulation/dataaccess/httpclient"
 	"asc-simulation/types"
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
 	"os"
 )
 
-func GetDirections(coordinates []types.Coordinates) (*DirectionsResponse, error) {
+func GetDirections(ctx context.Context, coordinates []types.Coordinates) (*DirectionsResponse, error) {
 	functionErrMsg := errors.New("error getting directions from OpenRouteService")
 
 	formattedCoordinates := make([][]float64, len(coordinates))
@@ -46,35 +45,17 @@ func GetDirections(coordinates []types.Coordinates) (*DirectionsResponse, error)
 
 	requestPath := "v2/directions/driving-car"
 
-	request, err := http.NewRequest(
-		http.MethodPost,
-		orsUrl+requestPath,
-		bytes.NewReader(requestBodyJson),
-	)
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", orsToken)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
-	if response.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(response.Body)
-		fmt.Println("\nResponse: ", string(respBody), "\n")
-
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("request to OpenRouteService failed"),
-			errors.New("response status: "+response.Status),
-		)
-	}
-
 	var result DirectionsResponse
-	decoder := json.NewDecoder(response.Body)
-	err = decoder.Decode(&result)
+	err := httpclient.Default().SendJson(ctx, httpclient.Request{
+		Service: "OpenRouteService",
+		Method:  http.MethodPost,
+		Url:     orsUrl + requestPath,
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": orsToken,
+		},
+		Body: requestBodyJson,
+	}, &result)
 	if err != nil {
 		return nil, errors.Join(functionErrMsg, err)
 	}
@@ -3,20 +3,40 @@ package dataaccess
 import (
 	"asc-simulation/dataaccess/ors"
 	"asc-simulation/types"
+	"context"
 	"encoding/json"
 	"errors"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/tkrajina/gpxgo/gpx"
 )
 
+/*
+This struct exists so we can add import settings to CreateRoutes() without having to
+change the code everywhere CreateRoutes() is used.
+*/
+type RouteImportOptions struct {
+	// Any fields set here replace the metadata guessed from the route name.
+	Metadata types.RouteMetadata
+	// Cancels OpenRouteService calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+func (options *RouteImportOptions) context() context.Context {
+	if options.Context != nil {
+		return options.Context
+	}
+	return context.Background()
+}
+
 /*
 Creates route files (which is just a .json file) from a .gpx file.
 Each route in the .gpx file will be written to a separate .json file in
-the output folder.
+the output folder, and the output folder's route catalog is updated.
 
 This function should not be called during a simulation!
 It calls external APIs to get additional route data.
@@ -25,7 +45,7 @@ This function returns an slice of errors; if it fails at creating a .json
 file for one route, it will continue trying to create .json files for all of the
 other routes. Always check the returned slice to see if any routes were not written to .json.
 */
-func CreateRoutes(inputGpxFilePath string, outputFolder string) []error {
+func CreateRoutes(inputGpxFilePath string, outputFolder string, options RouteImportOptions) []error {
 	gpxFile, err := gpx.ParseFile(inputGpxFilePath)
 	if err != nil {
 		return []error{errors.Join(errors.New("error parsing .gpx file"), err)}
@@ -35,7 +55,7 @@ func CreateRoutes(inputGpxFilePath string, outputFolder string) []error {
 	var errs []error
 
 	for _, route := range gpxFile.Routes {
-		createdRoute, err := createRouteFromGpxRoute(&route)
+		createdRoute, err := createRouteFromGpxRoute(options.context(), &route)
 		if err != nil {
 			newErr := errors.Join(
 				errors.New("could not get route data for route \""+route.Name+"\""),
@@ -45,11 +65,12 @@ func CreateRoutes(inputGpxFilePath string, outputFolder string) []error {
 			continue
 		}
 
+		createdRoute.Metadata = mergeRouteMetadata(createdRoute.Metadata, options.Metadata)
 		routes = append(routes, *createdRoute)
 	}
 
 	for _, track := range gpxFile.Tracks {
-		createdRoute, err := createRouteFromGpxTrack(&track)
+		createdRoute, err := createRouteFromGpxTrack(options.context(), &track)
 		if err != nil {
 			newErr := errors.Join(
 				errors.New("could not get route data for route \""+track.Name+"\""),
@@ -59,6 +80,7 @@ func CreateRoutes(inputGpxFilePath string, outputFolder string) []error {
 			continue
 		}
 
+		createdRoute.Metadata = mergeRouteMetadata(createdRoute.Metadata, options.Metadata)
 		routes = append(routes, *createdRoute)
 	}
 
@@ -81,7 +103,7 @@ func CreateRoutes(inputGpxFilePath string, outputFolder string) []error {
 Find a route in a .gpx file, then create a .route.json file for that route.
 Returns an error if the named route is not in the .gpx file.
 */
-func FindAndCreateRoute(routeName string, inputGpxFilePath, outputFolder string) error {
+func FindAndCreateRoute(routeName string, inputGpxFilePath, outputFolder string, options RouteImportOptions) error {
 	gpxFile, err := gpx.ParseFile(inputGpxFilePath)
 	if err != nil {
 		return errors.Join(errors.New("error parsing .gpx file"), err)
@@ -112,7 +134,7 @@ func FindAndCreateRoute(routeName string, inputGpxFilePath, outputFolder string)
 
 	var createdRoute *types.Route = nil
 	if gpxRoute != nil {
-		createdRoute, err = createRouteFromGpxRoute(gpxRoute)
+		createdRoute, err = createRouteFromGpxRoute(options.context(), gpxRoute)
 		if err != nil {
 			return errors.Join(
 				errors.New("could not get route data for route \""+gpxRoute.Name+"\""),
@@ -121,7 +143,7 @@ func FindAndCreateRoute(routeName string, inputGpxFilePath, outputFolder string)
 		}
 	}
 	if gpxTrack != nil {
-		createdRoute, err = createRouteFromGpxTrack(gpxTrack)
+		createdRoute, err = createRouteFromGpxTrack(options.context(), gpxTrack)
 		if err != nil {
 			return errors.Join(
 				errors.New("could not get route data for route \""+gpxTrack.Name+"\""),
@@ -130,6 +152,8 @@ func FindAndCreateRoute(routeName string, inputGpxFilePath, outputFolder string)
 		}
 	}
 
+	createdRoute.Metadata = mergeRouteMetadata(createdRoute.Metadata, options.Metadata)
+
 	err = writeRouteToFile(createdRoute, outputFolder)
 	if err != nil {
 		return errors.Join(
@@ -155,14 +179,27 @@ func LoadRoute(routeFilePath string) (*types.Route, error) {
 
 	defer file.Close()
 
-	var route types.Route
+	// Route files created before routes had metadata only have IsLoop
+	var routeFile struct {
+		types.Route
+		IsLoop bool
+	}
 
 	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&route)
+	err = decoder.Decode(&routeFile)
 	if err != nil {
 		return nil, errors.Join(functionErrMsg, err)
 	}
 
+	route := routeFile.Route
+	if route.Metadata.Kind == "" {
+		route.Metadata = inferRouteMetadata(route.Name)
+		route.Metadata.Kind = types.StageRoute
+		if routeFile.IsLoop {
+			route.Metadata.Kind = types.LoopRoute
+		}
+	}
+
 	for i := range route.Sections {
 		route.Sections[i].Route = &route
 		if i >= len(route.Sections)-1 {
@@ -196,8 +233,8 @@ func findSectionFromAddress(
 
 // const maxRoutepointsPerRequest int = 860 // May need to set this through configs in the future
 
-func createRouteFromGpxRoute(gpxRoute *gpx.GPXRoute) (*types.Route, error) {
-	route, err := createRouteFromGpxPoints(gpxRoute.Points)
+func createRouteFromGpxRoute(ctx context.Context, gpxRoute *gpx.GPXRoute) (*types.Route, error) {
+	route, err := createRouteFromGpxPoints(ctx, gpxRoute.Points)
 	if err != nil {
 		return nil, err
 	}
@@ -206,22 +243,18 @@ func createRouteFromGpxRoute(gpxRoute *gpx.GPXRoute) (*types.Route, error) {
 	if route.Name == "" {
 		route.Name = "myroute"
 	}
-
-	// True if the route name ends in "loop"
-	// Add option to set this manually?
-	route.IsLoop = len(gpxRoute.Name) >= 4 &&
-		gpxRoute.Name[len(gpxRoute.Name)-4:] == "Loop"
+	route.Metadata = inferRouteMetadata(gpxRoute.Name)
 
 	return route, nil
 }
 
-func createRouteFromGpxTrack(gpxTrack *gpx.GPXTrack) (*types.Route, error) {
+func createRouteFromGpxTrack(ctx context.Context, gpxTrack *gpx.GPXTrack) (*types.Route, error) {
 	points := []gpx.GPXPoint{}
 	for i := range gpxTrack.Segments {
 		points = append(points, gpxTrack.Segments[i].Points...)
 	}
 
-	route, err := createRouteFromGpxPoints(points)
+	route, err := createRouteFromGpxPoints(ctx, points)
 	if err != nil {
 		return nil, err
 	}
@@ -230,16 +263,72 @@ func createRouteFromGpxTrack(gpxTrack *gpx.GPXTrack) (*types.Route, error) {
 	if route.Name == "" {
 		route.Name = "myroute"
 	}
+	route.Metadata = inferRouteMetadata(gpxTrack.Name)
+
+	return route, nil
+}
+
+/*
+Guesses route metadata from names like "AL: Paducah Loop" or "A: Nashville to Paducah".
+Only used as a starting point when importing - the saved metadata is what everything else reads.
+*/
+func inferRouteMetadata(routeName string) types.RouteMetadata {
+	metadata := types.RouteMetadata{Kind: types.StageRoute}
+
+	prefix, description, hasPrefix := strings.Cut(routeName, ":")
+	if !hasPrefix {
+		description = routeName
+		prefix = ""
+	}
+	prefix = strings.TrimSpace(prefix)
+	description = strings.TrimSpace(description)
 
 	// True if the route name ends in "loop"
-	// Add option to set this manually?
-	route.IsLoop = len(gpxTrack.Name) >= 4 &&
-		gpxTrack.Name[len(gpxTrack.Name)-4:] == "Loop"
+	if strings.HasSuffix(strings.ToLower(description), "loop") {
+		metadata.Kind = types.LoopRoute
+		metadata.StageLetter = strings.TrimSuffix(prefix, "L")
+
+		city := strings.TrimSpace(description[:len(description)-len("loop")])
+		metadata.StartCheckpoint = city
+		metadata.EndCheckpoint = city
+		return metadata
+	}
 
-	return route, nil
+	metadata.StageLetter = prefix
+	lowerDescription := strings.ToLower(description)
+	if splitIndex := strings.Index(lowerDescription, " to "); splitIndex >= 0 {
+		metadata.StartCheckpoint = strings.TrimSpace(description[:splitIndex])
+		metadata.EndCheckpoint = strings.TrimSpace(description[splitIndex+len(" to "):])
+	}
+
+	return metadata
+}
+
+// Returns the guessed metadata, with any field set in overrides replacing it.
+func mergeRouteMetadata(guessed types.RouteMetadata, overrides types.RouteMetadata) types.RouteMetadata {
+	if overrides.Kind != "" {
+		guessed.Kind = overrides.Kind
+	}
+	if overrides.StageLetter != "" {
+		guessed.StageLetter = overrides.StageLetter
+	}
+	if overrides.StartCheckpoint != "" {
+		guessed.StartCheckpoint = overrides.StartCheckpoint
+	}
+	if overrides.EndCheckpoint != "" {
+		guessed.EndCheckpoint = overrides.EndCheckpoint
+	}
+	if overrides.EventYear != 0 {
+		guessed.EventYear = overrides.EventYear
+	}
+	if overrides.TimeZone != "" {
+		guessed.TimeZone = overrides.TimeZone
+	}
+
+	return guessed
 }
 
-func createRouteFromGpxPoints(gpxPoints []gpx.GPXPoint) (*types.Route, error) {
+func createRouteFromGpxPoints(ctx context.Context, gpxPoints []gpx.GPXPoint) (*types.Route, error) {
 	functionErrMsg := errors.New("error creating route")
 	var route types.Route
 
@@ -260,7 +349,7 @@ func createRouteFromGpxPoints(gpxPoints []gpx.GPXPoint) (*types.Route, error) {
 			float64(currIndex+50),
 		))
 
-		directions, err := ors.GetDirections(coordinates[currIndex:sliceEnd])
+		directions, err := ors.GetDirections(ctx, coordinates[currIndex:sliceEnd])
 		if err != nil {
 			return nil, errors.Join(functionErrMsg, err)
 		}
@@ -336,7 +425,11 @@ func createRouteFromGpxPoints(gpxPoints []gpx.GPXPoint) (*types.Route, error) {
 
 		if currIndex+50 < len(coordinates) {
 			// Wait 1.5 seconds to get around ORS API rate limits
-			time.Sleep(1500 * time.Millisecond)
+			select {
+			case <-time.After(1500 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, errors.Join(functionErrMsg, ctx.Err())
+			}
 		}
 
 	}
@@ -405,16 +498,41 @@ func writeRouteToFile(route *types.Route, outputFolder string) error {
 	}
 
 	fileName := removeIllegalFilenameChars(route.Name)
-	filePath := outputFolder + "/" + fileName + ".route.json"
-	file, err := os.Create(filePath)
+	filePath := outputFolder + "/" + fileName + routeFileExtension
+
+	err := saveRoute(route, filePath)
 	if err != nil {
 		return errors.Join(functionErrMsg, err)
 	}
 
-	defer file.Close()
+	err = updateRouteCatalog(outputFolder, filePath, route)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(*route)
+	return nil
+}
+
+/*
+Replaces the metadata of an existing route file, and updates the route catalog
+in the same folder.
+*/
+func UpdateRouteMetadata(routeFilePath string, metadata types.RouteMetadata) error {
+	functionErrMsg := errors.New("error updating route metadata")
+
+	route, err := LoadRoute(routeFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	route.Metadata = mergeRouteMetadata(route.Metadata, metadata)
+
+	err = saveRoute(route, routeFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	err = updateRouteCatalog(filepath.Dir(routeFilePath), routeFilePath, route)
 	if err != nil {
 		return errors.Join(functionErrMsg, err)
 	}
@@ -422,6 +540,18 @@ func writeRouteToFile(route *types.Route, outputFolder string) error {
 	return nil
 }
 
+func saveRoute(route *types.Route, routeFilePath string) error {
+	file, err := os.Create(routeFilePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	return encoder.Encode(*route)
+}
+
 func removeIllegalFilenameChars(filename string) string {
 	illegalChars := []string{"<", ">", ":", "\"", "/", "\\", "|", "?", "*"}
 
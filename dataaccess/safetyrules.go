@@ -0,0 +1,67 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+)
+
+/*
+Speed limits the driver follows in bad weather. Based on what our drivers were
+comfortable with in testing - adjust them in a file set by SAFETY_RULES_FILE.
+*/
+func DefaultSafetyRules() []types.SafetyRule {
+	return []types.SafetyRule{
+		{Condition: types.CrosswindCondition, Threshold: 15, MaxSpeedMph: 50},
+		{Condition: types.CrosswindCondition, Threshold: 20, MaxSpeedMph: 40},
+		{Condition: types.CrosswindCondition, Threshold: 30, MaxSpeedMph: 25},
+		{Condition: types.GustCondition, Threshold: 30, MaxSpeedMph: 45},
+		{Condition: types.GustCondition, Threshold: 40, MaxSpeedMph: 30},
+		{Condition: types.RainCondition, Threshold: 0.05, MaxSpeedMph: 45},
+		{Condition: types.RainCondition, Threshold: 0.25, MaxSpeedMph: 35},
+		{Condition: types.VisibilityCondition, Threshold: 1, MaxSpeedMph: 40},
+		{Condition: types.VisibilityCondition, Threshold: 0.25, MaxSpeedMph: 20},
+	}
+}
+
+/*
+Loads the safety rules from the JSON file set by the SAFETY_RULES_FILE environment
+variable, or returns DefaultSafetyRules() if it isn't set. The file is a list of rules:
+
+	[{"Condition": "crosswind", "Threshold": 20, "MaxSpeedMph": 40}, ...]
+*/
+func GetSafetyRules() ([]types.SafetyRule, error) {
+	safetyRulesFilePath := os.Getenv("SAFETY_RULES_FILE")
+	if safetyRulesFilePath == "" {
+		return DefaultSafetyRules(), nil
+	}
+
+	functionErrMsg := errors.New("error loading safety rules")
+
+	file, err := os.Open(safetyRulesFilePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var rules []types.SafetyRule
+	err = json.NewDecoder(file).Decode(&rules)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	for i, rule := range rules {
+		switch rule.Condition {
+		case types.CrosswindCondition, types.GustCondition, types.RainCondition, types.VisibilityCondition:
+		default:
+			return nil, errors.Join(functionErrMsg, errors.New("rule "+strconv.Itoa(i+1)+" has unknown condition \""+string(rule.Condition)+"\""))
+		}
+		if rule.MaxSpeedMph <= 0 {
+			return nil, errors.Join(functionErrMsg, errors.New("rule "+strconv.Itoa(i+1)+" must have a MaxSpeedMph above 0"))
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,48 @@
+package dataaccess
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"asc-simulation/types"
+)
+
+// Saves the per-section results of a simulation to a .json file.
+func SaveSimulatedRun(runFilePath string, run *types.SimulatedRun) error {
+	functionErrMsg := errors.New("error saving simulation result")
+
+	file, err := os.Create(runFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(*run)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Loads a simulation result saved by SaveSimulatedRun().
+func LoadSimulatedRun(runFilePath string) (*types.SimulatedRun, error) {
+	functionErrMsg := errors.New("error loading simulation result \"" + runFilePath + "\"")
+
+	file, err := os.Open(runFilePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var run types.SimulatedRun
+	err = json.NewDecoder(file).Decode(&run)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	return &run, nil
+}
@@ -0,0 +1,167 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sunlight at the top of the atmosphere
+const solarConstantWm2 = 1361.0
+
+// Fraction of sunlight reflected by the ground, for light reaching tilted arrays from below
+const groundAlbedo = 0.2
+
+/*
+Reads which way the solar array faces from the SOLAR_ARRAY_TILT and SOLAR_ARRAY_AZIMUTH
+environment variables, in degrees. Defaults to flat, which is what the car's array is.
+*/
+func GetSolarArray() (types.SolarArray, error) {
+	array := types.SolarArray{TiltDegrees: 0, AzimuthDegrees: 180}
+
+	for environmentVariable, value := range map[string]*float64{
+		"SOLAR_ARRAY_TILT":    &array.TiltDegrees,
+		"SOLAR_ARRAY_AZIMUTH": &array.AzimuthDegrees,
+	} {
+		setting := os.Getenv(environmentVariable)
+		if setting == "" {
+			continue
+		}
+
+		number, err := strconv.ParseFloat(setting, 64)
+		if err != nil {
+			return array, errors.New(environmentVariable + " must be a number of degrees, not: '" + setting + "'")
+		}
+		*value = number
+	}
+
+	if array.TiltDegrees < 0 || array.TiltDegrees > 90 {
+		return array, errors.New("SOLAR_ARRAY_TILT must be between 0 and 90 degrees")
+	}
+	array.AzimuthDegrees = math.Mod(math.Mod(array.AzimuthDegrees, 360)+360, 360)
+
+	return array, nil
+}
+
+/*
+Added to weather provider names, since the irradiance on the array depends on which way
+it faces. Empty for a flat array, so cached weather from before arrays could tilt is still used.
+*/
+func solarArrayName(array types.SolarArray) string {
+	if array.TiltDegrees == 0 {
+		return ""
+	}
+	return "-tilt" + strconv.FormatFloat(array.TiltDegrees, 'f', -1, 64) +
+		"-azimuth" + strconv.FormatFloat(array.AzimuthDegrees, 'f', -1, 64)
+}
+
+/*
+Calculates the angle between the sun and straight up, for weather sources that
+don't provide it. Accurate to within a fraction of a degree, which is plenty for us.
+*/
+func solarZenithDegrees(coordinates types.Coordinates, at time.Time) float64 {
+	zenith, _ := solarPositionDegrees(coordinates, at)
+	return zenith
+}
+
+/*
+Calculates the sun's zenith and azimuth angles (0 degrees is North, 90 degrees is East).
+
+Source: https://gml.noaa.gov/grad/solcalc/solareqns.PDF
+*/
+func solarPositionDegrees(coordinates types.Coordinates, at time.Time) (zenith float64, azimuth float64) {
+	utc := at.UTC()
+	hour := float64(utc.Hour()) + float64(utc.Minute())/60 + float64(utc.Second())/3600
+
+	// Fractional year, in radians
+	gamma := 2 * math.Pi / 365 * (float64(utc.YearDay()) - 1 + (hour-12)/24)
+
+	equationOfTimeMinutes := 229.18 * (0.000075 +
+		0.001868*math.Cos(gamma) -
+		0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) -
+		0.040849*math.Sin(2*gamma))
+
+	declination := 0.006918 -
+		0.399912*math.Cos(gamma) +
+		0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) +
+		0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) +
+		0.00148*math.Sin(3*gamma)
+
+	trueSolarTimeMinutes := hour*60 + equationOfTimeMinutes + 4*coordinates.Longitude
+	hourAngle := (trueSolarTimeMinutes/4 - 180) * math.Pi / 180
+
+	latitude := coordinates.Latitude * math.Pi / 180
+	cosZenith := math.Sin(latitude)*math.Sin(declination) +
+		math.Cos(latitude)*math.Cos(declination)*math.Cos(hourAngle)
+	zenithRadians := math.Acos(math.Max(-1, math.Min(1, cosZenith)))
+
+	// The sun is due south or north at noon, so the hour angle says which side of noon we're on
+	cosAzimuth := (math.Sin(latitude)*math.Cos(zenithRadians) - math.Sin(declination)) /
+		(math.Cos(latitude) * math.Sin(zenithRadians))
+	azimuthDegrees := math.Acos(math.Max(-1, math.Min(1, cosAzimuth))) * 180 / math.Pi
+	if math.Sin(hourAngle) > 0 {
+		azimuthDegrees = math.Mod(azimuthDegrees+180, 360)
+	} else {
+		azimuthDegrees = math.Mod(540-azimuthDegrees, 360)
+	}
+
+	return zenithRadians * 180 / math.Pi, azimuthDegrees
+}
+
+/*
+Estimates global horizontal, direct normal and diffuse horizontal irradiance in W/m^2,
+for weather sources that don't provide them.
+Clear sky direct light uses Meinel's air mass model, with diffuse light 10% of the direct
+light. Clouds block direct light and the total follows Kasten and Czeplak's cloud cover model.
+*/
+func estimateIrradianceWm2(zenithDegrees float64, cloudCoverPercentage float64) (ghi float64, dni float64, dhi float64) {
+	cosZenith := math.Cos(zenithDegrees * math.Pi / 180)
+	if cosZenith <= 0 {
+		return 0, 0, 0
+	}
+
+	airMass := 1 / math.Max(cosZenith, 0.05)
+	clearDni := solarConstantWm2 * math.Pow(0.7, math.Pow(airMass, 0.678))
+	clearGhi := 1.1 * clearDni * cosZenith
+
+	cloudFraction := math.Max(0, math.Min(1, cloudCoverPercentage/100))
+	ghi = clearGhi * (1 - 0.75*math.Pow(cloudFraction, 3.4))
+	dni = clearDni * (1 - cloudFraction)
+	dhi = math.Max(0, ghi-dni*cosZenith)
+
+	return ghi, dni, dhi
+}
+
+/*
+Irradiance on a tilted array in W/m^2, treating diffuse light as coming equally
+from the whole sky (the isotropic sky model).
+*/
+func tiltedIrradianceWm2(
+	ghi float64,
+	dni float64,
+	dhi float64,
+	zenithDegrees float64,
+	sunAzimuthDegrees float64,
+	array types.SolarArray,
+) float64 {
+	if array.TiltDegrees == 0 {
+		return ghi
+	}
+
+	zenith := zenithDegrees * math.Pi / 180
+	tilt := array.TiltDegrees * math.Pi / 180
+	cosIncidence := math.Cos(zenith)*math.Cos(tilt) +
+		math.Sin(zenith)*math.Sin(tilt)*math.Cos((sunAzimuthDegrees-array.AzimuthDegrees)*math.Pi/180)
+
+	direct := dni * math.Max(0, cosIncidence)
+	diffuse := dhi * (1 + math.Cos(tilt)) / 2
+	reflected := ghi * groundAlbedo * (1 - math.Cos(tilt)) / 2
+
+	return direct + diffuse + reflected
+}
@@ -1,16 +1,19 @@
 package solcast
 
 import (
+	"asc-simulation/dataaccess/httpclient"
 	"asc-simulation/types"
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 )
 
-// Currently used for both live and forecasted weather data
+// Currently used for live, forecasted and historic weather data
 var outputParameters = []string{
 	"air_temp",
 	"cloud_opacity",
@@ -20,70 +23,27 @@ var outputParameters = []string{
 	"wind_direction_10m",
 	"wind_speed_10m",
 	"zenith",
+	"azimuth",
+	"ghi",
+	"dni",
+	"dhi",
+	"gti",
 }
 
 func GetLiveIrradianceAndWeather(
+	ctx context.Context,
 	coordinates types.Coordinates,
+	array types.SolarArray,
 	responseWindowHours int,
 	responseIntervalMinutes TimePeriod,
 ) (*LiveIrradianceAndWeatherResponse, error) {
 	functionErrMsg := errors.New("error getting weather from Solcast")
 
-	solcastUrl := os.Getenv("SOLCAST_URL")
-	if solcastUrl == "" {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("no URL found for Solcast API"),
-		)
-	}
-
-	solcastToken := os.Getenv("SOLCAST_TOKEN")
-	if solcastToken == "" {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("no authorization token found for Solcast API"),
-		)
-	}
-
-	requestPath := "live/radiation_and_weather"
-	request, err := http.NewRequest(
-		http.MethodGet,
-		solcastUrl+requestPath,
-		bytes.NewReader(nil),
-	)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
-
-	request.Header.Set("Authorization", "Bearer "+solcastToken)
-
-	query := request.URL.Query()
-	query.Add("latitude", fmt.Sprint(coordinates.Latitude))
-	query.Add("longitude", fmt.Sprint(coordinates.Longitude))
+	query := createQuery(coordinates, array, responseIntervalMinutes)
 	query.Add("hours", fmt.Sprint(responseWindowHours))
-	query.Add("period", string(responseIntervalMinutes))
-
-	for _, param := range outputParameters {
-		query.Add("output_parameters", param)
-	}
-
-	query.Add("format", "json")
-	request.URL.RawQuery = query.Encode()
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
-	if response.StatusCode >= 400 {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("request to Solcast failed"),
-		)
-	}
 
 	var result LiveIrradianceAndWeatherResponse
-	decoder := json.NewDecoder(response.Body)
-	err = decoder.Decode(&result)
+	err := sendRequest(ctx, "live/radiation_and_weather", query, &result)
 	if err != nil {
 		return nil, errors.Join(functionErrMsg, err)
 	}
@@ -96,74 +56,118 @@ func GetLiveIrradianceAndWeather(
 }
 
 func GetForecastIrradianceAndWeather(
+	ctx context.Context,
 	coordinates types.Coordinates,
+	array types.SolarArray,
 	responseWindowHours int,
 	responseIntervalMinutes TimePeriod,
 ) (*ForecastIrradianceAndWeatherResponse, error) {
 	functionErrMsg := errors.New("error getting weather from Solcast")
 
-	solcastUrl := os.Getenv("SOLCAST_URL")
-	if solcastUrl == "" {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("no URL found for Solcast API"),
-		)
+	query := createQuery(coordinates, array, responseIntervalMinutes)
+	query.Add("hours", fmt.Sprint(responseWindowHours))
+
+	var result ForecastIrradianceAndWeatherResponse
+	err := sendRequest(ctx, "forecast/radiation_and_weather", query, &result)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
 	}
 
-	solcastToken := os.Getenv("SOLCAST_TOKEN")
-	if solcastToken == "" {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("no authorization token found for Solcast API"),
-		)
+	if len(result.Forecasts) <= 0 {
+		return nil, errors.New("no weather data returned from Solcast")
 	}
 
-	requestPath := "forecast/radiation_and_weather"
-	request, err := http.NewRequest(
-		http.MethodGet,
-		solcastUrl+requestPath,
-		bytes.NewReader(nil),
-	)
+	return &result, nil
+}
+
+/*
+Gets the weather that was measured between start and end.
+Solcast only allows up to 31 days per request.
+*/
+func GetHistoricIrradianceAndWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	array types.SolarArray,
+	start time.Time,
+	end time.Time,
+	responseIntervalMinutes TimePeriod,
+) (*HistoricIrradianceAndWeatherResponse, error) {
+	functionErrMsg := errors.New("error getting historic weather from Solcast")
+
+	query := createQuery(coordinates, array, responseIntervalMinutes)
+	query.Add("start", start.UTC().Format(time.RFC3339))
+	query.Add("end", end.UTC().Format(time.RFC3339))
+
+	var result HistoricIrradianceAndWeatherResponse
+	err := sendRequest(ctx, "historic/radiation_and_weather", query, &result)
 	if err != nil {
 		return nil, errors.Join(functionErrMsg, err)
 	}
 
-	request.Header.Set("Authorization", "Bearer "+solcastToken)
+	if len(result.EstimatedActuals) <= 0 {
+		return nil, errors.New("no weather data returned from Solcast")
+	}
+
+	return &result, nil
+}
 
-	query := request.URL.Query()
+func createQuery(coordinates types.Coordinates, array types.SolarArray, responseIntervalMinutes TimePeriod) url.Values {
+	query := url.Values{}
 	query.Add("latitude", fmt.Sprint(coordinates.Latitude))
 	query.Add("longitude", fmt.Sprint(coordinates.Longitude))
-	query.Add("hours", fmt.Sprint(responseWindowHours))
 	query.Add("period", string(responseIntervalMinutes))
+	// Used for gti
+	query.Add("tilt", fmt.Sprint(array.TiltDegrees))
+	query.Add("azimuth", fmt.Sprint(ToSolcastAzimuth(array.AzimuthDegrees)))
 
 	for _, param := range outputParameters {
 		query.Add("output_parameters", param)
 	}
 
 	query.Add("format", "json")
-	request.URL.RawQuery = query.Encode()
+	return query
+}
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
-	if response.StatusCode >= 400 {
-		return nil, errors.Join(
-			functionErrMsg,
-			errors.New("request to Solcast failed"),
-		)
+/*
+Solcast measures azimuths from North towards the West, so 90 degrees is West, -90 degrees
+is East and 180 degrees is South. Converts from compass degrees (90 degrees is East).
+*/
+func ToSolcastAzimuth(compassDegrees float64) float64 {
+	solcastDegrees := math.Mod(math.Mod(-compassDegrees, 360)+360, 360)
+	if solcastDegrees > 180 {
+		solcastDegrees -= 360
 	}
+	return solcastDegrees
+}
 
-	var result ForecastIrradianceAndWeatherResponse
-	decoder := json.NewDecoder(response.Body)
-	err = decoder.Decode(&result)
-	if err != nil {
-		return nil, errors.Join(functionErrMsg, err)
-	}
+// Converts Solcast azimuths to compass degrees. See ToSolcastAzimuth().
+func FromSolcastAzimuth(solcastDegrees float64) float64 {
+	return math.Mod(math.Mod(-solcastDegrees, 360)+360, 360)
+}
 
-	if len(result.Forecasts) <= 0 {
-		return nil, errors.New("no weather data returned from Solcast")
+func sendRequest(ctx context.Context, requestPath string, query url.Values, result any) error {
+	solcastUrl := os.Getenv("SOLCAST_URL")
+	if solcastUrl == "" {
+		return errors.New("no URL found for Solcast API")
 	}
 
-	return &result, nil
+	solcastToken := os.Getenv("SOLCAST_TOKEN")
+	if solcastToken == "" {
+		return errors.New("no authorization token found for Solcast API")
+	}
+
+	return httpclient.Default().SendJson(ctx, httpclient.Request{
+		Service: "Solcast",
+		Method:  http.MethodGet,
+		Url:     solcastUrl + requestPath,
+		Query:   query,
+		Headers: map[string]string{"Authorization": "Bearer " + solcastToken},
+		// Counted before every attempt, so failed requests count too - Solcast may still count them
+		BeforeAttempt: func() error {
+			if httpclient.Default().Replaying() {
+				return nil
+			}
+			return reserveCall(ctx, solcastToken)
+		},
+	}, result)
 }
@@ -0,0 +1,286 @@
+package solcast
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Solcast limits how many calls each API key can make per day (UTC), and going over
+the limit can cost money. Every call is counted in a file next to the weather cache,
+so the count survives between runs.
+
+SOLCAST_DAILY_BUDGET sets the most calls allowed per key per day. If it is not set,
+calls are counted but never blocked.
+SOLCAST_REQUESTS_PER_MINUTE limits how quickly calls are made (10 by default).
+*/
+
+const usageDateLayout = "2006-01-02"
+
+// Returned (joined with other errors) when the daily budget has been used up
+var ErrDailyBudgetExceeded = errors.New("daily Solcast API call budget used up")
+
+// API calls made with one key, by UTC date ("2006-01-02")
+type KeyUsage struct {
+	CallsByDate map[string]int
+}
+
+// Everything stored in the usage file, by key ID
+type usageFile struct {
+	Keys map[string]*KeyUsage
+}
+
+// Usage of the current API key
+type Usage struct {
+	// Short hash of the API key, so the key itself is never saved or shown
+	KeyId string
+	// UTC date
+	Date       string
+	CallsToday int
+	// 0 means there is no budget
+	DailyBudget int
+	// Calls on previous days, most recent first
+	History []DailyUsage
+}
+
+type DailyUsage struct {
+	Date  string
+	Calls int
+}
+
+var usageMutex sync.Mutex
+
+// Created on the first call, after .env has been loaded, so SOLCAST_REQUESTS_PER_MINUTE set there is used
+var limiter *tokenBucket
+var limiterOnce sync.Once
+
+// Returns the usage of the API key in SOLCAST_TOKEN.
+func GetUsage() (*Usage, error) {
+	functionErrMsg := errors.New("error getting Solcast API usage")
+
+	token := os.Getenv("SOLCAST_TOKEN")
+	if token == "" {
+		return nil, errors.Join(functionErrMsg, errors.New("no authorization token found for Solcast API"))
+	}
+
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	usage, err := readUsageFile()
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	keyId := createKeyId(token)
+	today := time.Now().UTC().Format(usageDateLayout)
+	result := Usage{
+		KeyId:       keyId,
+		Date:        today,
+		DailyBudget: DailyBudget(),
+	}
+
+	keyUsage, exists := usage.Keys[keyId]
+	if !exists {
+		return &result, nil
+	}
+
+	result.CallsToday = keyUsage.CallsByDate[today]
+	for date, calls := range keyUsage.CallsByDate {
+		if date != today {
+			result.History = append(result.History, DailyUsage{Date: date, Calls: calls})
+		}
+	}
+	sort.Slice(result.History, func(i, j int) bool {
+		return result.History[i].Date > result.History[j].Date
+	})
+
+	return &result, nil
+}
+
+// The budget from SOLCAST_DAILY_BUDGET, or 0 if there isn't one
+func DailyBudget() int {
+	budget, err := strconv.Atoi(os.Getenv("SOLCAST_DAILY_BUDGET"))
+	if err != nil || budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+func requestsPerMinute() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("SOLCAST_REQUESTS_PER_MINUTE"), 64)
+	if err != nil || rate <= 0 {
+		return 10
+	}
+	return rate
+}
+
+/*
+Counts a call for the API key, or returns ErrDailyBudgetExceeded without counting
+it if the key has no calls left today. Waits first if calls are being made too
+quickly, and gives up without counting the call if ctx is cancelled while waiting.
+*/
+func reserveCall(ctx context.Context, token string) error {
+	limiterOnce.Do(func() {
+		limiter = newTokenBucket(requestsPerMinute(), max(1, requestsPerMinute()/2))
+	})
+	err := limiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	usageMutex.Lock()
+
+	usage, err := readUsageFile()
+	if err != nil {
+		usageMutex.Unlock()
+		return err
+	}
+
+	keyId := createKeyId(token)
+	keyUsage, exists := usage.Keys[keyId]
+	if !exists {
+		keyUsage = &KeyUsage{CallsByDate: make(map[string]int)}
+		usage.Keys[keyId] = keyUsage
+	}
+
+	today := time.Now().UTC().Format(usageDateLayout)
+	budget := DailyBudget()
+	if budget > 0 && keyUsage.CallsByDate[today] >= budget {
+		usageMutex.Unlock()
+		return errors.Join(
+			ErrDailyBudgetExceeded,
+			fmt.Errorf("%d of %d calls made today (UTC), set SOLCAST_DAILY_BUDGET to change the budget", keyUsage.CallsByDate[today], budget),
+		)
+	}
+
+	keyUsage.CallsByDate[today]++
+	err = saveUsageFile(usage)
+	usageMutex.Unlock()
+	return err
+}
+
+func createKeyId(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+func getUsageFilePath() string {
+	cacheFolder, _ := os.UserCacheDir()
+	return filepath.Join(cacheFolder, "asc-tool", "solcast-usage.json")
+}
+
+func readUsageFile() (*usageFile, error) {
+	usage := usageFile{Keys: make(map[string]*KeyUsage)}
+
+	file, err := os.Open(getUsageFilePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &usage, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&usage)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid Solcast usage file \""+getUsageFilePath()+"\""), err)
+	}
+	if usage.Keys == nil {
+		usage.Keys = make(map[string]*KeyUsage)
+	}
+
+	return &usage, nil
+}
+
+func saveUsageFile(usage *usageFile) error {
+	filePath := getUsageFilePath()
+
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so a crash part way through can't lose the count
+	temporaryFilePath := filePath + ".tmp"
+	file, err := os.Create(temporaryFilePath)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(usage)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryFilePath, filePath)
+}
+
+/*
+Token bucket rate limiter. Holds up to capacity tokens, refilled at a steady rate.
+Each call takes a token, waiting for one if there are none left.
+*/
+type tokenBucket struct {
+	mutex            sync.Mutex
+	capacity         float64
+	tokens           float64
+	refillPerSecond  float64
+	lastRefillMoment time.Time
+}
+
+func newTokenBucket(perMinute float64, capacity float64) *tokenBucket {
+	return &tokenBucket{
+		capacity:         capacity,
+		tokens:           capacity,
+		refillPerSecond:  perMinute / 60,
+		lastRefillMoment: time.Now(),
+	}
+}
+
+/*
+Takes a token, waiting until it has been refilled if there are none left. The token is
+taken before waiting, so callers waiting at the same time queue up behind each other
+instead of all taking the next one. Returns ctx.Err() if ctx is cancelled first.
+*/
+func (bucket *tokenBucket) wait(ctx context.Context) error {
+	bucket.mutex.Lock()
+	now := time.Now()
+	elapsed := now.Sub(bucket.lastRefillMoment).Seconds()
+	bucket.tokens = math.Min(bucket.capacity, bucket.tokens+elapsed*bucket.refillPerSecond)
+	bucket.lastRefillMoment = now
+
+	bucket.tokens--
+	waitSeconds := -bucket.tokens / bucket.refillPerSecond
+	bucket.mutex.Unlock()
+
+	if waitSeconds <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(waitSeconds * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// The call won't be made, so the token goes back
+		bucket.mutex.Lock()
+		bucket.tokens = math.Min(bucket.capacity, bucket.tokens+1)
+		bucket.mutex.Unlock()
+		return ctx.Err()
+	}
+}
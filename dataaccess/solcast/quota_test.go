@@ -0,0 +1,150 @@
+package solcast
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute float64
+		capacity  float64
+		// Calls made one after another
+		calls int
+		// The context of the last call is cancelled this long after it starts, 0 never
+		cancelAfter time.Duration
+		wantErr     error
+		// How long all the calls should take together
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:      "tokens left",
+			perMinute: 60,
+			capacity:  3,
+			calls:     3,
+			wantMin:   0,
+			wantMax:   50 * time.Millisecond,
+		},
+		{
+			name:      "waits for a refill",
+			perMinute: 600,
+			capacity:  1,
+			calls:     3,
+			wantMin:   180 * time.Millisecond,
+			wantMax:   400 * time.Millisecond,
+		},
+		{
+			name:        "cancelled while waiting",
+			perMinute:   6,
+			capacity:    1,
+			calls:       2,
+			cancelAfter: 50 * time.Millisecond,
+			wantErr:     context.Canceled,
+			wantMin:     50 * time.Millisecond,
+			wantMax:     500 * time.Millisecond,
+		},
+		{
+			name:        "cancelled with tokens left",
+			perMinute:   6,
+			capacity:    2,
+			calls:       2,
+			cancelAfter: time.Nanosecond,
+			wantMin:     0,
+			wantMax:     50 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket := newTokenBucket(test.perMinute, test.capacity)
+			start := time.Now()
+
+			var err error
+			for i := 0; i < test.calls; i++ {
+				ctx := context.Background()
+				if i == test.calls-1 && test.cancelAfter > 0 {
+					// Cancelled rather than timed out, like a command stopped with Ctrl+C
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithCancel(ctx)
+					defer cancel()
+					time.AfterFunc(test.cancelAfter, cancel)
+				}
+				err = bucket.wait(ctx)
+			}
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("wait() error = %v, want %v", err, test.wantErr)
+			}
+			if elapsed < test.wantMin || elapsed > test.wantMax {
+				t.Errorf("calls took %v, want %v-%v", elapsed, test.wantMin, test.wantMax)
+			}
+		})
+	}
+}
+
+func TestTokenBucketCancelledTokenGoesBack(t *testing.T) {
+	bucket := newTokenBucket(6, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := bucket.wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bucket.wait(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait() error = %v, want %v", err, context.Canceled)
+	}
+
+	// Only the first call took a token, so the bucket is about as empty as it was after it
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	if bucket.tokens < -0.01 || bucket.tokens > 0.01 {
+		t.Errorf("tokens = %v, want 0", bucket.tokens)
+	}
+}
+
+func TestTokenBucketWaitersQueue(t *testing.T) {
+	// One call every 50 ms
+	bucket := newTokenBucket(1200, 1)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	finished := make(chan time.Duration, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := bucket.wait(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			finished <- time.Since(start)
+		}()
+	}
+
+	// Nothing holds the lock while callers wait
+	time.Sleep(10 * time.Millisecond)
+	if !bucket.mutex.TryLock() {
+		t.Fatal("the bucket is locked while callers wait")
+	}
+	bucket.mutex.Unlock()
+
+	wg.Wait()
+	close(finished)
+	last := time.Duration(0)
+	for elapsed := range finished {
+		last = max(last, elapsed)
+	}
+
+	// The first call doesn't wait, and each of the others waits for its own token
+	if last < 140*time.Millisecond || last > 400*time.Millisecond {
+		t.Errorf("last call finished after %v, want about 150ms", last)
+	}
+}
@@ -1,5 +1,7 @@
 package solcast
 
+import "time"
+
 // Enum to represent fixed time periods in the Solcast API.
 type TimePeriod string
 
@@ -40,6 +42,15 @@ type LiveIrradianceAndWeatherActuals struct {
 	WindDirection10m  float64 `json:"wind_direction_10m"`
 	WindSpeed10m      float64 `json:"wind_speed_10m"`
 	Zenith            float64
+	// Sun's azimuth, see FromSolcastAzimuth()
+	Azimuth float64
+	// Irradiance in W/m^2. Gti is on the array with the tilt and azimuth that were requested.
+	Ghi float64
+	Dni float64
+	Dhi float64
+	Gti float64
+	// End of the time period this data is averaged over
+	PeriodEnd time.Time `json:"period_end"`
 }
 
 type LiveIrradianceAndWeatherResponse struct {
@@ -53,3 +64,7 @@ type ForecastIrradianceAndWeather LiveIrradianceAndWeatherActuals
 type ForecastIrradianceAndWeatherResponse struct {
 	Forecasts []ForecastIrradianceAndWeather
 }
+
+type HistoricIrradianceAndWeatherResponse struct {
+	EstimatedActuals []LiveIrradianceAndWeatherActuals `json:"estimated_actuals"`
+}
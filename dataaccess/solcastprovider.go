@@ -0,0 +1,124 @@
+package dataaccess
+
+import (
+	"asc-simulation/dataaccess/solcast"
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Extra time requested before historical windows, so rain on the ground has time to build up
+const rainBuildUpHours = 3
+
+/*
+Gets weather from the Solcast API.
+Needs the SOLCAST_URL and SOLCAST_TOKEN environment variables.
+*/
+type SolcastWeatherProvider struct {
+	// Solcast gives the irradiance on this array
+	Array types.SolarArray
+}
+
+func (provider SolcastWeatherProvider) Name() string {
+	return "solcast" + solarArrayName(provider.Array)
+}
+
+func (provider SolcastWeatherProvider) GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*WeatherSample, error) {
+	responseInterval := solcast.Period5Mins
+	solcastResponse, err := solcast.GetLiveIrradianceAndWeather(
+		ctx,
+		coordinates,
+		provider.Array,
+		1,
+		responseInterval,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	samples := solcastDataToSamples(solcastResponse.EstimatedActuals, responseInterval)
+	return &samples[len(samples)-1], nil
+}
+
+// Can get forecasted data up to 336 hours (14 days) in the future.
+func (provider SolcastWeatherProvider) GetForecastWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	hoursInFuture := int(math.Ceil(time.Until(end).Hours()))
+	if hoursInFuture > 336 {
+		return nil, errors.New("cannot get forecast more than 336 hours in the future")
+	}
+
+	responseInterval := solcast.Period30Mins
+	solcastResponse, err := solcast.GetForecastIrradianceAndWeather(
+		ctx,
+		coordinates,
+		provider.Array,
+		max(1, hoursInFuture),
+		responseInterval,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	data := make([]solcast.LiveIrradianceAndWeatherActuals, len(solcastResponse.Forecasts))
+	for i, forecast := range solcastResponse.Forecasts {
+		data[i] = solcast.LiveIrradianceAndWeatherActuals(forecast)
+	}
+
+	return samplesInWindow(solcastDataToSamples(data, responseInterval), start, end), nil
+}
+
+func (provider SolcastWeatherProvider) GetHistoricalWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	responseInterval := solcast.Period30Mins
+	solcastResponse, err := solcast.GetHistoricIrradianceAndWeather(
+		ctx,
+		coordinates,
+		provider.Array,
+		start.Add(-rainBuildUpHours*time.Hour),
+		end,
+		responseInterval,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting weather data"), err)
+	}
+
+	return samplesInWindow(solcastDataToSamples(solcastResponse.EstimatedActuals, responseInterval), start, end), nil
+}
+
+func solcastDataToSamples(data []solcast.LiveIrradianceAndWeatherActuals, responseInterval solcast.TimePeriod) []WeatherSample {
+	periodMinutes := float64(responseInterval.MinuteValue())
+
+	weather := make([]metricWeather, len(data))
+	for i, weatherData := range data {
+		weather[i] = metricWeather{
+			// Solcast gives averages over each period, so they are most accurate in the middle of it
+			validAt:              weatherData.PeriodEnd.Add(-time.Duration(periodMinutes/2) * time.Minute),
+			zenithDegrees:        weatherData.Zenith,
+			solarAzimuthDegrees:  solcast.FromSolcastAzimuth(weatherData.Azimuth),
+			ghiWm2:               weatherData.Ghi,
+			dniWm2:               weatherData.Dni,
+			dhiWm2:               weatherData.Dhi,
+			gtiWm2:               weatherData.Gti,
+			airTempC:             weatherData.AirTemp,
+			dewPointC:            weatherData.DewpointTemp,
+			cloudCoverPercentage: weatherData.CloudOpacity,
+			windSpeedMpS:         weatherData.WindSpeed10m,
+			windDirectionDegrees: weatherData.WindDirection10m,
+			precipitationMmph:    weatherData.PrecipitationRate,
+			surfacePressurehPa:   weatherData.SurfacePressure,
+		}
+	}
+
+	return metricWeatherToSamples(weather, periodMinutes)
+}
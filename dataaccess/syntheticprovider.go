@@ -0,0 +1,143 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Time between samples returned by SyntheticWeatherProvider
+const syntheticSamplePeriod = 30 * time.Minute
+
+/*
+Makes up plausible weather without calling any API, so simulations can run
+offline and give the same results every time. The sun's position is real, and the
+air temperature follows a daily curve, coldest at sunrise and warmest mid-afternoon.
+Irradiance is estimated from the sun's position and the cloud cover.
+*/
+type SyntheticWeatherProvider struct {
+	LowAirTempDegreesF   float64
+	HighAirTempDegreesF  float64
+	CloudCoverPercentage float64
+	WindSpeedMph         float64
+	WindDirectionDegrees float64
+	RainOnGroundInches   float64
+	SurfacePressurePsi   float64
+	WindGustMph          float64
+	VisibilityMi         float64
+	// Irradiance is estimated for this array
+	Array types.SolarArray
+}
+
+// A clear, calm summer day
+func DefaultSyntheticWeatherProvider() SyntheticWeatherProvider {
+	return SyntheticWeatherProvider{
+		LowAirTempDegreesF:   70,
+		HighAirTempDegreesF:  90,
+		CloudCoverPercentage: 10,
+		WindSpeedMph:         5,
+		WindDirectionDegrees: 180,
+		RainOnGroundInches:   0,
+		SurfacePressurePsi:   14.7,
+		WindGustMph:          10,
+		VisibilityMi:         10,
+	}
+}
+
+func (provider SyntheticWeatherProvider) Name() string {
+	// Include the settings so changing them doesn't return old cached weather
+	return "synthetic-" +
+		strconv.FormatFloat(provider.LowAirTempDegreesF, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.HighAirTempDegreesF, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.CloudCoverPercentage, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.WindSpeedMph, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.WindDirectionDegrees, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.RainOnGroundInches, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.SurfacePressurePsi, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.WindGustMph, 'f', -1, 64) + "-" +
+		strconv.FormatFloat(provider.VisibilityMi, 'f', -1, 64) +
+		solarArrayName(provider.Array)
+}
+
+func (provider SyntheticWeatherProvider) GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*WeatherSample, error) {
+	sample := provider.sampleAt(coordinates, time.Now())
+	return &sample, nil
+}
+
+func (provider SyntheticWeatherProvider) GetForecastWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	return provider.samplesBetween(coordinates, start, end), nil
+}
+
+func (provider SyntheticWeatherProvider) GetHistoricalWeather(
+	ctx context.Context,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, error) {
+	return provider.samplesBetween(coordinates, start, end), nil
+}
+
+// Samples on every syntheticSamplePeriod boundary, covering start to end
+func (provider SyntheticWeatherProvider) samplesBetween(
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) []WeatherSample {
+	samples := make([]WeatherSample, 0)
+
+	for at := start.Truncate(syntheticSamplePeriod); ; at = at.Add(syntheticSamplePeriod) {
+		samples = append(samples, provider.sampleAt(coordinates, at))
+		if !at.Before(end) {
+			break
+		}
+	}
+
+	return samples
+}
+
+func (provider SyntheticWeatherProvider) sampleAt(coordinates types.Coordinates, at time.Time) WeatherSample {
+	// Approximate local solar time from the longitude
+	utc := at.UTC()
+	solarHour := float64(utc.Hour()) + float64(utc.Minute())/60 + coordinates.Longitude/15
+	solarHour = math.Mod(solarHour+24, 24)
+
+	// Coldest at 6am and warmest at 3pm, with a smooth curve in between
+	var warmth float64
+	if solarHour >= 6 && solarHour < 15 {
+		warmth = (1 - math.Cos(math.Pi*(solarHour-6)/9)) / 2
+	} else {
+		hoursSinceWarmest := math.Mod(solarHour-15+24, 24)
+		warmth = (1 + math.Cos(math.Pi*hoursSinceWarmest/15)) / 2
+	}
+
+	zenith, azimuth := solarPositionDegrees(coordinates, at)
+	ghi, dni, dhi := estimateIrradianceWm2(zenith, provider.CloudCoverPercentage)
+
+	return WeatherSample{
+		ValidAt: at,
+		Weather: types.Weather{
+			SolarZenithDegrees:   zenith,
+			AirTempDegreesF:      provider.LowAirTempDegreesF + warmth*(provider.HighAirTempDegreesF-provider.LowAirTempDegreesF),
+			CloudCoverPercentage: provider.CloudCoverPercentage,
+			WindSpeedMph:         provider.WindSpeedMph,
+			WindDirectionDegrees: provider.WindDirectionDegrees,
+			RainOnGroundInches:   provider.RainOnGroundInches,
+			SurfacePressurePsi:   provider.SurfacePressurePsi,
+			WindGustMph:          provider.WindGustMph,
+			VisibilityMi:         provider.VisibilityMi,
+			SolarAzimuthDegrees:  azimuth,
+
+			GlobalHorizontalIrradianceWm2:  ghi,
+			DirectNormalIrradianceWm2:      dni,
+			DiffuseHorizontalIrradianceWm2: dhi,
+			GlobalTiltedIrradianceWm2:      tiltedIrradianceWm2(ghi, dni, dhi, zenith, azimuth, provider.Array),
+		},
+	}
+}
@@ -0,0 +1,694 @@
+package dataaccess
+
+import (
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/types"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Reads telemetry logged by the car, so it can be compared to simulations of the same
+route. Logs are CSV files with a header row, or newline-delimited JSON (one object per
+line). Either way every row needs a time, plus any of:
+
+  - speed in mph, km/h or m/s
+  - bus current (A, positive when discharging) and bus voltage (V)
+  - array power (W)
+  - battery state of charge (%)
+  - GPS latitude and longitude
+
+Column names are matched loosely, so "Bus Current (A)", "bus_current_a" and
+"busCurrent" are all the bus current. CAN loggers often only write the signals that
+changed, so empty values keep the last value that was logged.
+*/
+
+// Samples farther than this from every section are not matched to the route
+const telemetryMatchDistanceMi float64 = 0.25
+
+// Sections this far past the first one a sample matches are also checked, so the nearest one wins
+const telemetryMatchLookaheadFt float64 = 5280
+
+/*
+How much being away from where the car should be (by its speed) counts, compared to being
+away from the road. Less than 1 so a wrong match can't hold on to later fixes.
+*/
+const telemetryExpectedDistanceWeight float64 = 0.2
+
+// GPS error can put a fix this far behind the last one along the route
+const telemetryBacktrackFt float64 = 300
+
+// Fastest the car could go, used to stop GPS fixes from matching sections that are too far ahead
+const telemetryMaxSpeedMph float64 = 100
+
+// Energy isn't counted across gaps in the log longer than this
+const telemetryMaxGapSeconds float64 = 60
+
+// A GPS fix is used for rows without one for this long
+const telemetryFixTimeout = 5 * time.Second
+
+type telemetryField int
+
+const (
+	telemetryTime telemetryField = iota
+	telemetrySpeedMph
+	telemetrySpeedKph
+	telemetrySpeedMps
+	telemetryBusCurrent
+	telemetryBusVoltage
+	telemetryArrayPower
+	telemetryBatteryPercent
+	telemetryLatitude
+	telemetryLongitude
+)
+
+// Column names after normalizeTelemetryColumn()
+var telemetryColumns = map[string]telemetryField{
+	"time":            telemetryTime,
+	"timestamp":       telemetryTime,
+	"datetime":        telemetryTime,
+	"timeutc":         telemetryTime,
+	"speed":           telemetrySpeedMph,
+	"speedmph":        telemetrySpeedMph,
+	"vehiclespeed":    telemetrySpeedMph,
+	"vehiclespeedmph": telemetrySpeedMph,
+	"speedkph":        telemetrySpeedKph,
+	"speedkmh":        telemetrySpeedKph,
+	"vehiclespeedkph": telemetrySpeedKph,
+	"vehiclespeedkmh": telemetrySpeedKph,
+	"speedms":         telemetrySpeedMps,
+	"speedmps":        telemetrySpeedMps,
+	"buscurrent":      telemetryBusCurrent,
+	"buscurrenta":     telemetryBusCurrent,
+	"buscurrentamps":  telemetryBusCurrent,
+	"batterycurrent":  telemetryBusCurrent,
+	"batterycurrenta": telemetryBusCurrent,
+	"busvoltage":      telemetryBusVoltage,
+	"busvoltagev":     telemetryBusVoltage,
+	"busvoltagevolts": telemetryBusVoltage,
+	"batteryvoltage":  telemetryBusVoltage,
+	"batteryvoltagev": telemetryBusVoltage,
+	"arraypower":      telemetryArrayPower,
+	"arraypowerw":     telemetryArrayPower,
+	"arraypowerwatts": telemetryArrayPower,
+	"solarpower":      telemetryArrayPower,
+	"solarpowerw":     telemetryArrayPower,
+	"mpptpower":       telemetryArrayPower,
+	"mpptpowerw":      telemetryArrayPower,
+	"soc":             telemetryBatteryPercent,
+	"socpercent":      telemetryBatteryPercent,
+	"batterysoc":      telemetryBatteryPercent,
+	"batterypercent":  telemetryBatteryPercent,
+	"stateofcharge":   telemetryBatteryPercent,
+	"lat":             telemetryLatitude,
+	"latitude":        telemetryLatitude,
+	"gpslat":          telemetryLatitude,
+	"gpslatitude":     telemetryLatitude,
+	"lon":             telemetryLongitude,
+	"lng":             telemetryLongitude,
+	"long":            telemetryLongitude,
+	"longitude":       telemetryLongitude,
+	"gpslon":          telemetryLongitude,
+	"gpslng":          telemetryLongitude,
+	"gpslongitude":    telemetryLongitude,
+}
+
+// Lower case letters and digits only, e.g. "Bus Current (A)" becomes "buscurrenta"
+func normalizeTelemetryColumn(name string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+// Reads a CSV or NDJSON telemetry log, picked by the file extension (.csv, or .ndjson/.jsonl/.json).
+func ReadTelemetryLog(logFilePath string) ([]types.TelemetrySample, error) {
+	functionErrMsg := errors.New("error reading telemetry log \"" + logFilePath + "\"")
+
+	file, err := os.Open(logFilePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	reader := telemetryReader{}
+	switch strings.ToLower(filepath.Ext(logFilePath)) {
+	case ".csv":
+		err = reader.readCsv(file)
+	case ".ndjson", ".jsonl", ".json":
+		err = reader.readNdjson(file)
+	default:
+		err = errors.New("unknown log format, expected .csv, .ndjson, .jsonl or .json")
+	}
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	if len(reader.samples) == 0 {
+		return nil, errors.Join(functionErrMsg, errors.New("log has no samples"))
+	}
+
+	sort.SliceStable(reader.samples, func(i, j int) bool {
+		return reader.samples[i].Time.Before(reader.samples[j].Time)
+	})
+
+	return reader.samples, nil
+}
+
+// Turns log rows into samples, carrying values over from earlier rows
+type telemetryReader struct {
+	current     types.TelemetrySample
+	lastFixTime time.Time
+	samples     []types.TelemetrySample
+}
+
+func (reader *telemetryReader) readCsv(file io.Reader) error {
+	csvReader := csv.NewReader(file)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+
+	fields := make([]telemetryField, len(header))
+	known := make([]bool, len(header))
+	for i, name := range header {
+		fields[i], known[i] = telemetryColumns[normalizeTelemetryColumn(name)]
+	}
+
+	for row := 2; ; row++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		values := make(map[telemetryField]string)
+		for i, value := range record {
+			if i < len(fields) && known[i] {
+				values[fields[i]] = value
+			}
+		}
+
+		err = reader.add(values)
+		if err != nil {
+			return errors.Join(errors.New("row "+strconv.Itoa(row)), err)
+		}
+	}
+}
+
+func (reader *telemetryReader) readNdjson(file io.Reader) error {
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+
+	for line := 1; ; line++ {
+		var object map[string]any
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Join(errors.New("line "+strconv.Itoa(line)), err)
+		}
+
+		err = reader.add(telemetryJsonValues(object))
+		if err != nil {
+			return errors.Join(errors.New("line "+strconv.Itoa(line)), err)
+		}
+	}
+}
+
+// Picks the known fields out of one JSON object
+func telemetryJsonValues(object map[string]any) map[telemetryField]string {
+	values := make(map[telemetryField]string)
+	for name, value := range object {
+		field, known := telemetryColumns[normalizeTelemetryColumn(name)]
+		if !known {
+			continue
+		}
+
+		switch value := value.(type) {
+		case json.Number:
+			values[field] = value.String()
+		case string:
+			values[field] = value
+		}
+	}
+	return values
+}
+
+/*
+Turns telemetry frames received one at a time, e.g. over the radio, into samples.
+Each frame is one JSON object in the same format as a line of an NDJSON log, and
+values missing from a frame keep their last value, the same as in a log.
+*/
+type TelemetryStream struct {
+	reader telemetryReader
+}
+
+// Adds one frame and returns the car's state after it.
+func (stream *TelemetryStream) AddFrame(frame []byte) (types.TelemetrySample, error) {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.UseNumber()
+
+	var object map[string]any
+	err := decoder.Decode(&object)
+	if err != nil {
+		return types.TelemetrySample{}, err
+	}
+
+	err = stream.reader.add(telemetryJsonValues(object))
+	if err != nil {
+		return types.TelemetrySample{}, err
+	}
+
+	// Streams run for hours, so samples aren't kept
+	sample := stream.reader.samples[len(stream.reader.samples)-1]
+	stream.reader.samples = stream.reader.samples[:0]
+	sample.SectionIndex = -1
+	return sample, nil
+}
+
+// Formats a sample as a frame that TelemetryStream and NDJSON logs can read.
+func FormatTelemetryFrame(sample types.TelemetrySample) ([]byte, error) {
+	object := map[string]any{
+		"time":            sample.Time.Format(time.RFC3339Nano),
+		"speed_mph":       sample.SpeedMph,
+		"bus_current_a":   sample.BusCurrentAmps,
+		"bus_voltage_v":   sample.BusVoltageVolts,
+		"array_power_w":   sample.ArrayPowerWatts,
+		"battery_percent": sample.BatteryPercent,
+	}
+	if sample.HasFix {
+		object["lat"] = sample.Coordinates.Latitude
+		object["lon"] = sample.Coordinates.Longitude
+	}
+
+	return json.Marshal(object)
+}
+
+// Adds a sample from one row. Values that are missing or empty keep their last value.
+func (reader *telemetryReader) add(values map[telemetryField]string) error {
+	timeValue := strings.TrimSpace(values[telemetryTime])
+	if timeValue == "" {
+		return errors.New("no time")
+	}
+	sampleTime, err := parseTelemetryTime(timeValue)
+	if err != nil {
+		return err
+	}
+
+	numbers := make(map[telemetryField]float64)
+	for field, value := range values {
+		value = strings.TrimSpace(value)
+		if field == telemetryTime || value == "" || strings.EqualFold(value, "nan") {
+			continue
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		numbers[field] = number
+	}
+
+	sample := &reader.current
+	sample.Time = sampleTime
+	if speed, exists := numbers[telemetrySpeedMph]; exists {
+		sample.SpeedMph = speed
+	}
+	if speed, exists := numbers[telemetrySpeedKph]; exists {
+		sample.SpeedMph = speed / 1.609344
+	}
+	if speed, exists := numbers[telemetrySpeedMps]; exists {
+		sample.SpeedMph = msToMph(speed)
+	}
+	if current, exists := numbers[telemetryBusCurrent]; exists {
+		sample.BusCurrentAmps = current
+	}
+	if voltage, exists := numbers[telemetryBusVoltage]; exists {
+		sample.BusVoltageVolts = voltage
+	}
+	if power, exists := numbers[telemetryArrayPower]; exists {
+		sample.ArrayPowerWatts = power
+	}
+	if battery, exists := numbers[telemetryBatteryPercent]; exists {
+		sample.BatteryPercent = battery
+	}
+
+	// GPS units log 0, 0 when they have no fix
+	latitude, hasLatitude := numbers[telemetryLatitude]
+	longitude, hasLongitude := numbers[telemetryLongitude]
+	if hasLatitude && hasLongitude && (latitude != 0 || longitude != 0) {
+		sample.Coordinates = types.Coordinates{Latitude: latitude, Longitude: longitude}
+		reader.lastFixTime = sampleTime
+	}
+	sample.HasFix = !reader.lastFixTime.IsZero() && sampleTime.Sub(reader.lastFixTime) <= telemetryFixTimeout
+
+	reader.samples = append(reader.samples, *sample)
+	return nil
+}
+
+/*
+Parses RFC 3339 times, "YYYY-MM-DD HH:MM:SS" in local time, or Unix times in
+seconds or milliseconds (which loggers often write without saying which).
+*/
+func parseTelemetryTime(value string) (time.Time, error) {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		// Unix time in seconds won't reach 1e11 until the year 5138
+		if number >= 1e11 {
+			number /= 1000
+		}
+		seconds, fraction := math.Modf(number)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), nil
+	}
+
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, errors.New("time \"" + value + "\" is not RFC 3339, YYYY-MM-DD HH:MM:SS or a Unix time")
+}
+
+/*
+Matches the samples (sorted by time) to the itinerary's sections and adds up what
+the car did on each section.
+
+The car only moves forward along the itinerary, so each GPS fix is matched to the
+nearest point on the route that isn't behind the last matched fix. This keeps laps of
+a loop and roads the route drives both ways apart, and stops fixes near a road the
+route crosses from matching the wrong part of it.
+*/
+func MatchTelemetry(name string, samples []types.TelemetrySample, itinerary *types.Itinerary) types.TelemetryRun {
+	run := types.TelemetryRun{
+		Name:      name,
+		Itinerary: itinerary.Name,
+		StartTime: samples[0].Time,
+		EndTime:   samples[len(samples)-1].Time,
+		Samples:   samples,
+	}
+
+	lastMatched := -1
+	for i := range samples {
+		sample := &samples[i]
+		sample.SectionIndex = -1
+		sample.DistanceFt = 0
+
+		if sample.HasFix {
+			window := telemetryMatchWindow{MinDistanceFt: 0, MaxDistanceFt: math.Inf(1), ExpectedDistanceFt: math.NaN()}
+			if lastMatched >= 0 {
+				previous := &samples[lastMatched]
+				elapsedHours := sample.Time.Sub(previous.Time).Hours()
+				window.MinDistanceFt = previous.DistanceFt - telemetryBacktrackFt
+				window.MaxDistanceFt = previous.DistanceFt + elapsedHours*telemetryMaxSpeedMph*5280 + telemetryMatchLookaheadFt
+				window.ExpectedDistanceFt = previous.DistanceFt + elapsedHours*(previous.SpeedMph+sample.SpeedMph)/2*5280
+			}
+
+			sample.SectionIndex, sample.DistanceFt = matchTelemetrySection(sample.Coordinates, itinerary, window)
+		}
+
+		if sample.SectionIndex < 0 {
+			run.UnmatchedSampleCount++
+			continue
+		}
+		lastMatched = i
+	}
+
+	run.Sections = sumTelemetrySections(samples)
+	return run
+}
+
+/*
+Finds where a GPS fix is on the itinerary, ignoring anything more than a short way
+behind minDistanceFt so roads the route drives twice match the right pass.
+If nothing matches ahead, e.g. because the car started the itinerary again, the
+whole itinerary is searched. Returns the index of the section and the distance along
+the itinerary, or -1 if the fix is too far from the route.
+*/
+func LocateOnItinerary(coordinates types.Coordinates, itinerary *types.Itinerary, minDistanceFt float64) (int, float64) {
+	window := telemetryMatchWindow{
+		MinDistanceFt:      minDistanceFt - telemetryBacktrackFt,
+		MaxDistanceFt:      math.Inf(1),
+		ExpectedDistanceFt: math.NaN(),
+	}
+
+	sectionIndex, distanceFt := matchTelemetrySection(coordinates, itinerary, window)
+	if sectionIndex < 0 && window.MinDistanceFt > 0 {
+		window.MinDistanceFt = 0
+		sectionIndex, distanceFt = matchTelemetrySection(coordinates, itinerary, window)
+	}
+	return sectionIndex, distanceFt
+}
+
+// Where along the itinerary a GPS fix can be matched
+type telemetryMatchWindow struct {
+	MinDistanceFt float64
+	MaxDistanceFt float64
+	// Where the car should be going by its speed. NaN if unknown.
+	ExpectedDistanceFt float64
+}
+
+/*
+Returns the index of the best section in the window, and the distance along the itinerary of
+the closest point on it. Sections close to the fix and close to where the car should be win,
+so a fix where the route passes the same place twice goes to the right pass.
+*/
+func matchTelemetrySection(coordinates types.Coordinates, itinerary *types.Itinerary, window telemetryMatchWindow) (int, float64) {
+	bestIndex := -1
+	bestCostFt := math.Inf(1)
+	bestDistanceFt := 0.0
+
+	// Sections are in order of distance, so skip to the first one that ends inside the window
+	startIndex := sort.Search(len(itinerary.Sections), func(j int) bool {
+		return itinerary.Sections[j].DistanceFt+itinerary.Sections[j].LengthFt >= window.MinDistanceFt
+	})
+
+	for j := startIndex; j < len(itinerary.Sections); j++ {
+		section := &itinerary.Sections[j]
+		if section.DistanceFt > window.MaxDistanceFt {
+			break
+		}
+		if bestIndex >= 0 && section.DistanceFt > bestDistanceFt+telemetryMatchLookaheadFt {
+			break
+		}
+
+		distanceMi, fraction := projectOntoSegmentMi(coordinates, section.CoordinatesInitial, section.CoordinatesFinal)
+		distanceFt := section.DistanceFt + fraction*section.LengthFt
+		if distanceMi >= telemetryMatchDistanceMi || distanceFt < window.MinDistanceFt || distanceFt > window.MaxDistanceFt {
+			continue
+		}
+
+		costFt := distanceMi * 5280
+		if !math.IsNaN(window.ExpectedDistanceFt) {
+			costFt += telemetryExpectedDistanceWeight * math.Abs(distanceFt-window.ExpectedDistanceFt)
+		}
+		if costFt < bestCostFt {
+			bestIndex = j
+			bestCostFt = costFt
+			bestDistanceFt = distanceFt
+		}
+	}
+
+	return bestIndex, bestDistanceFt
+}
+
+/*
+Adds up the time between each pair of samples on the section the first of them was on.
+Samples that couldn't be matched count towards the last section that was.
+*/
+func sumTelemetrySections(samples []types.TelemetrySample) []types.TelemetrySection {
+	sections := make([]types.TelemetrySection, 0)
+	positions := make(map[int]int)
+	secondsOnSection := make(map[int]float64)
+
+	sectionIndex := -1
+	for i := 1; i < len(samples); i++ {
+		previous, sample := &samples[i-1], &samples[i]
+		if previous.SectionIndex >= 0 {
+			sectionIndex = previous.SectionIndex
+		}
+		if sectionIndex < 0 {
+			continue
+		}
+
+		position, exists := positions[sectionIndex]
+		if !exists {
+			position = len(sections)
+			positions[sectionIndex] = position
+			sections = append(sections, types.TelemetrySection{SectionIndex: sectionIndex, StartTime: previous.Time})
+		}
+		section := &sections[position]
+
+		if section.StartBatteryPercent == 0 {
+			section.StartBatteryPercent = previous.BatteryPercent
+		}
+		if sample.BatteryPercent > 0 {
+			section.EndBatteryPercent = sample.BatteryPercent
+		}
+
+		seconds := sample.Time.Sub(previous.Time).Seconds()
+		if seconds <= 0 || seconds > telemetryMaxGapSeconds {
+			continue
+		}
+
+		// Average of both ends of the interval
+		busPower := (previous.BusVoltageVolts*previous.BusCurrentAmps + sample.BusVoltageVolts*sample.BusCurrentAmps) / 2
+		arrayPower := (previous.ArrayPowerWatts + sample.ArrayPowerWatts) / 2
+		speed := (previous.SpeedMph + sample.SpeedMph) / 2
+
+		section.EndTime = sample.Time
+		section.BusEnergyWh += busPower * seconds / 3600
+		section.ArrayEnergyWh += arrayPower * seconds / 3600
+		section.AverageSpeedMph += speed * seconds
+		secondsOnSection[sectionIndex] += seconds
+	}
+
+	for _, sample := range samples {
+		if position, exists := positions[sample.SectionIndex]; exists {
+			sections[position].SampleCount++
+		}
+	}
+
+	for i := range sections {
+		section := &sections[i]
+		if seconds := secondsOnSection[section.SectionIndex]; seconds > 0 {
+			section.AverageSpeedMph /= seconds
+		}
+		if section.EndTime.IsZero() {
+			section.EndTime = section.StartTime
+		}
+	}
+
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].SectionIndex < sections[j].SectionIndex
+	})
+
+	return sections
+}
+
+// Saves a matched telemetry run to a .json file.
+func SaveTelemetryRun(runFilePath string, run *types.TelemetryRun) error {
+	functionErrMsg := errors.New("error saving telemetry run")
+
+	file, err := os.Create(runFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(*run)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Loads a telemetry run saved by SaveTelemetryRun().
+func LoadTelemetryRun(runFilePath string) (*types.TelemetryRun, error) {
+	functionErrMsg := errors.New("error loading telemetry run \"" + runFilePath + "\"")
+
+	file, err := os.Open(runFilePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	var run types.TelemetryRun
+	err = json.NewDecoder(file).Decode(&run)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	return &run, nil
+}
+
+/*
+Loads the car's current state from a .json file, e.g. one kept up to date by
+"telemetry serve", or from the URL of a telemetry server's /state endpoint.
+*/
+func LoadCarState(stateFilePath string) (*types.CarState, error) {
+	functionErrMsg := errors.New("error loading car state \"" + stateFilePath + "\"")
+
+	var state types.CarState
+	if strings.HasPrefix(stateFilePath, "http://") || strings.HasPrefix(stateFilePath, "https://") {
+		// The state changes every second, so there's no point retrying an old request
+		client := httpclient.NewClient(httpclient.Config{Timeout: 5 * time.Second})
+		err := client.SendJson(context.Background(), httpclient.Request{
+			Service: "telemetry server",
+			Method:  "GET",
+			Url:     stateFilePath,
+		}, &state)
+		if err != nil {
+			return nil, errors.Join(functionErrMsg, err)
+		}
+		return &state, nil
+	}
+
+	file, err := os.Open(stateFilePath)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&state)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	return &state, nil
+}
+
+/*
+Saves the car's current state to a .json file. The file is replaced in one go,
+so something reading it at the same time never sees half of it.
+*/
+func SaveCarState(stateFilePath string, state *types.CarState) error {
+	functionErrMsg := errors.New("error saving car state")
+
+	temporaryFilePath := stateFilePath + ".tmp"
+	file, err := os.Create(temporaryFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(*state)
+	file.Close()
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	err = os.Rename(temporaryFilePath, stateFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
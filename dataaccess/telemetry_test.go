@@ -0,0 +1,501 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Length and width in degrees of longitude of every section of the out and back road
+const testSectionLengthFt float64 = 2640
+const testSectionDegrees float64 = 0.009
+
+/*
+A road driven east for ten half mile sections and then back west along itself, so
+every place on it is passed twice. Loaded with NewItinerary() like any other route.
+*/
+func testOutAndBackItinerary(t *testing.T) *types.Itinerary {
+	t.Helper()
+
+	const sectionCount = 10
+
+	route := types.Route{Name: "out and back"}
+	for i := 0; i < 2*sectionCount; i++ {
+		route.Sections = append(route.Sections, types.RouteSection{
+			LengthFt:           testSectionLengthFt,
+			CoordinatesInitial: testOutAndBackCoordinates(float64(i)),
+			CoordinatesFinal:   testOutAndBackCoordinates(float64(i + 1)),
+			PositionInRoute:    i,
+		})
+	}
+
+	routeFilePath := filepath.Join(t.TempDir(), "out_and_back"+routeFileExtension)
+	err := saveRoute(&route, routeFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itinerary, err := NewItinerary(route.Name, []ItineraryEntry{{RouteFilePath: routeFilePath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return itinerary
+}
+
+// Where the out and back road is after driving this many sections along it
+func testOutAndBackCoordinates(sections float64) types.Coordinates {
+	if sections > 10 {
+		sections = 20 - sections
+	}
+	return types.Coordinates{Latitude: 36, Longitude: -86 + sections*testSectionDegrees}
+}
+
+func TestReadTelemetryLog(t *testing.T) {
+	start := time.Date(2024, 7, 3, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		fileName string
+		contents string
+		want     []types.TelemetrySample
+		wantErr  bool
+	}{
+		{
+			name:     "csv column names",
+			fileName: "log.csv",
+			contents: "Time (UTC),Vehicle Speed (MPH),Bus Current (A),bus_voltage_v,arrayPower,GPS Lat,GPS Lng,Motor Temp\n" +
+				"2024-07-03T09:00:00Z,40,10,100,500,36.1,-86.7,80\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 40, BusCurrentAmps: 10, BusVoltageVolts: 100, ArrayPowerWatts: 500, Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+			},
+		},
+		{
+			name:     "speed units",
+			fileName: "log.csv",
+			contents: "timestamp,speed_kmh,speed_mps,speed\n" +
+				"2024-07-03T09:00:00Z,100,,\n" +
+				"2024-07-03T09:00:01Z,,20,\n" +
+				"2024-07-03T09:00:02Z,,,30\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 62.137},
+				{Time: start.Add(time.Second), SpeedMph: 44.739},
+				{Time: start.Add(2 * time.Second), SpeedMph: 30},
+			},
+		},
+		{
+			name:     "empty values keep the last value",
+			fileName: "log.csv",
+			contents: "time,speed,bus current,bus voltage,array power\n" +
+				"2024-07-03T09:00:00Z,40,10,100,500\n" +
+				"2024-07-03T09:00:01Z,,-5,,\n" +
+				"2024-07-03T09:00:02Z,45,,NaN,\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 40, BusCurrentAmps: 10, BusVoltageVolts: 100, ArrayPowerWatts: 500},
+				{Time: start.Add(time.Second), SpeedMph: 40, BusCurrentAmps: -5, BusVoltageVolts: 100, ArrayPowerWatts: 500},
+				{Time: start.Add(2 * time.Second), SpeedMph: 45, BusCurrentAmps: -5, BusVoltageVolts: 100, ArrayPowerWatts: 500},
+			},
+		},
+		{
+			name:     "0, 0 is no fix",
+			fileName: "log.csv",
+			contents: "time,lat,lon\n" +
+				"2024-07-03T09:00:00Z,0,0\n" +
+				"2024-07-03T09:00:01Z,36.1,-86.7\n" +
+				"2024-07-03T09:00:02Z,0,0\n",
+			want: []types.TelemetrySample{
+				{Time: start},
+				{Time: start.Add(time.Second), Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+				{Time: start.Add(2 * time.Second), Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+			},
+		},
+		{
+			name:     "fix times out",
+			fileName: "log.csv",
+			contents: "time,lat,lon\n" +
+				"2024-07-03T09:00:00Z,36.1,-86.7\n" +
+				"2024-07-03T09:00:05Z,,\n" +
+				"2024-07-03T09:00:06Z,,\n",
+			want: []types.TelemetrySample{
+				{Time: start, Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+				{Time: start.Add(5 * time.Second), Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+				{Time: start.Add(6 * time.Second), Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}},
+			},
+		},
+		{
+			name:     "unix seconds and milliseconds",
+			fileName: "log.csv",
+			contents: "time,speed\n" +
+				"1719997200,40\n" +
+				"1719997200500,41\n" +
+				"1719997201.25,42\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 40},
+				{Time: start.Add(500 * time.Millisecond), SpeedMph: 41},
+				{Time: start.Add(1250 * time.Millisecond), SpeedMph: 42},
+			},
+		},
+		{
+			name:     "sorted by time",
+			fileName: "log.csv",
+			contents: "time,speed\n" +
+				"2024-07-03T09:00:01Z,41\n" +
+				"2024-07-03T09:00:00Z,40\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 40},
+				{Time: start.Add(time.Second), SpeedMph: 41},
+			},
+		},
+		{
+			name:     "ndjson",
+			fileName: "log.ndjson",
+			contents: `{"timestamp": 1719997200, "speedKph": 100, "busCurrent": "12.5", "busVoltage": 100, "lat": 36.1, "lng": -86.7}` + "\n" +
+				`{"timestamp": 1719997201, "speedKph": null, "busCurrent": 2.5, "gps": {"fix": false}}` + "\n",
+			want: []types.TelemetrySample{
+				{Time: start, SpeedMph: 62.137, BusCurrentAmps: 12.5, BusVoltageVolts: 100, Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+				{Time: start.Add(time.Second), SpeedMph: 62.137, BusCurrentAmps: 2.5, BusVoltageVolts: 100, Coordinates: types.Coordinates{Latitude: 36.1, Longitude: -86.7}, HasFix: true},
+			},
+		},
+		{
+			name:     "row without a time",
+			fileName: "log.csv",
+			contents: "time,speed\n" +
+				",40\n",
+			wantErr: true,
+		},
+		{
+			name:     "bad time",
+			fileName: "log.csv",
+			contents: "time,speed\n" +
+				"9am,40\n",
+			wantErr: true,
+		},
+		{
+			name:     "bad number",
+			fileName: "log.csv",
+			contents: "time,speed\n" +
+				"2024-07-03T09:00:00Z,fast\n",
+			wantErr: true,
+		},
+		{
+			name:     "no samples",
+			fileName: "log.csv",
+			contents: "time,speed\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown format",
+			fileName: "log.txt",
+			contents: "time,speed\n" +
+				"2024-07-03T09:00:00Z,40\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logFilePath := filepath.Join(t.TempDir(), test.fileName)
+			err := os.WriteFile(logFilePath, []byte(test.contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			samples, err := ReadTelemetryLog(logFilePath)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ReadTelemetryLog() = %+v, want an error", samples)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadTelemetryLog() error: %v", err)
+			}
+
+			if len(samples) != len(test.want) {
+				t.Fatalf("got %d samples, want %d", len(samples), len(test.want))
+			}
+			for i, want := range test.want {
+				got := samples[i]
+				if !got.Time.Equal(want.Time) {
+					t.Errorf("sample %d time = %v, want %v", i, got.Time, want.Time)
+				}
+				if math.Abs(got.SpeedMph-want.SpeedMph) > 1e-3 ||
+					got.BusCurrentAmps != want.BusCurrentAmps ||
+					got.BusVoltageVolts != want.BusVoltageVolts ||
+					got.ArrayPowerWatts != want.ArrayPowerWatts {
+					t.Errorf("sample %d = %+v, want %+v", i, got, want)
+				}
+				if got.Coordinates != want.Coordinates || got.HasFix != want.HasFix {
+					t.Errorf("sample %d position = %+v (fix %t), want %+v (fix %t)", i, got.Coordinates, got.HasFix, want.Coordinates, want.HasFix)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchTelemetry(t *testing.T) {
+	itinerary := testOutAndBackItinerary(t)
+	start := time.Date(2024, 7, 3, 9, 0, 0, 0, time.UTC)
+
+	// A fix half way along a section of the itinerary
+	onSection := func(section int) telemetryTestFix {
+		return telemetryTestFix{coordinates: testOutAndBackCoordinates(float64(section) + 0.5), hasFix: true}
+	}
+
+	tests := []struct {
+		name string
+		// One sample every 30 s, which is 60 mph on half mile sections
+		fixes         []telemetryTestFix
+		wantSections  []int
+		wantUnmatched int
+	}{
+		{
+			name:         "along the road",
+			fixes:        []telemetryTestFix{onSection(0), onSection(1), onSection(2), onSection(3), onSection(4)},
+			wantSections: []int{0, 1, 2, 3, 4},
+		},
+		{
+			name: "out and back",
+			fixes: []telemetryTestFix{
+				onSection(7), onSection(8), onSection(9), onSection(10), onSection(11), onSection(12),
+			},
+			wantSections: []int{7, 8, 9, 10, 11, 12},
+		},
+		{
+			name:          "no fix",
+			fixes:         []telemetryTestFix{onSection(0), {}, onSection(2)},
+			wantSections:  []int{0, -1, 2},
+			wantUnmatched: 1,
+		},
+		{
+			name: "too far from the road",
+			fixes: []telemetryTestFix{
+				onSection(0),
+				{coordinates: types.Coordinates{Latitude: 36.01, Longitude: testOutAndBackCoordinates(1.5).Longitude}, hasFix: true},
+				onSection(2),
+			},
+			wantSections:  []int{0, -1, 2},
+			wantUnmatched: 1,
+		},
+		{
+			// The same place as section 1
+			name:         "too far ahead to have driven there",
+			fixes:        []telemetryTestFix{onSection(0), onSection(18)},
+			wantSections: []int{0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples := make([]types.TelemetrySample, len(test.fixes))
+			for i, fix := range test.fixes {
+				samples[i] = types.TelemetrySample{
+					Time:        start.Add(time.Duration(i) * 30 * time.Second),
+					SpeedMph:    60,
+					Coordinates: fix.coordinates,
+					HasFix:      fix.hasFix,
+				}
+			}
+
+			run := MatchTelemetry("test run", samples, itinerary)
+
+			for i, want := range test.wantSections {
+				if run.Samples[i].SectionIndex != want {
+					t.Errorf("sample %d section = %d, want %d", i, run.Samples[i].SectionIndex, want)
+				}
+			}
+			if run.UnmatchedSampleCount != test.wantUnmatched {
+				t.Errorf("unmatched samples = %d, want %d", run.UnmatchedSampleCount, test.wantUnmatched)
+			}
+			if run.Itinerary != itinerary.Name || !run.StartTime.Equal(start) || !run.EndTime.Equal(samples[len(samples)-1].Time) {
+				t.Errorf("run = %q from %v to %v", run.Itinerary, run.StartTime, run.EndTime)
+			}
+		})
+	}
+}
+
+type telemetryTestFix struct {
+	coordinates types.Coordinates
+	hasFix      bool
+}
+
+func TestSumTelemetrySections(t *testing.T) {
+	start := time.Date(2024, 7, 3, 9, 0, 0, 0, time.UTC)
+
+	// 100 V and 10 A is 1 kW out of the battery
+	sample := func(seconds float64, sectionIndex int, speedMph float64) types.TelemetrySample {
+		return types.TelemetrySample{
+			Time:            start.Add(time.Duration(seconds * float64(time.Second))),
+			SpeedMph:        speedMph,
+			BusCurrentAmps:  10,
+			BusVoltageVolts: 100,
+			ArrayPowerWatts: 360,
+			SectionIndex:    sectionIndex,
+		}
+	}
+
+	withBattery := func(sample types.TelemetrySample, batteryPercent float64) types.TelemetrySample {
+		sample.BatteryPercent = batteryPercent
+		return sample
+	}
+
+	tests := []struct {
+		name    string
+		samples []types.TelemetrySample
+		want    []types.TelemetrySection
+	}{
+		{
+			name:    "one section",
+			samples: []types.TelemetrySample{sample(0, 0, 40), sample(36, 0, 60)},
+			want: []types.TelemetrySection{
+				{SectionIndex: 0, StartTime: start, EndTime: start.Add(36 * time.Second), AverageSpeedMph: 50, BusEnergyWh: 10, ArrayEnergyWh: 3.6, SampleCount: 2},
+			},
+		},
+		{
+			name:    "time is counted on the section the interval starts on",
+			samples: []types.TelemetrySample{sample(0, 0, 40), sample(18, 1, 40), sample(54, 1, 40)},
+			want: []types.TelemetrySection{
+				{SectionIndex: 0, StartTime: start, EndTime: start.Add(18 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 5, ArrayEnergyWh: 1.8, SampleCount: 1},
+				{SectionIndex: 1, StartTime: start.Add(18 * time.Second), EndTime: start.Add(54 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, SampleCount: 2},
+			},
+		},
+		{
+			name:    "unmatched samples count towards the last section",
+			samples: []types.TelemetrySample{sample(0, -1, 40), sample(18, 2, 40), sample(36, -1, 40), sample(54, 3, 40)},
+			want: []types.TelemetrySection{
+				{SectionIndex: 2, StartTime: start.Add(18 * time.Second), EndTime: start.Add(54 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, SampleCount: 1},
+			},
+		},
+		{
+			name:    "gaps are not counted",
+			samples: []types.TelemetrySample{sample(0, 0, 40), sample(36, 0, 40), sample(136, 0, 60), sample(172, 0, 60)},
+			want: []types.TelemetrySection{
+				{SectionIndex: 0, StartTime: start, EndTime: start.Add(172 * time.Second), AverageSpeedMph: 50, BusEnergyWh: 20, ArrayEnergyWh: 7.2, SampleCount: 4},
+			},
+		},
+		{
+			name: "logged state of charge",
+			samples: []types.TelemetrySample{
+				withBattery(sample(0, 0, 40), 80), withBattery(sample(18, 0, 40), 79.5), sample(36, 1, 40),
+				withBattery(sample(54, 1, 40), 79), sample(72, 2, 40),
+			},
+			want: []types.TelemetrySection{
+				{SectionIndex: 0, StartTime: start, EndTime: start.Add(36 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, StartBatteryPercent: 80, EndBatteryPercent: 79.5, SampleCount: 2},
+				{SectionIndex: 1, StartTime: start.Add(36 * time.Second), EndTime: start.Add(72 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, StartBatteryPercent: 79, EndBatteryPercent: 79, SampleCount: 2},
+			},
+		},
+		{
+			name:    "sections driven out of order are sorted",
+			samples: []types.TelemetrySample{sample(0, 5, 40), sample(36, 4, 40), sample(72, 4, 40)},
+			want: []types.TelemetrySection{
+				{SectionIndex: 4, StartTime: start.Add(36 * time.Second), EndTime: start.Add(72 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, SampleCount: 2},
+				{SectionIndex: 5, StartTime: start, EndTime: start.Add(36 * time.Second), AverageSpeedMph: 40, BusEnergyWh: 10, ArrayEnergyWh: 3.6, SampleCount: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sections := sumTelemetrySections(test.samples)
+			if len(sections) != len(test.want) {
+				t.Fatalf("got %d sections, want %d: %+v", len(sections), len(test.want), sections)
+			}
+
+			for i, want := range test.want {
+				got := sections[i]
+				if got.SectionIndex != want.SectionIndex || got.SampleCount != want.SampleCount ||
+					got.StartBatteryPercent != want.StartBatteryPercent || got.EndBatteryPercent != want.EndBatteryPercent ||
+					!got.StartTime.Equal(want.StartTime) || !got.EndTime.Equal(want.EndTime) {
+					t.Errorf("section %d = %+v, want %+v", i, got, want)
+				}
+				if math.Abs(got.AverageSpeedMph-want.AverageSpeedMph) > 1e-9 ||
+					math.Abs(got.BusEnergyWh-want.BusEnergyWh) > 1e-9 ||
+					math.Abs(got.ArrayEnergyWh-want.ArrayEnergyWh) > 1e-9 {
+					t.Errorf("section %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchTelemetrySection(t *testing.T) {
+	itinerary := testOutAndBackItinerary(t)
+	anywhere := telemetryMatchWindow{MinDistanceFt: 0, MaxDistanceFt: math.Inf(1), ExpectedDistanceFt: math.NaN()}
+
+	// Half way along the second section, which is also half way along the second to last
+	onRoad := testOutAndBackCoordinates(1.5)
+	// Half way along the last section before the road turns back
+	nearTurn := testOutAndBackCoordinates(9.5)
+	// About 360 ft north of the road
+	besideRoad := types.Coordinates{Latitude: 36.001, Longitude: onRoad.Longitude}
+
+	tests := []struct {
+		name           string
+		coordinates    types.Coordinates
+		window         telemetryMatchWindow
+		wantIndex      int
+		wantDistanceFt float64
+	}{
+		{
+			name:           "first pass when nothing else is known",
+			coordinates:    onRoad,
+			window:         anywhere,
+			wantIndex:      1,
+			wantDistanceFt: 1.5 * testSectionLengthFt,
+		},
+		{
+			name:           "beside the road",
+			coordinates:    besideRoad,
+			window:         anywhere,
+			wantIndex:      1,
+			wantDistanceFt: 1.5 * testSectionLengthFt,
+		},
+		{
+			name:           "before the turn",
+			coordinates:    nearTurn,
+			window:         telemetryMatchWindow{MinDistanceFt: 0, MaxDistanceFt: math.Inf(1), ExpectedDistanceFt: 9 * testSectionLengthFt},
+			wantIndex:      9,
+			wantDistanceFt: 9.5 * testSectionLengthFt,
+		},
+		{
+			name:           "expected to be past the turn",
+			coordinates:    nearTurn,
+			window:         telemetryMatchWindow{MinDistanceFt: 0, MaxDistanceFt: math.Inf(1), ExpectedDistanceFt: 11 * testSectionLengthFt},
+			wantIndex:      10,
+			wantDistanceFt: 10.5 * testSectionLengthFt,
+		},
+		{
+			name:           "window starts after the first pass",
+			coordinates:    onRoad,
+			window:         telemetryMatchWindow{MinDistanceFt: 10 * testSectionLengthFt, MaxDistanceFt: math.Inf(1), ExpectedDistanceFt: math.NaN()},
+			wantIndex:      18,
+			wantDistanceFt: 18.5 * testSectionLengthFt,
+		},
+		{
+			name:        "window ends before the fix",
+			coordinates: onRoad,
+			window:      telemetryMatchWindow{MinDistanceFt: 0, MaxDistanceFt: testSectionLengthFt, ExpectedDistanceFt: math.NaN()},
+			wantIndex:   -1,
+		},
+		{
+			name:        "too far from the road",
+			coordinates: types.Coordinates{Latitude: 36.01, Longitude: onRoad.Longitude},
+			window:      anywhere,
+			wantIndex:   -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index, distanceFt := matchTelemetrySection(test.coordinates, itinerary, test.window)
+			if index != test.wantIndex {
+				t.Fatalf("section = %d, want %d", index, test.wantIndex)
+			}
+			if index >= 0 && math.Abs(distanceFt-test.wantDistanceFt) > 1 {
+				t.Errorf("distance = %v ft, want %v", distanceFt, test.wantDistanceFt)
+			}
+		})
+	}
+}
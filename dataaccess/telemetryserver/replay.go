@@ -0,0 +1,110 @@
+package telemetryserver
+
+import (
+	"asc-simulation/dataaccess"
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+/*
+This struct exists so we can add inputs to Replay() without having to
+change the code everywhere Replay() is used.
+*/
+type ReplayOptions struct {
+	// 1 plays the log back in real time, 10 ten times as fast. 0 sends every frame at once.
+	Speed float64
+	// Moves the sample times so the first one is when the replay starts, as if the car was driving now
+	Retime bool
+	// Prints every frame if true
+	Verbose bool
+}
+
+/*
+Sends recorded samples to a telemetry server, spaced out like they were logged.
+target is "udp://host:port", "tcp://host:port" or the URL of the server's /telemetry endpoint.
+*/
+func Replay(samples []types.TelemetrySample, target string, options ReplayOptions) error {
+	functionErrMsg := errors.New("error replaying telemetry to " + target)
+
+	if len(samples) == 0 {
+		return errors.Join(functionErrMsg, errors.New("no samples to replay"))
+	}
+
+	send, closeSender, err := frameSender(target)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer closeSender()
+
+	start := time.Now()
+	firstSampleTime := samples[0].Time
+
+	for _, sample := range samples {
+		sinceFirst := sample.Time.Sub(firstSampleTime)
+		if options.Speed > 0 {
+			// Waiting for each frame's time since the start keeps small delays from adding up
+			time.Sleep(time.Until(start.Add(time.Duration(float64(sinceFirst) / options.Speed))))
+		}
+		if options.Retime {
+			sample.Time = start.Add(sinceFirst)
+		}
+
+		frame, err := dataaccess.FormatTelemetryFrame(sample)
+		if err != nil {
+			return errors.Join(functionErrMsg, err)
+		}
+		err = send(frame)
+		if err != nil {
+			return errors.Join(functionErrMsg, err)
+		}
+
+		if options.Verbose {
+			fmt.Println(string(frame))
+		}
+	}
+
+	return nil
+}
+
+// Returns a function sending one frame to the target, and one closing the connection
+func frameSender(target string) (func([]byte) error, func(), error) {
+	scheme, address, found := strings.Cut(target, "://")
+	if !found {
+		return nil, nil, errors.New("target \"" + target + "\" has no scheme, expected udp://, tcp:// or http://")
+	}
+
+	switch scheme {
+	case "udp", "tcp":
+		connection, err := net.Dial(scheme, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		send := func(frame []byte) error {
+			_, err := connection.Write(append(frame, '\n'))
+			return err
+		}
+		return send, func() { connection.Close() }, nil
+
+	case "http", "https":
+		client := httpclient.NewClient(httpclient.Config{Timeout: 5 * time.Second})
+		send := func(frame []byte) error {
+			_, err := client.Send(context.Background(), httpclient.Request{
+				Service: "telemetry server",
+				Method:  "POST",
+				Url:     target,
+				Headers: map[string]string{"Content-Type": "application/x-ndjson"},
+				Body:    frame,
+			})
+			return err
+		}
+		return send, func() {}, nil
+	}
+
+	return nil, nil, errors.New("unknown scheme \"" + scheme + "\", expected udp, tcp or http")
+}
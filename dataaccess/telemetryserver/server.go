@@ -0,0 +1,222 @@
+/*
+Receives telemetry frames from the radio bridge and keeps the car's latest state, so the
+live re-planner and the GUI can follow the car. Frames are single-line JSON objects in the
+same format as NDJSON telemetry logs, sent over UDP (one or more lines per datagram),
+over TCP (one per line), or POSTed to /telemetry (one or more lines per request).
+*/
+package telemetryserver
+
+import (
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Biggest frame accepted over TCP or UDP
+const maxFrameBytes = 64 * 1024
+
+type Server struct {
+	// Where the car is located from its GPS position if set. Otherwise only the position is kept.
+	Itinerary *types.Itinerary
+	// Written with the latest state after every frame if set, for "live --state"
+	StateFilePath string
+	// Every frame received is appended to it if set, so it can be imported as a log later
+	Record io.Writer
+	// Prints every frame if true
+	Verbose bool
+
+	mutex      sync.Mutex
+	stream     dataaccess.TelemetryStream
+	sample     *types.TelemetrySample
+	state      types.CarState
+	frameCount int
+	// Distance of the last GPS fix matched to the itinerary, so later ones aren't matched behind it
+	lastDistanceFt float64
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// The car's latest state, and false if no frames have been received yet
+func (server *Server) State() (types.CarState, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.state, server.sample != nil
+}
+
+func (server *Server) FrameCount() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.frameCount
+}
+
+/*
+Adds every line of data as a frame. Frames that can't be read are skipped, and
+the first error is returned after the rest have been added.
+*/
+func (server *Server) AddFrames(data []byte) error {
+	var firstErr error
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		err := server.addFrame(line)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (server *Server) addFrame(frame []byte) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	sample, err := server.stream.AddFrame(frame)
+	if err != nil {
+		return errors.Join(errors.New("invalid telemetry frame"), err)
+	}
+	if server.Itinerary != nil && sample.HasFix {
+		sample.SectionIndex, sample.DistanceFt = dataaccess.LocateOnItinerary(sample.Coordinates, server.Itinerary, server.lastDistanceFt)
+		if sample.SectionIndex >= 0 {
+			server.lastDistanceFt = sample.DistanceFt
+		}
+	}
+	server.sample = &sample
+	server.frameCount++
+
+	state := types.CarState{
+		Time:           sample.Time,
+		BatteryPercent: sample.BatteryPercent,
+		SpeedMph:       sample.SpeedMph,
+		Coordinates:    sample.Coordinates,
+		HasFix:         sample.HasFix,
+		// Without a fix the car is where it was last seen
+		DistanceFt: server.lastDistanceFt,
+	}
+	server.state = state
+
+	if server.Verbose {
+		fmt.Printf("%s %5.1f mph %6.1f A %5.1f%% battery, mile %.1f\n", sample.Time.Format("15:04:05"),
+			sample.SpeedMph, sample.BusCurrentAmps, sample.BatteryPercent, state.DistanceFt/5280)
+	}
+
+	if server.Record != nil {
+		server.Record.Write(frame)
+		server.Record.Write([]byte("\n"))
+	}
+	if server.StateFilePath != "" {
+		err = dataaccess.SaveCarState(server.StateFilePath, &state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Receives frames sent as UDP datagrams until the connection fails
+func (server *Server) ServeUdp(address string) error {
+	connection, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	buffer := make([]byte, maxFrameBytes)
+	for {
+		length, _, err := connection.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		server.report(server.AddFrames(buffer[:length]))
+	}
+}
+
+// Receives frames sent over TCP, one per line, from any number of connections
+func (server *Server) ServeTcp(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer connection.Close()
+
+			scanner := bufio.NewScanner(connection)
+			scanner.Buffer(make([]byte, maxFrameBytes), maxFrameBytes)
+			for scanner.Scan() {
+				server.report(server.AddFrames(scanner.Bytes()))
+			}
+		}()
+	}
+}
+
+/*
+POST /telemetry adds the frames in the body.
+GET /state returns the car's state (types.CarState), and GET /sample the latest
+sample (types.TelemetrySample). Both are 404 until a frame has been received.
+*/
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	switch {
+	case request.Method == http.MethodPost && request.URL.Path == "/telemetry":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = server.AddFrames(body)
+		if err != nil {
+			server.report(err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	case request.Method == http.MethodGet && (request.URL.Path == "/state" || request.URL.Path == "/sample"):
+		server.mutex.Lock()
+		var response any = server.state
+		if request.URL.Path == "/sample" && server.sample != nil {
+			response = *server.sample
+		}
+		received := server.sample != nil
+		server.mutex.Unlock()
+
+		if !received {
+			http.Error(writer, "no telemetry received yet", http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(response)
+
+	default:
+		http.Error(writer, "not found", http.StatusNotFound)
+	}
+}
+
+// Bad frames shouldn't stop the server, so they are only printed
+func (server *Server) report(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, time.Now().Format("15:04:05"), err)
+	}
+}
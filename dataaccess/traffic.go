@@ -1,10 +1,64 @@
 package dataaccess
 
-import "asc-simulation/types"
+import (
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
 
-// Loads live traffic data at the specified section of the route.
-func GetTraffic(section types.RouteSection, options TrafficDataOptions) (*types.Traffic, error) {
-	return nil, nil // TODO: implement
+// Traffic is cached for each section and this much time, so nearby times share an API call
+const trafficCacheBucket = 15 * time.Minute
+
+type trafficCacheEntry struct {
+	// nil if the provider has no traffic data for the section
+	traffic     *types.Traffic
+	collectedAt time.Time
+}
+
+// Traffic is only cached in memory, since it changes too quickly to be worth keeping
+var trafficCache = make(map[string]trafficCacheEntry)
+
+/*
+Loads live traffic data at the specified section of the route.
+Returns nil if no traffic provider is set up or it has no data for the section.
+*/
+func GetTraffic(section *types.RouteSection, options TrafficDataOptions) (*types.Traffic, error) {
+	return GetTrafficAtTime(section, time.Now(), options)
+}
+
+/*
+Loads traffic at the specified section of the route at targetTime, which can be in
+the past or future (e.g. from a historical flow profile).
+Returns nil if no traffic provider is set up or it has no data for the section.
+*/
+func GetTrafficAtTime(section *types.RouteSection, targetTime time.Time, options TrafficDataOptions) (*types.Traffic, error) {
+	provider, err := options.getProvider()
+	if err != nil || provider == nil {
+		return nil, err
+	}
+
+	coordinates := sectionMidpoint(section)
+	key := provider.Name() + " " +
+		strconv.FormatFloat(coordinates.Latitude, 'f', 5, 64) + "," +
+		strconv.FormatFloat(coordinates.Longitude, 'f', 5, 64) + " " +
+		strconv.FormatInt(targetTime.Truncate(trafficCacheBucket).Unix(), 10)
+
+	cached, exists := trafficCache[key]
+	if exists && time.Since(cached.collectedAt) < options.refreshDuration() {
+		return cached.traffic, nil
+	}
+
+	traffic, err := provider.GetTraffic(options.context(), coordinates, targetTime)
+	if err != nil {
+		return nil, errors.Join(errors.New("error getting traffic data"), err)
+	}
+
+	trafficCache[key] = trafficCacheEntry{traffic: traffic, collectedAt: time.Now()}
+	return traffic, nil
 }
 
 /*
@@ -14,4 +68,100 @@ change the code everywhere GetTraffic() is used. This also allows us to use defa
 type TrafficDataOptions struct {
 	// Amount of time to wait before fetching new traffic data from API
 	RefreshRateSeconds float64
+	// Where to get traffic from. nil uses GetTrafficProvider().
+	Provider TrafficProvider
+	// Cancels traffic API calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+func DefaultTrafficDataOptions() TrafficDataOptions {
+	return TrafficDataOptions{
+		RefreshRateSeconds: 5 * 60,
+	}
+}
+
+func (options *TrafficDataOptions) getProvider() (TrafficProvider, error) {
+	if options.Provider != nil {
+		return options.Provider, nil
+	}
+	return GetTrafficProvider()
+}
+
+func (options *TrafficDataOptions) context() context.Context {
+	if options.Context != nil {
+		return options.Context
+	}
+	return context.Background()
+}
+
+// RefreshRateSeconds as a duration. Very large refresh rates never expire.
+func (options *TrafficDataOptions) refreshDuration() time.Duration {
+	if options.RefreshRateSeconds >= float64(math.MaxInt64/time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(options.RefreshRateSeconds * float64(time.Second))
+}
+
+/*
+A source of traffic data. GetTraffic() and GetTrafficAtTime() cache what providers
+return, so providers don't need to do any caching.
+*/
+type TrafficProvider interface {
+	// Short unique name, e.g. "http". Used to keep each provider's cached data apart.
+	Name() string
+	// Traffic at the coordinates at the given time. nil if the provider has no data there.
+	GetTraffic(ctx context.Context, coordinates types.Coordinates, at time.Time) (*types.Traffic, error)
+}
+
+var trafficProvider TrafficProvider = nil
+var trafficProviderLoaded = false
+
+/*
+Returns the traffic provider chosen by the TRAFFIC_PROVIDER environment variable:
+"none" (the default), "file" (reads TRAFFIC_FILE) or "http" (calls TRAFFIC_URL).
+Returns nil for "none".
+*/
+func GetTrafficProvider() (TrafficProvider, error) {
+	if trafficProviderLoaded {
+		return trafficProvider, nil
+	}
+
+	provider, err := NewTrafficProvider(os.Getenv("TRAFFIC_PROVIDER"))
+	if err != nil {
+		return nil, err
+	}
+
+	SetTrafficProvider(provider)
+	return trafficProvider, nil
+}
+
+// Replaces the traffic provider used when TrafficDataOptions.Provider is not set. nil turns traffic off.
+func SetTrafficProvider(provider TrafficProvider) {
+	trafficProvider = provider
+	trafficProviderLoaded = true
+}
+
+// Creates a traffic provider by name. See GetTrafficProvider() for the names.
+func NewTrafficProvider(name string) (TrafficProvider, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "file":
+		trafficFilePath := os.Getenv("TRAFFIC_FILE")
+		if trafficFilePath == "" {
+			return nil, errors.New("TRAFFIC_FILE must be set to use the file traffic provider")
+		}
+		return NewFileTrafficProvider(trafficFilePath)
+	case "http":
+		return HttpTrafficProvider{}, nil
+	}
+
+	return nil, errors.New("unknown traffic provider \"" + name + "\"")
+}
+
+func sectionMidpoint(section *types.RouteSection) types.Coordinates {
+	return types.Coordinates{
+		Latitude:  (section.CoordinatesInitial.Latitude + section.CoordinatesFinal.Latitude) / 2,
+		Longitude: (section.CoordinatesInitial.Longitude + section.CoordinatesFinal.Longitude) / 2,
+	}
 }
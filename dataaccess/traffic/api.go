@@ -0,0 +1,51 @@
+package traffic
+
+import (
+	"asc-simulation/dataaccess/httpclient"
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Gets the traffic flow speed at the coordinates at the given time.
+func GetFlow(ctx context.Context, coordinates types.Coordinates, at time.Time) (*FlowResponse, error) {
+	functionErrMsg := errors.New("error getting traffic flow")
+
+	trafficUrl := os.Getenv("TRAFFIC_URL")
+	if trafficUrl == "" {
+		return nil, errors.Join(functionErrMsg, errors.New("no URL found for the traffic API"))
+	}
+	if !strings.HasSuffix(trafficUrl, "/") {
+		trafficUrl += "/"
+	}
+
+	query := url.Values{}
+	query.Add("latitude", fmt.Sprint(coordinates.Latitude))
+	query.Add("longitude", fmt.Sprint(coordinates.Longitude))
+	query.Add("time", at.UTC().Format(time.RFC3339))
+
+	headers := map[string]string{}
+	if token := os.Getenv("TRAFFIC_TOKEN"); token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+
+	var result FlowResponse
+	err := httpclient.Default().SendJson(ctx, httpclient.Request{
+		Service: "traffic API",
+		Method:  http.MethodGet,
+		Url:     trafficUrl + "flow",
+		Query:   query,
+		Headers: headers,
+	}, &result)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,19 @@
+package traffic
+
+/*
+Type definitions for the traffic flow API. The API is our own (served by the
+mock-apis command), shaped so a thin proxy can put any commercial flow API behind it:
+
+	GET {TRAFFIC_URL}flow?latitude=36.16&longitude=-86.78&time=2024-07-20T14:00:00Z
+
+time is optional and defaults to now. Servers with only live data ignore it.
+*/
+
+type FlowResponse struct {
+	// Speed traffic is moving at
+	FlowSpeedMph float64 `json:"flow_speed_mph"`
+	// Speed traffic moves at when the road is empty, 0 if unknown
+	FreeFlowSpeedMph float64 `json:"free_flow_speed_mph"`
+	// False if the server has no data for the location, in which case the speeds are 0
+	Covered bool `json:"covered"`
+}
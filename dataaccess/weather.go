@@ -1,180 +1,142 @@
 package dataaccess
 
 import (
-	"asc-simulation/dataaccess/solcast"
 	"asc-simulation/types"
+	"context"
 	"encoding/json"
 	"errors"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 )
 
+// How far apart cached samples can be and still be interpolated between
+const weatherMaxInterpolationGap = 3 * time.Hour
+
+// How far from the requested time a single sample can be and still be used on its own
+const weatherSampleTolerance = 30 * time.Minute
+
+// Weather is fetched from this long before the requested time...
+const weatherFetchBefore = 1 * time.Hour
+
+// ...to this long after it, so nearby requests are answered by the cache
+const weatherFetchAfter = 6 * time.Hour
+
 /*
 Loads live weather data at the specified section of the route.
 Call DefaultWeatherOptions() to load the default weather options.
 */
 func GetWeather(section *types.RouteSection, options WeatherDataOptions) (*types.Weather, error) {
-	cacheSection, err := getWeatherCacheSection(section, &options)
+	provider, err := options.getProvider()
 	if err != nil {
-		// If the JSON Weather cache fails, we do NOT want to make calls to Solcast anyway.
-		// If that happens during an optimization run, the API endpoints could be called
-		// hundreds/thousands of times extra, using up our available API calls.
 		return nil, err
 	}
-	if cacheSection != nil && cacheSection.Weather != nil {
-		return cacheSection.Weather, nil
-	}
 
-	responseInterval := solcast.Period5Mins
-	solcastResponse, err := solcast.GetLiveIrradianceAndWeather(
-		section.CoordinatesInitial,
-		1,
-		responseInterval,
-	)
+	now := time.Now()
+	cachedWeather, err := getCachedWeather(section, provider, &options, now)
 	if err != nil {
-		return nil, errors.Join(errors.New("error getting weather data"), err)
+		// If the JSON Weather cache fails, we do NOT want to make calls to the weather API anyway.
+		// If that happens during an optimization run, the API endpoints could be called
+		// hundreds/thousands of times extra, using up our available API calls.
+		return nil, err
 	}
-
-	evaporationRateInputs := make([]evaporationRateInput, len(solcastResponse.EstimatedActuals))
-	precipitationRates := make([]float64, len(solcastResponse.EstimatedActuals))
-	for i, weatherData := range solcastResponse.EstimatedActuals {
-		evaporationRateInputs[i] = evaporationRateInput{
-			windSpeedMpS:   weatherData.WindSpeed10m,
-			airPressurehPa: weatherData.SurfacePressure,
-			airTempC:       weatherData.AirTemp,
-			dewPointC:      weatherData.DewpointTemp,
-		}
-		precipitationRates[i] = weatherData.PrecipitationRate
+	if cachedWeather != nil {
+		return cachedWeather, nil
 	}
 
-	mostRecentWeather := solcastResponse.EstimatedActuals[0]
-	weather := types.Weather{
-		SolarZenithDegrees:   mostRecentWeather.Zenith,
-		AirTempDegreesF:      celsiusToFahrenheit(mostRecentWeather.AirTemp),
-		CloudCoverPercentage: mostRecentWeather.CloudOpacity,
-		WindSpeedMph:         msToMph(mostRecentWeather.WindSpeed10m),
-		WindDirectionDegrees: mostRecentWeather.WindDirection10m,
-		RainOnGroundInches:   accumulatedRainfallInches(evaporationRateInputs, precipitationRates, responseInterval),
-		SurfacePressurePsi:   mostRecentWeather.SurfacePressure * hPaToPsi,
+	sample, err := provider.GetLiveWeather(options.context(), section.CoordinatesInitial)
+	if err != nil {
+		return nil, err
 	}
 
-	if options.UsingWeatherCache {
-		key := section.Route.Name
-
-		if cacheSection == nil {
-			weatherCache[key] = createWeatherCacheSectionsForRoute(section.Route)
-			cacheSection, _ = getWeatherCacheSection(section, &options)
-		}
-
-		now := time.Now()
-		cacheSection.Weather = &weather
-		cacheSection.CollectedAt = now
-		cacheSection.OccursAt = now
-
-		err = saveWeatherCacheToJson(key, weatherCache[key])
-		if err != nil {
-			return &weather, err
-		}
+	err = cacheWeather(section, provider, &options, []WeatherSample{*sample})
+	if err != nil {
+		return &sample.Weather, err
 	}
 
-	return &weather, nil
+	return &sample.Weather, nil
 }
 
 /*
-Loads forecasted weather data at the specified section of the route, at the specified time.
-Can get forecasted data up to 336 hours (14 days) in the future.
+Loads weather data at the specified section of the route, at the specified time.
+Times in the past use the provider's historical weather, and anything else uses its forecast.
+How far in the future forecasts are available depends on the weather provider
+(336 hours for Solcast).
 Call DefaultWeatherOptions() to load the default weather options.
 */
-func GetWeatherForecast(
+func GetWeatherAtTime(
 	section *types.RouteSection,
-	hoursInFuture int,
+	targetTime time.Time,
 	options WeatherDataOptions,
 ) (*types.Weather, error) {
-	if hoursInFuture > 336 {
-		return nil, errors.New("cannot get forecast more than 336 hours in the future")
+	provider, err := options.getProvider()
+	if err != nil {
+		return nil, err
 	}
 
-	cacheSection, err := getWeatherCacheSection(section, &options)
+	cachedWeather, err := getCachedWeather(section, provider, &options, targetTime)
 	if err != nil {
-		// If the JSON Weather cache fails, we do NOT want to make calls to Solcast anyway.
+		// If the JSON Weather cache fails, we do NOT want to make calls to the weather API anyway.
 		// If that happens during an optimization run, the API endpoints could be called
 		// hundreds/thousands of times extra, using up our available API calls.
 		return nil, err
 	}
-	if cacheSection != nil && cacheSection.Weather != nil {
-		return cacheSection.Weather, nil
+	if cachedWeather != nil {
+		return cachedWeather, nil
 	}
 
-	responseInterval := solcast.Period5Mins
+	now := time.Now()
+	start := targetTime.Add(-weatherFetchBefore)
+	end := targetTime.Add(weatherFetchAfter)
 
-	solcastResponse, err := solcast.GetForecastIrradianceAndWeather(
-		section.CoordinatesInitial,
-		hoursInFuture,
-		responseInterval,
-	)
-	if err != nil {
-		return nil, errors.Join(errors.New("error getting weather data"), err)
+	if targetTime.Before(now.Add(-weatherSampleTolerance)) {
+		end = minTime(end, now)
+	} else {
+		start = maxTime(start, now)
 	}
 
-	evaporationRateInputs := make([]evaporationRateInput, len(solcastResponse.Forecasts))
-	precipitationRates := make([]float64, len(solcastResponse.Forecasts))
-	for i, weatherData := range solcastResponse.Forecasts {
-		evaporationRateInputs[i] = evaporationRateInput{
-			windSpeedMpS:   weatherData.WindSpeed10m,
-			airPressurehPa: weatherData.SurfacePressure,
-			airTempC:       weatherData.AirTemp,
-			dewPointC:      weatherData.DewpointTemp,
-		}
-		precipitationRates[i] = weatherData.PrecipitationRate
+	samples, _, err := fetchWeatherSamples(options.context(), provider, section.CoordinatesInitial, start, end)
+	if err != nil {
+		return nil, err
 	}
 
-	weatherAtTargetTime := solcastResponse.Forecasts[len(solcastResponse.Forecasts)-1]
-	weather := types.Weather{
-		SolarZenithDegrees:   weatherAtTargetTime.Zenith,
-		AirTempDegreesF:      celsiusToFahrenheit(weatherAtTargetTime.AirTemp),
-		CloudCoverPercentage: weatherAtTargetTime.CloudOpacity,
-		WindSpeedMph:         msToMph(weatherAtTargetTime.WindSpeed10m),
-		WindDirectionDegrees: weatherAtTargetTime.WindDirection10m,
-		RainOnGroundInches:   accumulatedRainfallInches(evaporationRateInputs, precipitationRates, responseInterval),
-		SurfacePressurePsi:   weatherAtTargetTime.SurfacePressure * hPaToPsi,
+	weather := interpolateWeather(samples, targetTime)
+	if weather == nil {
+		return nil, errors.New(
+			"the " + provider.Name() + " weather provider has no weather for " + targetTime.Format(time.RFC3339),
+		)
 	}
 
-	if options.UsingWeatherCache {
-		key := section.Route.Name
-
-		if cacheSection == nil {
-			weatherCache[key] = createWeatherCacheSectionsForRoute(section.Route)
-			cacheSection, _ = getWeatherCacheSection(section, &options)
-		}
-
-		now := time.Now()
-		cacheSection.Weather = &weather
-		cacheSection.CollectedAt = now
-		cacheSection.OccursAt = now.Add(time.Duration(hoursInFuture) * time.Hour)
-
-		err = saveWeatherCacheToJson(key, weatherCache[key])
-		if err != nil {
-			return &weather, err
-		}
+	err = cacheWeather(section, provider, &options, samples)
+	if err != nil {
+		return weather, err
 	}
 
-	return &weather, nil
+	return weather, nil
 }
 
 /*
-Loads forecasted weather data at the specified section of the route, at the specified time.
-Can get forecasted data up to 336 hours (14 days) in the future.
+Loads forecasted weather data at the specified section of the route, hoursInFuture from now.
 Call DefaultWeatherOptions() to load the default weather options.
 */
+func GetWeatherForecast(
+	section *types.RouteSection,
+	hoursInFuture int,
+	options WeatherDataOptions,
+) (*types.Weather, error) {
+	targetTime := time.Now().Add(time.Duration(hoursInFuture) * time.Hour)
+	return GetWeatherAtTime(section, targetTime, options)
+}
+
+// Same as GetWeatherAtTime(). Exists so older code keeps working.
 func GetWeatherForecastAtTime(
 	section *types.RouteSection,
 	targetTime time.Time,
 	options WeatherDataOptions,
 ) (*types.Weather, error) {
-	timeUntilTarget := time.Until(targetTime)
-	return GetWeatherForecast(section, int(timeUntilTarget.Hours()), options)
+	return GetWeatherAtTime(section, targetTime, options)
 }
 
 /*
@@ -187,6 +149,24 @@ type WeatherDataOptions struct {
 	UsingWeatherCache bool
 	// Amount of time to wait before fetching new weather data from API
 	RefreshTimeSeconds float64
+	// Where weather data comes from. If nil, GetWeatherProvider() is used.
+	Provider WeatherProvider
+	// Cancels weather API calls and retry waits, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+func (options *WeatherDataOptions) getProvider() (WeatherProvider, error) {
+	if options.Provider != nil {
+		return options.Provider, nil
+	}
+	return GetWeatherProvider()
+}
+
+func (options *WeatherDataOptions) context() context.Context {
+	if options.Context != nil {
+		return options.Context
+	}
+	return context.Background()
 }
 
 func DefaultWeatherDataOptions() WeatherDataOptions {
@@ -196,28 +176,57 @@ func DefaultWeatherDataOptions() WeatherDataOptions {
 	}
 }
 
+// RefreshTimeSeconds as a duration. Very large refresh times never expire.
+func (options *WeatherDataOptions) refreshDuration() time.Duration {
+	if options.RefreshTimeSeconds >= float64(math.MaxInt64/time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(options.RefreshTimeSeconds * float64(time.Second))
+}
+
+type weatherCacheSample struct {
+	ValidAt     time.Time
+	CollectedAt time.Time
+	Weather     types.Weather
+}
+
 type weatherCacheSection struct {
 	CombinedLengthFt  float64
-	OccursAt          time.Time
-	CollectedAt       time.Time
 	StartSectionIndex int
 	EndSectionIndex   int
-	Weather           *types.Weather
+	// Weather at this part of the route over time, oldest first
+	Samples []weatherCacheSample
 }
 
 const weatherCacheSectionMinLengthFt float64 = 2 * miToFt
 
 var weatherCache = make(map[string][]weatherCacheSection)
 
-func getWeatherCacheSection(section *types.RouteSection, options *WeatherDataOptions) (*weatherCacheSection, error) {
+// Returns nil if the cache has no recent enough weather near the target time
+func getCachedWeather(
+	section *types.RouteSection,
+	provider WeatherProvider,
+	options *WeatherDataOptions,
+	targetTime time.Time,
+) (*types.Weather, error) {
+	cacheSection, err := getWeatherCacheSection(section, provider, options)
+	if err != nil || cacheSection == nil {
+		return nil, err
+	}
+
+	return interpolateWeather(cacheSection.freshSamples(options.refreshDuration()), targetTime), nil
+}
+
+func getWeatherCacheSection(
+	section *types.RouteSection,
+	provider WeatherProvider,
+	options *WeatherDataOptions,
+) (*weatherCacheSection, error) {
 	if !options.UsingWeatherCache {
 		return nil, nil
 	}
 
-	key := section.Route.Name
-	if key == "" {
-		key = createDefaultCacheKey(section)
-	}
+	key := createCacheKey(section, provider)
 
 	weatherCacheSections, existsInMemory := weatherCache[key]
 	if !existsInMemory {
@@ -251,18 +260,223 @@ func getWeatherCacheSection(section *types.RouteSection, options *WeatherDataOpt
 			left = mid + 1
 		}
 	}
+
+	return cacheSection, nil
+}
+
+// Samples collected less than refreshDuration ago
+func (cacheSection *weatherCacheSection) freshSamples(refreshDuration time.Duration) []WeatherSample {
+	now := time.Now()
+
+	samples := make([]WeatherSample, 0, len(cacheSection.Samples))
+	for _, sample := range cacheSection.Samples {
+		if now.Before(sample.CollectedAt.Add(refreshDuration)) {
+			samples = append(samples, WeatherSample{ValidAt: sample.ValidAt, Weather: sample.Weather})
+		}
+	}
+
+	return samples
+}
+
+/*
+Adds newly collected samples, replacing any existing samples at the same times.
+Expired samples are removed so the cache doesn't grow forever.
+*/
+func (cacheSection *weatherCacheSection) addSamples(samples []WeatherSample, refreshDuration time.Duration) {
+	now := time.Now()
+	newSampleTimes := make(map[int64]bool)
+	for _, sample := range samples {
+		newSampleTimes[sample.ValidAt.Unix()] = true
+	}
+
+	kept := make([]weatherCacheSample, 0, len(cacheSection.Samples)+len(samples))
+	for _, sample := range cacheSection.Samples {
+		if now.Before(sample.CollectedAt.Add(refreshDuration)) && !newSampleTimes[sample.ValidAt.Unix()] {
+			kept = append(kept, sample)
+		}
+	}
+	for _, sample := range samples {
+		kept = append(kept, weatherCacheSample{
+			ValidAt:     sample.ValidAt,
+			CollectedAt: now,
+			Weather:     sample.Weather,
+		})
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].ValidAt.Before(kept[j].ValidAt)
+	})
+	cacheSection.Samples = kept
+}
+
+func cacheWeather(
+	section *types.RouteSection,
+	provider WeatherProvider,
+	options *WeatherDataOptions,
+	samples []WeatherSample,
+) error {
+	if !options.UsingWeatherCache {
+		return nil
+	}
+
+	key := createCacheKey(section, provider)
+
+	cacheSection, _ := getWeatherCacheSection(section, provider, options)
 	if cacheSection == nil {
-		return nil, nil
+		weatherCache[key] = createWeatherCacheSectionsForRoute(section.Route)
+		cacheSection, _ = getWeatherCacheSection(section, provider, options)
 	}
 
-	duration := time.Duration(options.RefreshTimeSeconds * float64(time.Second))
-	expirationTime := cacheSection.CollectedAt.Add(duration)
+	cacheSection.addSamples(samples, options.refreshDuration())
 
-	if time.Now().After(expirationTime) {
-		cacheSection.Weather = nil
+	return saveWeatherCacheToJson(key, weatherCache[key])
+}
+
+/*
+Returns the weather at the target time, linearly interpolated between the samples on
+either side of it. If the samples on either side are too far apart, the closest sample
+is used if it is close enough. Returns nil if there is no usable sample.
+samples must be sorted oldest first.
+*/
+func interpolateWeather(samples []WeatherSample, targetTime time.Time) *types.Weather {
+	after := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].ValidAt.Before(targetTime)
+	})
+
+	if after < len(samples) && samples[after].ValidAt.Equal(targetTime) {
+		weather := samples[after].Weather
+		return &weather
 	}
 
-	return cacheSection, nil
+	if after > 0 && after < len(samples) {
+		previous, next := samples[after-1], samples[after]
+		gap := next.ValidAt.Sub(previous.ValidAt)
+
+		if gap <= weatherMaxInterpolationGap {
+			ratio := float64(targetTime.Sub(previous.ValidAt)) / float64(gap)
+			weather := lerpWeather(previous.Weather, next.Weather, ratio)
+			return &weather
+		}
+	}
+
+	var closest *WeatherSample = nil
+	for _, i := range []int{after - 1, after} {
+		if i < 0 || i >= len(samples) {
+			continue
+		}
+		if closest == nil || samples[i].ValidAt.Sub(targetTime).Abs() < closest.ValidAt.Sub(targetTime).Abs() {
+			closest = &samples[i]
+		}
+	}
+	if closest == nil || closest.ValidAt.Sub(targetTime).Abs() > weatherSampleTolerance {
+		return nil
+	}
+
+	weather := closest.Weather
+	return &weather
+}
+
+func lerpWeather(a types.Weather, b types.Weather, ratio float64) types.Weather {
+	lerp := func(a float64, b float64) float64 {
+		return a + (b-a)*ratio
+	}
+
+	// Go the short way around, so 350 and 10 degrees interpolate through 0 and not 180
+	lerpDegrees := func(a float64, b float64) float64 {
+		change := math.Mod(b-a+540, 360) - 180
+		return math.Mod(a+change*ratio+360, 360)
+	}
+
+	return types.Weather{
+		SolarZenithDegrees:   lerp(a.SolarZenithDegrees, b.SolarZenithDegrees),
+		AirTempDegreesF:      lerp(a.AirTempDegreesF, b.AirTempDegreesF),
+		CloudCoverPercentage: lerp(a.CloudCoverPercentage, b.CloudCoverPercentage),
+		WindSpeedMph:         lerp(a.WindSpeedMph, b.WindSpeedMph),
+		WindDirectionDegrees: lerpDegrees(a.WindDirectionDegrees, b.WindDirectionDegrees),
+		RainOnGroundInches:   lerp(a.RainOnGroundInches, b.RainOnGroundInches),
+		SurfacePressurePsi:   lerp(a.SurfacePressurePsi, b.SurfacePressurePsi),
+		WindGustMph:          lerp(a.WindGustMph, b.WindGustMph),
+		VisibilityMi:         lerp(a.VisibilityMi, b.VisibilityMi),
+		SolarAzimuthDegrees:  lerpDegrees(a.SolarAzimuthDegrees, b.SolarAzimuthDegrees),
+
+		GlobalHorizontalIrradianceWm2:  lerp(a.GlobalHorizontalIrradianceWm2, b.GlobalHorizontalIrradianceWm2),
+		DirectNormalIrradianceWm2:      lerp(a.DirectNormalIrradianceWm2, b.DirectNormalIrradianceWm2),
+		DiffuseHorizontalIrradianceWm2: lerp(a.DiffuseHorizontalIrradianceWm2, b.DiffuseHorizontalIrradianceWm2),
+		GlobalTiltedIrradianceWm2:      lerp(a.GlobalTiltedIrradianceWm2, b.GlobalTiltedIrradianceWm2),
+	}
+}
+
+/*
+Gets weather from start to end, using historical weather for the past and forecasts
+for the future. Returns the samples, oldest first, and the number of API calls made.
+*/
+func fetchWeatherSamples(
+	ctx context.Context,
+	provider WeatherProvider,
+	coordinates types.Coordinates,
+	start time.Time,
+	end time.Time,
+) ([]WeatherSample, int, error) {
+	now := time.Now()
+
+	if weatherFetchCallCount(start, end) == 1 {
+		if start.Before(now.Add(-weatherSampleTolerance)) {
+			samples, err := provider.GetHistoricalWeather(ctx, coordinates, start, end)
+			return samples, 1, err
+		}
+		samples, err := provider.GetForecastWeather(ctx, coordinates, start, end)
+		return samples, 1, err
+	}
+
+	// The window includes now, so it needs both
+	samples, err := provider.GetHistoricalWeather(ctx, coordinates, start, now)
+	if err != nil {
+		return nil, 1, err
+	}
+	forecast, err := provider.GetForecastWeather(ctx, coordinates, now, end)
+	if err != nil {
+		return nil, 2, err
+	}
+	for _, sample := range forecast {
+		if len(samples) == 0 || sample.ValidAt.After(samples[len(samples)-1].ValidAt) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples, 2, nil
+}
+
+// Number of API calls fetchWeatherSamples() makes for the window
+func weatherFetchCallCount(start time.Time, end time.Time) int {
+	now := time.Now()
+	if start.Before(now.Add(-weatherSampleTolerance)) && end.After(now.Add(weatherSampleTolerance)) {
+		return 2
+	}
+	return 1
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Each provider's weather is cached separately, so switching providers never returns another provider's data
+func createCacheKey(section *types.RouteSection, provider WeatherProvider) string {
+	key := section.Route.Name
+	if key == "" {
+		key = createDefaultCacheKey(section)
+	}
+
+	return provider.Name() + " " + key
 }
 
 func createDefaultCacheKey(section *types.RouteSection) string {
@@ -294,7 +508,6 @@ func createWeatherCacheSectionsForRoute(route *types.Route) []weatherCacheSectio
 				CombinedLengthFt:  section.LengthFt,
 				StartSectionIndex: section.PositionInRoute,
 				EndSectionIndex:   section.PositionInRoute,
-				Weather:           nil,
 			})
 		}
 	}
@@ -380,24 +593,6 @@ type evaporationRateInput struct {
 	dewPointC      float64
 }
 
-// This is an estimate - see comment for rainEvaporationRateInchesPerHour()
-func accumulatedRainfallInches(
-	evaporationRateInputs []evaporationRateInput,
-	precipitationRatesMmph []float64,
-	responseTimeInterval solcast.TimePeriod,
-) float64 {
-	accumulatedRain := 0.0
-	minuteRatio := float64(responseTimeInterval.MinuteValue()) / 60.0
-
-	for i := len(evaporationRateInputs) - 1; i >= 0; i-- {
-		accumulatedRain += (precipitationRatesMmph[i] / 1000) * mToFt * ftToIn * minuteRatio
-		accumulatedRain -= rainEvaporationRateInchesPerHour(evaporationRateInputs[i]) * minuteRatio
-		accumulatedRain = math.Max(0, accumulatedRain)
-	}
-
-	return accumulatedRain
-}
-
 /*
 THIS IS AN ESTIMATE!
 This estimates rain evaporation per hour, but it's based off an equation meant for
@@ -436,5 +631,5 @@ func humidityRatio(airPressurehPa float64, vaporPressurehPa float64) float64 {
 
 // Source: https://www.weather.gov/media/epz/wxcalc/vaporPressure.pdf
 func vaporPressurehPa(airTempC float64) float64 {
-	return 6.11 * 10 * (7.5 * airTempC / (237.3 + airTempC))
+	return 6.11 * math.Pow(10, 7.5*airTempC/(237.3+airTempC))
 }
@@ -0,0 +1,74 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestInterpolateWeather(t *testing.T) {
+	// Noon in Chicago
+	noon := time.Date(2024, 6, 20, 17, 0, 0, 0, time.UTC)
+	sample := func(offset time.Duration, tempF float64, windDirection float64) WeatherSample {
+		return WeatherSample{
+			ValidAt: noon.Add(offset),
+			Weather: types.Weather{AirTempDegreesF: tempF, WindDirectionDegrees: windDirection},
+		}
+	}
+
+	hourly := []WeatherSample{
+		sample(-time.Hour, 70, 350),
+		sample(0, 80, 10),
+		sample(time.Hour, 90, 30),
+	}
+	gappy := []WeatherSample{
+		sample(-5*time.Hour, 60, 0),
+		sample(5*time.Hour, 100, 0),
+	}
+
+	tests := []struct {
+		name       string
+		samples    []WeatherSample
+		targetTime time.Time
+		// False if there is no usable sample
+		wantFound         bool
+		wantTempF         float64
+		wantWindDirection float64
+	}{
+		{name: "on a sample", samples: hourly, targetTime: noon, wantFound: true, wantTempF: 80, wantWindDirection: 10},
+		{name: "between samples", samples: hourly, targetTime: noon.Add(15 * time.Minute), wantFound: true, wantTempF: 82.5, wantWindDirection: 15},
+		{name: "wind direction across north", samples: hourly, targetTime: noon.Add(-30 * time.Minute), wantFound: true, wantTempF: 75, wantWindDirection: 0},
+		{name: "just before the first sample", samples: hourly, targetTime: noon.Add(-80 * time.Minute), wantFound: true, wantTempF: 70, wantWindDirection: 350},
+		{name: "just after the last sample", samples: hourly, targetTime: noon.Add(80 * time.Minute), wantFound: true, wantTempF: 90, wantWindDirection: 30},
+		{name: "long before the first sample", samples: hourly, targetTime: noon.Add(-2 * time.Hour)},
+		{name: "long after the last sample", samples: hourly, targetTime: noon.Add(2 * time.Hour)},
+		{name: "samples too far apart", samples: gappy, targetTime: noon},
+		{name: "near a sample with the next too far away", samples: gappy, targetTime: noon.Add(-280 * time.Minute), wantFound: true, wantTempF: 60},
+		{name: "no samples", samples: nil, targetTime: noon},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			weather := interpolateWeather(test.samples, test.targetTime)
+			if !test.wantFound {
+				if weather != nil {
+					t.Fatalf("interpolateWeather() = %+v, want nil", weather)
+				}
+				return
+			}
+			if weather == nil {
+				t.Fatal("interpolateWeather() = nil")
+			}
+
+			if math.Abs(weather.AirTempDegreesF-test.wantTempF) > 1e-9 {
+				t.Errorf("AirTempDegreesF = %v, want %v", weather.AirTempDegreesF, test.wantTempF)
+			}
+			// 0 and 360 degrees are the same direction
+			directionError := math.Mod(weather.WindDirectionDegrees-test.wantWindDirection+540, 360) - 180
+			if math.Abs(directionError) > 1e-9 {
+				t.Errorf("WindDirectionDegrees = %v, want %v", weather.WindDirectionDegrees, test.wantWindDirection)
+			}
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package dataaccess
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const weatherCacheFileExtension = ".json"
+
+// Summary of the cached weather for part of a route
+type WeatherCacheChunk struct {
+	StartSectionIndex int
+	EndSectionIndex   int
+	LengthFt          float64
+	SampleCount       int
+	// Zero if the chunk has no samples
+	FirstValidAt    time.Time
+	LastValidAt     time.Time
+	LastCollectedAt time.Time
+}
+
+// Summary of one weather cache file. There is one file per route and weather provider.
+type WeatherCacheEntry struct {
+	// File name without the extension, e.g. "solcast A Independence to Topeka"
+	Name       string
+	FilePath   string
+	SizeBytes  int64
+	Chunks     []WeatherCacheChunk
+	ChunkCount int
+	// Number of chunks with at least one sample
+	CoveredChunkCount int
+	SampleCount       int
+	FirstValidAt      time.Time
+	LastValidAt       time.Time
+	LastCollectedAt   time.Time
+}
+
+/*
+This struct exists so we can add ways of choosing what to clear without having to
+change the code everywhere ClearWeatherCache() is used.
+*/
+type WeatherCacheClearOptions struct {
+	// Deletes every matching file instead of only the expired samples
+	All bool
+	// Only clear caches whose name contains this. Empty matches everything.
+	Name string
+	// Samples collected longer ago than this are expired
+	MaxAgeSeconds float64
+}
+
+// The folder weather cache files are stored in
+func WeatherCacheFolder() string {
+	return getWeatherCacheOutputFolder()
+}
+
+// Lists every weather cache file, sorted by name.
+func ListWeatherCache() ([]WeatherCacheEntry, error) {
+	functionErrMsg := errors.New("error listing weather cache")
+
+	filePaths, err := weatherCacheFilePaths()
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	entries := make([]WeatherCacheEntry, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		entry, err := loadWeatherCacheEntry(filePath)
+		if err != nil {
+			return nil, errors.Join(functionErrMsg, err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, nil
+}
+
+/*
+Finds the weather cache file with the given name. If no name matches exactly,
+a name containing it is used, as long as only one does.
+*/
+func FindWeatherCacheEntry(name string) (*WeatherCacheEntry, error) {
+	entries, err := ListWeatherCache()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []WeatherCacheEntry
+	for _, entry := range entries {
+		if entry.Name == name {
+			return &entry, nil
+		}
+		if strings.Contains(strings.ToLower(entry.Name), strings.ToLower(name)) {
+			matches = append(matches, entry)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, errors.New("no weather cache named \"" + name + "\"")
+	}
+	if len(matches) > 1 {
+		return nil, errors.New("more than one weather cache matches \"" + name + "\"")
+	}
+
+	return &matches[0], nil
+}
+
+/*
+Removes expired samples from the weather cache, and deletes files that end up empty.
+Returns the number of samples and files removed.
+*/
+func ClearWeatherCache(options WeatherCacheClearOptions) (int, int, error) {
+	functionErrMsg := errors.New("error clearing weather cache")
+
+	filePaths, err := weatherCacheFilePaths()
+	if err != nil {
+		return 0, 0, errors.Join(functionErrMsg, err)
+	}
+
+	// Whatever is in memory may now be out of date
+	weatherCache = make(map[string][]weatherCacheSection)
+
+	expiredBefore := time.Now().Add(-(&WeatherDataOptions{RefreshTimeSeconds: options.MaxAgeSeconds}).refreshDuration())
+
+	removedSamples, removedFiles := 0, 0
+	for _, filePath := range filePaths {
+		name := weatherCacheName(filePath)
+		if !strings.Contains(strings.ToLower(name), strings.ToLower(options.Name)) {
+			continue
+		}
+
+		cacheSections, err := readWeatherCacheFile(filePath)
+		if err != nil {
+			return removedSamples, removedFiles, errors.Join(functionErrMsg, err)
+		}
+
+		remainingSamples := 0
+		for i := range cacheSections {
+			kept := make([]weatherCacheSample, 0, len(cacheSections[i].Samples))
+			for _, sample := range cacheSections[i].Samples {
+				if !options.All && sample.CollectedAt.After(expiredBefore) {
+					kept = append(kept, sample)
+				}
+			}
+
+			removedSamples += len(cacheSections[i].Samples) - len(kept)
+			remainingSamples += len(kept)
+			cacheSections[i].Samples = kept
+		}
+
+		if remainingSamples == 0 {
+			err = os.Remove(filePath)
+			removedFiles++
+		} else {
+			err = writeWeatherCacheFile(filePath, cacheSections)
+		}
+		if err != nil {
+			return removedSamples, removedFiles, errors.Join(functionErrMsg, err)
+		}
+	}
+
+	return removedSamples, removedFiles, nil
+}
+
+/*
+Bundles the whole weather cache into a zip file, so it can be copied to another
+computer with ImportWeatherCache(). Returns the number of files bundled.
+*/
+func ExportWeatherCache(filePath string) (int, error) {
+	functionErrMsg := errors.New("error exporting weather cache")
+
+	cacheFilePaths, err := weatherCacheFilePaths()
+	if err != nil {
+		return 0, errors.Join(functionErrMsg, err)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return 0, errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for _, cacheFilePath := range cacheFilePaths {
+		err = addFileToZip(archive, cacheFilePath)
+		if err != nil {
+			return 0, errors.Join(functionErrMsg, err)
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return 0, errors.Join(functionErrMsg, err)
+	}
+
+	return len(cacheFilePaths), nil
+}
+
+/*
+Adds the weather in a zip file made by ExportWeatherCache() to the weather cache.
+Weather already in the cache is kept, unless the zip file has newer data for the same time.
+Returns the number of files imported.
+*/
+func ImportWeatherCache(filePath string) (int, error) {
+	functionErrMsg := errors.New("error importing weather cache")
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return 0, errors.Join(functionErrMsg, err)
+	}
+	defer archive.Close()
+
+	outputFolder := getWeatherCacheOutputFolder()
+	err = os.MkdirAll(outputFolder, os.ModePerm)
+	if err != nil {
+		return 0, errors.Join(functionErrMsg, err)
+	}
+
+	// Whatever is in memory may now be out of date
+	weatherCache = make(map[string][]weatherCacheSection)
+
+	imported := 0
+	for _, archiveFile := range archive.File {
+		// Only use the file name, so a zip file can't write outside of the cache folder
+		fileName := filepath.Base(archiveFile.Name)
+		if archiveFile.FileInfo().IsDir() || filepath.Ext(fileName) != weatherCacheFileExtension {
+			continue
+		}
+
+		importedSections, err := readWeatherCacheFromZip(archiveFile)
+		if err != nil {
+			return imported, errors.Join(functionErrMsg, errors.New("invalid file \""+archiveFile.Name+"\""), err)
+		}
+
+		cacheFilePath := filepath.Join(outputFolder, fileName)
+		existingSections, err := readWeatherCacheFile(cacheFilePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return imported, errors.Join(functionErrMsg, err)
+		}
+
+		err = writeWeatherCacheFile(cacheFilePath, mergeWeatherCacheSections(existingSections, importedSections))
+		if err != nil {
+			return imported, errors.Join(functionErrMsg, err)
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+func weatherCacheFilePaths() ([]string, error) {
+	dirEntries, err := os.ReadDir(getWeatherCacheOutputFolder())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	filePaths := make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() && filepath.Ext(dirEntry.Name()) == weatherCacheFileExtension {
+			filePaths = append(filePaths, filepath.Join(getWeatherCacheOutputFolder(), dirEntry.Name()))
+		}
+	}
+
+	sort.Strings(filePaths)
+	return filePaths, nil
+}
+
+func weatherCacheName(filePath string) string {
+	return strings.TrimSuffix(filepath.Base(filePath), weatherCacheFileExtension)
+}
+
+func loadWeatherCacheEntry(filePath string) (*WeatherCacheEntry, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSections, err := readWeatherCacheFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := WeatherCacheEntry{
+		Name:       weatherCacheName(filePath),
+		FilePath:   filePath,
+		SizeBytes:  fileInfo.Size(),
+		Chunks:     make([]WeatherCacheChunk, len(cacheSections)),
+		ChunkCount: len(cacheSections),
+	}
+
+	for i, cacheSection := range cacheSections {
+		chunk := WeatherCacheChunk{
+			StartSectionIndex: cacheSection.StartSectionIndex,
+			EndSectionIndex:   cacheSection.EndSectionIndex,
+			LengthFt:          cacheSection.CombinedLengthFt,
+			SampleCount:       len(cacheSection.Samples),
+		}
+
+		for _, sample := range cacheSection.Samples {
+			if chunk.FirstValidAt.IsZero() || sample.ValidAt.Before(chunk.FirstValidAt) {
+				chunk.FirstValidAt = sample.ValidAt
+			}
+			if sample.ValidAt.After(chunk.LastValidAt) {
+				chunk.LastValidAt = sample.ValidAt
+			}
+			if sample.CollectedAt.After(chunk.LastCollectedAt) {
+				chunk.LastCollectedAt = sample.CollectedAt
+			}
+		}
+
+		if chunk.SampleCount > 0 {
+			entry.CoveredChunkCount++
+			if entry.FirstValidAt.IsZero() || chunk.FirstValidAt.Before(entry.FirstValidAt) {
+				entry.FirstValidAt = chunk.FirstValidAt
+			}
+			if chunk.LastValidAt.After(entry.LastValidAt) {
+				entry.LastValidAt = chunk.LastValidAt
+			}
+			if chunk.LastCollectedAt.After(entry.LastCollectedAt) {
+				entry.LastCollectedAt = chunk.LastCollectedAt
+			}
+		}
+
+		entry.SampleCount += chunk.SampleCount
+		entry.Chunks[i] = chunk
+	}
+
+	return &entry, nil
+}
+
+func readWeatherCacheFile(filePath string) ([]weatherCacheSection, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cacheSections []weatherCacheSection
+	err = json.NewDecoder(file).Decode(&cacheSections)
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid weather cache file \""+filePath+"\""), err)
+	}
+
+	return cacheSections, nil
+}
+
+func writeWeatherCacheFile(filePath string, cacheSections []weatherCacheSection) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(cacheSections)
+}
+
+func readWeatherCacheFromZip(archiveFile *zip.File) ([]weatherCacheSection, error) {
+	reader, err := archiveFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var cacheSections []weatherCacheSection
+	err = json.NewDecoder(reader).Decode(&cacheSections)
+	if err != nil {
+		return nil, err
+	}
+
+	return cacheSections, nil
+}
+
+func addFileToZip(archive *zip.Writer, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := archive.Create(filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+/*
+Combines two caches of the same route. When both have a sample at the same time,
+the one collected most recently is kept. If the route was split into chunks differently
+(e.g. the route file changed), the cache with the newest data is used as is.
+*/
+func mergeWeatherCacheSections(existing []weatherCacheSection, imported []weatherCacheSection) []weatherCacheSection {
+	if len(existing) == 0 {
+		return imported
+	}
+
+	sameChunks := len(existing) == len(imported)
+	for i := 0; sameChunks && i < len(existing); i++ {
+		sameChunks = existing[i].StartSectionIndex == imported[i].StartSectionIndex &&
+			existing[i].EndSectionIndex == imported[i].EndSectionIndex
+	}
+	if !sameChunks {
+		if lastCollectedAt(imported).After(lastCollectedAt(existing)) {
+			return imported
+		}
+		return existing
+	}
+
+	merged := make([]weatherCacheSection, len(existing))
+	for i := range existing {
+		samplesByTime := make(map[int64]weatherCacheSample)
+		for _, sample := range append(existing[i].Samples, imported[i].Samples...) {
+			key := sample.ValidAt.Unix()
+			if current, exists := samplesByTime[key]; !exists || sample.CollectedAt.After(current.CollectedAt) {
+				samplesByTime[key] = sample
+			}
+		}
+
+		merged[i] = existing[i]
+		merged[i].Samples = make([]weatherCacheSample, 0, len(samplesByTime))
+		for _, sample := range samplesByTime {
+			merged[i].Samples = append(merged[i].Samples, sample)
+		}
+		sort.Slice(merged[i].Samples, func(a, b int) bool {
+			return merged[i].Samples[a].ValidAt.Before(merged[i].Samples[b].ValidAt)
+		})
+	}
+
+	return merged
+}
+
+func lastCollectedAt(cacheSections []weatherCacheSection) time.Time {
+	last := time.Time{}
+	for _, cacheSection := range cacheSections {
+		for _, sample := range cacheSection.Samples {
+			if sample.CollectedAt.After(last) {
+				last = sample.CollectedAt
+			}
+		}
+	}
+	return last
+}
@@ -0,0 +1,455 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Reads measured weather from CSV files, so past race days can be simulated with the
+weather that actually happened. Two formats are supported:
+
+  - NSRDB (https://nsrdb.nrel.gov) PSM CSV downloads: two lines of location metadata,
+    then one row per time with Year, Month, Day, Hour, Minute, GHI, DNI, DHI,
+    Temperature, Dew Point, Pressure, Wind Speed, Wind Direction and Cloud Type.
+  - ERA5 (https://cds.climate.copernicus.eu) time series converted to CSV: one row per
+    time and location with valid_time, latitude, longitude and any of t2m, d2m, sp,
+    u10, v10, i10fg, tcc, tp, ssrd and fdir, in ERA5's units.
+
+The stations are added to a weather file that FileWeatherProvider can read.
+*/
+
+// Stations closer together than this are treated as the same place when importing
+const weatherStationMergeDistanceMi float64 = 1
+
+type WeatherCsvFormat string
+
+const (
+	WeatherCsvNsrdb WeatherCsvFormat = "NSRDB"
+	WeatherCsvEra5  WeatherCsvFormat = "ERA5"
+)
+
+// Reads the stations in an NSRDB or ERA5 CSV file. The array is used to work out the irradiance on the solar array.
+func ReadWeatherCsv(csvFilePath string, array types.SolarArray) ([]WeatherStation, WeatherCsvFormat, error) {
+	functionErrMsg := errors.New("error reading weather CSV \"" + csvFilePath + "\"")
+
+	file, err := os.Open(csvFilePath)
+	if err != nil {
+		return nil, "", errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, "", errors.Join(functionErrMsg, err)
+	}
+	if len(rows) < 2 {
+		return nil, "", errors.Join(functionErrMsg, errors.New("file has no data rows"))
+	}
+
+	var format WeatherCsvFormat
+	var stations []WeatherStation
+	if columnIndex(rows[0], "Location ID") >= 0 {
+		format = WeatherCsvNsrdb
+		stations, err = readNsrdbRows(rows, array)
+	} else {
+		format = WeatherCsvEra5
+		stations, err = readEra5Rows(rows, array)
+	}
+	if err != nil {
+		return nil, format, errors.Join(functionErrMsg, err)
+	}
+
+	return stations, format, nil
+}
+
+/*
+Adds the stations to the weather file, creating it if it doesn't exist.
+Samples for a station that is already in the file replace any at the same time.
+*/
+func AddToWeatherFile(weatherFilePath string, stations []WeatherStation) error {
+	functionErrMsg := errors.New("error saving weather file")
+
+	var weatherFile WeatherFile
+	file, err := os.Open(weatherFilePath)
+	if err == nil {
+		err = json.NewDecoder(file).Decode(&weatherFile)
+		file.Close()
+		if err != nil {
+			return errors.Join(functionErrMsg, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	for _, station := range stations {
+		existing := -1
+		for i := range weatherFile.Stations {
+			if ApproximateDistanceMi(station.Coordinates, weatherFile.Stations[i].Coordinates) < weatherStationMergeDistanceMi {
+				existing = i
+				break
+			}
+		}
+
+		if existing < 0 {
+			weatherFile.Stations = append(weatherFile.Stations, station)
+			continue
+		}
+		weatherFile.Stations[existing].Samples = mergeWeatherSamples(weatherFile.Stations[existing].Samples, station.Samples)
+	}
+
+	file, err = os.Create(weatherFilePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(weatherFile)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Combines two lists of samples, oldest first. Samples in added win when both have the same time.
+func mergeWeatherSamples(existing []WeatherSample, added []WeatherSample) []WeatherSample {
+	byTime := make(map[int64]WeatherSample, len(existing)+len(added))
+	for _, sample := range existing {
+		byTime[sample.ValidAt.Unix()] = sample
+	}
+	for _, sample := range added {
+		byTime[sample.ValidAt.Unix()] = sample
+	}
+
+	merged := make([]WeatherSample, 0, len(byTime))
+	for _, sample := range byTime {
+		merged = append(merged, sample)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].ValidAt.Before(merged[j].ValidAt)
+	})
+
+	return merged
+}
+
+/*
+NSRDB times are in the local standard time given by the "Time Zone" metadata (unless
+UTC was requested, which sets it to 0). Values are instantaneous, not averages.
+*/
+func readNsrdbRows(rows [][]string, array types.SolarArray) ([]WeatherStation, error) {
+	if len(rows) < 4 {
+		return nil, errors.New("NSRDB file has no data rows")
+	}
+	metadataNames, metadata := rows[0], rows[1]
+
+	metadataValue := func(name string) (float64, error) {
+		i := columnIndex(metadataNames, name)
+		if i < 0 || i >= len(metadata) {
+			return 0, errors.New("NSRDB file has no " + name)
+		}
+		return strconv.ParseFloat(metadata[i], 64)
+	}
+
+	latitude, err := metadataValue("Latitude")
+	if err != nil {
+		return nil, err
+	}
+	longitude, err := metadataValue("Longitude")
+	if err != nil {
+		return nil, err
+	}
+	timeZoneHours, err := metadataValue("Time Zone")
+	if err != nil {
+		return nil, err
+	}
+	location := time.FixedZone("NSRDB", int(timeZoneHours*3600))
+	coordinates := types.Coordinates{Latitude: latitude, Longitude: longitude}
+
+	name := "NSRDB"
+	if i := columnIndex(metadataNames, "Location ID"); i >= 0 && i < len(metadata) {
+		name += " " + metadata[i]
+	}
+
+	columns, err := newCsvColumns(rows[2], "Year", "Month", "Day", "Hour", "Minute")
+	if err != nil {
+		return nil, err
+	}
+
+	weather := make([]metricWeather, 0, len(rows)-3)
+	for rowNumber, row := range rows[3:] {
+		validAt := time.Date(
+			int(columns.value(row, "Year", 0)),
+			time.Month(columns.value(row, "Month", 1)),
+			int(columns.value(row, "Day", 1)),
+			int(columns.value(row, "Hour", 0)),
+			int(columns.value(row, "Minute", 0)),
+			0, 0, location,
+		)
+		if columns.err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid NSRDB row %d", rowNumber+4), columns.err)
+		}
+
+		zenith, azimuth := solarPositionDegrees(coordinates, validAt)
+		ghi := columns.value(row, "GHI", 0)
+		dni := columns.value(row, "DNI", 0)
+		dhi := columns.value(row, "DHI", 0)
+
+		weather = append(weather, metricWeather{
+			validAt:              validAt.UTC(),
+			zenithDegrees:        zenith,
+			solarAzimuthDegrees:  azimuth,
+			ghiWm2:               ghi,
+			dniWm2:               dni,
+			dhiWm2:               dhi,
+			gtiWm2:               tiltedIrradianceWm2(ghi, dni, dhi, zenith, azimuth, array),
+			airTempC:             columns.value(row, "Temperature", 20),
+			dewPointC:            columns.value(row, "Dew Point", 10),
+			cloudCoverPercentage: nsrdbCloudCoverPercentage(int(columns.value(row, "Cloud Type", 0))),
+			windSpeedMpS:         columns.value(row, "Wind Speed", 0),
+			windDirectionDegrees: columns.value(row, "Wind Direction", 0),
+			// NSRDB doesn't measure rain
+			precipitationMmph:  0,
+			surfacePressurehPa: columns.value(row, "Pressure", 1013.25),
+		})
+		if columns.err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid NSRDB row %d", rowNumber+4), columns.err)
+		}
+	}
+
+	return []WeatherStation{{
+		Name:        name,
+		Coordinates: coordinates,
+		Samples:     metricWeatherToSamples(weather, samplePeriodMinutes(weather)),
+	}}, nil
+}
+
+/*
+NSRDB gives the type of cloud instead of how much of the sky is covered,
+so this is a rough guess at how much sun each type blocks.
+*/
+func nsrdbCloudCoverPercentage(cloudType int) float64 {
+	switch cloudType {
+	case 0: // Clear
+		return 0
+	case 1: // Probably clear
+		return 10
+	case 7: // Cirrus
+		return 40
+	case 10, 11, 12: // Unknown, dust and smoke
+		return 30
+	case 2, 3, 4, 5, 6, 8, 9: // Fog, water, super-cooled water, mixed, opaque ice, overlapping and overshooting
+		return 90
+	}
+
+	// Missing data
+	return 0
+}
+
+/*
+ERA5 times are in UTC. Radiation (ssrd and fdir, in J/m^2) and rain (tp, in m) are
+totals over the hour before each time, everything else is instantaneous.
+Temperatures are in K, pressure in Pa and cloud cover from 0 to 1.
+*/
+func readEra5Rows(rows [][]string, array types.SolarArray) ([]WeatherStation, error) {
+	timeColumn := "valid_time"
+	if columnIndex(rows[0], timeColumn) < 0 {
+		timeColumn = "time"
+	}
+
+	columns, err := newCsvColumns(rows[0], timeColumn, "latitude", "longitude", "t2m")
+	if err != nil {
+		return nil, errors.Join(errors.New("not an NSRDB or ERA5 CSV file"), err)
+	}
+
+	type era5Row struct {
+		weather            metricWeather
+		totalRadiationJm2  float64
+		directRadiationJm2 float64
+	}
+
+	// ERA5 files can have many grid points, which each become a station
+	rowsByStation := make(map[types.Coordinates][]era5Row)
+	stationOrder := make([]types.Coordinates, 0)
+
+	for rowNumber, row := range rows[1:] {
+		if len(row) == 0 || (len(row) == 1 && row[0] == "") {
+			continue
+		}
+
+		validAt, err := parseEra5Time(columns.text(row, timeColumn))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid ERA5 row %d", rowNumber+2), err)
+		}
+
+		coordinates := types.Coordinates{
+			Latitude:  columns.value(row, "latitude", 0),
+			Longitude: columns.value(row, "longitude", 0),
+		}
+		if _, exists := rowsByStation[coordinates]; !exists {
+			stationOrder = append(stationOrder, coordinates)
+		}
+
+		u10 := columns.value(row, "u10", 0)
+		v10 := columns.value(row, "v10", 0)
+
+		rowsByStation[coordinates] = append(rowsByStation[coordinates], era5Row{
+			weather: metricWeather{
+				validAt:              validAt,
+				airTempC:             columns.value(row, "t2m", 293.15) - 273.15,
+				dewPointC:            columns.value(row, "d2m", 283.15) - 273.15,
+				cloudCoverPercentage: columns.value(row, "tcc", 0) * 100,
+				windSpeedMpS:         math.Hypot(u10, v10),
+				// u and v are the direction the wind blows towards, and we want where it comes from
+				windDirectionDegrees: math.Mod(math.Atan2(-u10, -v10)*180/math.Pi+360, 360),
+				precipitationMmph:    columns.value(row, "tp", 0) * 1000,
+				surfacePressurehPa:   columns.value(row, "sp", 101325) / 100,
+				windGustMpS:          columns.value(row, "i10fg", 0),
+			},
+			totalRadiationJm2:  columns.value(row, "ssrd", 0),
+			directRadiationJm2: columns.value(row, "fdir", 0),
+		})
+		if columns.err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid ERA5 row %d", rowNumber+2), columns.err)
+		}
+	}
+
+	stations := make([]WeatherStation, 0, len(stationOrder))
+	for _, coordinates := range stationOrder {
+		stationRows := rowsByStation[coordinates]
+		sort.Slice(stationRows, func(i, j int) bool {
+			return stationRows[i].weather.validAt.Before(stationRows[j].weather.validAt)
+		})
+
+		totalRadiation := make([]float64, len(stationRows))
+		directRadiation := make([]float64, len(stationRows))
+		for i, row := range stationRows {
+			totalRadiation[i] = row.totalRadiationJm2 / 3600
+			directRadiation[i] = row.directRadiationJm2 / 3600
+		}
+
+		weather := make([]metricWeather, len(stationRows))
+		for i, row := range stationRows {
+			weather[i] = row.weather
+			zenith, azimuth := solarPositionDegrees(coordinates, weather[i].validAt)
+
+			// Hourly totals, so the value at a time is the average of the hours on either side of it
+			ghi := radiationAt(totalRadiation, i)
+			directHorizontal := radiationAt(directRadiation, i)
+			dni := 0.0
+			if cosZenith := math.Cos(zenith * math.Pi / 180); cosZenith > 0.05 {
+				dni = directHorizontal / cosZenith
+			}
+			dhi := math.Max(0, ghi-directHorizontal)
+
+			weather[i].zenithDegrees = zenith
+			weather[i].solarAzimuthDegrees = azimuth
+			weather[i].ghiWm2 = ghi
+			weather[i].dniWm2 = dni
+			weather[i].dhiWm2 = dhi
+			weather[i].gtiWm2 = tiltedIrradianceWm2(ghi, dni, dhi, zenith, azimuth, array)
+		}
+
+		stations = append(stations, WeatherStation{
+			Name:        fmt.Sprintf("ERA5 %.2f,%.2f", coordinates.Latitude, coordinates.Longitude),
+			Coordinates: coordinates,
+			Samples:     metricWeatherToSamples(weather, samplePeriodMinutes(weather)),
+		})
+	}
+
+	return stations, nil
+}
+
+// ERA5 CSV conversions write times in a few different ways
+func parseEra5Time(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		parsed, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time '" + value + "'")
+}
+
+// Time between the first two samples, or an hour if there is only one. Sorts the weather oldest first.
+func samplePeriodMinutes(weather []metricWeather) float64 {
+	sort.Slice(weather, func(i, j int) bool {
+		return weather[i].validAt.Before(weather[j].validAt)
+	})
+
+	if len(weather) < 2 || !weather[1].validAt.After(weather[0].validAt) {
+		return 60
+	}
+	return weather[1].validAt.Sub(weather[0].validAt).Minutes()
+}
+
+// Looks up CSV values by column name. The first error is kept in err.
+type csvColumns struct {
+	indices map[string]int
+	err     error
+}
+
+// Fails if any of the required columns are missing
+func newCsvColumns(header []string, required ...string) (*csvColumns, error) {
+	columns := csvColumns{indices: make(map[string]int)}
+	for i, name := range header {
+		columns.indices[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range required {
+		if _, exists := columns.indices[strings.ToLower(name)]; !exists {
+			return nil, errors.New("missing column \"" + name + "\"")
+		}
+	}
+
+	return &columns, nil
+}
+
+func (columns *csvColumns) text(row []string, name string) string {
+	i, exists := columns.indices[strings.ToLower(name)]
+	if !exists || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// Returns defaultValue if the column is missing or empty
+func (columns *csvColumns) value(row []string, name string, defaultValue float64) float64 {
+	text := columns.text(row, name)
+	if text == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		if columns.err == nil {
+			columns.err = errors.New("invalid " + name + " '" + text + "'")
+		}
+		return defaultValue
+	}
+	return value
+}
+
+// Index of the column with the name, ignoring case, or -1
+func columnIndex(header []string, name string) int {
+	for i, column := range header {
+		if strings.EqualFold(strings.TrimSpace(column), name) {
+			return i
+		}
+	}
+	return -1
+}
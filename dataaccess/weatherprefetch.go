@@ -0,0 +1,146 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"errors"
+	"time"
+)
+
+// One route to fetch weather for, and when the car will be on it
+type WeatherPrefetchRequest struct {
+	Route *types.Route
+	Start time.Time
+	End   time.Time
+}
+
+/*
+This struct exists so we can add inputs to PrefetchWeather() without having to
+change the code everywhere PrefetchWeather() is used.
+*/
+type WeatherPrefetchOptions struct {
+	// Most weather API calls to make. 0 means no limit.
+	MaxApiCalls int
+	// Which provider to use, and how long cached weather is good for
+	WeatherOptions WeatherDataOptions
+}
+
+type WeatherPrefetchResult struct {
+	ApiCalls int
+	// Chunks of about 2 miles that weather was fetched for
+	FetchedChunks int
+	// Chunks that already had recent enough weather for the whole window
+	CachedChunks int
+	// Requests that weren't finished because the API call budget ran out, starting with
+	// the one it ran out on. Empty if everything was fetched.
+	Unfinished []WeatherPrefetchRequest
+	// Distance along the first unfinished route where fetching stopped
+	StoppedAtFt float64
+}
+
+/*
+Fills the weather cache for every chunk of every route, over the time the car will
+be on it, so simulations don't have to call the weather API.
+Stops at the first chunk that would go over options.MaxApiCalls, and returns the
+requests that are left in the result. Running it again with the same requests picks up
+where it stopped, since chunks that were fetched are already cached.
+*/
+func PrefetchWeather(requests []WeatherPrefetchRequest, options WeatherPrefetchOptions) (*WeatherPrefetchResult, error) {
+	functionErrMsg := errors.New("error prefetching weather")
+
+	provider, err := options.WeatherOptions.getProvider()
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, err)
+	}
+
+	// Prefetching is pointless without the cache
+	weatherOptions := options.WeatherOptions
+	weatherOptions.UsingWeatherCache = true
+	refreshDuration := weatherOptions.refreshDuration()
+
+	result := WeatherPrefetchResult{}
+
+	for requestIndex, request := range requests {
+		if request.Route == nil || len(request.Route.Sections) == 0 {
+			continue
+		}
+
+		firstSection := &request.Route.Sections[0]
+		key := createCacheKey(firstSection, provider)
+
+		_, err := getWeatherCacheSection(firstSection, provider, &weatherOptions)
+		if err != nil {
+			return &result, errors.Join(functionErrMsg, err)
+		}
+		if _, exists := weatherCache[key]; !exists {
+			weatherCache[key] = createWeatherCacheSectionsForRoute(request.Route)
+		}
+		cacheSections := weatherCache[key]
+
+		fetchStart := request.Start.Add(-weatherFetchBefore)
+
+		for i := range cacheSections {
+			cacheSection := &cacheSections[i]
+
+			if cacheSection.covers(request.Start, request.End, refreshDuration) {
+				result.CachedChunks++
+				continue
+			}
+			calls := weatherFetchCallCount(fetchStart, request.End)
+			if options.MaxApiCalls > 0 && result.ApiCalls+calls > options.MaxApiCalls {
+				result.Unfinished = requests[requestIndex:]
+				result.StoppedAtFt = routeDistanceFt(request.Route, cacheSection.StartSectionIndex)
+
+				err = saveWeatherCacheToJson(key, cacheSections)
+				if err != nil {
+					return &result, errors.Join(functionErrMsg, err)
+				}
+				return &result, nil
+			}
+
+			coordinates := request.Route.Sections[cacheSection.StartSectionIndex].CoordinatesInitial
+			samples, calls, err := fetchWeatherSamples(weatherOptions.context(), provider, coordinates, fetchStart, request.End)
+			result.ApiCalls += calls
+			if err != nil {
+				// Keep whatever was fetched before the error
+				_ = saveWeatherCacheToJson(key, cacheSections)
+				return &result, errors.Join(functionErrMsg, err)
+			}
+
+			cacheSection.addSamples(samples, refreshDuration)
+			result.FetchedChunks++
+		}
+
+		err = saveWeatherCacheToJson(key, cacheSections)
+		if err != nil {
+			return &result, errors.Join(functionErrMsg, err)
+		}
+	}
+
+	return &result, nil
+}
+
+// Distance from the start of the route to the start of the section
+func routeDistanceFt(route *types.Route, sectionIndex int) float64 {
+	distanceFt := 0.0
+	for i := 0; i < sectionIndex && i < len(route.Sections); i++ {
+		distanceFt += route.Sections[i].LengthFt
+	}
+	return distanceFt
+}
+
+// True if the chunk has recent enough weather for every point from start to end
+func (cacheSection *weatherCacheSection) covers(start time.Time, end time.Time, refreshDuration time.Duration) bool {
+	samples := cacheSection.freshSamples(refreshDuration)
+
+	for at := start; ; at = at.Add(weatherSampleTolerance) {
+		if at.After(end) {
+			at = end
+		}
+		if interpolateWeather(samples, at) == nil {
+			return false
+		}
+		if !at.Before(end) {
+			return true
+		}
+	}
+}
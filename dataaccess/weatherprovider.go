@@ -0,0 +1,177 @@
+package dataaccess
+
+import (
+	"asc-simulation/types"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Weather at one point in time
+type WeatherSample struct {
+	ValidAt time.Time
+	Weather types.Weather
+}
+
+/*
+A source of weather data, such as Solcast.
+GetWeather() and GetWeatherAtTime() go through the weather cache first and only
+ask the provider when the cache misses, so providers don't need to do any caching.
+*/
+type WeatherProvider interface {
+	// Short unique name, e.g. "solcast". Used to keep each provider's cached data apart.
+	Name() string
+	// Current conditions at the coordinates
+	GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*WeatherSample, error)
+	// Forecasted conditions from start to end, oldest first
+	GetForecastWeather(ctx context.Context, coordinates types.Coordinates, start time.Time, end time.Time) ([]WeatherSample, error)
+	// Measured conditions from start to end, oldest first
+	GetHistoricalWeather(ctx context.Context, coordinates types.Coordinates, start time.Time, end time.Time) ([]WeatherSample, error)
+}
+
+var weatherProvider WeatherProvider = nil
+
+/*
+Returns the weather provider chosen by the WEATHER_PROVIDER environment variable:
+"solcast" (the default), "open-meteo", "file" (reads WEATHER_FILE) or "synthetic".
+*/
+func GetWeatherProvider() (WeatherProvider, error) {
+	if weatherProvider != nil {
+		return weatherProvider, nil
+	}
+
+	provider, err := NewWeatherProvider(os.Getenv("WEATHER_PROVIDER"))
+	if err != nil {
+		return nil, err
+	}
+
+	weatherProvider = provider
+	return weatherProvider, nil
+}
+
+// Replaces the weather provider used when WeatherDataOptions.Provider is not set.
+func SetWeatherProvider(provider WeatherProvider) {
+	weatherProvider = provider
+}
+
+// Creates a weather provider by name. See GetWeatherProvider() for the names.
+func NewWeatherProvider(name string) (WeatherProvider, error) {
+	array, err := GetSolarArray()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "", "solcast":
+		return SolcastWeatherProvider{Array: array}, nil
+	case "open-meteo":
+		return OpenMeteoWeatherProvider{Array: array}, nil
+	case "file":
+		weatherFilePath := os.Getenv("WEATHER_FILE")
+		if weatherFilePath == "" {
+			return nil, errors.New("WEATHER_FILE must be set to use the file weather provider")
+		}
+		return NewFileWeatherProvider(weatherFilePath)
+	case "synthetic":
+		provider := DefaultSyntheticWeatherProvider()
+		provider.Array = array
+		return provider, nil
+	}
+
+	return nil, errors.New("unknown weather provider \"" + name + "\"")
+}
+
+/*
+Weather in the units most weather APIs use, before converting it to types.Weather.
+Only used by weather providers.
+*/
+type metricWeather struct {
+	validAt              time.Time
+	zenithDegrees        float64
+	solarAzimuthDegrees  float64
+	ghiWm2               float64
+	dniWm2               float64
+	dhiWm2               float64
+	gtiWm2               float64
+	airTempC             float64
+	dewPointC            float64
+	cloudCoverPercentage float64
+	windSpeedMpS         float64
+	windDirectionDegrees float64
+	precipitationMmph    float64
+	surfacePressurehPa   float64
+	// 0 if unknown
+	windGustMpS float64
+	// 0 if unknown
+	visibilityM float64
+}
+
+/*
+Converts metric weather to samples, sorted oldest first.
+Rain on the ground builds up over the samples, so it is only accurate for samples after
+the first few hours - request some extra time before the time you care about.
+*/
+func metricWeatherToSamples(weather []metricWeather, periodMinutes float64) []WeatherSample {
+	sort.Slice(weather, func(i, j int) bool {
+		return weather[i].validAt.Before(weather[j].validAt)
+	})
+
+	samples := make([]WeatherSample, len(weather))
+	accumulatedRain := 0.0
+	minuteRatio := periodMinutes / 60.0
+
+	for i, data := range weather {
+		accumulatedRain += (data.precipitationMmph / 1000) * mToFt * ftToIn * minuteRatio
+		accumulatedRain -= rainEvaporationRateInchesPerHour(evaporationRateInput{
+			windSpeedMpS:   data.windSpeedMpS,
+			airPressurehPa: data.surfacePressurehPa,
+			airTempC:       data.airTempC,
+			dewPointC:      data.dewPointC,
+		}) * minuteRatio
+		accumulatedRain = math.Max(0, accumulatedRain)
+
+		samples[i] = WeatherSample{
+			ValidAt: data.validAt,
+			Weather: types.Weather{
+				SolarZenithDegrees:   data.zenithDegrees,
+				AirTempDegreesF:      celsiusToFahrenheit(data.airTempC),
+				CloudCoverPercentage: data.cloudCoverPercentage,
+				WindSpeedMph:         msToMph(data.windSpeedMpS),
+				WindDirectionDegrees: data.windDirectionDegrees,
+				RainOnGroundInches:   accumulatedRain,
+				SurfacePressurePsi:   data.surfacePressurehPa * hPaToPsi,
+				SolarAzimuthDegrees:  data.solarAzimuthDegrees,
+				WindGustMph:          msToMph(data.windGustMpS),
+				VisibilityMi:         data.visibilityM * mToFt / miToFt,
+
+				GlobalHorizontalIrradianceWm2:  data.ghiWm2,
+				DirectNormalIrradianceWm2:      data.dniWm2,
+				DiffuseHorizontalIrradianceWm2: data.dhiWm2,
+				GlobalTiltedIrradianceWm2:      data.gtiWm2,
+			},
+		}
+	}
+
+	return samples
+}
+
+// Returns the samples from start to end, plus the samples on either side so the
+// whole window is covered.
+func samplesInWindow(samples []WeatherSample, start time.Time, end time.Time) []WeatherSample {
+	if len(samples) == 0 {
+		return samples
+	}
+
+	first, last := 0, len(samples)-1
+	for first < last && !samples[first+1].ValidAt.After(start) {
+		first++
+	}
+	for last > first && !samples[last-1].ValidAt.Before(end) {
+		last--
+	}
+
+	return samples[first : last+1]
+}
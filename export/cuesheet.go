@@ -0,0 +1,398 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asc-simulation/types"
+)
+
+// Enum of the supported cue sheet formats
+type CueSheetFormat string
+
+const (
+	CueSheetPDF      CueSheetFormat = "pdf"
+	CueSheetHTML     CueSheetFormat = "html"
+	CueSheetMarkdown CueSheetFormat = "markdown"
+)
+
+// A new target speed is only called out if it is held for at least this far
+const minSpeedCueFt float64 = 0.25 * 5280
+
+// Target speeds are rounded to this, as drivers can't hold a speed closer than that anyway
+const speedCueStepMph float64 = 5
+
+// Guesses the cue sheet format from a file extension, e.g. "stage-a.pdf"
+func CueSheetFormatFromFilePath(filePath string) (CueSheetFormat, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".pdf":
+		return CueSheetPDF, nil
+	case ".html", ".htm":
+		return CueSheetHTML, nil
+	case ".md", ".markdown":
+		return CueSheetMarkdown, nil
+	}
+
+	return "", errors.New("unknown cue sheet format for file \"" + filePath + "\"")
+}
+
+// A checkpoint the cue sheet is split at
+type CueCheckpoint struct {
+	Name string
+	// Distance from the start of the itinerary
+	DistanceFt float64
+	// Zero if it isn't known
+	CloseTime time.Time
+}
+
+// Enum of the kinds of lines on a cue sheet
+type CueKind string
+
+const (
+	CueStart  CueKind = "start"
+	CueTurn   CueKind = "turn"
+	CueSpeed  CueKind = "speed"
+	CueArrive CueKind = "arrive"
+)
+
+// One line of a cue sheet
+type Cue struct {
+	Kind CueKind
+	// Distance from the start of the itinerary
+	DistanceFt  float64
+	Instruction string
+	// Speed to aim for after the cue. 0 without a speed plan.
+	TargetSpeedMph int
+	// When the cue is expected to be reached, and the battery % then. Zero without a speed plan.
+	Time           time.Time
+	BatteryPercent float64
+}
+
+// The cues from one checkpoint (or the start of a route) to the next
+type CueLeg struct {
+	Title string
+	// Day of the event, starting at 0
+	Day int
+	// When the checkpoint at the end of the leg closes. Zero if it isn't known.
+	CloseTime time.Time
+	Cues      []Cue
+}
+
+type CueSheet struct {
+	Title string
+	// False if there is only a route, so no speeds, times or battery %
+	HasPlan bool
+	Legs    []CueLeg
+}
+
+/*
+Lists every turn of the itinerary, with the target speed, expected time and battery % from
+the speed plan, split into legs at the start of every route and at every checkpoint.
+plan can be nil to only list the turns.
+*/
+func NewCueSheet(itinerary *types.Itinerary, plan *types.SimulatedRun, checkpoints []CueCheckpoint) *CueSheet {
+	planned := make(map[int]*types.SimulatedSection)
+	if plan != nil {
+		for i := range plan.Sections {
+			planned[plan.Sections[i].SectionIndex] = &plan.Sections[i]
+		}
+	}
+
+	sheet := CueSheet{Title: itinerary.Name, HasPlan: plan != nil}
+	if plan != nil && plan.Name != "" {
+		sheet.Title = plan.Name
+	}
+
+	sorted := make([]CueCheckpoint, len(checkpoints))
+	copy(sorted, checkpoints)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].DistanceFt < sorted[j].DistanceFt
+	})
+
+	for legIndex := range itinerary.Legs {
+		leg := &itinerary.Legs[legIndex]
+		route := leg.Route
+
+		routeName := legName(leg)
+		startName := route.Metadata.StartCheckpoint
+		if startName == "" {
+			startName = "start"
+		}
+		endName := route.Metadata.EndCheckpoint
+		if endName == "" {
+			endName = "finish"
+		}
+
+		// Where this route is split into legs
+		ends := make([]CueCheckpoint, 0)
+		for _, checkpoint := range sorted {
+			if checkpoint.DistanceFt > leg.StartDistanceFt && checkpoint.DistanceFt < leg.StartDistanceFt+leg.LengthFt {
+				ends = append(ends, checkpoint)
+			}
+		}
+		ends = append(ends, CueCheckpoint{Name: endName, DistanceFt: leg.StartDistanceFt + leg.LengthFt})
+		// A checkpoint given at the very end of the route keeps its close time
+		for _, checkpoint := range sorted {
+			if math.Abs(checkpoint.DistanceFt-(leg.StartDistanceFt+leg.LengthFt)) < 1 {
+				ends[len(ends)-1] = checkpoint
+			}
+		}
+
+		fromFt := leg.StartDistanceFt
+		fromName := startName
+		sectionIndex := leg.StartSectionIndex
+		for _, end := range ends {
+			cueLeg := CueLeg{
+				Title:     routeName,
+				Day:       leg.Day,
+				CloseTime: end.CloseTime,
+			}
+			if len(ends) > 1 {
+				cueLeg.Title += " - " + fromName + " to " + end.Name
+			}
+
+			// Sections that start inside this leg
+			lastSection := sectionIndex
+			for lastSection+1 <= leg.EndSectionIndex && itinerary.Sections[lastSection+1].DistanceFt < end.DistanceFt {
+				lastSection++
+			}
+
+			reachedAt, battery := plannedAt(itinerary, planned, fromFt)
+			cueLeg.Cues = append(cueLeg.Cues, Cue{
+				Kind:           CueStart,
+				DistanceFt:     fromFt,
+				Instruction:    "Leave " + fromName,
+				TargetSpeedMph: stretchSpeed(itinerary, planned, sectionIndex, leg.EndSectionIndex),
+				Time:           reachedAt,
+				BatteryPercent: battery,
+			})
+			currentSpeed := cueLeg.Cues[0].TargetSpeedMph
+
+			for j := sectionIndex; j < lastSection; j++ {
+				section := &itinerary.Sections[j]
+				cueFt := section.DistanceFt + section.LengthFt
+				nextSpeed := stretchSpeed(itinerary, planned, j+1, leg.EndSectionIndex)
+
+				cue := Cue{
+					DistanceFt:     cueFt,
+					TargetSpeedMph: nextSpeed,
+				}
+				if plannedSection := planned[j]; plannedSection != nil {
+					cue.Time = plannedSection.EndTime
+					cue.BatteryPercent = plannedSection.EndBatteryPercent
+				}
+
+				switch {
+				case isCueInstruction(section.InstructionCode):
+					cue.Kind = CueTurn
+					cue.Instruction = section.ExitInstruction
+				case nextSpeed != currentSpeed && holdsSpeed(itinerary, planned, j+1, leg.EndSectionIndex, nextSpeed):
+					cue.Kind = CueSpeed
+					cue.Instruction = "Speed " + strconv.Itoa(nextSpeed) + " mph"
+				default:
+					continue
+				}
+
+				cueLeg.Cues = append(cueLeg.Cues, cue)
+				currentSpeed = nextSpeed
+			}
+
+			reachedAt, battery = plannedAt(itinerary, planned, end.DistanceFt)
+			instruction := "Arrive at " + end.Name
+			if !end.CloseTime.IsZero() {
+				instruction += ", closes " + end.CloseTime.Format("15:04")
+			}
+			cueLeg.Cues = append(cueLeg.Cues, Cue{
+				Kind:           CueArrive,
+				DistanceFt:     end.DistanceFt,
+				Instruction:    instruction,
+				Time:           reachedAt,
+				BatteryPercent: battery,
+			})
+
+			sheet.Legs = append(sheet.Legs, cueLeg)
+			fromFt = end.DistanceFt
+			fromName = end.Name
+			sectionIndex = min(lastSection+1, leg.EndSectionIndex)
+			if itinerary.Sections[lastSection].DistanceFt+itinerary.Sections[lastSection].LengthFt > end.DistanceFt {
+				// The checkpoint is part way through a section, which the next leg starts on
+				sectionIndex = lastSection
+			}
+		}
+	}
+
+	return &sheet
+}
+
+/*
+Turns the driver has to be told about. "Head north on ..." instructions only mark
+where the directions were split up, so they are left out.
+*/
+func isCueInstruction(instruction types.RouteInstruction) bool {
+	return instruction != types.Depart && instruction != types.Goal
+}
+
+// Planned target speed of a section, rounded to speedCueStepMph. 0 if it wasn't planned.
+func plannedSpeed(planned map[int]*types.SimulatedSection, sectionIndex int) int {
+	section := planned[sectionIndex]
+	if section == nil {
+		return 0
+	}
+	speedMph := section.TargetSpeedMph
+	if speedMph == 0 {
+		// Plans saved before target speeds were kept only have the average
+		speedMph = section.AverageSpeedMph
+	}
+	return int(math.Round(speedMph/speedCueStepMph) * speedCueStepMph)
+}
+
+/*
+Speed to aim for from the start of a section: the fastest planned speed in the next
+minSpeedCueFt, so short slow bits at junctions don't set the speed for the whole stretch.
+*/
+func stretchSpeed(itinerary *types.Itinerary, planned map[int]*types.SimulatedSection, sectionIndex int, lastSectionIndex int) int {
+	speed := 0
+	startFt := itinerary.Sections[sectionIndex].DistanceFt
+	for j := sectionIndex; j <= lastSectionIndex && itinerary.Sections[j].DistanceFt-startFt < minSpeedCueFt; j++ {
+		speed = max(speed, plannedSpeed(planned, j))
+	}
+	return speed
+}
+
+// True if the plan keeps to speedMph for at least minSpeedCueFt from the start of a section
+func holdsSpeed(itinerary *types.Itinerary, planned map[int]*types.SimulatedSection, sectionIndex int, lastSectionIndex int, speedMph int) bool {
+	startFt := itinerary.Sections[sectionIndex].DistanceFt
+	for j := sectionIndex; j <= lastSectionIndex; j++ {
+		section := &itinerary.Sections[j]
+		if plannedSpeed(planned, j) != speedMph {
+			return section.DistanceFt-startFt >= minSpeedCueFt
+		}
+		if section.DistanceFt+section.LengthFt-startFt >= minSpeedCueFt {
+			return true
+		}
+	}
+	return false
+}
+
+// Expected time and battery % at a distance along the itinerary. Zero if that part wasn't planned.
+func plannedAt(itinerary *types.Itinerary, planned map[int]*types.SimulatedSection, distanceFt float64) (time.Time, float64) {
+	index := sort.Search(len(itinerary.Sections), func(j int) bool {
+		return itinerary.Sections[j].DistanceFt+itinerary.Sections[j].LengthFt >= distanceFt
+	})
+	if index >= len(itinerary.Sections) {
+		index = len(itinerary.Sections) - 1
+	}
+
+	section := planned[index]
+	if section == nil {
+		return time.Time{}, 0
+	}
+
+	fraction := 0.0
+	if itinerary.Sections[index].LengthFt > 0 {
+		fraction = (distanceFt - itinerary.Sections[index].DistanceFt) / itinerary.Sections[index].LengthFt
+		fraction = math.Min(1, math.Max(0, fraction))
+	}
+	elapsed := time.Duration(fraction * float64(section.EndTime.Sub(section.StartTime)))
+	battery := section.StartBatteryPercent + fraction*(section.EndBatteryPercent-section.StartBatteryPercent)
+
+	return section.StartTime.Add(elapsed), battery
+}
+
+/*
+Writes the cue sheet to a file, in the format matching the file's extension
+(.pdf, .html or .md).
+*/
+func ExportCueSheet(filePath string, sheet *CueSheet) error {
+	functionErrMsg := errors.New("error exporting cue sheet")
+
+	format, err := CueSheetFormatFromFilePath(filePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	err = WriteCueSheet(file, format, sheet)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Same as ExportCueSheet(), but writes to any writer in the given format.
+func WriteCueSheet(writer io.Writer, format CueSheetFormat, sheet *CueSheet) error {
+	switch format {
+	case CueSheetPDF:
+		return writeCueSheetPdf(writer, sheet)
+	case CueSheetHTML:
+		return writeCueSheetHtml(writer, sheet)
+	case CueSheetMarkdown:
+		return writeCueSheetMarkdown(writer, sheet)
+	}
+
+	return errors.New("unknown cue sheet format \"" + string(format) + "\"")
+}
+
+// Column headings, the same in every format
+func (sheet *CueSheet) columns() []string {
+	if !sheet.HasPlan {
+		return []string{"Mile", "Go", "Instruction"}
+	}
+	return []string{"Mile", "Go", "Instruction", "Speed", "Time", "Battery"}
+}
+
+// The cells of every cue in a leg, in the same order as columns()
+func (sheet *CueSheet) rows(leg *CueLeg) [][]string {
+	rows := make([][]string, 0, len(leg.Cues))
+	for i, cue := range leg.Cues {
+		// Distance from the previous cue
+		goMi := ""
+		if i > 0 {
+			goMi = strconv.FormatFloat((cue.DistanceFt-leg.Cues[i-1].DistanceFt)/5280, 'f', 2, 64)
+		}
+
+		row := []string{
+			strconv.FormatFloat(cue.DistanceFt/5280, 'f', 1, 64),
+			goMi,
+			cue.Instruction,
+		}
+
+		if sheet.HasPlan {
+			speed, clock, battery := "", "", ""
+			if cue.TargetSpeedMph > 0 {
+				speed = strconv.Itoa(cue.TargetSpeedMph) + " mph"
+			}
+			if !cue.Time.IsZero() {
+				clock = cue.Time.Format("15:04")
+				battery = strconv.FormatFloat(cue.BatteryPercent, 'f', 1, 64) + "%"
+			}
+			row = append(row, speed, clock, battery)
+		}
+
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Heading of a leg, with the day if the itinerary is more than one day
+func (sheet *CueSheet) legHeading(leg *CueLeg) string {
+	for _, other := range sheet.Legs {
+		if other.Day != leg.Day {
+			return "Day " + strconv.Itoa(leg.Day+1) + " - " + leg.Title
+		}
+	}
+	return leg.Title
+}
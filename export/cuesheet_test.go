@@ -0,0 +1,236 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+/*
+A 6000 ft stage in 1000 ft sections with one turn, planned at 40 mph and then 60 mph,
+and a 2000 ft loop after it that slows down after its turn.
+*/
+func testCueItinerary(t *testing.T) (*types.Itinerary, *types.SimulatedRun) {
+	t.Helper()
+
+	stage := types.Route{
+		Name:     "A: Nashville to Paducah",
+		Metadata: types.RouteMetadata{Kind: types.StageRoute, StageLetter: "A", StartCheckpoint: "Nashville", EndCheckpoint: "Paducah"},
+	}
+	instructions := []types.RouteInstruction{types.Right, types.Depart, types.Depart, types.Depart, types.Depart, types.Goal}
+	exitInstructions := []string{"Turn right onto Main Street", "Head north", "Head north", "Head north", "Head north", "Arrive"}
+	for i := range instructions {
+		stage.Sections = append(stage.Sections, types.RouteSection{
+			LengthFt:        1000,
+			InstructionCode: instructions[i],
+			ExitInstruction: exitInstructions[i],
+			PositionInRoute: i,
+		})
+	}
+
+	loop := types.Route{
+		Name:     "AL: Paducah Loop",
+		Metadata: types.RouteMetadata{Kind: types.LoopRoute, StageLetter: "A", StartCheckpoint: "Paducah", EndCheckpoint: "Paducah"},
+		Sections: []types.RouteSection{
+			{LengthFt: 1000, InstructionCode: types.Left, ExitInstruction: "Turn left onto Loop Road"},
+			{LengthFt: 1000, InstructionCode: types.Goal, ExitInstruction: "Arrive", PositionInRoute: 1},
+		},
+	}
+
+	// Saved and loaded like any other route, so the legs and sections are laid out by NewItinerary()
+	folder := t.TempDir()
+	var entries []dataaccess.ItineraryEntry
+	for _, route := range []types.Route{stage, loop} {
+		data, err := json.Marshal(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routeFilePath := filepath.Join(folder, route.Metadata.StageLetter+string(route.Metadata.Kind)+".route.json")
+		err = os.WriteFile(routeFilePath, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, dataaccess.ItineraryEntry{RouteFilePath: routeFilePath})
+	}
+
+	itinerary, err := dataaccess.NewItinerary("A,AL", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	speedsMph := []float64{40, 40, 40, 60, 60, 60, 60, 30}
+	plan := types.SimulatedRun{Name: "Day 1 plan", Itinerary: itinerary.Name}
+	at := time.Date(2024, 6, 20, 9, 0, 0, 0, time.UTC)
+	battery := 100.0
+	for i, speedMph := range speedsMph {
+		duration := time.Duration(1000 / (speedMph * 5280 / 3600) * float64(time.Second))
+		plan.Sections = append(plan.Sections, types.SimulatedSection{
+			SectionIndex:        i,
+			StartTime:           at,
+			EndTime:             at.Add(duration),
+			TargetSpeedMph:      speedMph,
+			StartBatteryPercent: battery,
+			EndBatteryPercent:   battery - 0.5,
+		})
+		at = at.Add(duration)
+		battery -= 0.5
+	}
+
+	return itinerary, &plan
+}
+
+// Kind, distance, instruction and target speed of every cue, one line each
+func describeCues(leg CueLeg) []string {
+	var lines []string
+	for _, cue := range leg.Cues {
+		lines = append(lines, fmt.Sprintf("%s %.0f %s %d", cue.Kind, cue.DistanceFt, cue.Instruction, cue.TargetSpeedMph))
+	}
+	return lines
+}
+
+func TestNewCueSheet(t *testing.T) {
+	itinerary, plan := testCueItinerary(t)
+	closeTime := time.Date(2024, 6, 20, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		plan        *types.SimulatedRun
+		checkpoints []CueCheckpoint
+		wantTitle   string
+		// Title and cues of each leg
+		wantLegs map[string][]string
+		// Titles in order
+		wantLegOrder []string
+	}{
+		{
+			name:         "route only",
+			wantTitle:    "A,AL",
+			wantLegOrder: []string{"A: Nashville to Paducah", "AL: Paducah Loop (lap 1)"},
+			wantLegs: map[string][]string{
+				"A: Nashville to Paducah": {
+					"start 0 Leave Nashville 0",
+					"turn 1000 Turn right onto Main Street 0",
+					"arrive 6000 Arrive at Paducah 0",
+				},
+				"AL: Paducah Loop (lap 1)": {
+					"start 6000 Leave Paducah 0",
+					"turn 7000 Turn left onto Loop Road 0",
+					"arrive 8000 Arrive at Paducah 0",
+				},
+			},
+		},
+		{
+			name:         "with a plan",
+			plan:         plan,
+			wantTitle:    "Day 1 plan",
+			wantLegOrder: []string{"A: Nashville to Paducah", "AL: Paducah Loop (lap 1)"},
+			wantLegs: map[string][]string{
+				"A: Nashville to Paducah": {
+					"start 0 Leave Nashville 40",
+					"turn 1000 Turn right onto Main Street 40",
+					"speed 3000 Speed 60 mph 60",
+					"arrive 6000 Arrive at Paducah 0",
+				},
+				"AL: Paducah Loop (lap 1)": {
+					"start 6000 Leave Paducah 60",
+					"turn 7000 Turn left onto Loop Road 30",
+					"arrive 8000 Arrive at Paducah 0",
+				},
+			},
+		},
+		{
+			name:         "checkpoint part way through a section",
+			plan:         plan,
+			checkpoints:  []CueCheckpoint{{Name: "Hopkinsville", DistanceFt: 2500}},
+			wantTitle:    "Day 1 plan",
+			wantLegOrder: []string{"A: Nashville to Paducah - Nashville to Hopkinsville", "A: Nashville to Paducah - Hopkinsville to Paducah", "AL: Paducah Loop (lap 1)"},
+			wantLegs: map[string][]string{
+				"A: Nashville to Paducah - Nashville to Hopkinsville": {
+					"start 0 Leave Nashville 40",
+					"turn 1000 Turn right onto Main Street 40",
+					"arrive 2500 Arrive at Hopkinsville 0",
+				},
+				// Starts part way through the last 40 mph section, so it already aims for 60
+				"A: Nashville to Paducah - Hopkinsville to Paducah": {
+					"start 2500 Leave Hopkinsville 60",
+					"arrive 6000 Arrive at Paducah 0",
+				},
+				"AL: Paducah Loop (lap 1)": {
+					"start 6000 Leave Paducah 60",
+					"turn 7000 Turn left onto Loop Road 30",
+					"arrive 8000 Arrive at Paducah 0",
+				},
+			},
+		},
+		{
+			name:         "checkpoint at the end of a route",
+			checkpoints:  []CueCheckpoint{{Name: "Paducah", DistanceFt: 6000, CloseTime: closeTime}},
+			wantTitle:    "A,AL",
+			wantLegOrder: []string{"A: Nashville to Paducah", "AL: Paducah Loop (lap 1)"},
+			wantLegs: map[string][]string{
+				"A: Nashville to Paducah": {
+					"start 0 Leave Nashville 0",
+					"turn 1000 Turn right onto Main Street 0",
+					"arrive 6000 Arrive at Paducah, closes 18:00 0",
+				},
+				"AL: Paducah Loop (lap 1)": {
+					"start 6000 Leave Paducah 0",
+					"turn 7000 Turn left onto Loop Road 0",
+					"arrive 8000 Arrive at Paducah 0",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sheet := NewCueSheet(itinerary, test.plan, test.checkpoints)
+
+			if sheet.Title != test.wantTitle {
+				t.Errorf("Title = %q, want %q", sheet.Title, test.wantTitle)
+			}
+			if sheet.HasPlan != (test.plan != nil) {
+				t.Errorf("HasPlan = %v", sheet.HasPlan)
+			}
+
+			var titles []string
+			for _, leg := range sheet.Legs {
+				titles = append(titles, leg.Title)
+				if cues := describeCues(leg); !reflect.DeepEqual(cues, test.wantLegs[leg.Title]) {
+					t.Errorf("leg %q cues:\n%q\nwant:\n%q", leg.Title, cues, test.wantLegs[leg.Title])
+				}
+			}
+			if !reflect.DeepEqual(titles, test.wantLegOrder) {
+				t.Errorf("legs = %q, want %q", titles, test.wantLegOrder)
+			}
+		})
+	}
+}
+
+func TestNewCueSheetTimes(t *testing.T) {
+	itinerary, plan := testCueItinerary(t)
+	sheet := NewCueSheet(itinerary, plan, []CueCheckpoint{{Name: "Hopkinsville", DistanceFt: 2500}})
+
+	// Half way through the third section
+	arrive := sheet.Legs[0].Cues[len(sheet.Legs[0].Cues)-1]
+	section := plan.Sections[2]
+	wantTime := section.StartTime.Add(section.EndTime.Sub(section.StartTime) / 2)
+	if !arrive.Time.Equal(wantTime) {
+		t.Errorf("arrive Time = %v, want %v", arrive.Time, wantTime)
+	}
+	if arrive.BatteryPercent != 98.75 {
+		t.Errorf("arrive BatteryPercent = %v, want 98.75", arrive.BatteryPercent)
+	}
+
+	start := sheet.Legs[1].Cues[0]
+	if !start.Time.Equal(arrive.Time) || start.BatteryPercent != arrive.BatteryPercent {
+		t.Errorf("next leg starts at %v with %v%%, want where the last one arrived", start.Time, start.BatteryPercent)
+	}
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"html/template"
+	"io"
+)
+
+// Printed with every leg starting on a new page
+var cueSheetTemplate = template.Must(template.New("cuesheet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+h2 { margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #888; padding: 0.3em 0.5em; text-align: left; }
+td.number { text-align: right; white-space: nowrap; }
+tr.start, tr.arrive { font-weight: bold; background: #eee; }
+tr.speed { font-style: italic; }
+@media print {
+	body { margin: 0; }
+	section + section { page-break-before: always; }
+	tr { page-break-inside: avoid; }
+}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Legs}}<section>
+<h2>{{.Heading}}</h2>
+{{if .Closes}}<p>Closes {{.Closes}}</p>
+{{end}}<table>
+<tr>{{range $.Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr class="{{.Kind}}">{{range $i, $cell := .Cells}}<td{{if ne $i 2}} class="number"{{end}}>{{$cell}}</td>{{end}}</tr>
+{{end}}</table>
+</section>
+{{end}}</body>
+</html>
+`))
+
+type cueSheetHtmlRow struct {
+	Kind  CueKind
+	Cells []string
+}
+
+type cueSheetHtmlLeg struct {
+	Heading string
+	Closes  string
+	Rows    []cueSheetHtmlRow
+}
+
+type cueSheetHtml struct {
+	Title   string
+	Columns []string
+	Legs    []cueSheetHtmlLeg
+}
+
+// Writes a printable page with a table per leg
+func writeCueSheetHtml(writer io.Writer, sheet *CueSheet) error {
+	page := cueSheetHtml{Title: sheet.Title, Columns: sheet.columns()}
+
+	for i := range sheet.Legs {
+		leg := &sheet.Legs[i]
+
+		htmlLeg := cueSheetHtmlLeg{Heading: sheet.legHeading(leg)}
+		if !leg.CloseTime.IsZero() {
+			htmlLeg.Closes = leg.CloseTime.Format("15:04")
+		}
+		for j, row := range sheet.rows(leg) {
+			htmlLeg.Rows = append(htmlLeg.Rows, cueSheetHtmlRow{Kind: leg.Cues[j].Kind, Cells: row})
+		}
+
+		page.Legs = append(page.Legs, htmlLeg)
+	}
+
+	return cueSheetTemplate.Execute(writer, page)
+}
@@ -0,0 +1,41 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Writes a heading and a table per leg
+func writeCueSheetMarkdown(writer io.Writer, sheet *CueSheet) error {
+	buffered := bufio.NewWriter(writer)
+
+	buffered.WriteString("# " + sheet.Title + "\n")
+
+	columns := sheet.columns()
+	for i := range sheet.Legs {
+		leg := &sheet.Legs[i]
+
+		buffered.WriteString("\n## " + markdownEscape(sheet.legHeading(leg)) + "\n\n")
+		if !leg.CloseTime.IsZero() {
+			buffered.WriteString("Closes " + leg.CloseTime.Format("15:04") + "\n\n")
+		}
+
+		buffered.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+		buffered.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+		for _, row := range sheet.rows(leg) {
+			for j := range row {
+				row[j] = markdownEscape(row[j])
+			}
+			buffered.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// Keeps street names from breaking the table or turning into formatting
+func markdownEscape(text string) string {
+	replacer := strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "#", "\\#")
+	return replacer.Replace(text)
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Widths of the columns in mm, in the same order as CueSheet.columns(). The instruction gets the rest of the page.
+var cueSheetPdfWidths = map[string]float64{
+	"Mile":    16,
+	"Go":      14,
+	"Speed":   18,
+	"Time":    15,
+	"Battery": 18,
+}
+
+const cueSheetPdfRowHeight float64 = 7
+const cueSheetPdfBottomMargin float64 = 15
+
+// Writes a Letter size document with a table per leg, each leg starting on a new page
+func writeCueSheetPdf(writer io.Writer, sheet *CueSheet) error {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle(sheet.Title, true)
+	pdf.SetAutoPageBreak(true, cueSheetPdfBottomMargin)
+	pdf.AliasNbPages("")
+	// The core fonts only have Latin-1 characters
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, translate(sheet.Title)+" - page "+strconv.Itoa(pdf.PageNo())+" of {nb}", "", 0, "C", false, 0, "")
+	})
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	columns := sheet.columns()
+	widths := make([]float64, len(columns))
+	instructionWidth := pageWidth - left - right
+	for i, column := range columns {
+		widths[i] = cueSheetPdfWidths[column]
+		instructionWidth -= widths[i]
+	}
+	widths[2] = instructionWidth
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(200, 200, 200)
+		for i, column := range columns {
+			pdf.CellFormat(widths[i], cueSheetPdfRowHeight, column, "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	for i := range sheet.Legs {
+		leg := &sheet.Legs[i]
+
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 8, translate(sheet.legHeading(leg)), "", 1, "L", false, 0, "")
+		if !leg.CloseTime.IsZero() {
+			pdf.SetFont("Helvetica", "", 11)
+			pdf.CellFormat(0, 6, "Closes "+leg.CloseTime.Format("15:04"), "", 1, "L", false, 0, "")
+		}
+		pdf.Ln(2)
+		header()
+
+		for j, row := range sheet.rows(leg) {
+			// Repeat the column names on every page of a long leg
+			if pdf.GetY()+cueSheetPdfRowHeight > pageHeight-cueSheetPdfBottomMargin {
+				pdf.AddPage()
+				header()
+			}
+
+			kind := leg.Cues[j].Kind
+			style := ""
+			fill := kind == CueStart || kind == CueArrive
+			if fill {
+				style = "B"
+				pdf.SetFillColor(235, 235, 235)
+			} else if kind == CueSpeed {
+				style = "I"
+			}
+			pdf.SetFont("Helvetica", style, 10)
+
+			for k, cell := range row {
+				align := "R"
+				if k == 2 {
+					align = "L"
+					cell = fitPdfText(pdf, translate(cell), widths[k]-2)
+				}
+				pdf.CellFormat(widths[k], cueSheetPdfRowHeight, cell, "1", 0, align, fill, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+	}
+
+	return pdf.Output(writer)
+}
+
+// Shortens already translated text with "..." until it fits in width. The core fonts have one byte per character.
+func fitPdfText(pdf *fpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
@@ -0,0 +1,198 @@
+/*
+Writes routes, and optionally the simulation results along them, to map file formats
+(GeoJSON, KML and GPX) so they can be opened in any map tool.
+*/
+package export
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"asc-simulation/phys"
+	"asc-simulation/types"
+)
+
+// Enum of the supported file formats
+type Format string
+
+const (
+	GeoJSON Format = "geojson"
+	KML     Format = "kml"
+	GPX     Format = "gpx"
+)
+
+const ftToMeters float64 = 0.3048
+const mpsToMph float64 = 2.23694
+
+// Guesses the format from a file extension, e.g. "stage-a.kml"
+func FormatFromFilePath(filePath string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".geojson", ".json":
+		return GeoJSON, nil
+	case ".kml":
+		return KML, nil
+	case ".gpx":
+		return GPX, nil
+	}
+
+	return "", errors.New("unknown export format for file \"" + filePath + "\"")
+}
+
+/*
+Writes the itinerary to a file, in the format matching the file's extension.
+result can be nil to only export the route. If it is not nil, every section
+it simulated is exported with its speed, battery % and energy.
+*/
+func ExportItinerary(filePath string, itinerary *types.Itinerary, result *phys.SimulationResult) error {
+	functionErrMsg := errors.New("error exporting itinerary")
+
+	format, err := FormatFromFilePath(filePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	err = WriteItinerary(file, format, itinerary, result)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Same as ExportItinerary(), but writes to any writer in the given format.
+func WriteItinerary(writer io.Writer, format Format, itinerary *types.Itinerary, result *phys.SimulationResult) error {
+	segments := createSegments(itinerary, result)
+
+	switch format {
+	case GeoJSON:
+		return writeGeoJson(writer, itinerary, segments)
+	case KML:
+		return writeKml(writer, itinerary, segments)
+	case GPX:
+		return writeGpx(writer, itinerary, segments)
+	}
+
+	return errors.New("unknown export format \"" + string(format) + "\"")
+}
+
+// One route section, plus its simulation result if it was simulated
+type segment struct {
+	section *types.ItinerarySection
+	result  *phys.SectionResult
+}
+
+type property struct {
+	name  string
+	value any
+}
+
+func createSegments(itinerary *types.Itinerary, result *phys.SimulationResult) []segment {
+	results := make(map[int]*phys.SectionResult)
+	if result != nil {
+		for i := range result.Sections {
+			results[result.Sections[i].Section.Position] = &result.Sections[i]
+		}
+	}
+
+	segments := make([]segment, len(itinerary.Sections))
+	for i := range itinerary.Sections {
+		segments[i] = segment{
+			section: &itinerary.Sections[i],
+			result:  results[i],
+		}
+	}
+
+	return segments
+}
+
+// Properties shown for every segment, in the order they should be listed
+func (seg *segment) properties() []property {
+	section := seg.section
+
+	grade := 0.0
+	if section.LengthFt > 0 {
+		grade = (section.ElevationFinalFt - section.ElevationInitialFt) / section.LengthFt * 100
+	}
+
+	properties := []property{
+		{"position", section.Position},
+		{"route", section.Leg.Route.Name},
+		{"kind", string(section.Leg.Kind)},
+		{"day", section.Leg.Day + 1},
+		{"lap", section.Leg.Repetition},
+		{"instruction", section.ExitInstruction},
+		{"distance_mi", round(section.DistanceFt/5280, 3)},
+		{"length_ft", round(section.LengthFt, 1)},
+		{"speed_limit_mph", section.SpeedLimitMph},
+		{"grade_percent", round(grade, 2)},
+		{"elevation_ft", round(section.ElevationInitialFt, 1)},
+	}
+
+	if seg.result != nil {
+		properties = append(properties,
+			property{"arrival_time", seg.result.StartTime.Format(time.RFC3339)},
+			property{"speed_mph", round(seg.result.AverageVelocityMps*mpsToMph, 1)},
+			property{"max_speed_mph", round(seg.result.MaxVelocityMps*mpsToMph, 1)},
+			property{"battery_percent", round(seg.result.EndBatteryPercent, 2)},
+			property{"energy_used_j", round(seg.result.EnergyUsedJ, 1)},
+			property{"energy_gained_j", round(seg.result.EnergyGainedJ, 1)},
+			property{"net_energy_j", round(seg.result.EnergyGainedJ-seg.result.EnergyUsedJ, 1)},
+		)
+	}
+
+	return properties
+}
+
+/*
+Red for fast sections and blue for slow ones, scaled to the fastest section.
+Sections that weren't simulated are gray.
+*/
+func (seg *segment) color(maxVelocityMps float64) (uint8, uint8, uint8) {
+	if seg.result == nil || maxVelocityMps <= 0 {
+		return 128, 128, 128
+	}
+
+	ratio := math.Min(1, math.Max(0, seg.result.AverageVelocityMps/maxVelocityMps))
+	return uint8(math.Round(255 * ratio)), 0, uint8(math.Round(255 * (1 - ratio)))
+}
+
+func maxAverageVelocity(segments []segment) float64 {
+	maxVelocity := 0.0
+	for _, seg := range segments {
+		if seg.result != nil {
+			maxVelocity = math.Max(maxVelocity, seg.result.AverageVelocityMps)
+		}
+	}
+	return maxVelocity
+}
+
+func formatPropertyValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(value)
+	case uint:
+		return strconv.FormatUint(uint64(value), 10)
+	}
+	return ""
+}
+
+func round(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,81 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"asc-simulation/types"
+)
+
+/*
+Type definitions for GeoJSON.
+Reference: https://datatracker.ietf.org/doc/html/rfc7946
+*/
+
+type geoJsonGeometry struct {
+	Type string `json:"type"`
+	// [longitude, latitude, elevation in meters]
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+type geoJsonFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJsonGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Name     string           `json:"name"`
+	Features []geoJsonFeature `json:"features"`
+}
+
+// Writes every segment as a LineString feature with its properties
+func writeGeoJson(writer io.Writer, itinerary *types.Itinerary, segments []segment) error {
+	collection := geoJsonFeatureCollection{
+		Type:     "FeatureCollection",
+		Name:     itinerary.Name,
+		Features: make([]geoJsonFeature, 0, len(segments)),
+	}
+
+	maxVelocity := maxAverageVelocity(segments)
+
+	for i := range segments {
+		seg := &segments[i]
+
+		properties := make(map[string]any)
+		for _, property := range seg.properties() {
+			properties[property.name] = property.value
+		}
+
+		// simplestyle-spec, understood by geojson.io and GitHub's map previews
+		red, green, blue := seg.color(maxVelocity)
+		properties["stroke"] = fmt.Sprintf("#%02x%02x%02x", red, green, blue)
+		properties["stroke-width"] = 4
+
+		collection.Features = append(collection.Features, geoJsonFeature{
+			Type: "Feature",
+			Geometry: geoJsonGeometry{
+				Type: "LineString",
+				Coordinates: [][]float64{
+					encodeGeoJsonCoordinates(seg.section.CoordinatesInitial, seg.section.ElevationInitialFt),
+					encodeGeoJsonCoordinates(seg.section.CoordinatesFinal, seg.section.ElevationFinalFt),
+				},
+			},
+			Properties: properties,
+		})
+	}
+
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(collection)
+}
+
+// Exists because GeoJSON puts longitude before latitude
+func encodeGeoJsonCoordinates(coordinates types.Coordinates, elevationFt float64) []float64 {
+	return []float64{
+		coordinates.Longitude,
+		coordinates.Latitude,
+		round(elevationFt*ftToMeters, 2),
+	}
+}
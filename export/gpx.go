@@ -0,0 +1,109 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"asc-simulation/types"
+)
+
+/*
+Type definitions for GPX 1.1.
+Reference: https://www.topografix.com/GPX/1/1/
+
+Segment properties are written as extensions in our own namespace,
+which map tools that don't know about them will ignore.
+*/
+
+const gpxExtensionNamespace string = "https://github.com/Solar-Gators/asc-tool"
+
+type gpxExtension struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type gpxPoint struct {
+	Latitude   float64        `xml:"lat,attr"`
+	Longitude  float64        `xml:"lon,attr"`
+	ElevationM float64        `xml:"ele"`
+	Time       string         `xml:"time,omitempty"`
+	Desc       string         `xml:"desc,omitempty"`
+	Extensions []gpxExtension `xml:"extensions>property,omitempty"`
+}
+
+type gpxTrack struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxFile struct {
+	XMLName  xml.Name   `xml:"gpx"`
+	Version  string     `xml:"version,attr"`
+	Creator  string     `xml:"creator,attr"`
+	Xmlns    string     `xml:"xmlns,attr"`
+	XmlnsAsc string     `xml:"xmlns:asc,attr"`
+	Name     string     `xml:"metadata>name"`
+	Tracks   []gpxTrack `xml:"trk"`
+}
+
+// Writes one track per leg, with a point at the start of every segment
+func writeGpx(writer io.Writer, itinerary *types.Itinerary, segments []segment) error {
+	file := gpxFile{
+		Version:  "1.1",
+		Creator:  "asc-simulation",
+		Xmlns:    "http://www.topografix.com/GPX/1/1",
+		XmlnsAsc: gpxExtensionNamespace,
+		Name:     itinerary.Name,
+	}
+
+	var currentLeg *types.ItineraryLeg = nil
+	for i := range segments {
+		seg := &segments[i]
+
+		if seg.section.Leg != currentLeg {
+			currentLeg = seg.section.Leg
+			file.Tracks = append(file.Tracks, gpxTrack{Name: legName(currentLeg)})
+		}
+		track := &file.Tracks[len(file.Tracks)-1]
+
+		point := gpxPoint{
+			Latitude:   seg.section.CoordinatesInitial.Latitude,
+			Longitude:  seg.section.CoordinatesInitial.Longitude,
+			ElevationM: round(seg.section.ElevationInitialFt*ftToMeters, 2),
+			Desc:       seg.section.ExitInstruction,
+		}
+		if seg.result != nil {
+			point.Time = seg.result.StartTime.UTC().Format(time.RFC3339)
+		}
+		for _, property := range seg.properties() {
+			point.Extensions = append(point.Extensions, gpxExtension{
+				XMLName: xml.Name{Local: "asc:" + property.name},
+				Value:   formatPropertyValue(property.value),
+			})
+		}
+		track.Points = append(track.Points, point)
+
+		// Close off the track at the end of the leg's last section
+		if seg.section.Position == currentLeg.EndSectionIndex {
+			endPoint := gpxPoint{
+				Latitude:   seg.section.CoordinatesFinal.Latitude,
+				Longitude:  seg.section.CoordinatesFinal.Longitude,
+				ElevationM: round(seg.section.ElevationFinalFt*ftToMeters, 2),
+			}
+			if seg.result != nil {
+				endPoint.Time = seg.result.EndTime.UTC().Format(time.RFC3339)
+			}
+			track.Points = append(track.Points, endPoint)
+		}
+	}
+
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	return encoder.Encode(file)
+}
@@ -0,0 +1,120 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"asc-simulation/types"
+)
+
+/*
+Type definitions for KML.
+Reference: https://developers.google.com/kml/documentation/kmlreference
+*/
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlLineStyle struct {
+	// aabbggrr
+	Color string `xml:"color"`
+	Width int    `xml:"width"`
+}
+
+type kmlStyle struct {
+	LineStyle kmlLineStyle `xml:"LineStyle"`
+}
+
+type kmlLineString struct {
+	AltitudeMode string `xml:"altitudeMode"`
+	// "longitude,latitude,altitude" tuples separated by spaces
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPlacemark struct {
+	Name         string        `xml:"name"`
+	Style        kmlStyle      `xml:"Style"`
+	ExtendedData []kmlData     `xml:"ExtendedData>Data"`
+	LineString   kmlLineString `xml:"LineString"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlDocument struct {
+	XMLName xml.Name    `xml:"kml"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Name    string      `xml:"Document>name"`
+	Folders []kmlFolder `xml:"Document>Folder"`
+}
+
+// Writes one folder per leg, with a colored placemark for every segment
+func writeKml(writer io.Writer, itinerary *types.Itinerary, segments []segment) error {
+	document := kmlDocument{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Name:  itinerary.Name,
+	}
+
+	maxVelocity := maxAverageVelocity(segments)
+
+	var currentLeg *types.ItineraryLeg = nil
+	for i := range segments {
+		seg := &segments[i]
+
+		if seg.section.Leg != currentLeg {
+			currentLeg = seg.section.Leg
+			document.Folders = append(document.Folders, kmlFolder{Name: legName(currentLeg)})
+		}
+		folder := &document.Folders[len(document.Folders)-1]
+
+		var data []kmlData
+		for _, property := range seg.properties() {
+			data = append(data, kmlData{Name: property.name, Value: formatPropertyValue(property.value)})
+		}
+
+		red, green, blue := seg.color(maxVelocity)
+		folder.Placemarks = append(folder.Placemarks, kmlPlacemark{
+			Name: seg.section.ExitInstruction,
+			Style: kmlStyle{LineStyle: kmlLineStyle{
+				Color: fmt.Sprintf("ff%02x%02x%02x", blue, green, red),
+				Width: 4,
+			}},
+			ExtendedData: data,
+			LineString: kmlLineString{
+				AltitudeMode: "clampToGround",
+				Coordinates: encodeKmlCoordinates(seg.section.CoordinatesInitial, seg.section.ElevationInitialFt) +
+					" " +
+					encodeKmlCoordinates(seg.section.CoordinatesFinal, seg.section.ElevationFinalFt),
+			},
+		})
+	}
+
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	return encoder.Encode(document)
+}
+
+// Exists because KML puts longitude before latitude
+func encodeKmlCoordinates(coordinates types.Coordinates, elevationFt float64) string {
+	return strconv.FormatFloat(coordinates.Longitude, 'f', -1, 64) + "," +
+		strconv.FormatFloat(coordinates.Latitude, 'f', -1, 64) + "," +
+		strconv.FormatFloat(round(elevationFt*ftToMeters, 2), 'f', -1, 64)
+}
+
+func legName(leg *types.ItineraryLeg) string {
+	if leg.Repetition > 0 {
+		return fmt.Sprintf("%s (lap %d)", leg.Route.Name, leg.Repetition)
+	}
+	return leg.Route.Name
+}
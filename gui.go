@@ -3,6 +3,8 @@
 package main
 
 import (
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
 	"bufio"
 	"fmt"
 	"fyne.io/fyne/v2/app"
@@ -10,9 +12,11 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 	"io"
-	"io/ioutil"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
 var routePath string = "./asc-routes-2024/"
@@ -33,27 +37,40 @@ func generateRouteList() ([]string, []string) {
 	var routeNames []string
 	var loopNames []string
 
-	files, err := ioutil.ReadDir(routePath)
+	catalog, err := dataaccess.LoadRouteCatalog(routePath)
 	if err != nil {
-		fmt.Println("Error reading directory:", err)
+		fmt.Println("Error reading route catalog:", err)
 		return routeNames, loopNames
 	}
 
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), routeFileType) {
-			if file.Name()[1] == 'L' {
-				loopName := strings.TrimSuffix(file.Name(), routeFileType)
-				loopNames = append(loopNames, loopName)
-			} else {
-				routeName := strings.TrimSuffix(file.Name(), routeFileType)
-				routeNames = append(routeNames, routeName)
-			}
-		}
+	for _, route := range catalog.RoutesOfKind(types.StageRoute) {
+		routeNames = append(routeNames, strings.TrimSuffix(route.FileName, routeFileType))
+	}
+	for _, loop := range catalog.RoutesOfKind(types.LoopRoute) {
+		loopNames = append(loopNames, strings.TrimSuffix(loop.FileName, routeFileType))
 	}
 
 	return routeNames, loopNames
 }
 
+/*
+Shows the car's state from a telemetry server ("telemetry serve") every few seconds,
+if TELEMETRY_STATE_URL is set, e.g. to http://127.0.0.1:8790/state
+*/
+func followCarState(stateUrl string, l *widget.Label, onState func(types.CarState)) {
+	for {
+		state, err := dataaccess.LoadCarState(stateUrl)
+		if err != nil {
+			l.SetText("Car: no telemetry")
+		} else {
+			l.SetText(fmt.Sprintf("Car: %.1f%% battery, %.0f mph, mile %.1f at %s",
+				state.BatteryPercent, state.SpeedMph, state.DistanceFt/5280, state.Time.Format("15:04:05")))
+			onState(*state)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
 func main() {
 
 	a := app.New()
@@ -105,6 +122,33 @@ func main() {
 	start_time := widget.NewEntry()
 	start_time.SetText("08:00")
 
+	car_state_label := widget.NewLabel("")
+	car_state_label.TextStyle.Monospace = true
+	car_state_label.Hide()
+
+	// Lets "Use Car State" fill in the battery and start time from the latest state
+	var car_state *types.CarState
+	var car_state_mutex sync.Mutex
+	use_car_state := widget.NewButton("Use Car State", func() {
+		car_state_mutex.Lock()
+		defer car_state_mutex.Unlock()
+		if car_state != nil {
+			starting_battery.SetText(fmt.Sprintf("%.0f", car_state.BatteryPercent))
+			start_time.SetText(car_state.Time.Local().Format("15:04"))
+		}
+	})
+	use_car_state.Hide()
+
+	if stateUrl := os.Getenv("TELEMETRY_STATE_URL"); stateUrl != "" {
+		car_state_label.Show()
+		use_car_state.Show()
+		go followCarState(stateUrl, car_state_label, func(state types.CarState) {
+			car_state_mutex.Lock()
+			defer car_state_mutex.Unlock()
+			car_state = &state
+		})
+	}
+
 	output_label := widget.NewLabel("Calculating...")
 	output_label.Hide()
 	output_label.TextStyle.Monospace = true
@@ -210,6 +254,8 @@ func main() {
 	w.SetContent(
 		container.NewVScroll(
 			container.NewVBox(
+				car_state_label,
+				use_car_state,
 				label_1,
 				route_segment,
 				label_2,
@@ -0,0 +1,250 @@
+package phys
+
+import (
+	"errors"
+	"image/color"
+	"math"
+	"os"
+	"sort"
+
+	"asc-simulation/types"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// Converts simulated sections to the format saved by "calc --output" and "event --output"
+func NewSimulatedRun(name string, itinerarySpec string, sections []SectionResult) types.SimulatedRun {
+	run := types.SimulatedRun{
+		Name:      name,
+		Itinerary: itinerarySpec,
+	}
+
+	for _, section := range sections {
+		run.Sections = append(run.Sections, types.SimulatedSection{
+			SectionIndex:        section.Section.Position,
+			StartTime:           section.StartTime,
+			EndTime:             section.EndTime,
+			AverageSpeedMph:     mpsToMph(section.AverageVelocityMps),
+			TargetSpeedMph:      mpsToMph(section.TargetVelocityMps),
+			BatteryEnergyWh:     (section.EnergyUsedJ - section.EnergyGainedJ) / 3600,
+			ArrayEnergyWh:       section.EnergyGainedJ / 3600,
+			StartBatteryPercent: section.StartBatteryPercent,
+			EndBatteryPercent:   section.EndBatteryPercent,
+		})
+	}
+
+	if len(run.Sections) > 0 {
+		run.StartTime = run.Sections[0].StartTime
+		run.EndTime = run.Sections[len(run.Sections)-1].EndTime
+	}
+
+	return run
+}
+
+// One section driven in both the simulation and the telemetry run. Errors are actual - simulated.
+type SectionComparison struct {
+	Section *types.ItinerarySection
+	// Time spent on the section
+	SimulatedSeconds float64
+	ActualSeconds    float64
+	// Energy out of the battery
+	SimulatedEnergyWh float64
+	ActualEnergyWh    float64
+	// Change in battery %, negative when discharging.
+	// The actual change is the logged state of charge if the log has it, or worked out from the bus energy if not.
+	SimulatedBatteryChangePercent float64
+	ActualBatteryChangePercent    float64
+	// Errors added up from the first compared section to the end of this one
+	CumulativeTimeErrorSeconds    float64
+	CumulativeEnergyErrorWh       float64
+	CumulativeBatteryErrorPercent float64
+}
+
+func (comparison SectionComparison) TimeErrorSeconds() float64 {
+	return comparison.ActualSeconds - comparison.SimulatedSeconds
+}
+
+func (comparison SectionComparison) EnergyErrorWh() float64 {
+	return comparison.ActualEnergyWh - comparison.SimulatedEnergyWh
+}
+
+func (comparison SectionComparison) BatteryErrorPercent() float64 {
+	return comparison.ActualBatteryChangePercent - comparison.SimulatedBatteryChangePercent
+}
+
+type RunComparison struct {
+	Simulation *types.SimulatedRun
+	Run        *types.TelemetryRun
+	// Sorted by distance along the itinerary
+	Sections []SectionComparison
+	// Sections only in the simulation or only in the telemetry run
+	UnmatchedSectionCount int
+	// Battery % at the start of the first compared section, from the simulation
+	StartBatteryPercent float64
+}
+
+/*
+Lines up a simulation and a telemetry run of the same itinerary by section, so by
+distance along the route, and works out how wrong the simulation was on each section.
+Only sections that are in both are compared.
+*/
+func CompareRuns(simulation *types.SimulatedRun, run *types.TelemetryRun, itinerary *types.Itinerary) (*RunComparison, error) {
+	simulated := make(map[int]types.SimulatedSection)
+	for _, section := range simulation.Sections {
+		if section.SectionIndex < 0 || section.SectionIndex >= len(itinerary.Sections) {
+			return nil, errors.New("simulation has sections outside of the itinerary")
+		}
+		simulated[section.SectionIndex] = section
+	}
+
+	comparison := RunComparison{
+		Simulation: simulation,
+		Run:        run,
+	}
+
+	matched := 0
+	for _, actual := range run.Sections {
+		if actual.SectionIndex < 0 || actual.SectionIndex >= len(itinerary.Sections) {
+			return nil, errors.New("telemetry run has sections outside of the itinerary")
+		}
+		predicted, ok := simulated[actual.SectionIndex]
+		if !ok {
+			comparison.UnmatchedSectionCount++
+			continue
+		}
+		matched++
+
+		// Estimated from the bus energy if the log has no state of charge
+		actualBatteryChange := -jtoBatteryPercent(actual.BusEnergyWh * 3600)
+		if actual.StartBatteryPercent > 0 && actual.EndBatteryPercent > 0 {
+			actualBatteryChange = actual.EndBatteryPercent - actual.StartBatteryPercent
+		}
+
+		if len(comparison.Sections) == 0 {
+			comparison.StartBatteryPercent = predicted.StartBatteryPercent
+		}
+
+		comparison.Sections = append(comparison.Sections, SectionComparison{
+			Section:                       &itinerary.Sections[actual.SectionIndex],
+			SimulatedSeconds:              predicted.EndTime.Sub(predicted.StartTime).Seconds(),
+			ActualSeconds:                 actual.EndTime.Sub(actual.StartTime).Seconds(),
+			SimulatedEnergyWh:             predicted.BatteryEnergyWh,
+			ActualEnergyWh:                actual.BusEnergyWh,
+			SimulatedBatteryChangePercent: predicted.EndBatteryPercent - predicted.StartBatteryPercent,
+			ActualBatteryChangePercent:    actualBatteryChange,
+		})
+	}
+	comparison.UnmatchedSectionCount += len(simulation.Sections) - matched
+
+	if len(comparison.Sections) == 0 {
+		return nil, errors.New("the simulation and telemetry run have no sections in common")
+	}
+
+	sort.Slice(comparison.Sections, func(i, j int) bool {
+		return comparison.Sections[i].Section.Position < comparison.Sections[j].Section.Position
+	})
+
+	timeError, energyError, batteryError := 0.0, 0.0, 0.0
+	for i := range comparison.Sections {
+		section := &comparison.Sections[i]
+		timeError += section.TimeErrorSeconds()
+		energyError += section.EnergyErrorWh()
+		batteryError += section.BatteryErrorPercent()
+
+		section.CumulativeTimeErrorSeconds = timeError
+		section.CumulativeEnergyErrorWh = energyError
+		section.CumulativeBatteryErrorPercent = batteryError
+	}
+
+	return &comparison, nil
+}
+
+/*
+Returns up to count sections with the biggest errors, biggest first.
+errorOf picks the error to rank by, e.g. SectionComparison.EnergyErrorWh.
+*/
+func (comparison *RunComparison) WorstSections(count int, errorOf func(SectionComparison) float64) []SectionComparison {
+	worst := make([]SectionComparison, len(comparison.Sections))
+	copy(worst, comparison.Sections)
+
+	sort.SliceStable(worst, func(i, j int) bool {
+		return math.Abs(errorOf(worst[i])) > math.Abs(errorOf(worst[j]))
+	})
+
+	return worst[:min(count, len(worst))]
+}
+
+// Writes the simulated and actual time, energy and battery % along the route to ./plots
+func OutputComparisonGraphs(comparison *RunComparison) {
+	var simulatedTime, actualTime plotter.XYs
+	var simulatedEnergy, actualEnergy plotter.XYs
+	var simulatedBattery, actualBattery plotter.XYs
+	var energyError plotter.XYs
+
+	simulatedSeconds, actualSeconds := 0.0, 0.0
+	simulatedWh, actualWh := 0.0, 0.0
+	simulatedPercent := comparison.StartBatteryPercent
+	actualPercent := comparison.StartBatteryPercent
+
+	for _, section := range comparison.Sections {
+		simulatedSeconds += section.SimulatedSeconds
+		actualSeconds += section.ActualSeconds
+		simulatedWh += section.SimulatedEnergyWh
+		actualWh += section.ActualEnergyWh
+		simulatedPercent += section.SimulatedBatteryChangePercent
+		actualPercent += section.ActualBatteryChangePercent
+
+		// Plotted at the end of each section, as that's when the totals are reached
+		distanceMi := (section.Section.DistanceFt + section.Section.LengthFt) / 5280
+		simulatedTime = append(simulatedTime, plotter.XY{X: distanceMi, Y: simulatedSeconds / 60})
+		actualTime = append(actualTime, plotter.XY{X: distanceMi, Y: actualSeconds / 60})
+		simulatedEnergy = append(simulatedEnergy, plotter.XY{X: distanceMi, Y: simulatedWh})
+		actualEnergy = append(actualEnergy, plotter.XY{X: distanceMi, Y: actualWh})
+		simulatedBattery = append(simulatedBattery, plotter.XY{X: distanceMi, Y: simulatedPercent})
+		actualBattery = append(actualBattery, plotter.XY{X: distanceMi, Y: actualPercent})
+		energyError = append(energyError, plotter.XY{X: distanceMi, Y: section.EnergyErrorWh()})
+	}
+
+	os.MkdirAll("./plots", 0755)
+	outputComparisonGraph(simulatedTime, actualTime, "Time driving (min)", "./plots/comparisonTime.png")
+	outputComparisonGraph(simulatedEnergy, actualEnergy, "Battery energy used (Wh)", "./plots/comparisonEnergy.png")
+	outputComparisonGraph(simulatedBattery, actualBattery, "Battery (%)", "./plots/comparisonBattery.png")
+	outputComparisonGraph(nil, energyError, "Section energy error, actual - simulated (Wh)", "./plots/comparisonEnergyError.png")
+}
+
+// Plots simulated and actual values against distance (mi). Pass nil simulated values to plot only the actual ones.
+func outputComparisonGraph(simulated plotter.XYs, actual plotter.XYs, yLabel string, fileName string) {
+	toPlot := plot.New()
+	toPlot.X.Label.Text = "Distance (mi)"
+	toPlot.Y.Label.Text = yLabel
+	toPlot.Legend.Top = true
+
+	if simulated != nil {
+		lines, err := plotter.NewLine(simulated)
+		if err != nil {
+			panic(err)
+		}
+		lines.Color = color.RGBA{R: 0, G: 0, B: 255, A: 255}
+		toPlot.Add(lines)
+		toPlot.Legend.Add("Simulated", lines)
+	}
+
+	lines, err := plotter.NewLine(actual)
+	if err != nil {
+		panic(err)
+	}
+	lines.Color = color.RGBA{R: 255, G: 0, B: 0, A: 255}
+	toPlot.Add(lines)
+	if simulated != nil {
+		toPlot.Legend.Add("Actual", lines)
+	}
+
+	toPlot.X.Tick.Marker = plot.DefaultTicks{}
+	toPlot.Y.Tick.Marker = plot.DefaultTicks{}
+	toPlot.Add(plotter.NewGrid())
+	if err := toPlot.Save(8*vg.Inch, 4*vg.Inch, fileName); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,144 @@
+package phys
+
+import (
+	"asc-simulation/types"
+	"errors"
+	"sort"
+	"time"
+)
+
+/*
+Towns slow the car down in ways speed limits don't show: traffic lights, stop signs
+and other cars. Sections are classified as urban or rural, and urban sections are
+driven slower depending on the time of day (unless there is traffic data for them,
+which already shows how busy the road is) and pay for stopping and starting again.
+*/
+
+/*
+Sections with a speed limit at or below this are in town. Speed limits come from
+OpenRouteService's average speed for each step, which is 35-40 mph on rural two lane roads.
+*/
+const urbanSpeedLimitMph = 30
+
+// Sections with at least this many turns per mile around them are in town, whatever the speed limit
+const urbanTurnsPerMile = 2.0
+
+// Turns are counted this far before and after each section
+const urbanTurnWindowFt = 0.5 * 5280
+
+// Traffic lights and stop signs per mile in town, not counting turns
+const urbanStopsPerMile = 1.5
+
+// Average time spent waiting at each stop
+const urbanStopWaitSeconds = 12.0
+
+// Speed multiplier in town for each hour of the day (in the route's time zone), slowest at rush hour
+var urbanSpeedFactorByHour = [24]float64{
+	0.95, 0.95, 0.95, 0.95, 0.95, 0.9, // midnight - 5am
+	0.85, 0.65, 0.65, 0.8, 0.85, 0.85, // 6am - 11am
+	0.8, 0.8, 0.85, 0.8, 0.65, 0.65, // noon - 5pm
+	0.75, 0.85, 0.85, 0.9, 0.95, 0.95, // 6pm - 11pm
+}
+
+type sectionCongestion struct {
+	urban bool
+	// Number of times the car is expected to stop in the section
+	stops float64
+	// Time zone of the section's route, which decides when rush hour is
+	location *time.Location
+}
+
+// Classifies every section of the itinerary, by index into Itinerary.Sections
+func classifyCongestion(itinerary *types.Itinerary) ([]sectionCongestion, error) {
+	// Distance along the itinerary of every turn, in order
+	turnsFt := make([]float64, 0)
+	for i := range itinerary.Sections {
+		section := &itinerary.Sections[i]
+		if isStoppingTurn(section.InstructionCode) {
+			turnsFt = append(turnsFt, section.DistanceFt+section.LengthFt)
+		}
+	}
+
+	locations := make(map[*types.Route]*time.Location)
+	congestion := make([]sectionCongestion, len(itinerary.Sections))
+	for i := range itinerary.Sections {
+		section := &itinerary.Sections[i]
+
+		location, exists := locations[section.Leg.Route]
+		if !exists {
+			var err error
+			location, err = routeLocation(section.Leg.Route)
+			if err != nil {
+				return nil, err
+			}
+			locations[section.Leg.Route] = location
+		}
+		congestion[i].location = location
+
+		windowStartFt := section.DistanceFt - urbanTurnWindowFt
+		windowEndFt := section.DistanceFt + section.LengthFt + urbanTurnWindowFt
+		turns := sort.SearchFloat64s(turnsFt, windowEndFt) - sort.SearchFloat64s(turnsFt, windowStartFt)
+		turnsPerMile := float64(turns) / ((windowEndFt - windowStartFt) / 5280)
+
+		urban := (section.SpeedLimitMph > 0 && section.SpeedLimitMph <= urbanSpeedLimitMph) ||
+			turnsPerMile >= urbanTurnsPerMile
+		if !urban {
+			continue
+		}
+
+		stops := urbanStopsPerMile * section.LengthFt / 5280
+		if isStoppingTurn(section.InstructionCode) {
+			stops++
+		}
+		congestion[i].urban = true
+		congestion[i].stops = stops
+	}
+
+	return congestion, nil
+}
+
+// The route's time zone from its metadata, or the computer's time zone if it doesn't have one
+func routeLocation(route *types.Route) (*time.Location, error) {
+	if route == nil || route.Metadata.TimeZone == "" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(route.Metadata.TimeZone)
+	if err != nil {
+		return nil, errors.Join(errors.New("route \""+route.Name+"\" has an unknown time zone"), err)
+	}
+	return location, nil
+}
+
+// Turns the car slows right down or stops for. Slight turns and forks usually don't need it.
+func isStoppingTurn(instruction types.RouteInstruction) bool {
+	switch instruction {
+	case types.Left, types.Right, types.SharpLeft, types.SharpRight, types.UTurn, types.EnterRoundabout:
+		return true
+	}
+	return false
+}
+
+// How much slower than the speed limit traffic in town moves at the given time in the given time zone
+func urbanSpeedFactor(at time.Time, location *time.Location) float64 {
+	return urbanSpeedFactorByHour[at.In(location).Hour()]
+}
+
+/*
+Extra energy and time for stopping and speeding back up to the given speed, compared
+to driving straight through. The car has no regenerative braking, so the kinetic
+energy lost at each stop is spent again to speed back up.
+*/
+func stopAndGoPenalty(stops float64, velocityMps float64, accelerationMps2 float64, decelerationMps2 float64) (energyJ float64, seconds float64) {
+	if stops <= 0 || velocityMps <= 0 {
+		return 0, 0
+	}
+
+	energyJ = stops * 0.5 * carMassKg * velocityMps * velocityMps
+
+	// Slowing down and speeding up at a constant rate takes twice as long as cruising the same distance
+	secondsPerStop := velocityMps/(2*accelerationMps2) + velocityMps/(2*decelerationMps2) + urbanStopWaitSeconds
+	seconds = stops * secondsPerStop
+
+	return energyJ, seconds
+}
@@ -0,0 +1,218 @@
+package phys
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"asc-simulation/types"
+)
+
+func TestClassifyCongestion(t *testing.T) {
+	tests := []struct {
+		name string
+		// Speed limit of each 1000 ft section
+		speedLimitsMph []uint
+		// Sections that end with a turn, and what kind
+		turns map[int]types.RouteInstruction
+		// Sections in town
+		wantUrban []int
+	}{
+		{
+			name:           "open road",
+			speedLimitsMph: []uint{55, 55, 55, 55, 55},
+		},
+		{
+			name:           "speed limit at the threshold",
+			speedLimitsMph: []uint{55, 35, 31, 30, 25, 55},
+			wantUrban:      []int{3, 4},
+		},
+		{
+			name:           "unknown speed limit",
+			speedLimitsMph: []uint{55, 0, 55},
+		},
+		{
+			// A 1000 ft section counts turns over 6280 ft, so it takes 3 to reach 2 per mile
+			name:           "three turns close together",
+			speedLimitsMph: []uint{55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55},
+			turns:          map[int]types.RouteInstruction{8: types.Right, 9: types.Left, 10: types.Right},
+			// Every section whose window starts at or before the first turn (9000 ft) and ends after the last (11000 ft)
+			wantUrban: []int{8, 9, 10, 11},
+		},
+		{
+			name:           "two turns close together",
+			speedLimitsMph: []uint{55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55},
+			turns:          map[int]types.RouteInstruction{5: types.Right, 6: types.Left},
+		},
+		{
+			name:           "slight turns and forks",
+			speedLimitsMph: []uint{55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55},
+			turns:          map[int]types.RouteInstruction{5: types.SlightRight, 6: types.KeepLeft, 7: types.SlightLeft},
+		},
+		{
+			name:           "turns spread out",
+			speedLimitsMph: []uint{55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55, 55},
+			turns:          map[int]types.RouteInstruction{2: types.Right, 8: types.Left, 14: types.Right},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lengthsFt := make([]float64, len(test.speedLimitsMph))
+			for i := range lengthsFt {
+				lengthsFt[i] = 1000
+			}
+			route := testStraightRoute(test.name, lengthsFt, nil)
+			for i, speedLimitMph := range test.speedLimitsMph {
+				route.Sections[i].SpeedLimitMph = speedLimitMph
+			}
+			for i, instruction := range test.turns {
+				route.Sections[i].InstructionCode = instruction
+			}
+			itinerary := testItinerary(t, route)
+
+			congestion, err := classifyCongestion(itinerary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var urban []int
+			for i, section := range congestion {
+				if !section.urban {
+					if section.stops != 0 {
+						t.Errorf("section %d is rural with %v stops", i, section.stops)
+					}
+					continue
+				}
+				urban = append(urban, i)
+
+				// Lights and stop signs, plus the turn at the end of the section
+				wantStops := urbanStopsPerMile * 1000 / 5280
+				if isStoppingTurn(route.Sections[i].InstructionCode) {
+					wantStops++
+				}
+				if math.Abs(section.stops-wantStops) > 1e-9 {
+					t.Errorf("section %d has %v stops, want %v", i, section.stops, wantStops)
+				}
+			}
+			if !reflect.DeepEqual(urban, test.wantUrban) {
+				t.Errorf("urban sections = %v, want %v", urban, test.wantUrban)
+			}
+		})
+	}
+}
+
+func TestStopAndGoPenalty(t *testing.T) {
+	tests := []struct {
+		name        string
+		stops       float64
+		velocityMps float64
+		wantEnergyJ float64
+		wantSeconds float64
+	}{
+		{name: "no stops", stops: 0, velocityMps: 10},
+		{name: "not moving", stops: 2, velocityMps: 0},
+		{
+			name:        "one stop",
+			stops:       1,
+			velocityMps: 10,
+			wantEnergyJ: 0.5 * carMassKg * 100,
+			// Speeding up and slowing down take twice as long as cruising the same distance, so 10/4 s and 10/6 s more, plus the wait
+			wantSeconds: 10.0/4 + 10.0/6 + urbanStopWaitSeconds,
+		},
+		{
+			name:        "part of a stop at twice the speed",
+			stops:       0.5,
+			velocityMps: 20,
+			wantEnergyJ: 0.5 * 0.5 * carMassKg * 400,
+			wantSeconds: 0.5 * (20.0/4 + 20.0/6 + urbanStopWaitSeconds),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			energyJ, seconds := stopAndGoPenalty(test.stops, test.velocityMps, 2, 3)
+			if math.Abs(energyJ-test.wantEnergyJ) > 1e-9 {
+				t.Errorf("energy = %v J, want %v", energyJ, test.wantEnergyJ)
+			}
+			if math.Abs(seconds-test.wantSeconds) > 1e-9 {
+				t.Errorf("time = %v s, want %v", seconds, test.wantSeconds)
+			}
+		})
+	}
+}
+
+func TestClassifyCongestionTimeZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		// Name of the location every section should get, empty if the route should be rejected
+		wantLocation string
+	}{
+		{name: "no time zone", timeZone: "", wantLocation: time.Local.String()},
+		{name: "route's time zone", timeZone: "America/Denver", wantLocation: "America/Denver"},
+		{name: "unknown time zone", timeZone: "America/Gering"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := testStraightRoute(test.name, []float64{1000, 1000}, nil)
+			route.Metadata.Kind = types.StageRoute
+			route.Metadata.TimeZone = test.timeZone
+			itinerary := testItinerary(t, route)
+
+			congestion, err := classifyCongestion(itinerary)
+			if test.wantLocation == "" {
+				if err == nil {
+					t.Fatal("classifyCongestion() worked, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, section := range congestion {
+				if section.location.String() != test.wantLocation {
+					t.Errorf("section %d time zone = %v, want %v", i, section.location, test.wantLocation)
+				}
+			}
+		})
+	}
+}
+
+func TestUrbanSpeedFactor(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	denver, err := time.LoadLocation("America/Denver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		location *time.Location
+		want     float64
+	}{
+		{name: "morning rush hour", at: time.Date(2024, 6, 20, 8, 15, 0, 0, chicago), location: chicago, want: 0.65},
+		{name: "early afternoon", at: time.Date(2024, 6, 20, 14, 0, 0, 0, chicago), location: chicago, want: 0.85},
+		{name: "evening rush hour", at: time.Date(2024, 6, 20, 17, 59, 0, 0, chicago), location: chicago, want: 0.65},
+		{name: "night", at: time.Date(2024, 6, 20, 3, 0, 0, 0, chicago), location: chicago, want: 0.95},
+		// 9am in Chicago is still rush hour in Denver
+		{name: "UTC time in Chicago", at: time.Date(2024, 6, 20, 14, 0, 0, 0, time.UTC), location: chicago, want: 0.8},
+		{name: "UTC time in Denver", at: time.Date(2024, 6, 20, 14, 0, 0, 0, time.UTC), location: denver, want: 0.65},
+		{name: "Chicago time in Denver", at: time.Date(2024, 6, 20, 18, 30, 0, 0, chicago), location: denver, want: 0.65},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if factor := urbanSpeedFactor(test.at, test.location); factor != test.want {
+				t.Errorf("urbanSpeedFactor(%v, %v) = %v, want %v", test.at, test.location, factor, test.want)
+			}
+		})
+	}
+}
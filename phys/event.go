@@ -0,0 +1,227 @@
+package phys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Minutes between weather lookups while the car is parked and charging
+const chargingStepMinutes = 10
+
+// Part of a day, stored as the time since midnight
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Parses a window in "HH:MM-HH:MM" format. An empty string is an empty window.
+func ParseTimeWindow(window string) (TimeWindow, error) {
+	if window == "" {
+		return TimeWindow{}, nil
+	}
+
+	var startHour, startMinute, endHour, endMinute int
+	_, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil {
+		return TimeWindow{}, errors.New("time window \"" + window + "\" not in HH:MM-HH:MM format")
+	}
+
+	result := TimeWindow{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}
+	if result.End < result.Start {
+		return TimeWindow{}, errors.New("time window \"" + window + "\" ends before it starts")
+	}
+
+	return result, nil
+}
+
+func (window TimeWindow) on(date time.Time) (time.Time, time.Time) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return midnight.Add(window.Start), midnight.Add(window.End)
+}
+
+/*
+This struct exists so we can add inputs to SimulateEvent() without having to
+change the code everywhere SimulateEvent() is used.
+*/
+type EventOptions struct {
+	InitialBatteryPercent float64
+	TargetSpeedMph        float64
+	// Date of the first day of the event. Only the date is used.
+	StartDate time.Time
+	// The car may only be on the road during this window. Loops that cannot be
+	// finished before the end of the window are not driven.
+	DrivingWindow TimeWindow
+	// Static charging windows at the start and end of each stage. Leave empty to skip.
+	MorningChargingWindow TimeWindow
+	EveningChargingWindow TimeWindow
+	ShowProgress          bool
+	// Car being simulated. nil uses LoadVehicle().
+	Vehicle *types.Vehicle
+	// Cancels weather and traffic API calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+type DayResult struct {
+	Day  int
+	Date time.Time
+
+	StartBatteryPercent float64
+	// Battery % gained during static charging
+	MorningChargePercent float64
+	EveningChargePercent float64
+	// Battery % at impound, after evening charging
+	EndOfDayBatteryPercent float64
+
+	DistanceFt     float64
+	DrivingEndTime time.Time
+	Legs           []LegResult
+	Sections       []SectionResult
+	// Loops that would have ended after the driving window closed
+	SkippedLegs []*types.ItineraryLeg
+}
+
+type EventResult struct {
+	Days                []DayResult
+	TotalDistanceFt     float64
+	FinalBatteryPercent float64
+}
+
+/*
+Simulates an itinerary one day at a time, following the event schedule:
+morning charging, driving, evening charging, then overnight impound (no charging).
+The battery % at the end of each day carries over to the next.
+*/
+func SimulateEvent(itinerary *types.Itinerary, options EventOptions) (*EventResult, error) {
+	if itinerary == nil || len(itinerary.Legs) == 0 {
+		return nil, errors.New("cannot simulate an empty itinerary")
+	}
+
+	vehicle := options.Vehicle
+	if vehicle == nil {
+		var err error
+		vehicle, err = LoadVehicle()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := EventResult{}
+	battery := options.InitialBatteryPercent
+
+	for day := 0; day < itinerary.DayCount; day++ {
+		var legs []*types.ItineraryLeg
+		for i := range itinerary.Legs {
+			if itinerary.Legs[i].Day == day {
+				legs = append(legs, &itinerary.Legs[i])
+			}
+		}
+		if len(legs) == 0 {
+			continue
+		}
+
+		date := options.StartDate.AddDate(0, 0, day)
+		dayResult := DayResult{
+			Day:                 day,
+			Date:                date,
+			StartBatteryPercent: battery,
+		}
+
+		// Morning charging happens where the day's first stage starts
+		firstSection := itinerary.Sections[legs[0].StartSectionIndex].RouteSection
+		chargeStart, chargeEnd := options.MorningChargingWindow.on(date)
+		charge, err := simulateStaticCharging(options.Context, vehicle, firstSection, chargeStart, chargeEnd)
+		if err != nil {
+			return nil, err
+		}
+		dayResult.MorningChargePercent = min(100-battery, charge)
+		battery += dayResult.MorningChargePercent
+
+		clock, drivingEnd := options.DrivingWindow.on(date)
+		parkedSection := firstSection
+
+		for _, leg := range legs {
+			if options.ShowProgress {
+				fmt.Printf("\nDay %d: %s", day+1, leg.Route.Name)
+			}
+
+			simulation, err := Simulate(itinerary, SimulationOptions{
+				InitialBatteryPercent: battery,
+				TargetSpeedMph:        options.TargetSpeedMph,
+				StartTime:             clock,
+				StartSectionIndex:     leg.StartSectionIndex,
+				SectionCount:          leg.EndSectionIndex - leg.StartSectionIndex + 1,
+				Vehicle:               vehicle,
+				Context:               options.Context,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			// Stages have to be driven no matter what, but loops are optional
+			if leg.Kind == types.LoopRoute && simulation.EndTime.After(drivingEnd) {
+				dayResult.SkippedLegs = append(dayResult.SkippedLegs, leg)
+				continue
+			}
+
+			dayResult.Legs = append(dayResult.Legs, simulation.Legs...)
+			dayResult.Sections = append(dayResult.Sections, simulation.Sections...)
+			dayResult.DistanceFt += leg.LengthFt
+			battery = simulation.FinalBatteryPercent
+			clock = simulation.EndTime
+			parkedSection = itinerary.Sections[leg.EndSectionIndex].RouteSection
+		}
+		dayResult.DrivingEndTime = clock
+
+		// Evening charging happens wherever the car stopped driving
+		chargeStart, chargeEnd = options.EveningChargingWindow.on(date)
+		if chargeStart.Before(clock) {
+			chargeStart = clock
+		}
+		charge, err = simulateStaticCharging(options.Context, vehicle, parkedSection, chargeStart, chargeEnd)
+		if err != nil {
+			return nil, err
+		}
+		dayResult.EveningChargePercent = min(100-battery, charge)
+		battery += dayResult.EveningChargePercent
+
+		dayResult.EndOfDayBatteryPercent = battery
+		result.TotalDistanceFt += dayResult.DistanceFt
+		result.Days = append(result.Days, dayResult)
+	}
+
+	if options.ShowProgress {
+		fmt.Println()
+	}
+
+	result.FinalBatteryPercent = battery
+	return &result, nil
+}
+
+/*
+Returns the battery % gained by the parked car at the section between from and to.
+ctx cancels weather API calls, nil never cancels.
+*/
+func simulateStaticCharging(ctx context.Context, vehicle *types.Vehicle, section *types.RouteSection, from time.Time, to time.Time) (float64, error) {
+	energyGained := 0.0
+
+	for clock := from; clock.Before(to); clock = clock.Add(chargingStepMinutes * time.Minute) {
+		step := min(chargingStepMinutes*time.Minute, to.Sub(clock))
+
+		weather, err := dataaccess.GetWeatherAtTime(section, clock.Add(step/2), simulationWeatherOptions(ctx))
+		if err != nil {
+			return 0, err
+		}
+
+		energyGained += solarPowerWatts(vehicle, weather) * step.Seconds()
+	}
+
+	return jtoBatteryPercent(energyGained), nil
+}
@@ -0,0 +1,336 @@
+package phys
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Intervals slower than this are left out of the drivetrain fit, since losses that don't depend on speed dominate
+const fitMinSpeedMps = 3.0
+
+/*
+Intervals where the vehicle being fitted would need less tractive power than this are left
+out, since the motor is regenerating or coasting and the model works differently.
+Choosing them by the modelled power rather than the measured power avoids biasing the fit.
+*/
+const fitMinTractivePowerW = 200.0
+
+// Length of road the slope is averaged over, which also smooths out GPS and map-matching error
+const fitSlopeWindowFt = 500.0
+
+// Intervals where the matched samples moved along the route this much more or less than the car drove are left out
+const fitMaxMatchErrorFraction = 0.25
+
+// Intervals longer than this (gaps in the log) are left out of the fit
+const fitMaxIntervalSeconds = 60.0
+
+// Fewest intervals needed to fit the drivetrain, and sections to fit the array
+const fitMinDrivetrainSamples = 30
+const fitMinArraySections = 3
+
+// A telemetry run and the itinerary it was matched to
+type FitRun struct {
+	Run       *types.TelemetryRun
+	Itinerary *types.Itinerary
+}
+
+// Measured and modelled power at one point of a run
+type FitResidual struct {
+	Run          string
+	Time         time.Time
+	SectionIndex int
+	SpeedMph     float64
+	MeasuredW    float64
+	ModelledW    float64
+}
+
+type VehicleFit struct {
+	// The vehicle passed to FitVehicle(), with defaults for missing values
+	InitialVehicle types.Vehicle
+	// InitialVehicle with the fitted values
+	Vehicle types.Vehicle
+	// Motor power between each pair of samples, against CdA, Crr and drivetrain efficiency
+	DrivetrainResiduals []FitResidual
+	DrivetrainRmsW      float64
+	// Fraction of the variation in measured power the model explains
+	DrivetrainRSquared float64
+	// Average array power on each section, against array efficiency
+	ArrayResiduals []FitResidual
+	ArrayRmsW      float64
+	ArrayRSquared  float64
+}
+
+/*
+Fits the vehicle's CdA, rolling resistance and drivetrain efficiency to the power the
+motor drew in the telemetry runs, and its array efficiency to the power the array made.
+
+Motor power is the battery's power plus the array's, since the array feeds the bus.
+While the motor is driving the car, CalculateWorkDone() models it as
+
+	power * efficiency = (CdA * drag + Crr * rolling + grade + mass * acceleration) * speed
+
+which is linear in CdA / efficiency, Crr / efficiency and 1 / efficiency, so those are
+found by least squares. Array power is the irradiance on the array times its area and
+efficiency, using the weather at each section when it was driven.
+ctx cancels weather API calls, nil never cancels.
+*/
+func FitVehicle(ctx context.Context, runs []FitRun, vehicle types.Vehicle) (*VehicleFit, error) {
+	functionErrMsg := errors.New("error fitting vehicle")
+
+	vehicle = withVehicleDefaults(vehicle)
+	fit := VehicleFit{InitialVehicle: vehicle, Vehicle: vehicle}
+
+	var drivetrainRows [][]float64
+	var drivetrainPower []float64
+	var arrayRows [][]float64
+	var arrayPower []float64
+
+	for _, fitRun := range runs {
+		run, itinerary := fitRun.Run, fitRun.Itinerary
+		weatherBySection := make(map[int]*types.Weather)
+
+		weatherAt := func(sectionIndex int, at time.Time) (*types.Weather, error) {
+			if weather, exists := weatherBySection[sectionIndex]; exists {
+				return weather, nil
+			}
+			if sectionIndex < 0 || sectionIndex >= len(itinerary.Sections) {
+				return nil, errors.New("run \"" + run.Name + "\" has section " + strconv.Itoa(sectionIndex) +
+					", but itinerary \"" + itinerary.Name + "\" only has " + strconv.Itoa(len(itinerary.Sections)))
+			}
+
+			weather, err := dataaccess.GetWeatherAtTime(itinerary.Sections[sectionIndex].RouteSection, at, simulationWeatherOptions(ctx))
+			if err != nil {
+				return nil, err
+			}
+			weatherBySection[sectionIndex] = weather
+			return weather, nil
+		}
+
+		for i := 1; i < len(run.Samples); i++ {
+			previous, sample := &run.Samples[i-1], &run.Samples[i]
+			if previous.SectionIndex < 0 {
+				continue
+			}
+
+			seconds := sample.Time.Sub(previous.Time).Seconds()
+			velocity := mphToMps((previous.SpeedMph + sample.SpeedMph) / 2)
+			motorPower := (previous.BusVoltageVolts*previous.BusCurrentAmps+sample.BusVoltageVolts*sample.BusCurrentAmps)/2 +
+				(previous.ArrayPowerWatts+sample.ArrayPowerWatts)/2
+			if seconds <= 0 || seconds > fitMaxIntervalSeconds || velocity < fitMinSpeedMps || sample.SectionIndex < 0 {
+				continue
+			}
+
+			// Where the route passes the same place twice or a section is a roundabout, the samples
+			// can be matched to the wrong place, which throws off the slope
+			matchedFt := sample.DistanceFt - previous.DistanceFt
+			drivenFt := velocity * seconds / 0.3048
+			if math.Abs(matchedFt-drivenFt) > fitMaxMatchErrorFraction*drivenFt {
+				continue
+			}
+
+			weather, err := weatherAt(previous.SectionIndex, previous.Time)
+			if err != nil {
+				return nil, errors.Join(functionErrMsg, err)
+			}
+
+			// Sections can be short with steep, noisy slopes, so use the average slope around the car instead
+			section := itinerary.Sections[previous.SectionIndex]
+			distanceFt := (previous.DistanceFt + sample.DistanceFt) / 2
+			slope := (elevationAtFt(itinerary, distanceFt+fitSlopeWindowFt/2) - elevationAtFt(itinerary, distanceFt-fitSlopeWindowFt/2)) / fitSlopeWindowFt
+			heading := calculateBearing(section.CoordinatesInitial, section.CoordinatesFinal)
+			acceleration := mphToMps(sample.SpeedMph-previous.SpeedMph) / seconds
+
+			row := []float64{
+				dragForcePerCdA(weather, velocity, heading) * velocity,
+				rollingForcePerCrr(slope) * velocity,
+				(gradeForce(slope) + carMassKg*acceleration) * velocity,
+			}
+			tractivePower := vehicle.DragAreaM2*row[0] + vehicle.RollingResistanceCoefficient*row[1] + row[2]
+			if tractivePower < fitMinTractivePowerW {
+				continue
+			}
+
+			drivetrainRows = append(drivetrainRows, row)
+			drivetrainPower = append(drivetrainPower, motorPower)
+			fit.DrivetrainResiduals = append(fit.DrivetrainResiduals, FitResidual{
+				Run:          run.Name,
+				Time:         previous.Time,
+				SectionIndex: previous.SectionIndex,
+				SpeedMph:     mpsToMph(velocity),
+				MeasuredW:    motorPower,
+			})
+		}
+
+		for _, section := range run.Sections {
+			seconds := section.EndTime.Sub(section.StartTime).Seconds()
+			if seconds <= 0 {
+				continue
+			}
+
+			midpoint := section.StartTime.Add(section.EndTime.Sub(section.StartTime) / 2)
+			weather, err := weatherAt(section.SectionIndex, midpoint)
+			if err != nil {
+				return nil, errors.Join(functionErrMsg, err)
+			}
+
+			sunlight := arrayIrradianceWm2(weather) * vehicle.ArrayAreaM2
+			if sunlight <= 0 {
+				continue
+			}
+
+			arrayRows = append(arrayRows, []float64{sunlight})
+			arrayPower = append(arrayPower, section.ArrayEnergyWh*3600/seconds)
+			fit.ArrayResiduals = append(fit.ArrayResiduals, FitResidual{
+				Run:          run.Name,
+				Time:         midpoint,
+				SectionIndex: section.SectionIndex,
+				SpeedMph:     section.AverageSpeedMph,
+				MeasuredW:    section.ArrayEnergyWh * 3600 / seconds,
+			})
+		}
+	}
+
+	if len(drivetrainRows) < fitMinDrivetrainSamples {
+		return nil, errors.Join(functionErrMsg, errors.New(
+			"only "+strconv.Itoa(len(drivetrainRows))+" samples with the car driving, need at least "+strconv.Itoa(fitMinDrivetrainSamples)))
+	}
+	if len(arrayRows) < fitMinArraySections {
+		return nil, errors.Join(functionErrMsg, errors.New(
+			"only "+strconv.Itoa(len(arrayRows))+" sections with sunlight, need at least "+strconv.Itoa(fitMinArraySections)))
+	}
+
+	drivetrain, err := leastSquares(drivetrainRows, drivetrainPower)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, errors.New("drivetrain"), err)
+	}
+	efficiency := 1 / drivetrain[2]
+	fit.Vehicle.DragAreaM2 = drivetrain[0] * efficiency
+	fit.Vehicle.RollingResistanceCoefficient = drivetrain[1] * efficiency
+	fit.Vehicle.DrivetrainEfficiency = efficiency
+	if efficiency <= 0 || efficiency > 1 || fit.Vehicle.DragAreaM2 <= 0 || fit.Vehicle.RollingResistanceCoefficient <= 0 {
+		return nil, errors.Join(functionErrMsg, errors.New(
+			"the drivetrain fit isn't physical (CdA "+strconv.FormatFloat(fit.Vehicle.DragAreaM2, 'g', 3, 64)+
+				" m^2, Crr "+strconv.FormatFloat(fit.Vehicle.RollingResistanceCoefficient, 'g', 3, 64)+
+				", efficiency "+strconv.FormatFloat(efficiency, 'g', 3, 64)+
+				"), the runs probably need more variety in speed and slope"))
+	}
+
+	array, err := leastSquares(arrayRows, arrayPower)
+	if err != nil {
+		return nil, errors.Join(functionErrMsg, errors.New("array"), err)
+	}
+	fit.Vehicle.ArrayEfficiency = array[0]
+	if fit.Vehicle.ArrayEfficiency <= 0 || fit.Vehicle.ArrayEfficiency > 1 {
+		return nil, errors.Join(functionErrMsg, errors.New(
+			"the array fit isn't physical (efficiency "+strconv.FormatFloat(fit.Vehicle.ArrayEfficiency, 'g', 3, 64)+
+				"), check the array power in the runs and the weather for those days"))
+	}
+
+	fit.DrivetrainRmsW, fit.DrivetrainRSquared = fillResiduals(fit.DrivetrainResiduals, drivetrainRows, drivetrain)
+	fit.ArrayRmsW, fit.ArrayRSquared = fillResiduals(fit.ArrayResiduals, arrayRows, array)
+
+	return &fit, nil
+}
+
+// Elevation of the route at the distance along the itinerary, assuming each section climbs evenly
+func elevationAtFt(itinerary *types.Itinerary, distanceFt float64) float64 {
+	j := sort.Search(len(itinerary.Sections), func(j int) bool {
+		return itinerary.Sections[j].DistanceFt+itinerary.Sections[j].LengthFt >= distanceFt
+	})
+	j = min(j, len(itinerary.Sections)-1)
+
+	section := &itinerary.Sections[j]
+	fraction := 0.0
+	if section.LengthFt > 0 {
+		fraction = math.Max(0, math.Min(1, (distanceFt-section.DistanceFt)/section.LengthFt))
+	}
+	return section.ElevationInitialFt + fraction*(section.ElevationFinalFt-section.ElevationInitialFt)
+}
+
+// Sets the modelled power of each residual, and returns the root mean square residual and R^2
+func fillResiduals(residuals []FitResidual, rows [][]float64, coefficients []float64) (float64, float64) {
+	meanMeasured := 0.0
+	for _, residual := range residuals {
+		meanMeasured += residual.MeasuredW / float64(len(residuals))
+	}
+
+	sumSquaredResiduals, sumSquaredDeviations := 0.0, 0.0
+	for i := range residuals {
+		residual := &residuals[i]
+		for j, value := range rows[i] {
+			residual.ModelledW += coefficients[j] * value
+		}
+		sumSquaredResiduals += math.Pow(residual.MeasuredW-residual.ModelledW, 2)
+		sumSquaredDeviations += math.Pow(residual.MeasuredW-meanMeasured, 2)
+	}
+
+	rSquared := 0.0
+	if sumSquaredDeviations > 0 {
+		rSquared = 1 - sumSquaredResiduals/sumSquaredDeviations
+	}
+	return math.Sqrt(sumSquaredResiduals / float64(len(residuals))), rSquared
+}
+
+/*
+Finds the coefficients that minimise the squared difference between rows * coefficients
+and values, by solving the normal equations with Gaussian elimination.
+*/
+func leastSquares(rows [][]float64, values []float64) ([]float64, error) {
+	n := len(rows[0])
+
+	// Normal equations (A^T A) x = A^T b, with A^T b as the last column
+	matrix := make([][]float64, n)
+	for i := range matrix {
+		matrix[i] = make([]float64, n+1)
+	}
+	for k, row := range rows {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				matrix[i][j] += row[i] * row[j]
+			}
+			matrix[i][n] += row[i] * values[k]
+		}
+	}
+
+	// Pivots much smaller than this mean a column is (close to) a mix of the others
+	tolerance := 0.0
+	for i := 0; i < n; i++ {
+		tolerance = math.Max(tolerance, math.Abs(matrix[i][i])*1e-12)
+	}
+
+	for column := 0; column < n; column++ {
+		pivot := column
+		for i := column + 1; i < n; i++ {
+			if math.Abs(matrix[i][column]) > math.Abs(matrix[pivot][column]) {
+				pivot = i
+			}
+		}
+		if math.Abs(matrix[pivot][column]) <= tolerance {
+			return nil, errors.New("the data can't tell the parameters apart")
+		}
+		matrix[column], matrix[pivot] = matrix[pivot], matrix[column]
+
+		for i := 0; i < n; i++ {
+			if i == column {
+				continue
+			}
+			factor := matrix[i][column] / matrix[column][column]
+			for j := column; j <= n; j++ {
+				matrix[i][j] -= factor * matrix[column][j]
+			}
+		}
+	}
+
+	coefficients := make([]float64, n)
+	for i := range coefficients {
+		coefficients[i] = matrix[i][n] / matrix[i][i]
+	}
+	return coefficients, nil
+}
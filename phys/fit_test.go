@@ -0,0 +1,271 @@
+package phys
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+func TestLeastSquares(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]float64
+		values  []float64
+		want    []float64
+		wantErr bool
+	}{
+		{
+			name:   "one parameter",
+			rows:   [][]float64{{1}, {2}, {4}},
+			values: []float64{0.5, 1, 2},
+			want:   []float64{0.5},
+		},
+		{
+			name:   "three parameters, exact",
+			rows:   [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 1}, {2, -1, 3}},
+			values: []float64{2, -3, 0.5, -0.5, 8.5},
+			want:   []float64{2, -3, 0.5},
+		},
+		{
+			name:   "three parameters, very different scales",
+			rows:   [][]float64{{8000, 20, 1}, {27000, 30, -2}, {64000, 40, 5}, {125000, 50, 0}, {1000, 10, 3}},
+			values: []float64{8000*2e-4 + 20*0.3 + 1*1.1, 27000*2e-4 + 30*0.3 - 2*1.1, 64000*2e-4 + 40*0.3 + 5*1.1, 125000*2e-4 + 50*0.3, 1000*2e-4 + 10*0.3 + 3*1.1},
+			want:   []float64{2e-4, 0.3, 1.1},
+		},
+		{
+			name:    "a column is a multiple of another",
+			rows:    [][]float64{{1, 2}, {2, 4}, {3, 6}},
+			values:  []float64{1, 2, 3},
+			wantErr: true,
+		},
+		{
+			name:    "a column is a mix of the others",
+			rows:    [][]float64{{1, 0, 1}, {0, 1, 1}, {2, 3, 5}, {1, 1, 2}},
+			values:  []float64{1, 2, 3, 4},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coefficients, err := leastSquares(test.rows, test.values)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("leastSquares() = %v, want an error", coefficients)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range test.want {
+				if math.Abs(coefficients[i]-want) > 1e-9*math.Max(1, math.Abs(want)) {
+					t.Errorf("coefficient %d = %v, want %v", i, coefficients[i], want)
+				}
+			}
+		})
+	}
+}
+
+// Returns the same weather at every place and time
+type constantWeatherProvider struct {
+	weather types.Weather
+}
+
+func (provider constantWeatherProvider) Name() string {
+	return "constant"
+}
+
+func (provider constantWeatherProvider) GetLiveWeather(ctx context.Context, coordinates types.Coordinates) (*dataaccess.WeatherSample, error) {
+	return &dataaccess.WeatherSample{ValidAt: time.Now(), Weather: provider.weather}, nil
+}
+
+func (provider constantWeatherProvider) GetForecastWeather(ctx context.Context, coordinates types.Coordinates, start time.Time, end time.Time) ([]dataaccess.WeatherSample, error) {
+	return provider.samples(start, end), nil
+}
+
+func (provider constantWeatherProvider) GetHistoricalWeather(ctx context.Context, coordinates types.Coordinates, start time.Time, end time.Time) ([]dataaccess.WeatherSample, error) {
+	return provider.samples(start, end), nil
+}
+
+func (provider constantWeatherProvider) samples(start time.Time, end time.Time) []dataaccess.WeatherSample {
+	var samples []dataaccess.WeatherSample
+	for at := start.Truncate(time.Hour); !at.After(end.Add(time.Hour)); at = at.Add(time.Hour) {
+		samples = append(samples, dataaccess.WeatherSample{ValidAt: at, Weather: provider.weather})
+	}
+	return samples
+}
+
+// A straight road heading east with hills of different grades, in half mile sections
+func testFitItinerary(t *testing.T, name string) *types.Itinerary {
+	elevationsFt := []float64{500, 530, 510, 570, 545, 545, 610, 560, 585, 530, 500, 520, 520}
+	lengthsFt := make([]float64, len(elevationsFt)-1)
+	for i := range lengthsFt {
+		lengthsFt[i] = 2640
+	}
+	return testItinerary(t, testStraightRoute(name, lengthsFt, elevationsFt))
+}
+
+/*
+Drives the itinerary once a second with a varying speed, logging the power the model
+says the vehicle needs. The bus power of each sample is chosen so the average over
+each pair of samples, which is what FitVehicle() uses, matches the model exactly.
+*/
+func testFitRun(itinerary *types.Itinerary, vehicle types.Vehicle, weather *types.Weather, durationSeconds int) *types.TelemetryRun {
+	const busVoltage = 100.0
+	startTime := time.Date(2024, 6, 20, 15, 0, 0, 0, time.UTC)
+
+	speedMph := func(second int) float64 {
+		t := float64(second)
+		return 35 + 12*math.Sin(t/40) + 6*math.Sin(t/7)
+	}
+
+	arrayPower := arrayIrradianceWm2(weather) * vehicle.ArrayAreaM2 * vehicle.ArrayEfficiency
+	run := types.TelemetryRun{Name: itinerary.Name, StartTime: startTime}
+
+	sectionIndex := func(distanceFt float64) int {
+		for i := len(itinerary.Sections) - 1; i >= 0; i-- {
+			if distanceFt >= itinerary.Sections[i].DistanceFt {
+				return i
+			}
+		}
+		return 0
+	}
+
+	distanceFt := 0.0
+	for second := 0; second <= durationSeconds && distanceFt < itinerary.LengthFt; second++ {
+		if second > 0 {
+			distanceFt += mphToMps((speedMph(second-1)+speedMph(second))/2) / 0.3048
+		}
+		run.Samples = append(run.Samples, types.TelemetrySample{
+			Time:            startTime.Add(time.Duration(second) * time.Second),
+			SpeedMph:        speedMph(second),
+			BusVoltageVolts: busVoltage,
+			ArrayPowerWatts: arrayPower,
+			HasFix:          true,
+			SectionIndex:    sectionIndex(distanceFt),
+			DistanceFt:      distanceFt,
+		})
+	}
+
+	motorPower := make([]float64, len(run.Samples))
+	for i := 1; i < len(run.Samples); i++ {
+		previous, sample := &run.Samples[i-1], &run.Samples[i]
+		velocity := mphToMps((previous.SpeedMph + sample.SpeedMph) / 2)
+		section := itinerary.Sections[previous.SectionIndex]
+		middleFt := (previous.DistanceFt + sample.DistanceFt) / 2
+		slope := (elevationAtFt(itinerary, middleFt+fitSlopeWindowFt/2) - elevationAtFt(itinerary, middleFt-fitSlopeWindowFt/2)) / fitSlopeWindowFt
+		heading := calculateBearing(section.CoordinatesInitial, section.CoordinatesFinal)
+		// Samples are a second apart
+		acceleration := mphToMps(sample.SpeedMph - previous.SpeedMph)
+
+		tractivePower := (vehicle.DragAreaM2*dragForcePerCdA(weather, velocity, heading) +
+			vehicle.RollingResistanceCoefficient*rollingForcePerCrr(slope) +
+			gradeForce(slope) + carMassKg*acceleration) * velocity
+		intervalPower := tractivePower / vehicle.DrivetrainEfficiency
+
+		if i == 1 {
+			motorPower[0] = intervalPower
+		}
+		motorPower[i] = 2*intervalPower - motorPower[i-1]
+	}
+	for i := range run.Samples {
+		run.Samples[i].BusCurrentAmps = (motorPower[i] - arrayPower) / busVoltage
+	}
+
+	for _, sample := range run.Samples {
+		if len(run.Sections) == 0 || run.Sections[len(run.Sections)-1].SectionIndex != sample.SectionIndex {
+			run.Sections = append(run.Sections, types.TelemetrySection{SectionIndex: sample.SectionIndex, StartTime: sample.Time})
+		}
+		section := &run.Sections[len(run.Sections)-1]
+		section.EndTime = sample.Time
+		section.ArrayEnergyWh = arrayPower * section.EndTime.Sub(section.StartTime).Hours()
+		section.SampleCount++
+	}
+	run.EndTime = run.Samples[len(run.Samples)-1].Time
+
+	return &run
+}
+
+func TestFitVehicle(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	sunny := types.Weather{SolarZenithDegrees: 30, AirTempDegreesF: 85, SurfacePressurePsi: 14.5, GlobalTiltedIrradianceWm2: 850}
+	windy := sunny
+	windy.WindSpeedMph = 15
+	windy.WindDirectionDegrees = 60
+	night := types.Weather{SolarZenithDegrees: 120, AirTempDegreesF: 65, SurfacePressurePsi: 14.7}
+
+	slippery := DefaultVehicle()
+	slippery.DragAreaM2 = 0.15
+	slippery.RollingResistanceCoefficient = 0.0035
+	slippery.DrivetrainEfficiency = 0.97
+	slippery.ArrayEfficiency = 0.21
+
+	draggy := DefaultVehicle()
+	draggy.DragAreaM2 = 0.3
+	draggy.RollingResistanceCoefficient = 0.008
+	draggy.DrivetrainEfficiency = 0.85
+	draggy.ArrayEfficiency = 0.18
+
+	tests := []struct {
+		name            string
+		vehicle         types.Vehicle
+		weather         types.Weather
+		durationSeconds int
+		// Part of the error message, empty if the fit should work
+		wantErr string
+	}{
+		{name: "default vehicle", vehicle: DefaultVehicle(), weather: sunny, durationSeconds: 3600},
+		{name: "low drag", vehicle: slippery, weather: sunny, durationSeconds: 3600},
+		{name: "high drag in the wind", vehicle: draggy, weather: windy, durationSeconds: 3600},
+		{name: "too short", vehicle: DefaultVehicle(), weather: sunny, durationSeconds: 20, wantErr: "samples with the car driving"},
+		{name: "at night", vehicle: DefaultVehicle(), weather: night, durationSeconds: 3600, wantErr: "sections with sunlight"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataaccess.SetWeatherProvider(constantWeatherProvider{weather: test.weather})
+			t.Cleanup(func() { dataaccess.SetWeatherProvider(nil) })
+
+			// Each case has its own route name, so they don't share weather cache entries
+			itinerary := testFitItinerary(t, "fit test "+test.name)
+			run := testFitRun(itinerary, test.vehicle, &test.weather, test.durationSeconds)
+
+			fit, err := FitVehicle(context.Background(), []FitRun{{Run: run, Itinerary: itinerary}}, DefaultVehicle())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FitVehicle() error = %v, want one about %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checks := []struct {
+				name      string
+				got, want float64
+			}{
+				{"DragAreaM2", fit.Vehicle.DragAreaM2, test.vehicle.DragAreaM2},
+				{"RollingResistanceCoefficient", fit.Vehicle.RollingResistanceCoefficient, test.vehicle.RollingResistanceCoefficient},
+				{"DrivetrainEfficiency", fit.Vehicle.DrivetrainEfficiency, test.vehicle.DrivetrainEfficiency},
+				{"ArrayEfficiency", fit.Vehicle.ArrayEfficiency, test.vehicle.ArrayEfficiency},
+			}
+			for _, check := range checks {
+				if math.Abs(check.got-check.want) > 1e-6*check.want {
+					t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+				}
+			}
+			if fit.DrivetrainRSquared < 0.999999 {
+				t.Errorf("DrivetrainRSquared = %v, want 1", fit.DrivetrainRSquared)
+			}
+		})
+	}
+}
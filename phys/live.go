@@ -0,0 +1,207 @@
+package phys
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Used when LivePlanOptions.MinSpeedMph and MaxSpeedMph are not set
+const defaultLiveMinSpeedMph = 20
+const defaultLiveMaxSpeedMph = 60
+
+// A checkpoint still ahead of the car, which has to be reached before it closes
+type Checkpoint struct {
+	// Distance from the start of the itinerary
+	DistanceFt float64
+	CloseTime  time.Time
+}
+
+/*
+This struct exists so we can add inputs to PlanLive() without having to
+change the code everywhere PlanLive() is used.
+*/
+type LivePlanOptions struct {
+	// Where the car is. The simulation starts from the start of the section the car is on.
+	SectionIndex   int
+	BatteryPercent float64
+	Time           time.Time
+	// Checkpoints ahead of the car. The end of the stage can be added as the last checkpoint.
+	Checkpoints []Checkpoint
+	// Battery % that has to be left at the end of the stage
+	MinBatteryPercent float64
+	// Target speeds to pick from. Default to 20 and 60 mph.
+	MinSpeedMph int
+	MaxSpeedMph int
+	// Car being simulated. nil uses LoadVehicle().
+	Vehicle *types.Vehicle
+	// How weather is looked up, e.g. to refresh forecasts during the day. nil uses the simulation default.
+	WeatherOptions *dataaccess.WeatherDataOptions
+	// Cancels weather and traffic API calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+// The rest of the stage driven at one target speed
+type LiveSpeedOption struct {
+	TargetSpeedMph      int
+	EndTime             time.Time
+	FinalBatteryPercent float64
+	// When each checkpoint is reached, in the same order as LivePlanOptions.Checkpoints
+	CheckpointArrivals []time.Time
+	MakesCloseTimes    bool
+	AboveMinBattery    bool
+}
+
+type LivePlan struct {
+	Recommended LiveSpeedOption
+	// Every target speed that was simulated, slowest first
+	Options []LiveSpeedOption
+}
+
+/*
+Finds the target speed to drive the rest of the itinerary at, from where the car is now.
+
+The slowest speed that reaches every checkpoint before it closes is recommended, as it
+uses the least battery. If that speed would leave less than the minimum battery, the
+fastest speed that doesn't is recommended instead, and checkpoints will be missed.
+Speeds are found by bisection, since faster is always sooner and uses more battery.
+*/
+func PlanLive(itinerary *types.Itinerary, options LivePlanOptions) (*LivePlan, error) {
+	if options.SectionIndex < 0 || options.SectionIndex >= len(itinerary.Sections) {
+		return nil, errors.New("car is not on the itinerary")
+	}
+
+	minSpeed, maxSpeed := options.MinSpeedMph, options.MaxSpeedMph
+	if minSpeed <= 0 {
+		minSpeed = defaultLiveMinSpeedMph
+	}
+	if maxSpeed <= 0 {
+		maxSpeed = defaultLiveMaxSpeedMph
+	}
+	if maxSpeed < minSpeed {
+		return nil, errors.New("max speed is below min speed")
+	}
+
+	if options.Vehicle == nil {
+		vehicle, err := LoadVehicle()
+		if err != nil {
+			return nil, err
+		}
+		options.Vehicle = vehicle
+	}
+
+	simulated := make(map[int]LiveSpeedOption)
+	simulate := func(speedMph int) (LiveSpeedOption, error) {
+		if option, ok := simulated[speedMph]; ok {
+			return option, nil
+		}
+
+		simulation, err := Simulate(itinerary, SimulationOptions{
+			InitialBatteryPercent: options.BatteryPercent,
+			TargetSpeedMph:        float64(speedMph),
+			StartTime:             options.Time,
+			StartSectionIndex:     options.SectionIndex,
+			Vehicle:               options.Vehicle,
+			WeatherOptions:        options.WeatherOptions,
+			Context:               options.Context,
+		})
+		if err != nil {
+			return LiveSpeedOption{}, err
+		}
+
+		option := LiveSpeedOption{
+			TargetSpeedMph:      speedMph,
+			EndTime:             simulation.EndTime,
+			FinalBatteryPercent: simulation.FinalBatteryPercent,
+			MakesCloseTimes:     true,
+			AboveMinBattery:     simulation.FinalBatteryPercent >= options.MinBatteryPercent,
+		}
+		for _, checkpoint := range options.Checkpoints {
+			arrival := arrivalTime(simulation, checkpoint.DistanceFt)
+			option.CheckpointArrivals = append(option.CheckpointArrivals, arrival)
+			if arrival.After(checkpoint.CloseTime) {
+				option.MakesCloseTimes = false
+			}
+		}
+
+		simulated[speedMph] = option
+		return option, nil
+	}
+
+	// Slowest speed that makes every close time, or maxSpeed+1 if there isn't one
+	low, high := minSpeed, maxSpeed+1
+	for low < high {
+		mid := (low + high) / 2
+		option, err := simulate(mid)
+		if err != nil {
+			return nil, err
+		}
+		if option.MakesCloseTimes {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	slowestOnTime := low
+
+	// Fastest speed that keeps enough battery, or minSpeed-1 if there isn't one
+	low, high = minSpeed-1, min(slowestOnTime, maxSpeed)
+	for low < high {
+		mid := (low + high + 1) / 2
+		option, err := simulate(mid)
+		if err != nil {
+			return nil, err
+		}
+		if option.AboveMinBattery {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	fastestWithBattery := low
+
+	recommended := min(slowestOnTime, maxSpeed)
+	if fastestWithBattery < recommended {
+		// Making the close times would drain the battery too far, so go as fast as the
+		// battery allows, or as slow as allowed if even that is too fast
+		recommended = max(fastestWithBattery, minSpeed)
+	}
+
+	plan := LivePlan{}
+	var err error
+	plan.Recommended, err = simulate(recommended)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range simulated {
+		plan.Options = append(plan.Options, option)
+	}
+	sort.Slice(plan.Options, func(i, j int) bool {
+		return plan.Options[i].TargetSpeedMph < plan.Options[j].TargetSpeedMph
+	})
+
+	return &plan, nil
+}
+
+// When the simulation reached a distance along the itinerary, or its end time if it never did.
+func arrivalTime(simulation *SimulationResult, distanceFt float64) time.Time {
+	for _, section := range simulation.Sections {
+		endFt := section.Section.DistanceFt + section.Section.LengthFt
+		if distanceFt > endFt {
+			continue
+		}
+
+		fraction := 0.0
+		if section.Section.LengthFt > 0 {
+			fraction = max(0, distanceFt-section.Section.DistanceFt) / section.Section.LengthFt
+		}
+		return section.StartTime.Add(time.Duration(fraction * float64(section.EndTime.Sub(section.StartTime))))
+	}
+
+	return simulation.EndTime
+}
@@ -0,0 +1,168 @@
+package phys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Used when LoopPlanOptions.MaxLoops is not set
+const defaultMaxLoops = 20
+
+/*
+This struct exists so we can add inputs to PlanLoops() without having to
+change the code everywhere PlanLoops() is used.
+*/
+type LoopPlanOptions struct {
+	InitialBatteryPercent float64
+	TargetSpeedMph        float64
+	StartTime             time.Time
+	// Loops have to be finished by this time to count
+	CloseTime time.Time
+	// Battery % that has to be left at the end of the day (after evening charging)
+	MinBatteryPercent float64
+	// Charging after the last loop, counted towards the battery left for the next day.
+	// Leave empty to skip.
+	EveningChargingWindow TimeWindow
+	// Stop trying more loops after this many. Defaults to 20.
+	MaxLoops     int
+	ShowProgress bool
+	// Car being simulated. nil uses LoadVehicle().
+	Vehicle *types.Vehicle
+	// Cancels weather and traffic API calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+// What the end of the day looks like if a given number of loops is driven
+type LoopCountOption struct {
+	Loops          int
+	EndTime        time.Time
+	BatteryPercent float64
+	// Battery % after evening charging
+	EndOfDayBatteryPercent float64
+	DistanceFt             float64
+	// Time and battery % this loop cost compared to one loop fewer
+	ExtraLoopDuration       time.Duration
+	ExtraLoopBatteryPercent float64
+	FinishedBeforeClose     bool
+	AboveMinBattery         bool
+}
+
+type LoopPlan struct {
+	// Index i is the result of driving i loops. Unless HitLoopLimit is set, the last option
+	// is the first one that broke a constraint, so the cost of one loop too many is visible.
+	Options []LoopCountOption
+	// Highest loop count that finishes before close and stays above the minimum battery.
+	// -1 if even the stage alone breaks a constraint.
+	MaxLoops int
+	// True if every loop count up to LoopPlanOptions.MaxLoops kept to the constraints,
+	// so more loops might fit than were tried.
+	HitLoopLimit bool
+}
+
+/*
+Finds how many times a loop can be driven after finishing a stage, while finishing
+before close time and keeping enough battery for the next day.
+The stage is simulated once, then loops are added one at a time until a constraint is broken.
+*/
+func PlanLoops(stageFilePath string, loopFilePath string, options LoopPlanOptions) (*LoopPlan, error) {
+	maxLoops := options.MaxLoops
+	if maxLoops <= 0 {
+		maxLoops = defaultMaxLoops
+	}
+
+	itinerary, err := dataaccess.NewItinerary(stageFilePath, []dataaccess.ItineraryEntry{
+		{RouteFilePath: stageFilePath, Repetitions: 1},
+		{RouteFilePath: loopFilePath, Repetitions: maxLoops},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(itinerary.Legs) < 2 || itinerary.Legs[1].Kind != types.LoopRoute {
+		return nil, errors.New("\"" + loopFilePath + "\" is not a loop")
+	}
+
+	vehicle := options.Vehicle
+	if vehicle == nil {
+		vehicle, err = LoadVehicle()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plan := LoopPlan{}
+	battery := options.InitialBatteryPercent
+	clock := options.StartTime
+	distanceFt := 0.0
+
+	for i, leg := range itinerary.Legs {
+		if options.ShowProgress {
+			fmt.Printf("\rSimulating %s, %d loops", itinerary.Legs[0].Route.Name, i)
+		}
+
+		simulation, err := Simulate(itinerary, SimulationOptions{
+			InitialBatteryPercent: battery,
+			TargetSpeedMph:        options.TargetSpeedMph,
+			StartTime:             clock,
+			StartSectionIndex:     leg.StartSectionIndex,
+			SectionCount:          leg.EndSectionIndex - leg.StartSectionIndex + 1,
+			Vehicle:               vehicle,
+			Context:               options.Context,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		option := LoopCountOption{
+			Loops:                   i,
+			EndTime:                 simulation.EndTime,
+			BatteryPercent:          simulation.FinalBatteryPercent,
+			DistanceFt:              distanceFt + leg.LengthFt,
+			ExtraLoopDuration:       simulation.EndTime.Sub(clock),
+			ExtraLoopBatteryPercent: simulation.FinalBatteryPercent - battery,
+		}
+
+		chargeStart, chargeEnd := options.EveningChargingWindow.on(options.StartTime)
+		if chargeStart.Before(option.EndTime) {
+			chargeStart = option.EndTime
+		}
+		parkedSection := itinerary.Sections[leg.EndSectionIndex].RouteSection
+		charge, err := simulateStaticCharging(options.Context, vehicle, parkedSection, chargeStart, chargeEnd)
+		if err != nil {
+			return nil, err
+		}
+		option.EndOfDayBatteryPercent = min(100, option.BatteryPercent+charge)
+
+		option.FinishedBeforeClose = !option.EndTime.After(options.CloseTime)
+		option.AboveMinBattery = option.BatteryPercent > 0 &&
+			option.EndOfDayBatteryPercent >= options.MinBatteryPercent
+
+		plan.Options = append(plan.Options, option)
+		if !option.FinishedBeforeClose || !option.AboveMinBattery {
+			break
+		}
+
+		plan.MaxLoops = i
+		battery = simulation.FinalBatteryPercent
+		clock = simulation.EndTime
+		distanceFt = option.DistanceFt
+	}
+
+	if options.ShowProgress {
+		fmt.Println()
+	}
+
+	if len(plan.Options) > 0 {
+		lastOption := plan.Options[len(plan.Options)-1]
+		plan.HitLoopLimit = lastOption.FinishedBeforeClose && lastOption.AboveMinBattery
+	}
+	if len(plan.Options) > 0 && (!plan.Options[0].FinishedBeforeClose || !plan.Options[0].AboveMinBattery) {
+		plan.MaxLoops = -1
+	}
+
+	return &plan, nil
+}
@@ -1,6 +1,7 @@
 package phys
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
@@ -21,13 +22,19 @@ import (
 const Cells int = 256
 const CellEfficiency float64 = 0.227
 const PanelWattage float64 = 430
-const CellSize float64 = 1046 * 1812 * 0.01 //m^2
-const SystemVoltage float64 = 70            //TODO: replace with real value
+const CellAreaM2 float64 = 0.125 * 0.125
+const SystemVoltage float64 = 70 //TODO: replace with real value
+
+const batteryCapacitymAh float64 = 50000 //placeholder
 
 func mphToMps(mph float64) float64 {
 	return mph * 0.44704
 }
 
+func mpsToMph(mps float64) float64 {
+	return mps / 0.44704
+}
+
 func ftToMeters(ft float64) float64 {
 	return ft * 0.3048
 }
@@ -36,11 +43,15 @@ func jtomAh(joules float64) float64 {
 	return joules / (SystemVoltage * 3600 * 0.01)
 }
 
+func jtoBatteryPercent(joules float64) float64 {
+	return jtomAh(joules) / batteryCapacitymAh * 100
+}
+
 func calculateBearing(start types.Coordinates, end types.Coordinates) float64 {
-	lat1 := start.Latitude
-	lon1 := start.Longitude
-	lat2 := end.Latitude
-	lon2 := end.Longitude
+	lat1 := start.Latitude * math.Pi / 180
+	lon1 := start.Longitude * math.Pi / 180
+	lat2 := end.Latitude * math.Pi / 180
+	lon2 := end.Longitude * math.Pi / 180
 
 	dLon := lon2 - lon1
 	y := math.Sin(dLon) * math.Cos(lat2)
@@ -56,10 +67,6 @@ func calculateBearing(start types.Coordinates, end types.Coordinates) float64 {
 
 const SolarConstant = 1361.0 //W/m^2
 
-// not yet implemented
-var windDirectionRadians = 0.0
-var windSpeed = 10.0 //placeholder value
-
 // number of points in the graph to compute:
 const numTicks = 100
 
@@ -71,33 +78,78 @@ const numTicks = 100
 // 2-4: parabola params
 // next 3: parabola params
 
-func CalculateWorkDone(velocity float64, step_distance float64, slope float64, prev_velo float64, facing_direction float64) float64 {
-	const carMassKg = 298.0
-	const dragCoefficient = 0.1275
-	const wheelCircumference = 1.875216
+const carMassKg = 298.0
+
+/*
+Energy in Joules the motor draws from the battery to go step_distance meters from
+prev_velo to velocity. Negative when the motor recovers energy going downhill or slowing down.
+*/
+func CalculateWorkDone(vehicle *types.Vehicle, weather *types.Weather, velocity float64, step_distance float64, slope float64, prev_velo float64, facing_direction float64) float64 {
+	total_force := vehicle.DragAreaM2*dragForcePerCdA(weather, velocity, facing_direction) +
+		vehicle.RollingResistanceCoefficient*rollingForcePerCrr(slope) +
+		gradeForce(slope)
+	net_velo_energy := .5 * carMassKg * (math.Pow(velocity, 2) - math.Pow(prev_velo, 2))
+	total_work := total_force*step_distance + net_velo_energy
 
-	//change the velocity to make relative to wind for airResistance only
-	relativeVelocity := velocity - windSpeed*math.Cos(math.Abs(windDirectionRadians-facing_direction))
-	airResistance := dragCoefficient * math.Pow(relativeVelocity, 2)
+	// work motor does is "positive"
+	if total_work > 0 {
+		return total_work / vehicle.DrivetrainEfficiency
+	}
+	return total_work * vehicle.DrivetrainEfficiency
+}
 
-	//mgsin(theta)
-	slope_force := carMassKg * 9.81 * math.Sin(math.Atan(slope)) // slope = tan(Theta)
-	net_velo_energy := .5 * carMassKg * (velocity - prev_velo)
-	total_force := airResistance + slope_force
-	total_work := total_force*step_distance + net_velo_energy
+// Air resistance in N for each m^2 of CdA
+func dragForcePerCdA(weather *types.Weather, velocity float64, facing_direction float64) float64 {
+	airSpeed := airSpeedMps(weather, velocity, facing_direction)
+	return 0.5 * airDensityKgM3(weather) * airSpeed * math.Abs(airSpeed)
+}
 
-	//Calculating motor efficiency (function of velocity)
-	motorRpm := 60 * (velocity / wheelCircumference)
-	motorCurrent := (-3*motorRpm - 2700) / 13
-	var motorEfficiency float64
-	if motorCurrent >= 14 {
-		motorEfficiency = 0.9264 + 0.0015*(motorCurrent-14)
-	} else {
-		motorEfficiency = ((motorCurrent - 1.1) / (motorCurrent - .37)) - .02
+// Rolling resistance in N for a rolling resistance coefficient of 1
+func rollingForcePerCrr(slope float64) float64 {
+	return carMassKg * 9.81 * math.Cos(math.Atan(slope)) // slope = tan(Theta)
+}
+
+// mgsin(theta)
+func gradeForce(slope float64) float64 {
+	return carMassKg * 9.81 * math.Sin(math.Atan(slope)) // slope = tan(Theta)
+}
+
+// Speed of the air over the car. Wind comes from WindDirectionDegrees, so a headwind adds to it.
+func airSpeedMps(weather *types.Weather, velocity float64, facing_direction float64) float64 {
+	windDirectionRadians := weather.WindDirectionDegrees * math.Pi / 180
+	return velocity + mphToMps(weather.WindSpeedMph)*math.Cos(windDirectionRadians-facing_direction)
+}
+
+// Ideal gas law. Uses sea level air when the weather has no pressure.
+func airDensityKgM3(weather *types.Weather) float64 {
+	const seaLevelAirDensity = 1.225
+	const dryAirGasConstant = 287.05 // J/(kg K)
+
+	if weather.SurfacePressurePsi <= 0 {
+		return seaLevelAirDensity
 	}
+	pressurePa := weather.SurfacePressurePsi * 6894.757
+	temperatureK := (weather.AirTempDegreesF-32)*5/9 + 273.15
+	return pressurePa / (dryAirGasConstant * temperatureK)
+}
 
-	// work motor does is "positive"
-	return motorEfficiency * total_work
+// Power collected by the solar array under the given weather
+func solarPowerWatts(vehicle *types.Vehicle, weather *types.Weather) float64 {
+	return arrayIrradianceWm2(weather) * vehicle.ArrayAreaM2 * vehicle.ArrayEfficiency //Does not take into account changes in voltage / current from the system or from working in series
+}
+
+/*
+Sunlight reaching the solar array in W/m^2. Uses the irradiance from the weather data
+when there is any, and otherwise estimates it from the sun's angle and the cloud cover.
+*/
+func arrayIrradianceWm2(weather *types.Weather) float64 {
+	if weather.GlobalTiltedIrradianceWm2 > 0 {
+		return weather.GlobalTiltedIrradianceWm2
+	}
+	if weather.GlobalHorizontalIrradianceWm2 > 0 {
+		return weather.GlobalHorizontalIrradianceWm2
+	}
+	return SolarConstant * max(0, math.Cos(weather.SolarZenithDegrees*math.Pi/180)) * (1 - (weather.CloudCoverPercentage * 0.01))
 }
 
 func integrand(x float64, q float64, w float64, e float64, r float64) float64 {
@@ -124,8 +176,11 @@ func simpson(a float64, b float64, q float64, w float64, e float64, r float64, n
 	return (h / 3) * sum
 }
 
-func outputGraph(inputArr plotter.XYs, fileName string) {
+func outputGraph(inputArr plotter.XYs, title string, yLabel string, fileName string) {
 	toPlot := plot.New()
+	toPlot.Title.Text = title
+	toPlot.X.Label.Text = "Time (s)"
+	toPlot.Y.Label.Text = yLabel
 
 	lines, err := plotter.NewLine(inputArr)
 	if err != nil {
@@ -143,7 +198,11 @@ func outputGraph(inputArr plotter.XYs, fileName string) {
 }
 
 // physics sim should be main program
-func CalcPhysics(routeName string, battery int, targSpeed int, loopName string, loopCount int, startTime string, cpOneClose string, cpTwoClose string, cpThreeClose string, stageClose string) {
+// date is the day to simulate, startTime is the time on that day
+// resultFilePath is where to save the per-section results for "compare", empty to skip
+// reportFilePath is where to write the HTML report, empty to skip
+// ctx cancels weather and traffic API calls, nil never cancels
+func CalcPhysics(ctx context.Context, routeName string, battery int, targSpeed int, loopName string, loopCount int, date time.Time, startTime string, cpOneClose string, cpTwoClose string, cpThreeClose string, stageClose string, resultFilePath string, reportFilePath string) {
 	//TODO: currently no way to account for checkpoints. As they are provided day of maybe we could take an input parameter as to the position or distance along route of the checkpoint and manage from there?
 
 	//vehicle, err := dataaccess.GetVehicle("vehicle.json") //TODO: Change vehicle constants to values attained from api
@@ -152,209 +211,95 @@ func CalcPhysics(routeName string, battery int, targSpeed int, loopName string,
 	//}
 	const timeLayout = "15:04"
 
-	startT, err := time.Parse(timeLayout, startTime)
+	startClock, err := time.Parse(timeLayout, startTime)
 	if err != nil {
 		panic(err)
 	}
-	var currTime = startT
-	//TODO: implement acceleration curve
+	startT := time.Date(date.Year(), date.Month(), date.Day(), startClock.Hour(), startClock.Minute(), 0, 0, time.Local)
 
-	//maxBatteryCapmAh := vehicle.BatteryCapacityMilliamps
-	maxBatteryCapmAh := 50000.0 //placeholder
-	initialBatteryCapmAh := maxBatteryCapmAh * (float64(battery) * 0.01)
+	entries := []dataaccess.ItineraryEntry{{RouteFilePath: routeName, Repetitions: 1}}
+	itinerarySpec := routeName
+	if loopCount > 0 {
+		entries = append(entries, dataaccess.ItineraryEntry{RouteFilePath: loopName, Repetitions: loopCount})
+		itinerarySpec += "," + loopName + "*" + strconv.Itoa(loopCount)
+	}
 
-	route, err := dataaccess.LoadRoute(routeName)
+	itinerary, err := dataaccess.NewItinerary(routeName, entries)
 	if err != nil {
 		panic(err)
 	}
-	//fmt.Println("Route loaded")
 
-	loop, err := dataaccess.LoadRoute(loopName)
+	result, err := Simulate(itinerary, SimulationOptions{
+		InitialBatteryPercent: float64(battery),
+		TargetSpeedMph:        float64(targSpeed),
+		StartTime:             startT,
+		ShowProgress:          true,
+		Context:               ctx,
+	})
 	if err != nil {
 		panic(err)
 	}
-	//fmt.Println("Loop loaded")
-
-	//create step distance to calculate over from the total route length and tick count
-	totalRouteLength := 0.0
-	for _, section := range route.Sections {
-		totalRouteLength = ftToMeters(section.LengthFt)
-	}
-
-	//each step is 1/numticks the length of the whole route
-	var stepDistance float64 = 1 / float64(numTicks)
-	stepDistance *= totalRouteLength
 
-	targSpeedMps := mphToMps(float64(targSpeed))
-	//For each section we begin at a complete stop, thus initial velocity and acceleration are 0
-	var initialVelo float64 = 1
-	currentTickVelo := initialVelo
+	printSimulationSummary(result)
+	outputSimulationGraphs(result)
 
-	facingDirectionRadians := 0.0
-	vehicleAccel := 2.0  //placeholder
-	vehicleDecel := -3.0 //placeholder
+	if resultFilePath != "" {
+		run := NewSimulatedRun(itinerary.Name, itinerarySpec, result.Sections)
+		err = dataaccess.SaveSimulatedRun(resultFilePath, &run)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Saved result to", resultFilePath)
+	}
 
-	currentTickAccel := 0.0
+	if reportFilePath != "" {
+		err = ExportHtmlReport(reportFilePath, itinerary.Name, result.Sections, result.Legs)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Wrote report to", reportFilePath)
+	}
+}
 
-	prevVelo := initialVelo
-	var deltaTimeS = 0.0 //Graph or output time to traverse
-	var totalEnergyUsed = 0.0
-	var totalEnergyGained = 0.0
-	var maxAccel, minAccel, maxVelo, minVelo float64 = math.Inf(-1), math.Inf(1), math.Inf(-1), targSpeedMps
+func printSimulationSummary(result *SimulationResult) {
+	fmt.Println("Time Elapsed (s):", result.ElapsedSeconds)
+	fmt.Println("Energy Consumption (W):", result.TotalEnergyUsedJ-result.TotalEnergyGainedJ)
+	fmt.Println("Initial Velocity (m/s):", result.InitialVelocityMps)
+	fmt.Println("Final Velocity (m/s):", result.FinalVelocityMps)
+	fmt.Println("Max Velocity (m/s):", result.MaxVelocityMps)
+	fmt.Println("Min Velocity (m/s):", result.MinVelocityMps)
+	fmt.Println("Max Acceleration (m/s^2):", result.MaxAccelerationMps2)
+	fmt.Println("Min Acceleration (m/s^2):", result.MinAccelerationMps2)
+	//TODO: curvature and centripetal force are not simulated yet
+	fmt.Println("Max Centripetal Acceleration (m/s^2):", 0.0)
+	fmt.Println("Final Battery (%):", result.FinalBatteryPercent)
+	fmt.Println("Time in Town (s):", result.UrbanSeconds)
+	fmt.Println("Time Lost to Weather (s):", result.SafetyDelaySeconds)
+	for _, summary := range result.SafetyCaps {
+		fmt.Printf("  %s for %.1f mi, %.0f s lost\n", DescribeSafetyRule(summary.Rule), summary.DistanceFt/5280, summary.DelaySeconds)
+	}
+}
 
-	//Graphing
-	graphOutput := true
+func outputSimulationGraphs(result *SimulationResult) {
 	var energyUsedPlot plotter.XYs
 	var energyGainedPlot plotter.XYs
 	var veloPlot plotter.XYs
 	var accelPlot plotter.XYs
 	var batteryPlot plotter.XYs
-	var colorOffsetVar = 0.0
-	var trackDrawingVelocities = ""
-
-	//TODO: Handle loops and loop count
-
-	//fmt.Println("Starting simulation")
-
-	sectionsWithLoops := route.Sections
 
-	//Add Loops
-	for i := 0; i < loopCount; i++ {
-		sectionsWithLoops = append(sectionsWithLoops, loop.Sections...)
+	// The route colored by speed is drawn in the HTML report (see ExportHtmlReport())
+	for _, tick := range result.Ticks {
+		energyUsedPlot = append(energyUsedPlot, plotter.XY{X: tick.ElapsedSeconds, Y: tick.EnergyUsedJ})
+		energyGainedPlot = append(energyGainedPlot, plotter.XY{X: tick.ElapsedSeconds, Y: tick.EnergyGainedJ})
+		veloPlot = append(veloPlot, plotter.XY{X: tick.ElapsedSeconds, Y: tick.VelocityMps})
+		accelPlot = append(accelPlot, plotter.XY{X: tick.ElapsedSeconds, Y: tick.AccelerationMps2})
+		batteryPlot = append(batteryPlot, plotter.XY{X: tick.ElapsedSeconds, Y: tick.BatteryPercent})
 	}
 
-	//weather, err := dataaccess.GetWeather(&sectionsWithLoops[0], dataaccess.WeatherDataOptions{RefreshTimeSeconds: 600000000}) //TODO: adjust refresh time
-	///if err != nil {
-	//	panic(err)
-	//}
-
-	for j, section := range sectionsWithLoops {
-
-		loadString := `-\|/`
-		fmt.Printf("\n%c Section: %d / %d", loadString[j%4], j+1, len(sectionsWithLoops))
-
-		//fmt.Println("Calculating for section: ", j+1)
-
-		//if j > 50 { //Used to cap number of sections for testing
-		//	break
-		//}
-
-		//fmt.Println("Fetching weather and traffic data")
-		weather, err := dataaccess.GetWeather(&section, dataaccess.WeatherDataOptions{UsingWeatherCache: true, RefreshTimeSeconds: 60000000000000000}) //TODO: adjust refresh time
-		if err != nil {
-			panic(err)
-		}
-
-		//traffic, err := dataaccess.GetTraffic(section, dataaccess.TrafficDataOptions{RefreshRateSeconds: 60}) //TODO: adjust refresh rate
-		//if err != nil {
-		//	panic(err)
-		//}
-
-		facingDirectionRadians = calculateBearing(section.CoordinatesInitial, section.CoordinatesFinal) // direction estimation for section determined by difference between start and end point
-
-		windSpeed = mphToMps(weather.WindSpeedMph)
-		windDirectionRadians = weather.WindDirectionDegrees * math.Pi / 180
-
-		sectionSlope := (section.ElevationFinalFt - section.ElevationInitialFt) / section.LengthFt
-		sectionMaxSpeed := min(mphToMps(float64(section.SpeedLimitMph)), targSpeedMps)
-		//fmt.Println("Section Max Speed: ", sectionMaxSpeed)
-
-		for i := 0.0; i < ftToMeters(section.LengthFt); i += stepDistance {
-			//fmt.Println("Calculating for subsection: ", i)
-			currMaxSpeed := min(sectionMaxSpeed, mphToMps(60))
-
-			//basic acceleration model
-			if math.Abs(currMaxSpeed-currentTickVelo) < 0.1 {
-				currentTickAccel = 0
-			} else if math.Abs(currMaxSpeed-currentTickVelo) < 0.5 {
-				if currentTickVelo < currMaxSpeed {
-					currentTickAccel = vehicleAccel
-				} else {
-					currentTickAccel = vehicleDecel * 0.5
-				}
-			} else if currentTickVelo < currMaxSpeed {
-				currentTickAccel = vehicleAccel
-			} else if currentTickVelo > currMaxSpeed {
-				currentTickAccel = vehicleDecel
-			} else {
-				currentTickAccel = 0
-			}
-
-			timeToTravel := 0.0
-			if currentTickAccel != 0 {
-				timeToTravel = (-currentTickVelo + math.Sqrt(math.Pow(currentTickVelo, 2)+2*currentTickAccel*stepDistance)) / currentTickAccel
-			} else {
-				timeToTravel = stepDistance / currentTickVelo
-			}
-
-			//fmt.Println("Time to travel: ", timeToTravel, "s")
-			deltaTimeS += timeToTravel
-			currTime = currTime.Add(time.Duration(deltaTimeS) * time.Second)
-
-			prevVelo = currentTickVelo
-
-			currentTickVelo += currentTickAccel * timeToTravel
-			//fmt.Println("currentTickVelo: ", currentTickVelo, "m/s")
-			//fmt.Println("currentTickAccel: ", currentTickAccel, "m/s^2")
-
-			maxAccel = max(maxAccel, currentTickAccel)
-			minAccel = min(minAccel, currentTickAccel)
-			maxVelo = min(maxVelo, currentTickVelo) //TODO: not sure this is the correct method of setting the max speed, as before it was allowed to go beyond the "max speed" to take decelleration into account (?). Confirm with Jack
-			minVelo = min(minVelo, currentTickVelo)
-
-			//TODO: curvature and centripetal force, is this even possible with how we are storing route data?
-			var currentTickEnergy = max(0, -CalculateWorkDone(currentTickVelo, stepDistance, sectionSlope, prevVelo, facingDirectionRadians)) //Energy in Joules
-			if currentTickEnergy > 0 {
-				totalEnergyUsed += currentTickEnergy
-			}
-			//fmt.Println("Energy used: ", currentTickEnergy, "J")
-			//fmt.Println("Total Energy used: ", totalEnergyUsed, "J")
-
-			//energy gain from sun
-			instantaneousPower := min(430, SolarConstant*max(0, math.Cos(weather.SolarZenithDegrees*math.Pi/180))*CellEfficiency*(1-(weather.CloudCoverPercentage*0.01))*CellSize) * float64(Cells) * 0.00000000003 //Does not take into account changes in voltage / current from the system or from working in series
-			solarEnergyGain := instantaneousPower * timeToTravel
-
-			//fmt.Println("Instantaneous Power: ", instantaneousPower, "W")
-			//fmt.Println("Energy gained: ", solarEnergyGain, "J")
-
-			if solarEnergyGain > 0 {
-				totalEnergyGained += solarEnergyGain
-			}
-			//fmt.Println("Total Energy gained: ", totalEnergyGained, "J")
-
-			currBatteryPercent := min(100, ((initialBatteryCapmAh-jtomAh(totalEnergyUsed)+jtomAh(totalEnergyGained))/maxBatteryCapmAh)*100) //TODO: ensure this calculation is correct
-			//fmt.Println("Battery percent: ", currBatteryPercent, "%")
-
-			if graphOutput {
-				energyUsedPlot = append(energyUsedPlot, plotter.XY{X: deltaTimeS, Y: currentTickEnergy})
-				energyGainedPlot = append(energyGainedPlot, plotter.XY{X: deltaTimeS, Y: solarEnergyGain})
-				veloPlot = append(veloPlot, plotter.XY{X: deltaTimeS, Y: currentTickVelo})
-				accelPlot = append(accelPlot, plotter.XY{X: deltaTimeS, Y: currentTickAccel})
-				batteryPlot = append(batteryPlot, plotter.XY{X: deltaTimeS, Y: currBatteryPercent})
-
-			}
-			// if statment only needed to prevent printing final point
-			if graphOutput && colorOffsetVar/totalRouteLength <= 1.0 {
-				//max of 16 units of speed... can change scale later by putting in for denominator
-				const redDivisor = 16
-
-				const blue = 0
-				const green = 0
-				//converts and makes velocity string
-				colorOffsetStr := strconv.FormatFloat(colorOffsetVar/totalRouteLength, 'f', 4, 64)
-
-				trackDrawingVelocities += "<stop offset=\"" + colorOffsetStr + "\" style=\"stop-color:rgb(" + strconv.Itoa(int(math.Round(255*currentTickVelo/redDivisor))) + "," + strconv.Itoa(green) + "," + strconv.Itoa(blue) + ");stop-opacity:1\"/>\n"
-
-				colorOffsetVar += stepDistance
-			}
-		}
-	}
-	if graphOutput {
-		os.MkdirAll("./plots", 0755)
-		outputGraph(energyUsedPlot, "./plots/energyUsed.png")
-		outputGraph(energyGainedPlot, "./plots/energyGained.png")
-		outputGraph(veloPlot, "./plots/velocity.png")
-		outputGraph(accelPlot, "./plots/acceleration.png")
-		outputGraph(batteryPlot, "./plots/battery.png")
-	}
+	os.MkdirAll("./plots", 0755)
+	outputGraph(energyUsedPlot, "Energy Used", "Energy (J)", "./plots/energyUsed.png")
+	outputGraph(energyGainedPlot, "Energy Gained", "Energy (J)", "./plots/energyGained.png")
+	outputGraph(veloPlot, "Velocity", "Velocity (m/s)", "./plots/velocity.png")
+	outputGraph(accelPlot, "Acceleration", "Acceleration (m/s^2)", "./plots/acceleration.png")
+	outputGraph(batteryPlot, "Battery", "Battery (%)", "./plots/battery.png")
 }
@@ -0,0 +1,35 @@
+package phys
+
+import (
+	"math"
+	"testing"
+
+	"asc-simulation/types"
+)
+
+func TestCalculateBearing(t *testing.T) {
+	nashville := types.Coordinates{Latitude: 36.16, Longitude: -86.78}
+
+	tests := []struct {
+		name        string
+		end         types.Coordinates
+		wantDegrees float64
+	}{
+		{name: "north", end: types.Coordinates{Latitude: 36.26, Longitude: -86.78}, wantDegrees: 0},
+		{name: "east", end: types.Coordinates{Latitude: 36.16, Longitude: -86.68}, wantDegrees: 90},
+		{name: "south", end: types.Coordinates{Latitude: 36.06, Longitude: -86.78}, wantDegrees: 180},
+		{name: "west", end: types.Coordinates{Latitude: 36.16, Longitude: -86.88}, wantDegrees: 270},
+		{name: "northeast", end: types.Coordinates{Latitude: 36.2, Longitude: -86.73}, wantDegrees: 44.8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bearingDegrees := calculateBearing(nashville, test.end) * 180 / math.Pi
+			// 0 and 360 degrees are the same direction
+			difference := math.Mod(bearingDegrees-test.wantDegrees+540, 360) - 180
+			if math.Abs(difference) > 0.5 {
+				t.Errorf("bearing = %.1f degrees, want %.1f", bearingDegrees, test.wantDegrees)
+			}
+		})
+	}
+}
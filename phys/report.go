@@ -0,0 +1,256 @@
+package phys
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"asc-simulation/types"
+)
+
+/*
+The page and the JavaScript drawing its charts and map are all in one file, so the
+report can be opened or sent around without an internet connection.
+*/
+//go:embed report.html
+var reportTemplateHtml string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportTemplateHtml))
+
+// One row of a summary table
+type reportRow struct {
+	Name  string
+	Value string
+}
+
+type reportLeg struct {
+	Name          string
+	Day           int
+	Start         string
+	End           string
+	DistanceMi    string
+	AverageMph    string
+	Battery       string
+	EnergyUsedWh  string
+	EnergyGotWh   string
+	UrbanMinutes  string
+	WeatherDelay  string
+	SafetyDetails []string
+}
+
+// What is drawn for every section, kept short since there can be thousands of them
+type reportSection struct {
+	// Start and end along the itinerary in miles, and the times in ms since 1970 for JavaScript
+	StartMi   float64 `json:"d0"`
+	EndMi     float64 `json:"d1"`
+	StartTime int64   `json:"t0"`
+	EndTime   int64   `json:"t1"`
+	AvgMph    float64 `json:"v"`
+	TargetMph float64 `json:"vt"`
+	MaxMph    float64 `json:"vm"`
+	StartSoC  float64 `json:"b0"`
+	EndSoC    float64 `json:"b1"`
+	// Cumulative from the start of the report
+	UsedWh      float64 `json:"eu"`
+	GainedWh    float64 `json:"eg"`
+	StartElevFt float64 `json:"z0"`
+	EndElevFt   float64 `json:"z1"`
+	StartLat    float64 `json:"y0"`
+	StartLon    float64 `json:"x0"`
+	EndLat      float64 `json:"y1"`
+	EndLon      float64 `json:"x1"`
+	Leg         string  `json:"l"`
+	Instruction string  `json:"i"`
+}
+
+type reportPage struct {
+	Title     string
+	Generated string
+	Totals    []reportRow
+	Legs      []reportLeg
+	// Sections as JSON, read by the charts and the map
+	Data template.JS
+}
+
+/*
+Writes a single HTML file with interactive charts of speed, battery %, energy in and
+out and elevation against distance and time, a map of the route colored by speed
+and summary tables. sections and legs are the results of Simulate() or of every day
+of SimulateEvent(), in itinerary order.
+*/
+func ExportHtmlReport(filePath string, title string, sections []SectionResult, legs []LegResult) error {
+	functionErrMsg := errors.New("error exporting report to " + filePath)
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+	defer file.Close()
+
+	err = WriteHtmlReport(file, title, sections, legs)
+	if err != nil {
+		return errors.Join(functionErrMsg, err)
+	}
+
+	return nil
+}
+
+// Same as ExportHtmlReport(), but writes to any writer
+func WriteHtmlReport(writer io.Writer, title string, sections []SectionResult, legs []LegResult) error {
+	if len(sections) == 0 {
+		return errors.New("no sections to report on")
+	}
+
+	page := reportPage{
+		Title:     title,
+		Generated: time.Now().Format("2006-01-02 15:04"),
+		Totals:    reportTotals(sections, legs),
+	}
+
+	for i := range legs {
+		page.Legs = append(page.Legs, newReportLeg(&legs[i]))
+	}
+
+	data, err := json.Marshal(reportSections(sections))
+	if err != nil {
+		return err
+	}
+	page.Data = template.JS(data)
+
+	return reportTemplate.Execute(writer, page)
+}
+
+func reportSections(sections []SectionResult) []reportSection {
+	rows := make([]reportSection, 0, len(sections))
+	usedWh := 0.0
+	gainedWh := 0.0
+	for i := range sections {
+		section := &sections[i]
+		itinerarySection := section.Section
+		usedWh += section.EnergyUsedJ / 3600
+		gainedWh += section.EnergyGainedJ / 3600
+
+		rows = append(rows, reportSection{
+			StartMi:     round(itinerarySection.DistanceFt/5280, 3),
+			EndMi:       round((itinerarySection.DistanceFt+itinerarySection.LengthFt)/5280, 3),
+			StartTime:   section.StartTime.UnixMilli(),
+			EndTime:     section.EndTime.UnixMilli(),
+			AvgMph:      round(mpsToMph(section.AverageVelocityMps), 1),
+			TargetMph:   round(mpsToMph(section.TargetVelocityMps), 1),
+			MaxMph:      round(mpsToMph(section.MaxVelocityMps), 1),
+			StartSoC:    round(section.StartBatteryPercent, 2),
+			EndSoC:      round(section.EndBatteryPercent, 2),
+			UsedWh:      round(usedWh, 1),
+			GainedWh:    round(gainedWh, 1),
+			StartElevFt: round(itinerarySection.ElevationInitialFt, 0),
+			EndElevFt:   round(itinerarySection.ElevationFinalFt, 0),
+			StartLat:    round(itinerarySection.CoordinatesInitial.Latitude, 5),
+			StartLon:    round(itinerarySection.CoordinatesInitial.Longitude, 5),
+			EndLat:      round(itinerarySection.CoordinatesFinal.Latitude, 5),
+			EndLon:      round(itinerarySection.CoordinatesFinal.Longitude, 5),
+			Leg:         reportLegName(itinerarySection.Leg),
+			Instruction: itinerarySection.ExitInstruction,
+		})
+	}
+	return rows
+}
+
+func reportTotals(sections []SectionResult, legs []LegResult) []reportRow {
+	first := &sections[0]
+	last := &sections[len(sections)-1]
+
+	distanceFt := 0.0
+	drivingSeconds := 0.0
+	usedJ := 0.0
+	gainedJ := 0.0
+	maxVelocityMps := 0.0
+	for i := range sections {
+		distanceFt += sections[i].Section.LengthFt
+		drivingSeconds += sections[i].EndTime.Sub(sections[i].StartTime).Seconds()
+		usedJ += sections[i].EnergyUsedJ
+		gainedJ += sections[i].EnergyGainedJ
+		maxVelocityMps = math.Max(maxVelocityMps, sections[i].MaxVelocityMps)
+	}
+
+	urbanSeconds := 0.0
+	safetyDelaySeconds := 0.0
+	for _, leg := range legs {
+		urbanSeconds += leg.UrbanSeconds
+		safetyDelaySeconds += leg.SafetyDelaySeconds
+	}
+
+	averageMph := 0.0
+	if drivingSeconds > 0 {
+		averageMph = distanceFt / 5280 / (drivingSeconds / 3600)
+	}
+
+	return []reportRow{
+		{"Distance", fmt.Sprintf("%.1f mi", distanceFt/5280)},
+		{"Start", first.StartTime.Format("2006-01-02 15:04")},
+		{"Finish", last.EndTime.Format("2006-01-02 15:04")},
+		{"Driving time", formatReportDuration(drivingSeconds)},
+		{"Average speed", fmt.Sprintf("%.1f mph", averageMph)},
+		{"Top speed", fmt.Sprintf("%.1f mph", mpsToMph(maxVelocityMps))},
+		{"Battery", fmt.Sprintf("%.1f%% to %.1f%%", first.StartBatteryPercent, last.EndBatteryPercent)},
+		{"Energy used", fmt.Sprintf("%.0f Wh", usedJ/3600)},
+		{"Energy from the array", fmt.Sprintf("%.0f Wh", gainedJ/3600)},
+		{"Net energy", fmt.Sprintf("%.0f Wh", (usedJ-gainedJ)/3600)},
+		{"Time in town", formatReportDuration(urbanSeconds)},
+		{"Time lost to weather", formatReportDuration(safetyDelaySeconds)},
+	}
+}
+
+func newReportLeg(leg *LegResult) reportLeg {
+	drivingHours := leg.EndTime.Sub(leg.StartTime).Hours()
+	averageMph := 0.0
+	if drivingHours > 0 {
+		averageMph = leg.Leg.LengthFt / 5280 / drivingHours
+	}
+
+	row := reportLeg{
+		Name:         reportLegName(leg.Leg),
+		Day:          leg.Leg.Day + 1,
+		Start:        leg.StartTime.Format("15:04"),
+		End:          leg.EndTime.Format("15:04"),
+		DistanceMi:   fmt.Sprintf("%.1f", leg.Leg.LengthFt/5280),
+		AverageMph:   fmt.Sprintf("%.1f", averageMph),
+		Battery:      fmt.Sprintf("%.1f%% to %.1f%%", leg.StartBatteryPercent, leg.EndBatteryPercent),
+		EnergyUsedWh: fmt.Sprintf("%.0f", leg.EnergyUsedJ/3600),
+		EnergyGotWh:  fmt.Sprintf("%.0f", leg.EnergyGainedJ/3600),
+		UrbanMinutes: fmt.Sprintf("%.0f", leg.UrbanSeconds/60),
+		WeatherDelay: fmt.Sprintf("%.0f", leg.SafetyDelaySeconds/60),
+	}
+	for _, summary := range leg.SafetyCaps {
+		row.SafetyDetails = append(row.SafetyDetails, fmt.Sprintf("%s for %.1f mi, %.0f min lost",
+			DescribeSafetyRule(summary.Rule), summary.DistanceFt/5280, summary.DelaySeconds/60))
+	}
+	return row
+}
+
+func reportLegName(leg *types.ItineraryLeg) string {
+	if leg.Repetition > 0 {
+		return fmt.Sprintf("%s (lap %d)", leg.Route.Name, leg.Repetition)
+	}
+	return leg.Route.Name
+}
+
+// e.g. "5 h 12 min"
+func formatReportDuration(seconds float64) string {
+	minutes := int(math.Round(seconds / 60))
+	if minutes < 60 {
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return fmt.Sprintf("%d h %02d min", minutes/60, minutes%60)
+}
+
+// Keeps the embedded data small
+func round(value float64, digits int) float64 {
+	scale := math.Pow(10, float64(digits))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,110 @@
+package phys
+
+import (
+	"asc-simulation/types"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+Weather can force the driver to slow down below the speed limit, e.g. strong crosswinds
+on open plains. Each section is checked against the safety rules (see
+dataaccess.GetSafetyRules()), and the lowest max speed of the rules that apply wins.
+*/
+
+// The rule limiting the car's speed, and the weather that triggered it
+type SafetyCap struct {
+	Rule types.SafetyRule
+	// Value of the rule's condition, e.g. 23 for a 23 mph crosswind
+	Value float64
+}
+
+// How much one safety rule slowed the car down
+type SafetyCapSummary struct {
+	Rule         types.SafetyRule
+	DistanceFt   float64
+	DelaySeconds float64
+}
+
+// e.g. "crosswind >= 20 mph: 40 mph max"
+func DescribeSafetyRule(rule types.SafetyRule) string {
+	comparison, unit := ">=", "mph"
+	switch rule.Condition {
+	case types.RainCondition:
+		unit = "in"
+	case types.VisibilityCondition:
+		comparison, unit = "<=", "mi"
+	}
+	return fmt.Sprintf("%s %s %g %s: %g mph max", rule.Condition, comparison, rule.Threshold, unit, rule.MaxSpeedMph)
+}
+
+/*
+Returns the max speed allowed by the rules in the weather, driving in the direction
+of headingRadians, and the rule that sets it. Returns +Inf and nil if no rule applies.
+Conditions the weather doesn't have data for (0 gusts or visibility) never apply.
+*/
+func safetySpeedCap(rules []types.SafetyRule, weather *types.Weather, headingRadians float64) (float64, *SafetyCap) {
+	maxSpeedMps := math.Inf(1)
+	var binding *SafetyCap = nil
+
+	for _, rule := range rules {
+		value, known := safetyConditionValue(rule.Condition, weather, headingRadians)
+		if !known {
+			continue
+		}
+
+		applies := value >= rule.Threshold
+		if rule.Condition == types.VisibilityCondition {
+			applies = value <= rule.Threshold
+		}
+
+		if applies && mphToMps(rule.MaxSpeedMph) < maxSpeedMps {
+			maxSpeedMps = mphToMps(rule.MaxSpeedMph)
+			binding = &SafetyCap{Rule: rule, Value: value}
+		}
+	}
+
+	return maxSpeedMps, binding
+}
+
+func safetyConditionValue(condition types.SafetyCondition, weather *types.Weather, headingRadians float64) (float64, bool) {
+	switch condition {
+	case types.CrosswindCondition:
+		return crosswindMph(weather, headingRadians), true
+	case types.GustCondition:
+		return weather.WindGustMph, weather.WindGustMph > 0
+	case types.RainCondition:
+		return weather.RainOnGroundInches, true
+	case types.VisibilityCondition:
+		return weather.VisibilityMi, weather.VisibilityMi > 0
+	}
+	return 0, false
+}
+
+// Part of the wind blowing across the direction the car is driving in
+func crosswindMph(weather *types.Weather, headingRadians float64) float64 {
+	windDirectionRadians := weather.WindDirectionDegrees * math.Pi / 180
+	return math.Abs(weather.WindSpeedMph * math.Sin(windDirectionRadians-headingRadians))
+}
+
+// Adds a section's delay to the summary for its rule, keeping the summaries sorted by most delay first.
+func addSafetyCapSummary(summaries []SafetyCapSummary, rule types.SafetyRule, distanceFt float64, delaySeconds float64) []SafetyCapSummary {
+	found := false
+	for i := range summaries {
+		if summaries[i].Rule == rule {
+			summaries[i].DistanceFt += distanceFt
+			summaries[i].DelaySeconds += delaySeconds
+			found = true
+			break
+		}
+	}
+	if !found {
+		summaries = append(summaries, SafetyCapSummary{Rule: rule, DistanceFt: distanceFt, DelaySeconds: delaySeconds})
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].DelaySeconds > summaries[j].DelaySeconds
+	})
+	return summaries
+}
@@ -0,0 +1,109 @@
+package phys
+
+import (
+	"math"
+	"testing"
+
+	"asc-simulation/types"
+)
+
+func TestSafetySpeedCap(t *testing.T) {
+	rules := []types.SafetyRule{
+		{Condition: types.CrosswindCondition, Threshold: 20, MaxSpeedMph: 40},
+		{Condition: types.GustCondition, Threshold: 30, MaxSpeedMph: 45},
+		{Condition: types.GustCondition, Threshold: 40, MaxSpeedMph: 30},
+		{Condition: types.RainCondition, Threshold: 0.05, MaxSpeedMph: 45},
+		{Condition: types.VisibilityCondition, Threshold: 1, MaxSpeedMph: 40},
+		{Condition: types.VisibilityCondition, Threshold: 0.25, MaxSpeedMph: 20},
+	}
+	// Driving east
+	const headingRadians = math.Pi / 2
+
+	tests := []struct {
+		name    string
+		weather types.Weather
+		// 0 if no rule should apply
+		wantMaxSpeedMph float64
+		wantRule        types.SafetyRule
+		wantValue       float64
+	}{
+		{
+			// 0 means the weather source has no gust or visibility data, which would otherwise be the worst visibility there is
+			name:    "no gust or visibility data",
+			weather: types.Weather{},
+		},
+		{
+			name:    "good visibility",
+			weather: types.Weather{VisibilityMi: 10},
+		},
+		{
+			name:            "visibility at the threshold",
+			weather:         types.Weather{VisibilityMi: 1},
+			wantMaxSpeedMph: 40,
+			wantRule:        rules[4],
+			wantValue:       1,
+		},
+		{
+			name:            "visibility below both thresholds",
+			weather:         types.Weather{VisibilityMi: 0.2},
+			wantMaxSpeedMph: 20,
+			wantRule:        rules[5],
+			wantValue:       0.2,
+		},
+		{
+			name:    "gusts below the threshold",
+			weather: types.Weather{WindGustMph: 25},
+		},
+		{
+			name:    "headwind",
+			weather: types.Weather{WindSpeedMph: 25, WindDirectionDegrees: 90},
+		},
+		{
+			name:            "crosswind",
+			weather:         types.Weather{WindSpeedMph: 25, WindDirectionDegrees: 0},
+			wantMaxSpeedMph: 40,
+			wantRule:        rules[0],
+			wantValue:       25,
+		},
+		{
+			name:            "lowest cap wins",
+			weather:         types.Weather{WindSpeedMph: 25, WindDirectionDegrees: 180, WindGustMph: 45, RainOnGroundInches: 0.1, VisibilityMi: 0.5},
+			wantMaxSpeedMph: 30,
+			wantRule:        rules[2],
+			wantValue:       45,
+		},
+		{
+			name:            "first of equal caps wins",
+			weather:         types.Weather{WindGustMph: 35, RainOnGroundInches: 0.1},
+			wantMaxSpeedMph: 45,
+			wantRule:        rules[1],
+			wantValue:       35,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxSpeedMps, binding := safetySpeedCap(rules, &test.weather, headingRadians)
+
+			if test.wantMaxSpeedMph == 0 {
+				if !math.IsInf(maxSpeedMps, 1) || binding != nil {
+					t.Fatalf("safetySpeedCap() = %v m/s, %+v, want no cap", maxSpeedMps, binding)
+				}
+				return
+			}
+			if binding == nil {
+				t.Fatalf("safetySpeedCap() = %v m/s with no rule, want %v mph", maxSpeedMps, test.wantMaxSpeedMph)
+			}
+
+			if math.Abs(maxSpeedMps-mphToMps(test.wantMaxSpeedMph)) > 1e-9 {
+				t.Errorf("max speed = %v m/s, want %v mph", maxSpeedMps, test.wantMaxSpeedMph)
+			}
+			if binding.Rule != test.wantRule {
+				t.Errorf("rule = %+v, want %+v", binding.Rule, test.wantRule)
+			}
+			if math.Abs(binding.Value-test.wantValue) > 1e-9 {
+				t.Errorf("value = %v, want %v", binding.Value, test.wantValue)
+			}
+		})
+	}
+}
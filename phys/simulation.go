@@ -0,0 +1,485 @@
+package phys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+/*
+This struct exists so we can add inputs to Simulate() without having to
+change the code everywhere Simulate() is used.
+*/
+type SimulationOptions struct {
+	InitialBatteryPercent float64
+	TargetSpeedMph        float64
+	StartTime             time.Time
+	// Only simulate part of the itinerary, starting at this index into Itinerary.Sections
+	StartSectionIndex int
+	// Number of sections to simulate. 0 simulates every remaining section.
+	SectionCount int
+	// Prints the section currently being simulated (used by the GUI)
+	ShowProgress bool
+	// Speed limits for bad weather. nil uses dataaccess.GetSafetyRules().
+	SafetyRules []types.SafetyRule
+	// Car being simulated. nil uses LoadVehicle().
+	Vehicle *types.Vehicle
+	// How weather is looked up. nil uses simulationWeatherOptions(), which never refreshes cached weather.
+	WeatherOptions *dataaccess.WeatherDataOptions
+	// Cancels weather and traffic API calls, e.g. when the user presses Ctrl-C. nil never cancels.
+	Context context.Context
+}
+
+// State of the car at the end of one step of the simulation
+type SimulationTick struct {
+	ElapsedSeconds   float64
+	Time             time.Time
+	DistanceM        float64
+	VelocityMps      float64
+	AccelerationMps2 float64
+	EnergyUsedJ      float64
+	EnergyGainedJ    float64
+	BatteryPercent   float64
+	// Index into Itinerary.Sections
+	SectionPosition int
+	// Set if a weather safety rule limited the speed
+	SafetyCap *SafetyCap
+}
+
+type LegResult struct {
+	Leg                 *types.ItineraryLeg
+	StartTime           time.Time
+	EndTime             time.Time
+	StartBatteryPercent float64
+	EndBatteryPercent   float64
+	EnergyUsedJ         float64
+	EnergyGainedJ       float64
+	// Time spent in sections classified as urban
+	UrbanSeconds float64
+	// Time lost to weather safety rules, and which rules cost it
+	SafetyDelaySeconds float64
+	SafetyCaps         []SafetyCapSummary
+}
+
+// Totals for one itinerary section
+type SectionResult struct {
+	Section            *types.ItinerarySection
+	StartTime          time.Time
+	EndTime            time.Time
+	AverageVelocityMps float64
+	MaxVelocityMps     float64
+	// Speed the car aims for on the section, after speed limits, traffic and weather
+	TargetVelocityMps   float64
+	EnergyUsedJ         float64
+	EnergyGainedJ       float64
+	StartBatteryPercent float64
+	EndBatteryPercent   float64
+	Weather             types.Weather
+	// nil if there was no traffic data for the section
+	Traffic *types.Traffic
+	// True if the section is in town (see classifyCongestion())
+	Urban bool
+	// Set if a weather safety rule limited the speed
+	SafetyCap *SafetyCap
+	// Time lost to the safety rule, compared to driving at the speed it would have otherwise
+	SafetyDelaySeconds float64
+}
+
+type SimulationResult struct {
+	Itinerary *types.Itinerary
+	Ticks     []SimulationTick
+	Sections  []SectionResult
+	Legs      []LegResult
+
+	ElapsedSeconds      float64
+	EndTime             time.Time
+	TotalEnergyUsedJ    float64
+	TotalEnergyGainedJ  float64
+	FinalBatteryPercent float64
+	// Time spent in sections classified as urban
+	UrbanSeconds float64
+	// Time lost to weather safety rules, and which rules cost it
+	SafetyDelaySeconds float64
+	SafetyCaps         []SafetyCapSummary
+
+	InitialVelocityMps  float64
+	FinalVelocityMps    float64
+	MaxVelocityMps      float64
+	MinVelocityMps      float64
+	MaxAccelerationMps2 float64
+	MinAccelerationMps2 float64
+}
+
+/*
+Weather is fetched for the time the car is predicted to be halfway through each section.
+If the simulated time ends up further than this from the prediction, the section is
+simulated again with weather for the new time.
+*/
+const weatherTimeTolerance = 5 * time.Minute
+
+// Gives up on matching the weather time to the simulated time after this many tries
+const maxWeatherIterations = 3
+
+// State of the car that carries over from one section to the next
+type simulationState struct {
+	velocityMps         float64
+	accelerationMps2    float64
+	elapsedSeconds      float64
+	distanceM           float64
+	totalEnergyUsedJ    float64
+	totalEnergyGainedJ  float64
+	batteryPercent      float64
+	maxAccelerationMps2 float64
+	minAccelerationMps2 float64
+	maxVelocityMps      float64
+	minVelocityMps      float64
+}
+
+// Simulates driving every leg of the itinerary back to back, starting at options.StartTime.
+func Simulate(itinerary *types.Itinerary, options SimulationOptions) (*SimulationResult, error) {
+	if itinerary == nil || len(itinerary.Sections) == 0 {
+		return nil, errors.New("cannot simulate an empty itinerary")
+	}
+
+	firstSection := options.StartSectionIndex
+	lastSection := len(itinerary.Sections) - 1
+	if options.SectionCount > 0 {
+		lastSection = firstSection + options.SectionCount - 1
+	}
+	if firstSection < 0 || firstSection > lastSection || lastSection >= len(itinerary.Sections) {
+		return nil, errors.New("sections to simulate are outside of the itinerary")
+	}
+
+	//TODO: implement acceleration curve
+
+	congestion, err := classifyCongestion(itinerary)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Vehicle == nil {
+		vehicle, err := LoadVehicle()
+		if err != nil {
+			return nil, err
+		}
+		options.Vehicle = vehicle
+	}
+
+	if options.WeatherOptions == nil {
+		weatherOptions := simulationWeatherOptions(options.Context)
+		options.WeatherOptions = &weatherOptions
+	} else if options.WeatherOptions.Context == nil {
+		weatherOptions := *options.WeatherOptions
+		weatherOptions.Context = options.Context
+		options.WeatherOptions = &weatherOptions
+	}
+
+	trafficOptions := dataaccess.DefaultTrafficDataOptions()
+	trafficOptions.Context = options.Context
+
+	if options.SafetyRules == nil {
+		safetyRules, err := dataaccess.GetSafetyRules()
+		if err != nil {
+			return nil, err
+		}
+		options.SafetyRules = safetyRules
+	}
+
+	totalLengthFt := 0.0
+	for j := firstSection; j <= lastSection; j++ {
+		totalLengthFt += itinerary.Sections[j].LengthFt
+	}
+
+	//each step is 1/numticks the length of the simulated sections
+	var stepDistance float64 = 1 / float64(numTicks)
+	stepDistance *= ftToMeters(totalLengthFt)
+
+	targSpeedMps := mphToMps(options.TargetSpeedMph)
+	//For each section we begin at a complete stop, thus initial velocity and acceleration are 0
+	var initialVelo float64 = 1
+
+	state := simulationState{
+		velocityMps:         initialVelo,
+		batteryPercent:      options.InitialBatteryPercent,
+		maxAccelerationMps2: math.Inf(-1),
+		minAccelerationMps2: math.Inf(1),
+		maxVelocityMps:      math.Inf(-1),
+		minVelocityMps:      targSpeedMps,
+	}
+
+	result := SimulationResult{
+		Itinerary:          itinerary,
+		InitialVelocityMps: initialVelo,
+	}
+
+	var currentLeg *LegResult = nil
+
+	for j := firstSection; j <= lastSection; j++ {
+		section := &itinerary.Sections[j]
+		arrivalTime := options.StartTime.Add(secondsToDuration(state.elapsedSeconds))
+
+		if currentLeg == nil || currentLeg.Leg != section.Leg {
+			result.Legs = append(result.Legs, LegResult{
+				Leg:                 section.Leg,
+				StartTime:           arrivalTime,
+				StartBatteryPercent: state.batteryPercent,
+			})
+			currentLeg = &result.Legs[len(result.Legs)-1]
+		}
+
+		if options.ShowProgress {
+			loadString := `-\|/`
+			fmt.Printf("\n%c Section: %d / %d", loadString[j%4], j-firstSection+1, lastSection-firstSection+1)
+		}
+
+		traffic, err := dataaccess.GetTrafficAtTime(section.RouteSection, arrivalTime, trafficOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		sectionMaxSpeed := min(mphToMps(float64(section.SpeedLimitMph)), targSpeedMps)
+		// Can't drive faster than the cars around us
+		if traffic != nil && traffic.FlowSpeedMph > 0 {
+			sectionMaxSpeed = min(sectionMaxSpeed, mphToMps(traffic.FlowSpeedMph))
+		} else if congestion[j].urban {
+			sectionMaxSpeed *= urbanSpeedFactor(arrivalTime, congestion[j].location)
+		}
+
+		// First guess: driving the whole section at its max speed
+		weatherTime := arrivalTime
+		if sectionMaxSpeed > 0 {
+			weatherTime = arrivalTime.Add(secondsToDuration(ftToMeters(section.LengthFt) / sectionMaxSpeed / 2))
+		}
+
+		var sectionResult SectionResult
+		var ticks []SimulationTick
+		var nextState simulationState
+
+		for attempt := 1; ; attempt++ {
+			//fmt.Println("Fetching weather data")
+			weather, err := dataaccess.GetWeatherAtTime(section.RouteSection, weatherTime, *options.WeatherOptions)
+			if err != nil {
+				return nil, err
+			}
+
+			nextState = state
+			sectionResult, ticks = simulateSection(&nextState, section, weather, sectionMaxSpeed, congestion[j], stepDistance, options)
+
+			midpointTime := sectionResult.StartTime.Add(sectionResult.EndTime.Sub(sectionResult.StartTime) / 2)
+			if midpointTime.Sub(weatherTime).Abs() <= weatherTimeTolerance || attempt >= maxWeatherIterations {
+				break
+			}
+			weatherTime = midpointTime
+		}
+
+		sectionResult.Traffic = traffic
+
+		state = nextState
+		result.Ticks = append(result.Ticks, ticks...)
+		result.Sections = append(result.Sections, sectionResult)
+
+		currentLeg.EnergyUsedJ += sectionResult.EnergyUsedJ
+		currentLeg.EnergyGainedJ += sectionResult.EnergyGainedJ
+		if sectionResult.Urban {
+			urbanSeconds := sectionResult.EndTime.Sub(sectionResult.StartTime).Seconds()
+			currentLeg.UrbanSeconds += urbanSeconds
+			result.UrbanSeconds += urbanSeconds
+		}
+		if sectionResult.SafetyCap != nil {
+			rule := sectionResult.SafetyCap.Rule
+			currentLeg.SafetyDelaySeconds += sectionResult.SafetyDelaySeconds
+			currentLeg.SafetyCaps = addSafetyCapSummary(currentLeg.SafetyCaps, rule, section.LengthFt, sectionResult.SafetyDelaySeconds)
+			result.SafetyDelaySeconds += sectionResult.SafetyDelaySeconds
+			result.SafetyCaps = addSafetyCapSummary(result.SafetyCaps, rule, section.LengthFt, sectionResult.SafetyDelaySeconds)
+		}
+		currentLeg.EndTime = sectionResult.EndTime
+		currentLeg.EndBatteryPercent = state.batteryPercent
+	}
+
+	if options.ShowProgress {
+		fmt.Println()
+	}
+
+	result.ElapsedSeconds = state.elapsedSeconds
+	result.EndTime = options.StartTime.Add(secondsToDuration(state.elapsedSeconds))
+	result.TotalEnergyUsedJ = state.totalEnergyUsedJ
+	result.TotalEnergyGainedJ = state.totalEnergyGainedJ
+	result.FinalBatteryPercent = state.batteryPercent
+	result.FinalVelocityMps = state.velocityMps
+	result.MaxVelocityMps = state.maxVelocityMps
+	result.MinVelocityMps = state.minVelocityMps
+	result.MaxAccelerationMps2 = state.maxAccelerationMps2
+	result.MinAccelerationMps2 = state.minAccelerationMps2
+
+	return &result, nil
+}
+
+// Drives one section with the given weather, updating state as it goes.
+func simulateSection(
+	state *simulationState,
+	section *types.ItinerarySection,
+	weather *types.Weather,
+	sectionMaxSpeed float64,
+	congestion sectionCongestion,
+	stepDistance float64,
+	options SimulationOptions,
+) (SectionResult, []SimulationTick) {
+	//maxBatteryCapmAh := vehicle.BatteryCapacityMilliamps
+	maxBatteryCapmAh := batteryCapacitymAh
+	initialBatteryCapmAh := maxBatteryCapmAh * (options.InitialBatteryPercent * 0.01)
+
+	vehicleAccel := 2.0  //placeholder
+	vehicleDecel := -3.0 //placeholder
+
+	facingDirectionRadians := calculateBearing(section.CoordinatesInitial, section.CoordinatesFinal) // direction estimation for section determined by difference between start and end point
+
+	sectionResult := SectionResult{
+		Section:             section,
+		StartTime:           options.StartTime.Add(secondsToDuration(state.elapsedSeconds)),
+		StartBatteryPercent: state.batteryPercent,
+		Weather:             *weather,
+	}
+	sectionStartS := state.elapsedSeconds
+	ticks := make([]SimulationTick, 0)
+
+	sectionSlope := (section.ElevationFinalFt - section.ElevationInitialFt) / section.LengthFt
+	sectionLength := ftToMeters(section.LengthFt)
+
+	// The weather and direction don't change within a section, so neither do the safety rules that apply
+	uncappedMaxSpeed := min(sectionMaxSpeed, mphToMps(60))
+	safetyMaxSpeed, safetyCap := safetySpeedCap(options.SafetyRules, weather, facingDirectionRadians)
+	sectionResult.TargetVelocityMps = min(uncappedMaxSpeed, safetyMaxSpeed)
+	if safetyMaxSpeed < uncappedMaxSpeed && uncappedMaxSpeed > 0 {
+		sectionResult.SafetyCap = safetyCap
+		sectionResult.SafetyDelaySeconds = sectionLength/safetyMaxSpeed - sectionLength/uncappedMaxSpeed
+	} else {
+		safetyCap = nil
+	}
+
+	for i := 0.0; i < sectionLength; i += stepDistance {
+		// The last step of a section only covers what is left of it
+		tickDistance := min(stepDistance, sectionLength-i)
+		currMaxSpeed := min(uncappedMaxSpeed, safetyMaxSpeed)
+		currentTickVelo := state.velocityMps
+		currentTickAccel := 0.0
+
+		//basic acceleration model
+		if math.Abs(currMaxSpeed-currentTickVelo) < 0.1 {
+			currentTickAccel = 0
+		} else if math.Abs(currMaxSpeed-currentTickVelo) < 0.5 {
+			if currentTickVelo < currMaxSpeed {
+				currentTickAccel = vehicleAccel
+			} else {
+				currentTickAccel = vehicleDecel * 0.5
+			}
+		} else if currentTickVelo < currMaxSpeed {
+			currentTickAccel = vehicleAccel
+		} else if currentTickVelo > currMaxSpeed {
+			currentTickAccel = vehicleDecel
+		} else {
+			currentTickAccel = 0
+		}
+
+		// Distance it takes to reach the max speed. The car holds that speed for the rest of the step.
+		distanceToMaxSpeed := math.Inf(1)
+		if currentTickAccel != 0 {
+			distanceToMaxSpeed = (math.Pow(currMaxSpeed, 2) - math.Pow(currentTickVelo, 2)) / (2 * currentTickAccel)
+		}
+
+		timeToTravel := 0.0
+		nextTickVelo := currentTickVelo
+		if currMaxSpeed > 0 && distanceToMaxSpeed >= 0 && distanceToMaxSpeed < tickDistance {
+			timeToTravel = (currMaxSpeed-currentTickVelo)/currentTickAccel + (tickDistance-distanceToMaxSpeed)/currMaxSpeed
+			nextTickVelo = currMaxSpeed
+		} else if currentTickAccel != 0 && math.Pow(currentTickVelo, 2)+2*currentTickAccel*tickDistance > 0 {
+			timeToTravel = (-currentTickVelo + math.Sqrt(math.Pow(currentTickVelo, 2)+2*currentTickAccel*tickDistance)) / currentTickAccel
+			nextTickVelo += currentTickAccel * timeToTravel
+		} else {
+			currentTickAccel = 0
+			timeToTravel = tickDistance / currentTickVelo
+		}
+
+		state.elapsedSeconds += timeToTravel
+		state.distanceM += tickDistance
+
+		prevVelo := currentTickVelo
+
+		currentTickVelo = nextTickVelo
+		state.velocityMps = currentTickVelo
+		state.accelerationMps2 = currentTickAccel
+
+		state.maxAccelerationMps2 = max(state.maxAccelerationMps2, currentTickAccel)
+		state.minAccelerationMps2 = min(state.minAccelerationMps2, currentTickAccel)
+		state.maxVelocityMps = max(state.maxVelocityMps, currentTickVelo) //TODO: not sure this is the correct method of setting the max speed, as before it was allowed to go beyond the "max speed" to take decelleration into account (?). Confirm with Jack
+		state.minVelocityMps = min(state.minVelocityMps, currentTickVelo)
+
+		//TODO: curvature and centripetal force, is this even possible with how we are storing route data?
+		var currentTickEnergy = max(0, CalculateWorkDone(options.Vehicle, weather, currentTickVelo, tickDistance, sectionSlope, prevVelo, facingDirectionRadians)) //Energy in Joules
+		if currentTickEnergy > 0 {
+			state.totalEnergyUsedJ += currentTickEnergy
+			sectionResult.EnergyUsedJ += currentTickEnergy
+		}
+
+		//energy gain from sun
+		solarEnergyGain := solarPowerWatts(options.Vehicle, weather) * timeToTravel
+
+		if solarEnergyGain > 0 {
+			state.totalEnergyGainedJ += solarEnergyGain
+			sectionResult.EnergyGainedJ += solarEnergyGain
+		}
+
+		state.batteryPercent = min(100, ((initialBatteryCapmAh-jtomAh(state.totalEnergyUsedJ)+jtomAh(state.totalEnergyGainedJ))/maxBatteryCapmAh)*100) //TODO: ensure this calculation is correct
+
+		sectionResult.MaxVelocityMps = max(sectionResult.MaxVelocityMps, currentTickVelo)
+
+		ticks = append(ticks, SimulationTick{
+			ElapsedSeconds:   state.elapsedSeconds,
+			Time:             options.StartTime.Add(secondsToDuration(state.elapsedSeconds)),
+			DistanceM:        state.distanceM,
+			VelocityMps:      currentTickVelo,
+			AccelerationMps2: currentTickAccel,
+			EnergyUsedJ:      currentTickEnergy,
+			EnergyGainedJ:    solarEnergyGain,
+			BatteryPercent:   state.batteryPercent,
+			SectionPosition:  section.Position,
+			SafetyCap:        safetyCap,
+		})
+	}
+
+	// Stopping at lights and junctions in town, at the speed the car was actually allowed to drive
+	sectionResult.Urban = congestion.urban
+	stopEnergyJ, stopSeconds := stopAndGoPenalty(congestion.stops, sectionResult.TargetVelocityMps, vehicleAccel, -vehicleDecel)
+	if stopEnergyJ > 0 {
+		// The array keeps charging while the car is stopped
+		stopSolarGainJ := max(0, solarPowerWatts(options.Vehicle, weather)) * stopSeconds
+
+		state.elapsedSeconds += stopSeconds
+		state.totalEnergyUsedJ += stopEnergyJ
+		state.totalEnergyGainedJ += stopSolarGainJ
+		sectionResult.EnergyUsedJ += stopEnergyJ
+		sectionResult.EnergyGainedJ += stopSolarGainJ
+		state.batteryPercent = min(100, ((initialBatteryCapmAh-jtomAh(state.totalEnergyUsedJ)+jtomAh(state.totalEnergyGainedJ))/maxBatteryCapmAh)*100)
+	}
+
+	sectionResult.EndTime = options.StartTime.Add(secondsToDuration(state.elapsedSeconds))
+	sectionResult.EndBatteryPercent = state.batteryPercent
+	if state.elapsedSeconds > sectionStartS {
+		sectionResult.AverageVelocityMps = sectionLength / (state.elapsedSeconds - sectionStartS)
+	}
+
+	return sectionResult, ticks
+}
+
+// The weather options used everywhere in the simulation. ctx cancels API calls, nil never cancels.
+func simulationWeatherOptions(ctx context.Context) dataaccess.WeatherDataOptions {
+	return dataaccess.WeatherDataOptions{UsingWeatherCache: true, RefreshTimeSeconds: 60000000000000000, Context: ctx} //TODO: adjust refresh time
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,248 @@
+package phys
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Saves the routes to route files and lays them out end to end with dataaccess.NewItinerary(), the way commands load them
+func testItinerary(t *testing.T, routes ...types.Route) *types.Itinerary {
+	t.Helper()
+
+	folder := t.TempDir()
+	entries := make([]dataaccess.ItineraryEntry, 0, len(routes))
+	for i, route := range routes {
+		data, err := json.Marshal(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routeFilePath := filepath.Join(folder, strconv.Itoa(i)+".route.json")
+		err = os.WriteFile(routeFilePath, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, dataaccess.ItineraryEntry{RouteFilePath: routeFilePath})
+	}
+
+	itinerary, err := dataaccess.NewItinerary("test", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return itinerary
+}
+
+/*
+A road heading east from Nashville with a section for each length. elevationsFt has
+the elevation at the start of every section and at the end of the road, nil is flat.
+*/
+func testStraightRoute(name string, lengthsFt []float64, elevationsFt []float64) types.Route {
+	// Near enough at this latitude
+	const ftPerDegreeLongitude = 295000.0
+
+	route := types.Route{Name: name}
+	distanceFt := 0.0
+	for i, lengthFt := range lengthsFt {
+		section := types.RouteSection{
+			SpeedLimitMph:      65,
+			LengthFt:           lengthFt,
+			ElevationInitialFt: 500,
+			ElevationFinalFt:   500,
+			CoordinatesInitial: types.Coordinates{Latitude: 36.16, Longitude: -86.78 + distanceFt/ftPerDegreeLongitude},
+			CoordinatesFinal:   types.Coordinates{Latitude: 36.16, Longitude: -86.78 + (distanceFt+lengthFt)/ftPerDegreeLongitude},
+			InstructionCode:    types.Depart,
+			PositionInRoute:    i,
+		}
+		if elevationsFt != nil {
+			section.ElevationInitialFt = elevationsFt[i]
+			section.ElevationFinalFt = elevationsFt[i+1]
+		}
+		route.Sections = append(route.Sections, section)
+		distanceFt += lengthFt
+	}
+	return route
+}
+
+func TestSimulateSectionStepKinematics(t *testing.T) {
+	itinerary := testItinerary(t, testStraightRoute("step test", []float64{100 / 0.3048}, nil))
+	weather := types.Weather{SolarZenithDegrees: 30, AirTempDegreesF: 80}
+
+	tests := []struct {
+		name        string
+		startMps    float64
+		maxMps      float64
+		wantEndMps  float64
+		wantSeconds float64
+		// Step lengths to try. The result shouldn't depend on them.
+		stepsM []float64
+	}{
+		{
+			name:        "slows to a lower max speed part way through",
+			startMps:    25,
+			maxMps:      15,
+			wantEndMps:  15,
+			wantSeconds: 10.0/3 + (100-400.0/6)/15,
+			stepsM:      []float64{100, 10},
+		},
+		{
+			name:        "speeds up to a higher max speed part way through",
+			startMps:    10,
+			maxMps:      20,
+			wantEndMps:  20,
+			wantSeconds: 5 + 25.0/20,
+			stepsM:      []float64{100, 10},
+		},
+		{
+			name:        "reaches the max speed at the end",
+			startMps:    25,
+			maxMps:      5,
+			wantEndMps:  5,
+			wantSeconds: 20.0 / 3,
+			stepsM:      []float64{100, 50},
+		},
+		{
+			name:        "doesn't reach the max speed",
+			startMps:    10,
+			maxMps:      25,
+			wantEndMps:  math.Sqrt(500),
+			wantSeconds: (math.Sqrt(500) - 10) / 2,
+			stepsM:      []float64{100, 50},
+		},
+		{
+			name:        "already at the max speed",
+			startMps:    15,
+			maxMps:      15,
+			wantEndMps:  15,
+			wantSeconds: 100.0 / 15,
+			stepsM:      []float64{100, 10},
+		},
+	}
+
+	for _, test := range tests {
+		for _, stepM := range test.stepsM {
+			t.Run(test.name+", "+strconv.FormatFloat(stepM, 'f', -1, 64)+" m steps", func(t *testing.T) {
+				state := simulationState{
+					velocityMps:    test.startMps,
+					batteryPercent: 100,
+					maxVelocityMps: math.Inf(-1),
+					minVelocityMps: test.startMps,
+				}
+				vehicle := DefaultVehicle()
+				options := SimulationOptions{InitialBatteryPercent: 100, StartTime: time.Date(2024, 6, 20, 14, 0, 0, 0, time.UTC), Vehicle: &vehicle}
+
+				sectionResult, ticks := simulateSection(&state, &itinerary.Sections[0], &weather, test.maxMps, sectionCongestion{}, stepM, options)
+
+				if math.Abs(state.velocityMps-test.wantEndMps) > 1e-9 {
+					t.Errorf("end speed = %v m/s, want %v", state.velocityMps, test.wantEndMps)
+				}
+				if math.Abs(state.elapsedSeconds-test.wantSeconds) > 1e-9 {
+					t.Errorf("took %v s, want %v", state.elapsedSeconds, test.wantSeconds)
+				}
+				if math.Abs(state.distanceM-100) > 1e-9 {
+					t.Errorf("drove %v m, want 100", state.distanceM)
+				}
+
+				// The car never goes past the max speed or the speed it started at
+				lowestMps, highestMps := min(test.startMps, test.maxMps), max(test.startMps, test.maxMps)
+				energyUsedJ, energyGainedJ := 0.0, 0.0
+				topMps, bottomMps := math.Inf(-1), test.startMps
+				for _, tick := range ticks {
+					if tick.VelocityMps < lowestMps-1e-9 || tick.VelocityMps > highestMps+1e-9 {
+						t.Errorf("speed %v m/s at %v m, want %v-%v", tick.VelocityMps, tick.DistanceM, lowestMps, highestMps)
+					}
+					topMps, bottomMps = max(topMps, tick.VelocityMps), min(bottomMps, tick.VelocityMps)
+					energyUsedJ += tick.EnergyUsedJ
+					energyGainedJ += tick.EnergyGainedJ
+				}
+				if state.maxVelocityMps != topMps || state.minVelocityMps != bottomMps {
+					t.Errorf("speed range %v-%v m/s, want %v-%v", state.minVelocityMps, state.maxVelocityMps, bottomMps, topMps)
+				}
+
+				if math.Abs(energyUsedJ-sectionResult.EnergyUsedJ) > 1e-6 || math.Abs(energyUsedJ-state.totalEnergyUsedJ) > 1e-6 {
+					t.Errorf("steps used %v J, section %v J, total %v J", energyUsedJ, sectionResult.EnergyUsedJ, state.totalEnergyUsedJ)
+				}
+				// The array charges for as long as the section took
+				wantGainedJ := solarPowerWatts(&vehicle, &weather) * state.elapsedSeconds
+				if math.Abs(energyGainedJ-wantGainedJ) > 1e-9*max(1, wantGainedJ) {
+					t.Errorf("array made %v J, want %v", energyGainedJ, wantGainedJ)
+				}
+				if math.Abs(sectionResult.AverageVelocityMps-100/test.wantSeconds) > 1e-9 {
+					t.Errorf("AverageVelocityMps = %v, want %v", sectionResult.AverageVelocityMps, 100/test.wantSeconds)
+				}
+			})
+		}
+	}
+}
+
+func TestSimulateSectionStopAndGo(t *testing.T) {
+	itinerary := testItinerary(t, testStraightRoute("stop and go test", []float64{100 / 0.3048}, nil))
+	rainRule := types.SafetyRule{Condition: types.RainCondition, Threshold: 0.05, MaxSpeedMph: 20}
+
+	tests := []struct {
+		name    string
+		weather types.Weather
+		// Speed the car drives the section at, and stops from
+		wantTargetMps float64
+	}{
+		{
+			name:          "no safety cap",
+			weather:       types.Weather{SolarZenithDegrees: 30, AirTempDegreesF: 80},
+			wantTargetMps: 25,
+		},
+		{
+			name:          "capped for rain",
+			weather:       types.Weather{SolarZenithDegrees: 30, AirTempDegreesF: 80, RainOnGroundInches: 0.1},
+			wantTargetMps: mphToMps(20),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := simulationState{
+				velocityMps:    test.wantTargetMps,
+				batteryPercent: 100,
+				maxVelocityMps: math.Inf(-1),
+				minVelocityMps: test.wantTargetMps,
+			}
+			vehicle := DefaultVehicle()
+			options := SimulationOptions{
+				InitialBatteryPercent: 100,
+				StartTime:             time.Date(2024, 6, 20, 14, 0, 0, 0, time.UTC),
+				Vehicle:               &vehicle,
+				SafetyRules:           []types.SafetyRule{rainRule},
+			}
+			congestion := sectionCongestion{urban: true, stops: 1}
+
+			sectionResult, ticks := simulateSection(&state, &itinerary.Sections[0], &test.weather, 25, congestion, 10, options)
+
+			if math.Abs(sectionResult.TargetVelocityMps-test.wantTargetMps) > 1e-9 {
+				t.Fatalf("TargetVelocityMps = %v, want %v", sectionResult.TargetVelocityMps, test.wantTargetMps)
+			}
+
+			drivingEnergyJ := 0.0
+			for _, tick := range ticks {
+				drivingEnergyJ += tick.EnergyUsedJ
+			}
+			wantStopEnergyJ, wantStopSeconds := stopAndGoPenalty(1, test.wantTargetMps, 2, 3)
+			if math.Abs(sectionResult.EnergyUsedJ-drivingEnergyJ-wantStopEnergyJ) > 1e-6 {
+				t.Errorf("stop and go used %v J, want %v", sectionResult.EnergyUsedJ-drivingEnergyJ, wantStopEnergyJ)
+			}
+			if math.Abs(state.elapsedSeconds-100/test.wantTargetMps-wantStopSeconds) > 1e-9 {
+				t.Errorf("section took %v s, want %v", state.elapsedSeconds, 100/test.wantTargetMps+wantStopSeconds)
+			}
+
+			// The array charges while the car waits at stops too
+			wantGainedJ := solarPowerWatts(&vehicle, &test.weather) * state.elapsedSeconds
+			if math.Abs(sectionResult.EnergyGainedJ-wantGainedJ) > 1e-9*wantGainedJ || math.Abs(state.totalEnergyGainedJ-wantGainedJ) > 1e-9*wantGainedJ {
+				t.Errorf("array made %v J (total %v J), want %v", sectionResult.EnergyGainedJ, state.totalEnergyGainedJ, wantGainedJ)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package phys
+
+import (
+	"errors"
+	"os"
+
+	"asc-simulation/dataaccess"
+	"asc-simulation/types"
+)
+
+// Vehicle file used when VEHICLE_FILE isn't set, if it exists
+const defaultVehicleFilePath = "vehicle.json"
+
+// Best guesses at the car, used until "vehicle fit" has fitted them to telemetry
+func DefaultVehicle() types.Vehicle {
+	return types.Vehicle{
+		SolarPanelPowerWatts:         PanelWattage,
+		WheelCircumferenceInches:     1.875216 / 0.0254,
+		BatteryCapacityMilliamps:     batteryCapacitymAh,
+		CellCount:                    Cells,
+		CellEfficiency:               CellEfficiency,
+		DragAreaM2:                   0.208,
+		RollingResistanceCoefficient: 0.005,
+		DrivetrainEfficiency:         0.93,
+		ArrayAreaM2:                  float64(Cells) * CellAreaM2,
+		ArrayEfficiency:              CellEfficiency,
+	}
+}
+
+// Path of the vehicle file set by the VEHICLE_FILE environment variable, or vehicle.json
+func VehicleFilePath() string {
+	vehicleFilePath := os.Getenv("VEHICLE_FILE")
+	if vehicleFilePath == "" {
+		return defaultVehicleFilePath
+	}
+	return vehicleFilePath
+}
+
+/*
+Loads the vehicle from VehicleFilePath(). Returns DefaultVehicle() if VEHICLE_FILE isn't
+set and there is no vehicle.json. Values missing from the file come from DefaultVehicle().
+*/
+func LoadVehicle() (*types.Vehicle, error) {
+	vehicleFilePath := VehicleFilePath()
+
+	vehicle, err := dataaccess.GetVehicle(vehicleFilePath)
+	if err != nil {
+		if os.Getenv("VEHICLE_FILE") == "" && errors.Is(err, os.ErrNotExist) {
+			defaultVehicle := DefaultVehicle()
+			return &defaultVehicle, nil
+		}
+		return nil, err
+	}
+
+	withDefaults := withVehicleDefaults(*vehicle)
+	return &withDefaults, nil
+}
+
+// Fills in values that are 0, e.g. from a vehicle file written before they were added
+func withVehicleDefaults(vehicle types.Vehicle) types.Vehicle {
+	defaults := DefaultVehicle()
+
+	if vehicle.DragAreaM2 <= 0 {
+		vehicle.DragAreaM2 = defaults.DragAreaM2
+	}
+	if vehicle.RollingResistanceCoefficient <= 0 {
+		vehicle.RollingResistanceCoefficient = defaults.RollingResistanceCoefficient
+	}
+	if vehicle.DrivetrainEfficiency <= 0 {
+		vehicle.DrivetrainEfficiency = defaults.DrivetrainEfficiency
+	}
+	if vehicle.ArrayAreaM2 <= 0 {
+		vehicle.ArrayAreaM2 = defaults.ArrayAreaM2
+	}
+	if vehicle.ArrayEfficiency <= 0 {
+		vehicle.ArrayEfficiency = defaults.ArrayEfficiency
+	}
+
+	return vehicle
+}
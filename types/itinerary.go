@@ -0,0 +1,48 @@
+package types
+
+/*
+A single pass over one route inside an itinerary.
+A loop driven three times shows up as three legs that share the same Route,
+so every lap gets its own indexes and distance.
+*/
+type ItineraryLeg struct {
+	Route *Route `json:"-"`
+	Kind  RouteKind
+	// Day of the event this leg is driven on, starting at 0
+	Day int
+	// Lap number for loops, starting at 1. Always 0 for stages.
+	Repetition int
+	// Indexes into Itinerary.Sections (both inclusive)
+	StartSectionIndex int
+	EndSectionIndex   int
+	// Distance from the start of the itinerary to the start of this leg
+	StartDistanceFt float64
+	LengthFt        float64
+}
+
+/*
+A route section placed somewhere in an itinerary.
+RouteSection still points at its own Route and keeps its PositionInRoute, so
+lookups keyed by route (like the weather cache) keep working for repeated loops.
+Position and Next are global to the whole itinerary.
+*/
+type ItinerarySection struct {
+	*RouteSection
+	Leg      *ItineraryLeg
+	Position int
+	// Distance from the start of the itinerary to the start of this section
+	DistanceFt float64
+	Next       *ItinerarySection
+}
+
+/*
+An ordered list of stages and loops driven over one or more days,
+e.g. A, AL x3, B, BL x2.
+*/
+type Itinerary struct {
+	Name     string
+	Legs     []ItineraryLeg
+	Sections []ItinerarySection
+	LengthFt float64
+	DayCount int
+}
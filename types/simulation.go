@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// What the simulation predicted for one section of the itinerary, in the same terms as a TelemetrySection
+type SimulatedSection struct {
+	// Index into Itinerary.Sections
+	SectionIndex    int
+	StartTime       time.Time
+	EndTime         time.Time
+	AverageSpeedMph float64
+	// Speed the car aims for on the section, after speed limits, traffic and weather
+	TargetSpeedMph float64
+	// Energy out of the battery (negative when it charged) and into it from the array
+	BatteryEnergyWh     float64
+	ArrayEnergyWh       float64
+	StartBatteryPercent float64
+	EndBatteryPercent   float64
+}
+
+/*
+The per-section results of a simulation, saved so they can be compared to
+telemetry from the same itinerary once it has been driven.
+*/
+type SimulatedRun struct {
+	Name string
+	// Itinerary that was simulated, in ParseItinerary() format, e.g. "A,AL*2"
+	Itinerary string
+	StartTime time.Time
+	EndTime   time.Time
+	Sections  []SimulatedSection
+}
@@ -0,0 +1,67 @@
+package types
+
+import "time"
+
+// One reading from the car's telemetry log
+type TelemetrySample struct {
+	Time     time.Time
+	SpeedMph float64
+	// Positive when the battery is discharging
+	BusCurrentAmps  float64
+	BusVoltageVolts float64
+	ArrayPowerWatts float64
+	// State of charge. 0 if it isn't logged.
+	BatteryPercent float64
+	Coordinates    Coordinates
+	// False if the GPS had no fix when the sample was taken
+	HasFix bool
+	// Index into Itinerary.Sections. -1 if the sample could not be matched to the route.
+	SectionIndex int
+	// Distance from the start of the itinerary. Only set if SectionIndex is set.
+	DistanceFt float64
+}
+
+// What the car did on one section of the itinerary, in the same terms as a simulated section
+type TelemetrySection struct {
+	// Index into Itinerary.Sections
+	SectionIndex int
+	StartTime    time.Time
+	EndTime      time.Time
+	// Time-weighted average of the speed readings
+	AverageSpeedMph float64
+	// Energy out of the battery (negative when it charged) and into it from the array
+	BusEnergyWh   float64
+	ArrayEnergyWh float64
+	// Logged state of charge when the car reached and left the section. 0 if it isn't logged.
+	StartBatteryPercent float64
+	EndBatteryPercent   float64
+	SampleCount         int
+}
+
+/*
+A telemetry log matched to an itinerary, so it can be compared to a simulation
+of the same itinerary. Sections the car did not drive are left out.
+*/
+type TelemetryRun struct {
+	Name string
+	// Itinerary the log was matched to, in ParseItinerary() format, e.g. "A,AL*2"
+	Itinerary string
+	StartTime time.Time
+	EndTime   time.Time
+	Samples   []TelemetrySample
+	Sections  []TelemetrySection
+	// Samples without a GPS fix or too far from the route
+	UnmatchedSampleCount int
+}
+
+// Where the car is right now and how much battery it has left, used to re-plan during a stage
+type CarState struct {
+	Time           time.Time
+	BatteryPercent float64
+	SpeedMph       float64
+	Coordinates    Coordinates
+	// False if the GPS has no fix, in which case DistanceFt is used instead of Coordinates
+	HasFix bool
+	// Distance from the start of the itinerary
+	DistanceFt float64
+}
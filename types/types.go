@@ -47,10 +47,31 @@ type RouteSection struct {
 	PositionInRoute int
 }
 
+// Enum separating stage routes (which go from one city to the next) from loops
+// (which start and end at the same stage finish and can be driven repeatedly).
+type RouteKind string
+
+const (
+	StageRoute RouteKind = "stage"
+	LoopRoute  RouteKind = "loop"
+)
+
+// Information about where a route fits in the event. Set when the route is imported.
+type RouteMetadata struct {
+	Kind RouteKind
+	// Stage the route belongs to, e.g. "A" for both "A: Nashville to Paducah" and "AL: Paducah Loop"
+	StageLetter     string
+	StartCheckpoint string
+	EndCheckpoint   string
+	EventYear       int
+	// IANA time zone at the start of the route, e.g. "America/Chicago". Empty uses the computer's time zone.
+	TimeZone string
+}
+
 type Route struct {
 	Name     string
+	Metadata RouteMetadata
 	Sections []RouteSection
-	IsLoop   bool
 }
 
 type Weather struct {
@@ -62,10 +83,54 @@ type Weather struct {
 	WindDirectionDegrees float64
 	RainOnGroundInches   float64
 	SurfacePressurePsi   float64
+	// 0 if the weather source doesn't provide it
+	WindGustMph float64
+	// 0 if the weather source doesn't provide it
+	VisibilityMi float64
+	// 0 degrees is North, 90 degrees is East
+	SolarAzimuthDegrees float64
+	// Irradiance in W/m^2. All 0 if the weather source doesn't provide it.
+	GlobalHorizontalIrradianceWm2  float64
+	DirectNormalIrradianceWm2      float64
+	DiffuseHorizontalIrradianceWm2 float64
+	// Irradiance on the solar array, for the SolarArray the weather was requested for
+	GlobalTiltedIrradianceWm2 float64
+}
+
+// Which way the solar array faces
+type SolarArray struct {
+	// 0 degrees is flat, 90 degrees is vertical
+	TiltDegrees float64
+	// Direction the array tilts towards. 0 degrees is North, 90 degrees is East.
+	AzimuthDegrees float64
+}
+
+// Weather value a SafetyRule looks at
+type SafetyCondition string
+
+const (
+	// Wind across the car's direction of travel, in mph
+	CrosswindCondition SafetyCondition = "crosswind"
+	// Wind gusts, in mph
+	GustCondition SafetyCondition = "gust"
+	// Rain on the ground, in inches
+	RainCondition SafetyCondition = "rain"
+	// How far the driver can see, in miles. Applies at or below the threshold instead of at or above it.
+	VisibilityCondition SafetyCondition = "visibility"
+)
+
+// Slows the car down to MaxSpeedMph when the condition is at least Threshold (at most for visibility)
+type SafetyRule struct {
+	Condition   SafetyCondition
+	Threshold   float64
+	MaxSpeedMph float64
 }
 
 type Traffic struct {
+	// Speed traffic is moving at
 	FlowSpeedMph float64
+	// Speed traffic moves at when the road is empty. 0 if unknown.
+	FreeFlowSpeedMph float64
 }
 
 // TODO: Add units
@@ -78,4 +143,13 @@ type Vehicle struct {
 	BatteryCapacityMilliamps float64
 	CellCount                int
 	CellEfficiency           float64
+	// Drag coefficient times frontal area, in m^2
+	DragAreaM2                   float64
+	RollingResistanceCoefficient float64
+	// Fraction of the electrical power into the motor that reaches the wheels
+	DrivetrainEfficiency float64
+	// Area of the solar cells, in m^2
+	ArrayAreaM2 float64
+	// Fraction of the sunlight on the array that reaches the battery
+	ArrayEfficiency float64
 }